@@ -0,0 +1,9 @@
+// Package benchmarks compares ways of sending a multipart upload: assembling
+// the body in a bytes.Buffer, streaming it through io.Pipe, spooling it to a
+// temporary file first, and splitting it into chunked requests.
+//
+// Run with:
+//
+//	go test -run=^$ -bench=. ./benchmarks
+//	go test -run=^$ -bench=. ./benchmarks -large   # adds 256MB and 1GB payloads
+package benchmarks
@@ -0,0 +1,230 @@
+package benchmarks
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+)
+
+var large = flag.Bool("large", false, "include 256MB and 1GB payloads")
+
+// chunkSize is the payload carried by each request in chunked mode.
+const chunkSize = 8 << 20
+
+func sizes() []int64 {
+	s := []int64{1 << 10, 64 << 10, 1 << 20, 16 << 20, 64 << 20}
+	if *large {
+		s = append(s, 256<<20, 1<<30)
+	}
+	return s
+}
+
+func sizeName(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%dGB", n>>30)
+	case n >= 1<<20:
+		return fmt.Sprintf("%dMB", n>>20)
+	default:
+		return fmt.Sprintf("%dKB", n>>10)
+	}
+}
+
+// payload returns n bytes without allocating them.
+func payload(n int64) io.Reader {
+	return io.LimitReader(pattern{}, n)
+}
+
+type pattern struct{}
+
+func (pattern) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte('a' + i%26)
+	}
+	return len(p), nil
+}
+
+// sink is the upload server: it reads every part to the end and discards it.
+func sink(b *testing.B) *httptest.Server {
+	b.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			io.Copy(io.Discard, p)
+		}
+	}))
+	b.Cleanup(srv.Close)
+	return srv
+}
+
+func post(client *http.Client, url, contentType string, body io.Reader, length int64) error {
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if length >= 0 {
+		req.ContentLength = length
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+func writeForm(mw *multipart.Writer, r io.Reader) error {
+	if err := mw.WriteField("title", "benchmark"); err != nil {
+		return err
+	}
+	fw, err := mw.CreateFormFile("file", "payload.bin")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, r); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// uploadBuffered assembles the whole body in memory, then sends it.
+func uploadBuffered(client *http.Client, url string, n int64) error {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := writeForm(mw, payload(n)); err != nil {
+		return err
+	}
+	return post(client, url, mw.FormDataContentType(), &buf, int64(buf.Len()))
+}
+
+// uploadPiped streams the body through io.Pipe while it is being sent.
+func uploadPiped(client *http.Client, url string, n int64) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeForm(mw, payload(n)))
+	}()
+	err := post(client, url, mw.FormDataContentType(), pr, -1)
+	pr.Close()
+	return err
+}
+
+// uploadSpooled writes the body to a temporary file first so the request
+// carries a Content-Length, then sends the file.
+func uploadSpooled(client *http.Client, url string, n int64) error {
+	f, err := os.CreateTemp("", "spool-*.multipart")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	mw := multipart.NewWriter(f)
+	if err := writeForm(mw, payload(n)); err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return post(client, url, mw.FormDataContentType(), f, size)
+}
+
+// uploadChunked splits the payload into chunkSize pieces, each sent as its
+// own buffered multipart request with index fields.
+func uploadChunked(client *http.Client, url string, n int64) error {
+	src := payload(n)
+	total := (n + chunkSize - 1) / chunkSize
+	var buf bytes.Buffer
+	for i := int64(0); i < total; i++ {
+		buf.Reset()
+		mw := multipart.NewWriter(&buf)
+		mw.WriteField("chunk", strconv.FormatInt(i, 10))
+		mw.WriteField("chunks", strconv.FormatInt(total, 10))
+		if err := writeForm(mw, io.LimitReader(src, chunkSize)); err != nil {
+			return err
+		}
+		if err := post(client, url, mw.FormDataContentType(), &buf, int64(buf.Len())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func benchmark(b *testing.B, upload func(*http.Client, string, int64) error) {
+	srv := sink(b)
+	for _, n := range sizes() {
+		b.Run(sizeName(n), func(b *testing.B) {
+			b.SetBytes(n)
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := upload(srv.Client(), srv.URL, n); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBuffered(b *testing.B) { benchmark(b, uploadBuffered) }
+func BenchmarkPiped(b *testing.B)    { benchmark(b, uploadPiped) }
+func BenchmarkSpooled(b *testing.B)  { benchmark(b, uploadSpooled) }
+func BenchmarkChunked(b *testing.B)  { benchmark(b, uploadChunked) }
+
+// TestUploadModes checks that every mode delivers a well-formed upload.
+func TestUploadModes(t *testing.T) {
+	var got []int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got = append(got, r.MultipartForm.File["file"][0].Size)
+		r.MultipartForm.RemoveAll()
+	}))
+	defer srv.Close()
+
+	modes := map[string]func(*http.Client, string, int64) error{
+		"buffered": uploadBuffered, "piped": uploadPiped, "spooled": uploadSpooled, "chunked": uploadChunked,
+	}
+	for name, upload := range modes {
+		got = nil
+		if err := upload(srv.Client(), srv.URL, chunkSize+100); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var sum int64
+		for _, n := range got {
+			sum += n
+		}
+		if sum != chunkSize+100 {
+			t.Errorf("%s: server received %d bytes in %d requests", name, sum, len(got))
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Command multipart-inspect prints the structure of a captured multipart body.
+//
+// Usage:
+//
+//	multipart-inspect [flags] [file]
+//
+// The body is read from file, or from stdin when no file is given. The
+// boundary is taken from -boundary, from -content-type, or detected from the
+// first delimiter line.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"strings"
+
+	"github.com/isauran/go-std-library/http/request/multipartcheck"
+	"github.com/isauran/go-std-library/http/request/multipartinspect"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "multipart-inspect:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	boundary := flag.String("boundary", "", "multipart boundary (detected when empty)")
+	contentType := flag.String("content-type", "", "Content-Type header to take the boundary from")
+	preview := flag.Int("preview", 64, "number of content bytes to preview per part")
+	extractDir := flag.String("extract", "", "directory to extract part contents into")
+	only := flag.String("parts", "", "comma-separated part IDs to extract (default all)")
+	check := flag.Bool("check", false, "also validate the stream structure")
+	flag.Parse()
+
+	in := os.Stdin
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	br := bufio.NewReaderSize(in, 64<<10)
+
+	if *boundary == "" && *contentType != "" {
+		_, params, err := mime.ParseMediaType(*contentType)
+		if err != nil {
+			return fmt.Errorf("parse content type: %w", err)
+		}
+		*boundary = params["boundary"]
+	}
+	if *boundary == "" {
+		b, err := multipartinspect.DetectBoundary(br)
+		if err != nil {
+			return err
+		}
+		*boundary = b
+	}
+
+	opts := multipartinspect.Options{PreviewBytes: *preview, ExtractDir: *extractDir}
+	if *only != "" {
+		opts.Extract = strings.Split(*only, ",")
+	}
+
+	var src io.Reader = br
+	var reports chan multipartcheck.Report
+	var pw *io.PipeWriter
+	if *check {
+		// Validate the same bytes concurrently instead of reading the input twice.
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+		src = io.TeeReader(br, pw)
+		reports = make(chan multipartcheck.Report, 1)
+		go func() {
+			report, err := multipartcheck.Validate(pr, *boundary)
+			pr.CloseWithError(err)
+			reports <- report
+		}()
+	}
+
+	fmt.Printf("boundary: %s\n", *boundary)
+	parts, inspectErr := multipartinspect.Inspect(src, *boundary, opts)
+	if err := multipartinspect.Print(os.Stdout, parts); err != nil {
+		return err
+	}
+
+	if *check {
+		// The parser may stop early on corrupted input; feed the rest to the validator.
+		io.Copy(io.Discard, src)
+		pw.Close()
+		fmt.Printf("\nvalidation: %s\n", <-reports)
+	}
+	return inspectErr
+}
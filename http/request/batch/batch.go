@@ -0,0 +1,180 @@
+// Package batch packs several independent HTTP requests into one
+// multipart/mixed request, as the batch endpoints of Google APIs and OData
+// expect, and splits the multipart/mixed reply back into one response per
+// request:
+//
+//	resps, err := batch.New(ctx, client, "https://api.example.com/batch").
+//		Add(getUser).
+//		Add(updateUser).
+//		Do()
+//
+// Every request becomes an "application/http" part carrying the request in
+// HTTP/1.1 wire format, with Content-ID "<item-N>". The batch body is
+// streamed, so request bodies are not buffered.
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of batch requests and responses.
+const ContentType = "multipart/mixed"
+
+// ErrMissingResponse is returned by Do when the reply holds fewer
+// responses than the batch had requests.
+var ErrMissingResponse = errors.New("batch: missing response")
+
+// Batch is a batch request being built. Nothing is sent until Do.
+type Batch struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	header   http.Header
+	requests []*http.Request
+}
+
+// New returns an empty batch to be posted to url. A nil client means
+// http.DefaultClient.
+func New(ctx context.Context, client *http.Client, url string) *Batch {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Batch{ctx: ctx, client: client, url: url, header: http.Header{}}
+}
+
+// Header adds a header to the outer batch request, such as Authorization.
+func (b *Batch) Header(key, value string) *Batch {
+	b.header.Add(key, value)
+	return b
+}
+
+// Add appends a request to the batch. Its context is ignored; the batch
+// is sent with the context given to New.
+func (b *Batch) Add(req *http.Request) *Batch {
+	b.requests = append(b.requests, req)
+	return b
+}
+
+// Do sends the batch and returns one response per request, in the order
+// they were added. Responses are matched by their Content-ID when the
+// server sets one ("<response-item-N>"), otherwise by position. Their
+// bodies are read into memory, so they need not be closed. A reply that is
+// not multipart/mixed, such as an error for the whole batch, is returned
+// as an error holding its status.
+func (b *Batch) Do() ([]*http.Response, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(b.writeBody(mw))
+	}()
+
+	req, err := http.NewRequestWithContext(b.ctx, http.MethodPost, b.url, pr)
+	if err != nil {
+		pr.Close()
+		return nil, fmt.Errorf("failed to create batch request: %w", err)
+	}
+	for k, vs := range b.header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", mime.FormatMediaType(ContentType, map[string]string{"boundary": mw.Boundary()}))
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send batch request: %w", err)
+	}
+	defer resp.Body.Close()
+	return b.readResponses(resp)
+}
+
+func (b *Batch) writeBody(mw *multipart.Writer) error {
+	for i, req := range b.requests {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"application/http"},
+			"Content-Transfer-Encoding": {"binary"},
+			"Content-Id":                {"<item-" + strconv.Itoa(i) + ">"},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create batch part %d: %w", i, err)
+		}
+		if err := req.Write(part); err != nil {
+			return fmt.Errorf("failed to write batch request %d: %w", i, err)
+		}
+	}
+	return mw.Close()
+}
+
+func (b *Batch) readResponses(resp *http.Response) ([]*http.Response, error) {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != ContentType {
+		return nil, fmt.Errorf("batch request failed: %s", resp.Status)
+	}
+
+	resps := make([]*http.Response, len(b.requests))
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for n := 0; ; n++ {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read batch response: %w", err)
+		}
+		i := itemIndex(part.Header.Get("Content-ID"), n)
+		if i < 0 || i >= len(resps) {
+			return nil, fmt.Errorf("failed to read batch response: part %d does not match a request", n)
+		}
+		r, err := readResponse(part, b.requests[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read batch response %d: %w", i, err)
+		}
+		resps[i] = r
+	}
+	for i, r := range resps {
+		if r == nil {
+			return nil, fmt.Errorf("%w for request %d", ErrMissingResponse, i)
+		}
+	}
+	return resps, nil
+}
+
+// itemIndex returns the request index named by a response Content-ID such
+// as "<response-item-3>", or n when there is none.
+func itemIndex(contentID string, n int) int {
+	id := strings.Trim(contentID, "<>")
+	if id == "" {
+		return n
+	}
+	id = strings.TrimPrefix(id, "response-")
+	i, err := strconv.Atoi(strings.TrimPrefix(id, "item-"))
+	if err != nil {
+		return n
+	}
+	return i
+}
+
+// readResponse parses one embedded response and buffers its body, which
+// is only readable until the next part.
+func readResponse(part *multipart.Part, req *http.Request) (*http.Response, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(part), req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, nil
+}
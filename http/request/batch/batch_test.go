@@ -0,0 +1,123 @@
+package batch
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// batchServer executes every embedded request with h and replies in
+// reverse order, tagging responses with Content-ID when tag is set.
+func batchServer(t *testing.T, h http.Handler, tag bool) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		type result struct {
+			id  string
+			rec *httptest.ResponseRecorder
+		}
+		var results []result
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if part.Header.Get("Content-Type") != "application/http" {
+				http.Error(w, "bad part type", http.StatusBadRequest)
+				return
+			}
+			req, err := http.ReadRequest(bufio.NewReader(part))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			results = append(results, result{strings.Trim(part.Header.Get("Content-ID"), "<>"), rec})
+		}
+
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+		slices.Reverse(results)
+		if !tag {
+			results = results[:0]
+		}
+		for _, res := range results {
+			part, _ := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type": {"application/http"},
+				"Content-Id":   {"<response-" + res.id + ">"},
+			})
+			res.rec.Result().Write(part)
+		}
+		mw.Close()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func echoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, r.Method+" "+r.URL.Path+" "+string(body))
+	})
+}
+
+func TestDo(t *testing.T) {
+	srv := batchServer(t, echoHandler(), true)
+
+	get, _ := http.NewRequest(http.MethodGet, "http://api.example.com/users/1", nil)
+	post, _ := http.NewRequest(http.MethodPost, "http://api.example.com/users", strings.NewReader(`{"name":"ann"}`))
+	resps, err := New(context.Background(), srv.Client(), srv.URL).Add(get).Add(post).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"GET /users/1 ", `POST /users {"name":"ann"}`}
+	if len(resps) != len(want) {
+		t.Fatalf("got %d responses", len(resps))
+	}
+	for i, resp := range resps {
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusAccepted || string(body) != want[i] {
+			t.Errorf("response %d = %d %q, want %q", i, resp.StatusCode, body, want[i])
+		}
+	}
+}
+
+func TestMissingResponse(t *testing.T) {
+	srv := batchServer(t, echoHandler(), false)
+	get, _ := http.NewRequest(http.MethodGet, "http://api.example.com/", nil)
+	_, err := New(context.Background(), srv.Client(), srv.URL).Add(get).Do()
+	if !errors.Is(err, ErrMissingResponse) {
+		t.Errorf("Do() = %v, want ErrMissingResponse", err)
+	}
+}
+
+func TestBatchRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	get, _ := http.NewRequest(http.MethodGet, "http://api.example.com/", nil)
+	_, err := New(context.Background(), srv.Client(), srv.URL).Add(get).Do()
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Do() = %v, want status error", err)
+	}
+}
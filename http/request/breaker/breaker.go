@@ -0,0 +1,218 @@
+// Package breaker is a per-host circuit breaker for HTTP clients. After a
+// run of failed requests to a host it fails further requests at once with
+// ErrOpen, instead of streaming large uploads into a dead or flapping
+// endpoint, and lets a few probe requests through once a cool-down passed
+// to find out whether the host recovered:
+//
+//	b := breaker.New(breaker.Threshold(5), breaker.CoolDown(time.Minute),
+//		breaker.OnStateChange(func(host string, from, to breaker.State) { ... }))
+//	client := httpx.NewClient(httpx.CircuitBreaker(b))
+//
+// A request fails when the transport returns an error or the response
+// status is 5xx.
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults of New.
+const (
+	DefaultThreshold = 5
+	DefaultCoolDown  = 30 * time.Second
+	DefaultProbes    = 1
+)
+
+// ErrOpen is returned for requests to a host whose circuit is open.
+var ErrOpen = errors.New("breaker: circuit open")
+
+// State is the state of the circuit of one host.
+type State int
+
+const (
+	// Closed lets requests through and counts consecutive failures.
+	Closed State = iota
+	// Open rejects requests until the cool-down has passed.
+	Open
+	// HalfOpen lets a limited number of probe requests through.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Option configures a Breaker.
+type Option func(*Breaker)
+
+// Threshold sets how many consecutive failures open the circuit of a host.
+func Threshold(n int) Option {
+	return func(b *Breaker) { b.threshold = max(n, 1) }
+}
+
+// CoolDown sets how long a circuit stays open before probes are allowed.
+func CoolDown(d time.Duration) Option {
+	return func(b *Breaker) { b.coolDown = d }
+}
+
+// Probes sets how many requests may run at once while half-open; that many
+// successes close the circuit again.
+func Probes(n int) Option {
+	return func(b *Breaker) { b.probes = max(n, 1) }
+}
+
+// OnStateChange registers fn to be called after the circuit of a host
+// changes state, e.g. to alert on flapping endpoints. It is called without
+// locks held, but must not block for long.
+func OnStateChange(fn func(host string, from, to State)) Option {
+	return func(b *Breaker) { b.onChange = fn }
+}
+
+// Breaker tracks the circuits of all hosts. It is safe for concurrent use.
+type Breaker struct {
+	threshold int
+	coolDown  time.Duration
+	probes    int
+	onChange  func(host string, from, to State)
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+type circuit struct {
+	state     State
+	failures  int // consecutive failures while closed
+	openedAt  time.Time
+	inFlight  int // probes running while half-open
+	successes int // successful probes while half-open
+}
+
+// New returns a Breaker with all circuits closed.
+func New(opts ...Option) *Breaker {
+	b := &Breaker{
+		threshold: DefaultThreshold,
+		coolDown:  DefaultCoolDown,
+		probes:    DefaultProbes,
+		hosts:     map[string]*circuit{},
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// State returns the current state of the circuit of host.
+func (b *Breaker) State(host string) State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := b.hosts[host]; c != nil {
+		return c.state
+	}
+	return Closed
+}
+
+// Allow reports whether a request to host may be sent now, returning
+// ErrOpen if not. Every allowed request must be followed by a call to
+// Record with its outcome.
+func (b *Breaker) Allow(host string) error {
+	b.mu.Lock()
+	c := b.circuit(host)
+	from := c.state
+	if c.state == Open && time.Since(c.openedAt) >= b.coolDown {
+		c.state, c.inFlight, c.successes = HalfOpen, 0, 0
+	}
+	var err error
+	switch {
+	case c.state == Open:
+		err = fmt.Errorf("%w for %s", ErrOpen, host)
+	case c.state == HalfOpen && c.inFlight >= b.probes:
+		err = fmt.Errorf("%w for %s: probing", ErrOpen, host)
+	case c.state == HalfOpen:
+		c.inFlight++
+	}
+	to := c.state
+	b.mu.Unlock()
+	b.changed(host, from, to)
+	return err
+}
+
+// Record reports the outcome of a request allowed by Allow.
+func (b *Breaker) Record(host string, ok bool) {
+	b.mu.Lock()
+	c := b.circuit(host)
+	from := c.state
+	switch c.state {
+	case Closed:
+		if ok {
+			c.failures = 0
+		} else if c.failures++; c.failures >= b.threshold {
+			c.state, c.openedAt = Open, time.Now()
+		}
+	case HalfOpen:
+		c.inFlight--
+		if !ok {
+			c.state, c.openedAt = Open, time.Now()
+		} else if c.successes++; c.successes >= b.probes {
+			c.state, c.failures = Closed, 0
+		}
+	}
+	to := c.state
+	b.mu.Unlock()
+	b.changed(host, from, to)
+}
+
+func (b *Breaker) circuit(host string) *circuit {
+	c := b.hosts[host]
+	if c == nil {
+		c = &circuit{}
+		b.hosts[host] = c
+	}
+	return c
+}
+
+func (b *Breaker) changed(host string, from, to State) {
+	if from != to && b.onChange != nil {
+		b.onChange(host, from, to)
+	}
+}
+
+// Transport wraps base (http.DefaultTransport if nil) so requests go
+// through the breaker, keyed by the URL host. Rejected requests fail with
+// ErrOpen before anything is sent; their body is closed, which stops a
+// streaming producer.
+func (b *Breaker) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base, b: b}
+}
+
+type transport struct {
+	base http.RoundTripper
+	b    *Breaker
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := t.b.Allow(host); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	t.b.Record(host, err == nil && resp.StatusCode < 500)
+	return resp, err
+}
@@ -0,0 +1,89 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStateMachine(t *testing.T) {
+	var changes []string
+	b := New(Threshold(2), CoolDown(20*time.Millisecond), Probes(2),
+		OnStateChange(func(host string, from, to State) {
+			changes = append(changes, fmt.Sprintf("%s:%s->%s", host, from, to))
+		}))
+
+	for range 2 {
+		if err := b.Allow("h"); err != nil {
+			t.Fatal(err)
+		}
+		b.Record("h", false)
+	}
+	if err := b.Allow("h"); !errors.Is(err, ErrOpen) {
+		t.Fatalf("Allow while open = %v", err)
+	}
+	if b.State("other") != Closed {
+		t.Error("circuits are not per host")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	for range 2 {
+		if err := b.Allow("h"); err != nil {
+			t.Fatalf("probe rejected: %v", err)
+		}
+	}
+	if err := b.Allow("h"); !errors.Is(err, ErrOpen) {
+		t.Errorf("third concurrent probe = %v", err)
+	}
+	b.Record("h", true)
+	b.Record("h", true)
+	if b.State("h") != Closed {
+		t.Errorf("state after probes = %v", b.State("h"))
+	}
+
+	want := "h:closed->open h:open->half-open h:half-open->closed"
+	if got := strings.Join(changes, " "); got != want {
+		t.Errorf("changes = %q, want %q", got, want)
+	}
+}
+
+func TestFailedProbeReopens(t *testing.T) {
+	b := New(Threshold(1), CoolDown(0))
+	b.Allow("h")
+	b.Record("h", false)
+	if err := b.Allow("h"); err != nil {
+		t.Fatal(err)
+	}
+	b.Record("h", false)
+	if b.State("h") != Open {
+		t.Errorf("state = %v, want open", b.State("h"))
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: New(Threshold(3), CoolDown(time.Hour)).Transport(nil)}
+	for range 5 {
+		resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("payload"))
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+	if hits.Load() != 3 {
+		t.Errorf("server saw %d requests, want 3", hits.Load())
+	}
+	if _, err := client.Get(srv.URL); !errors.Is(err, ErrOpen) {
+		t.Errorf("Get = %v, want ErrOpen", err)
+	}
+}
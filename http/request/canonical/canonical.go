@@ -0,0 +1,262 @@
+// Package canonical signs multipart requests between services. The
+// signature covers a canonical form of the request instead of its raw
+// bytes, so it survives proxies that re-encode the body with another
+// boundary or reorder header parameters:
+//
+//	err := canonical.Sign(req, secret, canonical.SignedHeaders("X-Request-Date"))
+//	resp, err := client.Do(req)
+//
+//	err := canonical.Verify(r, secret) // in the receiver's handler
+//	mux.Handle("/upload", canonical.VerifySignature(h, keys.Lookup))
+//
+// The canonical form lists the method, path, sorted query, the signed
+// headers by lowercase name in sorted order with the boundary replaced by
+// Boundary, and then for each part its normalized headers and the SHA-256
+// of its content. Part order is kept, as handlers may depend on it.
+package canonical
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Defaults of Sign and Verify.
+const (
+	DefaultSignatureHeader     = "X-Canonical-Signature"
+	DefaultSignedHeadersHeader = "X-Signed-Headers"
+	DefaultKeyIDHeader         = "X-Key-Id"
+	DefaultMaxBody             = 32 << 20
+)
+
+// Boundary stands in for the boundary of a multipart body in the
+// canonical form.
+const Boundary = "canonical"
+
+var (
+	// ErrSignature is returned by Verify for a missing or wrong signature.
+	ErrSignature = errors.New("canonical: invalid signature")
+	// ErrBodyTooLarge is returned for bodies over MaxBody, which have to
+	// be held in memory to be canonicalized.
+	ErrBodyTooLarge = errors.New("canonical: body too large")
+)
+
+type config struct {
+	headers         []string
+	signatureHeader string
+	keyID           string
+	maxBody         int64
+}
+
+func newConfig(opts []Option) config {
+	c := config{signatureHeader: DefaultSignatureHeader, maxBody: DefaultMaxBody}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// Option configures Sign, Verify and VerifySignature.
+type Option func(*config)
+
+// SignedHeaders adds request headers to the signature, e.g. a date to
+// bound replays. Content-Type is always signed.
+func SignedHeaders(names ...string) Option {
+	return func(c *config) { c.headers = append(c.headers, names...) }
+}
+
+// SignatureHeader sets the header carrying "sha256=<hex HMAC>".
+func SignatureHeader(name string) Option {
+	return func(c *config) { c.signatureHeader = name }
+}
+
+// KeyID makes Sign send id in X-Key-Id, so a receiver holding several
+// secrets, such as VerifySignature, knows which one to check with.
+func KeyID(id string) Option {
+	return func(c *config) { c.keyID = id }
+}
+
+// MaxBody limits the size of the body read to canonicalize it.
+func MaxBody(n int64) Option {
+	return func(c *config) { c.maxBody = n }
+}
+
+// Sign reads the body of req, sets a replayable copy in its place and adds
+// the signature of its canonical form. The names of the signed headers go
+// in X-Signed-Headers so the receiver knows what to check.
+func Sign(req *http.Request, secret []byte, opts ...Option) error {
+	cfg := newConfig(opts)
+	body, err := readBody(req, cfg.maxBody)
+	if err != nil {
+		return err
+	}
+	names := signedNames(cfg.headers)
+	form, err := Form(req, body, names)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(DefaultSignedHeadersHeader, strings.Join(names, ";"))
+	if cfg.keyID != "" {
+		req.Header.Set(DefaultKeyIDHeader, cfg.keyID)
+	}
+	req.Header.Set(cfg.signatureHeader, "sha256="+hex.EncodeToString(mac(secret, form)))
+	return nil
+}
+
+// Verify checks the signature of a request received by a handler. It
+// reads the body and puts a copy back, so the handler can still parse the
+// form afterwards. Every header listed in SignedHeaders must have been
+// signed by the sender.
+func Verify(r *http.Request, secret []byte, opts ...Option) error {
+	cfg := newConfig(opts)
+	body, err := readBody(r, cfg.maxBody)
+	if err != nil {
+		return err
+	}
+	return cfg.check(r, secret, bytes.NewReader(body))
+}
+
+// check compares the signature of r with the one of its canonical form,
+// reading the body from body.
+func (c *config) check(r *http.Request, secret []byte, body io.Reader) error {
+	names := strings.Split(r.Header.Get(DefaultSignedHeadersHeader), ";")
+	for _, want := range signedNames(c.headers) {
+		if !slices.Contains(names, want) {
+			return fmt.Errorf("%w: header %q not signed", ErrSignature, want)
+		}
+	}
+	form, err := form(r, body, names)
+	if err != nil {
+		return err
+	}
+	got, ok := strings.CutPrefix(r.Header.Get(c.signatureHeader), "sha256=")
+	sig, err := hex.DecodeString(got)
+	if !ok || err != nil || !hmac.Equal(sig, mac(secret, form)) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Form returns the canonical form of a request with the given body,
+// signing the headers in names, which are expected in lowercase and
+// sorted. Bodies that are not multipart are covered by a single digest.
+func Form(req *http.Request, body []byte, names []string) ([]byte, error) {
+	return form(req, bytes.NewReader(body), names)
+}
+
+// form builds the canonical form while reading body, hashing each part as
+// it streams by.
+func form(req *http.Request, body io.Reader, names []string) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(req.Method + "\n")
+	b.WriteString(req.URL.EscapedPath() + "\n")
+	b.WriteString(req.URL.Query().Encode() + "\n")
+
+	mediaType, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	for _, name := range names {
+		value := strings.Join(req.Header.Values(name), ",")
+		if name == "content-type" {
+			value = normalize(value, Boundary)
+		}
+		fmt.Fprintf(&b, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	b.WriteString("\n")
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		h := sha256.New()
+		if _, err := io.Copy(h, body); err != nil {
+			return nil, fmt.Errorf("failed to read body: %w", err)
+		}
+		fmt.Fprintf(&b, "body:%x\n", h.Sum(nil))
+		return b.Bytes(), nil
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			return b.Bytes(), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read part: %w", err)
+		}
+		keys := make([]string, 0, len(part.Header))
+		for k := range part.Header {
+			keys = append(keys, strings.ToLower(k))
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			value := strings.Join(part.Header.Values(k), ",")
+			if k == "content-disposition" || k == "content-type" {
+				value = normalize(value, "")
+			}
+			fmt.Fprintf(&b, "%s:%s\n", k, strings.TrimSpace(value))
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, part); err != nil {
+			return nil, fmt.Errorf("failed to read part: %w", err)
+		}
+		fmt.Fprintf(&b, "sha256:%x\n\n", h.Sum(nil))
+	}
+}
+
+// normalize re-formats a media type header, which sorts its parameters and
+// fixes their quoting. A non-empty boundary replaces the one in value.
+// Values that do not parse are kept as they are.
+func normalize(value, boundary string) string {
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return value
+	}
+	if _, ok := params["boundary"]; ok && boundary != "" {
+		params["boundary"] = boundary
+	}
+	if formatted := mime.FormatMediaType(mediaType, params); formatted != "" {
+		return formatted
+	}
+	return value
+}
+
+// signedNames returns Content-Type and extra as sorted lowercase names
+// without duplicates.
+func signedNames(extra []string) []string {
+	names := []string{"content-type"}
+	for _, name := range extra {
+		names = append(names, strings.ToLower(name))
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// readBody reads the body of req up to limit bytes and replaces it with a
+// copy that can be read again.
+func readBody(req *http.Request, limit int64) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("%w (limit %d bytes)", ErrBodyTooLarge, limit)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	return body, nil
+}
+
+func mac(secret, form []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write(form)
+	return m.Sum(nil)
+}
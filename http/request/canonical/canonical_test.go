@@ -0,0 +1,142 @@
+package canonical
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newUpload(t *testing.T, boundary, file string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.SetBoundary(boundary); err != nil {
+		t.Fatal(err)
+	}
+	mw.WriteField("title", "report")
+	fw, _ := mw.CreateFormFile("file", "report.csv")
+	fw.Write([]byte(file))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload?b=2&a=1", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Request-Date", "2026-01-02T03:04:05Z")
+	return req
+}
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("s3cret")
+	req := newUpload(t, "first", "a,b\n1,2\n")
+	if err := Sign(req, secret, SignedHeaders("X-Request-Date")); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get(DefaultSignedHeadersHeader); got != "content-type;x-request-date" {
+		t.Errorf("signed headers = %q", got)
+	}
+	if err := Verify(req, secret, SignedHeaders("X-Request-Date")); err != nil {
+		t.Fatalf("Verify = %v", err)
+	}
+	if err := req.ParseMultipartForm(1 << 20); err != nil || req.FormValue("title") != "report" {
+		t.Errorf("form after Verify = %q, %v", req.FormValue("title"), err)
+	}
+
+	// A proxy re-encoding the body with another boundary keeps the signature.
+	reencoded := newUpload(t, "second", "a,b\n1,2\n")
+	reencoded.Header.Set(DefaultSignedHeadersHeader, req.Header.Get(DefaultSignedHeadersHeader))
+	reencoded.Header.Set(DefaultSignatureHeader, req.Header.Get(DefaultSignatureHeader))
+	if err := Verify(reencoded, secret); err != nil {
+		t.Errorf("Verify after re-encoding = %v", err)
+	}
+
+	tampered := newUpload(t, "first", "a,b\n1,3\n")
+	tampered.Header.Set(DefaultSignedHeadersHeader, req.Header.Get(DefaultSignedHeadersHeader))
+	tampered.Header.Set(DefaultSignatureHeader, req.Header.Get(DefaultSignatureHeader))
+	if err := Verify(tampered, secret); !errors.Is(err, ErrSignature) {
+		t.Errorf("Verify of tampered part = %v, want ErrSignature", err)
+	}
+
+	unsigned := newUpload(t, "first", "a,b\n1,2\n")
+	if err := Sign(unsigned, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(unsigned, secret, SignedHeaders("X-Request-Date")); !errors.Is(err, ErrSignature) {
+		t.Errorf("Verify without required header = %v, want ErrSignature", err)
+	}
+}
+
+func TestForm(t *testing.T) {
+	req := newUpload(t, "xyz", "data")
+	body, _ := io.ReadAll(req.Body)
+	form, err := Form(req, body, []string{"content-type"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"POST\n/upload\na=1&b=2\ncontent-type:multipart/form-data; boundary=canonical\n\n",
+		"content-disposition:form-data; name=title\nsha256:",
+		"content-disposition:form-data; filename=report.csv; name=file\ncontent-type:application/octet-stream\nsha256:",
+	} {
+		if !strings.Contains(string(form), want) {
+			t.Errorf("form lacks %q:\n%s", want, form)
+		}
+	}
+}
+
+func TestMaxBody(t *testing.T) {
+	req := newUpload(t, "first", strings.Repeat("x", 100))
+	if err := Sign(req, []byte("k"), MaxBody(10)); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("Sign = %v, want ErrBodyTooLarge", err)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	keys := map[string][]byte{"svc-a": []byte("secret-a")}
+	var handled int
+	h := VerifySignature(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled++
+		if err := r.ParseMultipartForm(1 << 20); err != nil || r.FormValue("title") != "report" {
+			t.Errorf("form in handler = %q, %v", r.FormValue("title"), err)
+		}
+	}), func(id string) []byte { return keys[id] }, MaxBody(1<<10))
+
+	serve := func(req *http.Request) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	req := newUpload(t, "first", "a,b\n1,2\n")
+	if err := Sign(req, keys["svc-a"], KeyID("svc-a")); err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(req); code != http.StatusOK || handled != 1 {
+		t.Errorf("signed upload: status %d, handled %d", code, handled)
+	}
+
+	tampered := newUpload(t, "first", "a,b\n1,3\n")
+	for _, k := range []string{DefaultKeyIDHeader, DefaultSignedHeadersHeader, DefaultSignatureHeader} {
+		tampered.Header.Set(k, req.Header.Get(k))
+	}
+	if code := serve(tampered); code != http.StatusUnauthorized {
+		t.Errorf("tampered upload: status %d, want 401", code)
+	}
+
+	unknown := newUpload(t, "first", "a,b\n1,2\n")
+	Sign(unknown, []byte("other"), KeyID("svc-b"))
+	if code := serve(unknown); code != http.StatusUnauthorized {
+		t.Errorf("unknown key: status %d, want 401", code)
+	}
+
+	large := newUpload(t, "first", strings.Repeat("x", 2<<10))
+	Sign(large, keys["svc-a"], KeyID("svc-a"))
+	if code := serve(large); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large upload: status %d, want 413", code)
+	}
+	if handled != 1 {
+		t.Errorf("handler ran %d times, want 1", handled)
+	}
+}
@@ -0,0 +1,59 @@
+package canonical
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// VerifySignature returns a handler that checks the signature of every
+// request before passing it to next. The secret is looked up by the key
+// ID the sender put in X-Key-Id (see KeyID); keyLookup returns nil for
+// unknown keys.
+//
+// The body is hashed while it streams through to a temporary file, so it
+// is read only once and never held in memory, and MaxBody limits the disk
+// use instead. A tampered or unsigned request is rejected with 401 before
+// next runs; next then reads the spooled copy as the request body.
+func VerifySignature(next http.Handler, keyLookup func(keyID string) []byte, opts ...Option) http.Handler {
+	cfg := newConfig(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := keyLookup(r.Header.Get(DefaultKeyIDHeader))
+		if secret == nil {
+			http.Error(w, "unknown signing key", http.StatusUnauthorized)
+			return
+		}
+		spool, err := os.CreateTemp("", "canonical-*")
+		if err != nil {
+			http.Error(w, "failed to spool body", http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		body := io.TeeReader(http.MaxBytesReader(w, r.Body, cfg.maxBody), spool)
+		err = cfg.check(r, secret, body)
+		if err == nil {
+			// The multipart reader stops at the closing boundary; keep
+			// what follows so next sees the body unchanged.
+			_, err = io.Copy(io.Discard, body)
+		}
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			http.Error(w, fmt.Sprintf("body over %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, "failed to spool body", http.StatusInternalServerError)
+			return
+		}
+		r.Body = spool
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -9,24 +11,42 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/isauran/go-std-library/http/request/multipartcheck"
+	"github.com/isauran/go-std-library/http/request/safewriter"
+	"github.com/isauran/go-std-library/http/server/httpbinlite"
 )
 
 func main() {
 	fmt.Println("=== Advanced Demonstration: Multipart Boundary Corruption ===")
 	fmt.Println()
 
+	// A local httpbin stand-in keeps the demo runnable offline
+	httpbin, err := httpbinlite.Start(context.Background())
+	if err != nil {
+		fmt.Printf("Error starting local httpbin: %v\n", err)
+		return
+	}
+	defer httpbin.Shutdown()
+	postURL := httpbin.URL() + "/post"
+
 	fmt.Println("1. First, let's see what CORRECT multipart data looks like:")
 	showCorrectMultipartStructure()
 
 	fmt.Println("\n" + strings.Repeat("=", 70) + "\n")
 
 	fmt.Println("2. Now let's see what happens with RACE CONDITIONS:")
-	demonstrateRaceCondition()
+	demonstrateRaceCondition(postURL)
 
 	fmt.Println("\n" + strings.Repeat("=", 70) + "\n")
 
 	fmt.Println("3. Finally, let's see CORRUPTED multipart boundaries:")
 	demonstrateBoundaryCorruption()
+
+	fmt.Println("\n" + strings.Repeat("=", 70) + "\n")
+
+	fmt.Println("4. Detecting the same misuse at runtime with safewriter.SafeWriter:")
+	demonstrateDetection()
 }
 
 // showCorrectMultipartStructure demonstrates what proper multipart data looks like
@@ -48,7 +68,7 @@ func showCorrectMultipartStructure() {
 }
 
 // demonstrateRaceCondition shows race conditions when multiple goroutines write
-func demonstrateRaceCondition() {
+func demonstrateRaceCondition(url string) {
 	fmt.Println("Creating io.Pipe with concurrent writers...")
 
 	pr, pw := io.Pipe()
@@ -59,7 +79,7 @@ func demonstrateRaceCondition() {
 	capturedReader := io.TeeReader(pr, &capturedData)
 
 	// Create request
-	req, _ := http.NewRequest("POST", "https://httpbin.org/post", capturedReader)
+	req, _ := http.NewRequest("POST", url, capturedReader)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 
 	var wg sync.WaitGroup
@@ -226,6 +246,14 @@ func demonstrateBoundaryCorruption() {
 		fmt.Println("  This indicates the multipart structure is corrupted!")
 	}
 
+	// Same analysis, automated: the validator pinpoints each structural problem
+	report, err := multipartcheck.Validate(strings.NewReader(corrupted), mw.Boundary())
+	if err != nil {
+		fmt.Printf("[ERROR] Validation failed: %v\n", err)
+	} else {
+		fmt.Printf("Validator report: %s\n", report)
+	}
+
 	// Show a sample of the corrupted data
 	if len(corrupted) > 0 {
 		sample := corrupted
@@ -241,6 +269,51 @@ func demonstrateBoundaryCorruption() {
 	fmt.Println("   unparseable by HTTP servers and clients!")
 }
 
+// demonstrateDetection repeats the racing writers from demonstrateBoundaryCorruption
+// through a SafeWriter, which rejects overlapping calls with ErrConcurrentWrite
+func demonstrateDetection() {
+	pr, pw := io.Pipe()
+	sw := safewriter.New(multipart.NewWriter(pw))
+
+	var captured bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Slow consumer keeps each write in flight long enough to overlap
+		buf := make([]byte, 16)
+		for {
+			n, err := pr.Read(buf)
+			captured.Write(buf[:n])
+			if err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Go(func() {
+			fieldName := fmt.Sprintf("racing_field_%d", i)
+			err := sw.WriteField(fieldName, fmt.Sprintf("Value written by goroutine %d", i))
+			if errors.Is(err, safewriter.ErrConcurrentWrite) {
+				fmt.Printf("[DETECTED] Goroutine %d rejected: %v\n", i, err)
+			} else if err != nil {
+				fmt.Printf("[ERROR] Goroutine %d failed: %v\n", i, err)
+			} else {
+				fmt.Printf("[OK] Goroutine %d wrote its field\n", i)
+			}
+		})
+	}
+	wg.Wait()
+	sw.Close()
+	pw.Close()
+	<-done
+
+	fmt.Printf("\nRejected operations: %d\n", sw.Misuses())
+	fmt.Printf("Written data is still well-formed:\n%s\n", captured.String())
+}
+
 // min helper function
 func min(a, b int) int {
 	if a < b {
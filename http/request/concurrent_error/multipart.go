@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -8,27 +9,38 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/isauran/go-std-library/http/server/httpbinlite"
 )
 
 func main() {
 	fmt.Println("=== Demonstration of io.Pipe Concurrent Write Error ===")
 	fmt.Println()
 
+	// A local httpbin stand-in keeps the demo runnable offline
+	httpbin, err := httpbinlite.Start(context.Background())
+	if err != nil {
+		fmt.Printf("Error starting local httpbin: %v\n", err)
+		return
+	}
+	defer httpbin.Shutdown()
+	postURL := httpbin.URL() + "/post"
+
 	fmt.Println("1. Showing CORRECT sequential multipart writing:")
-	demonstrateCorrectUsage()
+	demonstrateCorrectUsage(postURL)
 
 	fmt.Println("\n" + strings.Repeat("=", 60) + "\n")
 
 	fmt.Println("2. Showing INCORRECT concurrent multipart writing (will cause errors):")
-	demonstrateConcurrentError()
+	demonstrateConcurrentError(postURL)
 }
 
 // demonstrateCorrectUsage shows the proper way to write multipart data sequentially
-func demonstrateCorrectUsage() {
+func demonstrateCorrectUsage(url string) {
 	pr, pw := io.Pipe()
 
 	// Create HTTP request
-	req, err := http.NewRequest("POST", "https://httpbin.org/post", pr)
+	req, err := http.NewRequest("POST", url, pr)
 	if err != nil {
 		fmt.Printf("Error creating request: %v\n", err)
 		return
@@ -89,11 +101,11 @@ func demonstrateCorrectUsage() {
 }
 
 // demonstrateConcurrentError shows what happens when multiple goroutines write concurrently
-func demonstrateConcurrentError() {
+func demonstrateConcurrentError(url string) {
 	pr, pw := io.Pipe()
 
 	// Create HTTP request
-	req, err := http.NewRequest("POST", "https://httpbin.org/post", pr)
+	req, err := http.NewRequest("POST", url, pr)
 	if err != nil {
 		fmt.Printf("Error creating request: %v\n", err)
 		return
@@ -0,0 +1,170 @@
+// Package dedupe collapses concurrent identical HTTP requests into one
+// network call, in the manner of singleflight: while a request is in
+// flight, requests with the same method, URL and body wait for it and get
+// a copy of its response instead of being sent again. Typical for retrying
+// workers that upload the same file from several goroutines.
+//
+//	client := &http.Client{Transport: dedupe.Transport(nil)}
+//
+// The credential headers Authorization, Proxy-Authorization and Cookie
+// are part of the key, so requests made for different principals are never
+// collapsed and nobody gets a response meant for someone else. Other
+// headers are not, so requests differing only in them are collapsed. To
+// hash the body it is read into memory first; bodies over MaxBody bytes
+// are streamed and never deduplicated.
+package dedupe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// DefaultMaxBody is the largest request body deduplicated by default.
+const DefaultMaxBody = 32 << 20
+
+// credentialHeaders are the request headers identifying the caller, which
+// are hashed into the key.
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// Option configures Transport.
+type Option func(*transport)
+
+// MaxBody sets the largest request body that is buffered to be hashed.
+func MaxBody(n int64) Option {
+	return func(t *transport) { t.maxBody = n }
+}
+
+// OnShared is called with the key of a request that was answered by a call
+// already in flight, e.g. to count saved uploads.
+func OnShared(fn func(key string)) Option {
+	return func(t *transport) { t.onShared = fn }
+}
+
+// Transport returns a RoundTripper deduplicating the requests it sends
+// through base (http.DefaultTransport if nil). Responses of deduplicated
+// requests are read into memory, so every caller gets its own body. When
+// the shared call fails, for example because the context of the request
+// that started it was canceled, every caller waiting on it gets its error.
+func Transport(base http.RoundTripper, opts ...Option) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &transport{base: base, maxBody: DefaultMaxBody, calls: map[string]*call{}}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+type transport struct {
+	base     http.RoundTripper
+	maxBody  int64
+	onShared func(key string)
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is a request in flight and, once done is closed, its outcome.
+type call struct {
+	done chan struct{}
+	resp *http.Response
+	body []byte
+	err  error
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(req.Body, t.maxBody+1))
+		if err != nil {
+			req.Body.Close()
+			return nil, err
+		}
+		if int64(len(body)) > t.maxBody {
+			req = req.Clone(req.Context())
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+			return t.base.RoundTrip(req)
+		}
+		req.Body.Close()
+	}
+	key := req.Method + " " + req.URL.String() + " " + hash(req, body)
+
+	t.mu.Lock()
+	if c, ok := t.calls[key]; ok {
+		t.mu.Unlock()
+		if t.onShared != nil {
+			t.onShared(key)
+		}
+		select {
+		case <-c.done:
+			return c.response(req)
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	c := &call{done: make(chan struct{})}
+	t.calls[key] = c
+	t.mu.Unlock()
+
+	c.do(t.base, req, body)
+	t.mu.Lock()
+	delete(t.calls, key)
+	t.mu.Unlock()
+	close(c.done)
+	return c.response(req)
+}
+
+// hash returns the hex SHA-256 of body and the credential headers of req.
+// The credentials are hashed rather than put in the key as they are, since
+// OnShared hands the key out.
+func hash(req *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write(body)
+	for _, k := range credentialHeaders {
+		for _, v := range req.Header.Values(k) {
+			fmt.Fprintf(h, "\x00%s: %s", k, v)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *call) do(base http.RoundTripper, req *http.Request, body []byte) {
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	if len(body) == 0 {
+		req.Body, req.GetBody = http.NoBody, nil
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		c.err = err
+		return
+	}
+	defer resp.Body.Close()
+	c.body, c.err = io.ReadAll(resp.Body)
+	c.resp = resp
+}
+
+// response returns a copy of the shared response for req.
+func (c *call) response(req *http.Request) (*http.Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	resp := *c.resp
+	resp.Header = c.resp.Header.Clone()
+	resp.Body = io.NopCloser(bytes.NewReader(c.body))
+	resp.ContentLength = int64(len(c.body))
+	resp.Request = req
+	return &resp, nil
+}
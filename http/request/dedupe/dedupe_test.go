@@ -0,0 +1,134 @@
+package dedupe
+
+import (
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrentIdenticalRequests(t *testing.T) {
+	var hits atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		<-release
+		io.WriteString(w, "stored "+string(body))
+	}))
+	defer srv.Close()
+
+	const callers = 5
+	shared := make(chan string, callers)
+	client := &http.Client{Transport: Transport(srv.Client().Transport, OnShared(func(key string) { shared <- key }))}
+
+	var wg sync.WaitGroup
+	bodies := make([]string, callers)
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Post(srv.URL+"/upload", "text/plain", strings.NewReader("file"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			b, _ := io.ReadAll(resp.Body)
+			bodies[i] = string(b)
+		}()
+	}
+	for range callers - 1 {
+		<-shared
+	}
+	close(release)
+	wg.Wait()
+
+	if hits.Load() != 1 {
+		t.Errorf("server saw %d requests, want 1", hits.Load())
+	}
+	for i, b := range bodies {
+		if b != "stored file" {
+			t.Errorf("caller %d got %q", i, b)
+		}
+	}
+}
+
+func TestDistinctRequests(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		io.Copy(w, r.Body)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: Transport(srv.Client().Transport, MaxBody(4))}
+	for _, body := range []string{"a", "b", "too large"} {
+		resp, err := client.Post(srv.URL, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(got) != body {
+			t.Errorf("response = %q, want %q", got, body)
+		}
+	}
+	if hits.Load() != 3 {
+		t.Errorf("server saw %d requests, want 3", hits.Load())
+	}
+}
+
+func TestCredentialsSplitKey(t *testing.T) {
+	const callers = 3
+	var hits atomic.Int32
+	arrived := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == callers {
+			close(arrived)
+		}
+		// Hold every call until all callers are in flight, so collapsed
+		// calls would show.
+		select {
+		case <-arrived:
+		case <-time.After(time.Second):
+		}
+		io.WriteString(w, r.Header.Get("Authorization")+r.Header.Get("Cookie"))
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: Transport(srv.Client().Transport, OnShared(func(key string) {
+		t.Errorf("request for another principal shared call %s", key)
+	}))}
+	creds := []http.Header{
+		{"Authorization": {"Bearer alice"}},
+		{"Authorization": {"Bearer bob"}},
+		{"Cookie": {"session=carol"}},
+	}
+	var wg sync.WaitGroup
+	for _, h := range creds {
+		wg.Go(func() {
+			req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("file"))
+			maps.Copy(req.Header, h)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			b, _ := io.ReadAll(resp.Body)
+			if want := h.Get("Authorization") + h.Get("Cookie"); string(b) != want {
+				t.Errorf("response = %q, want %q", b, want)
+			}
+		})
+	}
+	wg.Wait()
+	if hits.Load() != callers {
+		t.Errorf("server saw %d requests, want %d", hits.Load(), callers)
+	}
+}
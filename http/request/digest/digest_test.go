@@ -0,0 +1,94 @@
+package digest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTransportVerify(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		where := "header"
+		if r.Header.Get(ContentDigest) == "" && r.Trailer.Get(ReprDigest) != "" {
+			where = "trailer"
+		}
+		got = append(got, string(body)+" in "+where)
+	}), Require()))
+	defer srv.Close()
+	client := &http.Client{Transport: Transport(nil, Repr())}
+
+	// Known length with GetBody: the digest goes in a header.
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("header digest: status %d", resp.StatusCode)
+	}
+
+	// Streamed body: the digest goes in a trailer.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("streamed "))
+		pw.Write([]byte("body"))
+		pw.Close()
+	}()
+	resp, err = client.Post(srv.URL, "text/plain", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("trailer digest: status %d", resp.StatusCode)
+	}
+	if strings.Join(got, "|") != "hello in header|streamed body in trailer" {
+		t.Errorf("handler got %q", got)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	h := Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler ran for a rejected body")
+	}), Require(), MaxBody(16))
+	sum := sha256.Sum256([]byte("original"))
+
+	for name, req := range map[string]*http.Request{
+		"mismatch": func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("tampered"))
+			r.Header.Set(ContentDigest, format(SHA256, sum[:]))
+			return r
+		}(),
+		"missing": httptest.NewRequest(http.MethodPost, "/", strings.NewReader("original")),
+		"unknown algorithm": func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("original"))
+			r.Header.Set(ContentDigest, "md5=:AAAA:")
+			return r
+		}(),
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(make([]byte, 64))))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body: status %d, want 413", rec.Code)
+	}
+}
+
+func TestParse(t *testing.T) {
+	sums := parse(`sha-256=:` + "YWJj" + `:, SHA-512=:ZGVm:, broken=abc`)
+	if string(sums[SHA256]) != "abc" || string(sums[SHA512]) != "def" || len(sums) != 2 {
+		t.Errorf("parse = %q", sums)
+	}
+}
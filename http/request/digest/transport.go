@@ -0,0 +1,80 @@
+package digest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Transport returns a RoundTripper that sends the Content-Digest of every
+// request body through base; nil means http.DefaultTransport. Requests
+// that already carry a Content-Digest are passed on unchanged.
+func Transport(base http.RoundTripper, opts ...Option) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base, cfg: newConfig(opts)}
+}
+
+type transport struct {
+	base http.RoundTripper
+	cfg  config
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.Header.Get(ContentDigest) != "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	names := []string{ContentDigest}
+	if t.cfg.repr && req.Header.Get("Content-Encoding") == "" {
+		names = append(names, ReprDigest)
+	}
+	newHash := algorithms[t.cfg.algorithm]
+
+	if req.ContentLength > 0 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			req.Body.Close()
+			return nil, fmt.Errorf("failed to read body for digest: %w", err)
+		}
+		h := newHash()
+		_, err = io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			req.Body.Close()
+			return nil, fmt.Errorf("failed to read body for digest: %w", err)
+		}
+		for _, name := range names {
+			req.Header.Set(name, format(t.cfg.algorithm, h.Sum(nil)))
+		}
+		return t.base.RoundTrip(req)
+	}
+
+	// Unknown length: send chunked and fill in the trailer at EOF.
+	if req.Trailer == nil {
+		req.Trailer = http.Header{}
+	}
+	for _, name := range names {
+		req.Trailer[name] = nil
+	}
+	wrap := func(body io.ReadCloser) io.ReadCloser {
+		return &hashingBody{ReadCloser: body, h: newHash(), done: func(sum []byte) {
+			for _, name := range names {
+				req.Trailer.Set(name, format(t.cfg.algorithm, sum))
+			}
+		}}
+	}
+	req.ContentLength = -1
+	req.Body = wrap(req.Body)
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return wrap(body), nil
+		}
+	}
+	return t.base.RoundTrip(req)
+}
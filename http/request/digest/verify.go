@@ -0,0 +1,89 @@
+package digest
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+)
+
+// Verify returns a handler that checks the Content-Digest of every request
+// body, and its Repr-Digest when the body has no Content-Encoding, before
+// passing the request to next. Digests may come as headers or trailers.
+//
+// The body is hashed while it streams to a temporary file, since a trailer
+// is only known once the whole body was read. A mismatch, or a missing
+// digest with Require, is rejected with 400 and a body over MaxBody with
+// 413, before next runs; next then reads the spooled copy as the body.
+// Digests with algorithms other than SHA256 and SHA512 are ignored.
+func Verify(next http.Handler, opts ...Option) http.Handler {
+	cfg := newConfig(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spool, err := os.CreateTemp("", "digest-*")
+		if err != nil {
+			http.Error(w, "failed to spool body", http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		hashes := map[string]hash.Hash{}
+		writers := []io.Writer{spool}
+		for alg, newHash := range algorithms {
+			hashes[alg] = newHash()
+			writers = append(writers, hashes[alg])
+		}
+		_, err = io.Copy(io.MultiWriter(writers...), http.MaxBytesReader(w, r.Body, cfg.maxBody))
+		if err == nil {
+			err = cfg.verify(r, hashes)
+		}
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			http.Error(w, fmt.Sprintf("body over %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		case errors.Is(err, ErrMismatch), errors.Is(err, ErrMissing):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, "failed to spool body", http.StatusInternalServerError)
+			return
+		}
+		r.Body = spool
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verify checks the digest fields of r, read in full, against hashes.
+func (c *config) verify(r *http.Request, hashes map[string]hash.Hash) error {
+	sums := map[string][]byte{}
+	for alg, h := range hashes {
+		sums[alg] = h.Sum(nil)
+	}
+	names := []string{ContentDigest}
+	if r.Header.Get("Content-Encoding") == "" {
+		names = append(names, ReprDigest)
+	}
+	checked := false
+	for _, name := range names {
+		field := r.Header.Get(name)
+		if field == "" {
+			field = r.Trailer.Get(name)
+		}
+		ok, err := check(field, sums)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		checked = checked || ok
+	}
+	if !checked && c.require {
+		return ErrMissing
+	}
+	return nil
+}
@@ -0,0 +1,439 @@
+// Package download fetches a URL into a file, the counterpart of the
+// streaming upload builder: the body is streamed to disk, interrupted
+// downloads resume with Range requests, large files can be fetched over
+// several connections at once, and the file only appears under its final
+// name once it is complete and verified.
+//
+//	err := download.New(ctx, client, url, "image.iso").
+//		Resume().
+//		Connections(4).
+//		Checksum(sha256.New(), want).
+//		Progress(func(done, total int64) { ... }).
+//		Fetch()
+//
+// GetRange and RangeAssembler fetch parts of a URL into memory instead.
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PartSuffix is appended to the destination path for the file being
+// downloaded. It is renamed to the destination once complete.
+const PartSuffix = ".part"
+
+// validatorSuffix is appended to the partial file's path for the file
+// holding the ETag or Last-Modified of the content being downloaded.
+const validatorSuffix = ".validator"
+
+// ErrChecksum is returned by Fetch when the downloaded content does not
+// match the expected checksum.
+var ErrChecksum = errors.New("download: checksum mismatch")
+
+// Download is a download being configured. Options only record settings;
+// nothing is fetched until Fetch.
+type Download struct {
+	ctx         context.Context
+	client      *http.Client
+	url         string
+	path        string
+	header      http.Header
+	resume      bool
+	connections int
+	hash        hash.Hash
+	sum         []byte
+	progress    func(done, total int64)
+}
+
+// New returns a download of url into the file at path. A nil client means
+// http.DefaultClient.
+func New(ctx context.Context, client *http.Client, url, path string) *Download {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Download{
+		ctx:         ctx,
+		client:      client,
+		url:         url,
+		path:        path,
+		header:      http.Header{},
+		connections: 1,
+	}
+}
+
+// Header adds a header to every request of the download.
+func (d *Download) Header(key, value string) *Download {
+	d.header.Add(key, value)
+	return d
+}
+
+// Resume continues from a partial file left by an earlier failed Fetch
+// instead of starting over, and keeps the partial file when this Fetch
+// fails too. The ETag or Last-Modified of the content is kept next to the
+// partial file and sent as If-Range, so a file changed on the server is
+// downloaded again instead of being stitched onto old content. Servers that
+// ignore the Range header send the whole content again, which replaces the
+// partial file. Only single-connection downloads
+// resume; segmented ones always start from scratch.
+func (d *Download) Resume() *Download {
+	d.resume = true
+	return d
+}
+
+// Connections fetches the content in n segments over parallel connections
+// when the server advertises byte ranges and a length. Otherwise, and for
+// n <= 1, a single connection is used.
+func (d *Download) Connections(n int) *Download {
+	d.connections = max(n, 1)
+	return d
+}
+
+// Checksum verifies the complete file with h against sum before it is
+// renamed into place. On a mismatch the partial file is removed and Fetch
+// returns ErrChecksum.
+func (d *Download) Checksum(h hash.Hash, sum []byte) *Download {
+	d.hash, d.sum = h, bytes.Clone(sum)
+	return d
+}
+
+// Progress calls fn as data arrives with the bytes on disk so far, resumed
+// ones included, and the total size or -1 if it is unknown. Calls are
+// serialized even when several connections are in use.
+func (d *Download) Progress(fn func(done, total int64)) *Download {
+	d.progress = fn
+	return d
+}
+
+// Fetch runs the download and renames the file to its destination once it
+// is complete and its checksum, if any, matches.
+func (d *Download) Fetch() (err error) {
+	part := d.path + PartSuffix
+	defer func() {
+		if err != nil && (!d.resume || errors.Is(err, ErrChecksum)) {
+			os.Remove(part)
+			os.Remove(part + validatorSuffix)
+		}
+	}()
+
+	size := int64(-1)
+	if d.connections > 1 {
+		size, err = d.probe()
+		if err != nil {
+			return err
+		}
+	}
+	if size > 0 {
+		err = d.fetchSegments(part, size)
+	} else {
+		err = d.fetchSingle(part)
+	}
+	if err != nil {
+		return err
+	}
+	if d.hash != nil {
+		if err := d.verify(part); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(part, d.path); err != nil {
+		return err
+	}
+	os.Remove(part + validatorSuffix)
+	return nil
+}
+
+// probe asks for the size of the content with a HEAD request. It returns
+// -1 when the server does not support ranges or does not tell the length.
+func (d *Download) probe() (int64, error) {
+	resp, err := d.do(http.MethodHead, "")
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" {
+		return -1, nil
+	}
+	return resp.ContentLength, nil
+}
+
+func (d *Download) do(method, byteRange string) (*http.Response, error) {
+	return d.doIf(method, byteRange, "")
+}
+
+// doIf is do with an If-Range validator, if any.
+func (d *Download) doIf(method, byteRange, ifRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(d.ctx, method, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range d.header {
+		req.Header[k] = v
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", "bytes="+byteRange)
+	}
+	if ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+	return d.client.Do(req)
+}
+
+// fetchSingle downloads over one connection, continuing the partial file
+// when resuming.
+func (d *Download) fetchSingle(part string) error {
+	flags := os.O_CREATE | os.O_WRONLY
+	if !d.resume {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(part, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	byteRange, ifRange := "", ""
+	if offset > 0 {
+		byteRange = strconv.FormatInt(offset, 10) + "-"
+		if b, err := os.ReadFile(part + validatorSuffix); err == nil {
+			ifRange = string(b)
+		}
+	}
+	resp, err := d.doIf(http.MethodGet, byteRange, ifRange)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	total := resp.ContentLength
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// A full reply: asked for, the range was ignored, or the content
+		// changed since the partial file was written.
+		if err := saveValidator(part, resp); err != nil {
+			return err
+		}
+		if offset > 0 {
+			if err := f.Truncate(0); err != nil {
+				return err
+			}
+			if offset, err = f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		start, _, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return err
+		}
+		if start != offset {
+			return fmt.Errorf("download: server resumed at byte %d, want %d", start, offset)
+		}
+		total = size
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file may already hold everything.
+		if _, _, size, err := parseContentRange(resp.Header.Get("Content-Range")); err == nil && size == offset {
+			newCounter(d.progress, offset, size).add(0)
+			return f.Close()
+		}
+		return statusError(resp)
+	default:
+		return statusError(resp)
+	}
+
+	c := newCounter(d.progress, offset, total)
+	if _, err := io.Copy(&countingWriter{w: f, c: c}, resp.Body); err != nil {
+		return err
+	}
+	if total >= 0 && c.done != total {
+		return fmt.Errorf("download: got %d of %d bytes", c.done, total)
+	}
+	return f.Close()
+}
+
+// saveValidator keeps the strong ETag of resp, or else its Last-Modified,
+// next to the partial file for the If-Range of a later resume.
+func saveValidator(part string, resp *http.Response) error {
+	v := resp.Header.Get("ETag")
+	if v == "" || strings.HasPrefix(v, "W/") {
+		v = resp.Header.Get("Last-Modified")
+	}
+	if v == "" {
+		if err := os.Remove(part + validatorSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(part+validatorSuffix, []byte(v), 0o644)
+}
+
+// fetchSegments downloads size bytes as ranges over parallel connections,
+// writing each at its offset. The first failure cancels the others.
+func (d *Download) fetchSegments(part string, size int64) error {
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	// Segmented downloads do not resume; a validator left by a single
+	// connection download no longer describes the file.
+	os.Remove(part + validatorSuffix)
+
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+	seg := *d
+	seg.ctx = ctx
+
+	c := newCounter(d.progress, 0, size)
+	n := int64(min(d.connections, int(size)))
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i := range n {
+		start, end := size*i/n, size*(i+1)/n
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := seg.fetchRange(f, c, start, end); err != nil {
+				errOnce.Do(func() { firstErr = err; cancel() })
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return f.Close()
+}
+
+// fetchRange downloads bytes [start, end) into f.
+func (d *Download) fetchRange(f *os.File, c *counter, start, end int64) error {
+	resp, err := d.do(http.MethodGet, fmt.Sprintf("%d-%d", start, end-1))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return statusError(resp)
+	}
+	if got, _, _, err := parseContentRange(resp.Header.Get("Content-Range")); err != nil {
+		return err
+	} else if got != start {
+		return fmt.Errorf("download: server sent range at byte %d, want %d", got, start)
+	}
+	w := &countingWriter{w: io.NewOffsetWriter(f, start), c: c}
+	n, err := io.Copy(w, io.LimitReader(resp.Body, end-start))
+	if err != nil {
+		return err
+	}
+	if n != end-start {
+		return fmt.Errorf("download: range at %d: got %d of %d bytes", start, n, end-start)
+	}
+	return nil
+}
+
+// verify hashes the file at path and compares it with the expected sum.
+func (d *Download) verify(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	d.hash.Reset()
+	if _, err := io.Copy(d.hash, f); err != nil {
+		return err
+	}
+	if got := d.hash.Sum(nil); !bytes.Equal(got, d.sum) {
+		return fmt.Errorf("%w: got %x, want %x", ErrChecksum, got, d.sum)
+	}
+	return nil
+}
+
+func statusError(resp *http.Response) error {
+	return fmt.Errorf("download: %s: %s", resp.Request.URL, resp.Status)
+}
+
+// parseContentRange parses "bytes start-end/size" and "bytes */size". size
+// is -1 when the server sends "*".
+func parseContentRange(s string) (start, end, size int64, err error) {
+	bad := fmt.Errorf("download: invalid Content-Range %q", s)
+	spec, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, 0, 0, bad
+	}
+	rng, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, bad
+	}
+	size = -1
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, 0, bad
+		}
+	}
+	if rng == "*" {
+		return -1, -1, size, nil
+	}
+	first, last, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, 0, bad
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, 0, bad
+	}
+	if end, err = strconv.ParseInt(last, 10, 64); err != nil {
+		return 0, 0, 0, bad
+	}
+	return start, end, size, nil
+}
+
+// counter tracks the bytes on disk and reports them to the progress
+// callback, one call at a time.
+type counter struct {
+	mu    sync.Mutex
+	fn    func(done, total int64)
+	done  int64
+	total int64
+}
+
+func newCounter(fn func(done, total int64), done, total int64) *counter {
+	return &counter{fn: fn, done: done, total: total}
+}
+
+func (c *counter) add(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done += n
+	if c.fn != nil {
+		c.fn(c.done, c.total)
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	c *counter
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.c.add(int64(n))
+	return n, err
+}
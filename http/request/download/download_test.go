@@ -0,0 +1,298 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var content = []byte(strings.Repeat("0123456789abcdef", 4096))
+
+// server serves content with range support and records the Range header
+// of every GET.
+type server struct {
+	*httptest.Server
+	mu     sync.Mutex
+	ranges []string
+}
+
+func newServer(t *testing.T, ranges bool) *server {
+	s := &server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			s.mu.Lock()
+			s.ranges = append(s.ranges, r.Header.Get("Range"))
+			s.mu.Unlock()
+		}
+		if !ranges {
+			r.Header.Del("Range")
+			w.Write(content)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *server) gets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+func checkFile(t *testing.T, path string) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("file has %d bytes, differs from the %d served", len(got), len(content))
+	}
+	if _, err := os.Stat(path + PartSuffix); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	srv := newServer(t, true)
+	path := filepath.Join(t.TempDir(), "file")
+	var last, total int64
+	sum := sha256.Sum256(content)
+	err := New(context.Background(), srv.Client(), srv.URL, path).
+		Checksum(sha256.New(), sum[:]).
+		Progress(func(done, size int64) { last, total = done, size }).
+		Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path)
+	if last != int64(len(content)) || total != int64(len(content)) {
+		t.Errorf("last progress = %d/%d", last, total)
+	}
+}
+
+func TestFetchResume(t *testing.T) {
+	srv := newServer(t, true)
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path+PartSuffix, content[:1000], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var first int64 = -1
+	err := New(context.Background(), srv.Client(), srv.URL, path).
+		Resume().
+		Progress(func(done, _ int64) {
+			if first < 0 {
+				first = done
+			}
+		}).
+		Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path)
+	if got := srv.gets(); len(got) != 1 || got[0] != "bytes=1000-" {
+		t.Errorf("requests = %q", got)
+	}
+	if first <= 1000 {
+		t.Errorf("first progress = %d, want resumed bytes included", first)
+	}
+}
+
+func TestFetchResumeComplete(t *testing.T) {
+	srv := newServer(t, true)
+	path := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(path+PartSuffix, content, 0o644)
+	if err := New(context.Background(), srv.Client(), srv.URL, path).Resume().Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path)
+}
+
+func TestFetchResumeIgnored(t *testing.T) {
+	srv := newServer(t, false)
+	path := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(path+PartSuffix, []byte("stale partial content"), 0o644)
+	if err := New(context.Background(), srv.Client(), srv.URL, path).Resume().Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path)
+}
+
+func TestFetchResumeChanged(t *testing.T) {
+	var ifRanges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifRanges = append(ifRanges, r.Header.Get("If-Range"))
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		etag    string
+		partial []byte
+	}{
+		{`"v1"`, bytes.Repeat([]byte("x"), 1000)}, // changed: start over
+		{`"v2"`, content[:1000]},                  // unchanged: resume
+	} {
+		ifRanges = nil
+		path := filepath.Join(t.TempDir(), "file")
+		os.WriteFile(path+PartSuffix, tt.partial, 0o644)
+		os.WriteFile(path+PartSuffix+validatorSuffix, []byte(tt.etag), 0o644)
+		if err := New(context.Background(), srv.Client(), srv.URL, path).Resume().Fetch(); err != nil {
+			t.Fatal(err)
+		}
+		checkFile(t, path)
+		if len(ifRanges) != 1 || ifRanges[0] != tt.etag {
+			t.Errorf("%s: If-Range = %q", tt.etag, ifRanges)
+		}
+		if _, err := os.Stat(path + PartSuffix + validatorSuffix); !os.IsNotExist(err) {
+			t.Errorf("%s: validator left behind: %v", tt.etag, err)
+		}
+	}
+
+	// An interrupted download keeps the validator for the next resume.
+	short := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content[:1000])
+	}))
+	defer short.Close()
+	path := filepath.Join(t.TempDir(), "file")
+	if err := New(context.Background(), short.Client(), short.URL, path).Resume().Fetch(); err == nil {
+		t.Fatal("Fetch of a cut-off body succeeded")
+	}
+	if v, _ := os.ReadFile(path + PartSuffix + validatorSuffix); string(v) != `"v2"` {
+		t.Errorf("validator = %q, want the ETag", v)
+	}
+}
+
+func TestFetchSegments(t *testing.T) {
+	srv := newServer(t, true)
+	path := filepath.Join(t.TempDir(), "file")
+	var mu sync.Mutex
+	var last int64
+	err := New(context.Background(), srv.Client(), srv.URL, path).
+		Connections(4).
+		Progress(func(done, _ int64) {
+			mu.Lock()
+			last = max(last, done)
+			mu.Unlock()
+		}).
+		Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path)
+	if got := srv.gets(); len(got) != 4 {
+		t.Errorf("requests = %q, want 4 ranges", got)
+	}
+	if last != int64(len(content)) {
+		t.Errorf("last progress = %d", last)
+	}
+}
+
+func TestFetchSegmentsFallback(t *testing.T) {
+	srv := newServer(t, false)
+	path := filepath.Join(t.TempDir(), "file")
+	if err := New(context.Background(), srv.Client(), srv.URL, path).Connections(4).Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path)
+	if got := srv.gets(); len(got) != 1 || got[0] != "" {
+		t.Errorf("requests = %q, want one plain GET", got)
+	}
+}
+
+func TestFetchChecksumMismatch(t *testing.T) {
+	srv := newServer(t, true)
+	path := filepath.Join(t.TempDir(), "file")
+	err := New(context.Background(), srv.Client(), srv.URL, path).
+		Resume().
+		Checksum(sha256.New(), make([]byte, sha256.Size)).
+		Fetch()
+	if !errors.Is(err, ErrChecksum) {
+		t.Fatalf("err = %v", err)
+	}
+	for _, p := range []string{path, path + PartSuffix} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s exists after checksum mismatch", filepath.Base(p))
+		}
+	}
+}
+
+func TestFetchStatus(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "file")
+	err := New(context.Background(), srv.Client(), srv.URL, path).Fetch()
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := os.Stat(path + PartSuffix); !os.IsNotExist(err) {
+		t.Error("partial file left behind without Resume")
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	for _, ranges := range []bool{true, false} {
+		srv := newServer(t, ranges)
+		body, err := GetRange(context.Background(), srv.Client(), srv.URL, 16, 35)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content[16:36]) {
+			t.Errorf("ranges=%v: got %q, want %q", ranges, got, content[16:36])
+		}
+	}
+}
+
+func TestRangeAssembler(t *testing.T) {
+	size := int64(len(content))
+	for _, ranges := range []bool{true, false} {
+		srv := newServer(t, ranges)
+		a := NewRangeAssembler(context.Background(), srv.Client(), srv.URL).
+			Add(100, 109).
+			Add(0, 3).
+			Add(105, 120). // overlaps the first
+			Add(size-5, -1)
+		got, err := a.Fetch()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := [][]byte{content[100:110], content[0:4], content[105:121], content[size-5:]}
+		for i := range want {
+			if !bytes.Equal(got[i], want[i]) {
+				t.Errorf("ranges=%v: range %d = %q, want %q", ranges, i, got[i], want[i])
+			}
+		}
+		if gets := srv.gets(); len(gets) != 1 {
+			t.Errorf("ranges=%v: requests %q, want one", ranges, gets)
+		}
+
+		var buf bytes.Buffer
+		if _, err := NewRangeAssembler(context.Background(), srv.Client(), srv.URL).Add(4, 7).Add(0, 3).WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if want := string(content[4:8]) + string(content[0:4]); buf.String() != want {
+			t.Errorf("ranges=%v: WriteTo = %q, want %q", ranges, buf.String(), want)
+		}
+	}
+}
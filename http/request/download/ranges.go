@@ -0,0 +1,276 @@
+package download
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// GetRange fetches bytes from to to, inclusive, of url, or from from to the
+// end when to is negative. The body of a server that ignores the Range
+// header is cut down to the range. The caller must close the returned
+// reader. A nil client means http.DefaultClient.
+func GetRange(ctx context.Context, client *http.Client, url string, from, to int64) (io.ReadCloser, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	d := &Download{ctx: ctx, client: client, url: url}
+	resp, err := d.do(http.MethodGet, Range{From: from, To: to}.String())
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, _, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err == nil && start != from {
+			err = fmt.Errorf("download: server sent range at byte %d, want %d", start, from)
+		}
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	case http.StatusOK:
+		if _, err := io.CopyN(io.Discard, resp.Body, from); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("download: skipping to byte %d: %w", from, err)
+		}
+	default:
+		resp.Body.Close()
+		return nil, statusError(resp)
+	}
+	if to < 0 {
+		return resp.Body, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, to-from+1), resp.Body}, nil
+}
+
+// Range is the byte range from From to To, inclusive. A negative To means
+// up to the end of the content.
+type Range struct {
+	From, To int64
+}
+
+// String returns r in the syntax of the Range header, without "bytes=".
+func (r Range) String() string {
+	if r.To < 0 {
+		return strconv.FormatInt(r.From, 10) + "-"
+	}
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+// RangeAssembler fetches several ranges of a URL in one request, which the
+// server may answer with a multipart/byteranges body, a single coalesced
+// range or the whole content, and returns them in the order they were
+// added. Ranges the reply lacks are fetched one by one with GetRange. The
+// ranges are held in memory.
+type RangeAssembler struct {
+	d      *Download
+	ranges []Range
+}
+
+// NewRangeAssembler returns an assembler of ranges of url. A nil client
+// means http.DefaultClient.
+func NewRangeAssembler(ctx context.Context, client *http.Client, url string) *RangeAssembler {
+	return &RangeAssembler{d: New(ctx, client, url, "")}
+}
+
+// Header adds a header to every request of the assembler.
+func (a *RangeAssembler) Header(key, value string) *RangeAssembler {
+	a.d.Header(key, value)
+	return a
+}
+
+// Add adds the range from to to, inclusive, or from from to the end when to
+// is negative.
+func (a *RangeAssembler) Add(from, to int64) *RangeAssembler {
+	a.ranges = append(a.ranges, Range{From: from, To: to})
+	return a
+}
+
+// Fetch returns the content of every range, in the order they were added.
+func (a *RangeAssembler) Fetch() ([][]byte, error) {
+	if len(a.ranges) == 0 {
+		return nil, nil
+	}
+	specs := make([]string, len(a.ranges))
+	for i, r := range a.ranges {
+		if r.From < 0 || (r.To >= 0 && r.To < r.From) {
+			return nil, fmt.Errorf("download: invalid range %s", r)
+		}
+		specs[i] = r.String()
+	}
+	resp, err := a.d.do(http.MethodGet, strings.Join(specs, ","))
+	if err != nil {
+		return nil, err
+	}
+	pieces, size, err := a.readPieces(resp)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([][]byte, len(a.ranges))
+	for i, r := range a.ranges {
+		if data, ok := cut(pieces, size, r); ok {
+			out[i] = data
+			continue
+		}
+		body, err := GetRange(a.d.ctx, a.d.client, a.d.url, r.From, r.To)
+		if err != nil {
+			return nil, err
+		}
+		out[i], err = io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// WriteTo writes the ranges to w one after the other, in the order they
+// were added.
+func (a *RangeAssembler) WriteTo(w io.Writer) (int64, error) {
+	parts, err := a.Fetch()
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, p := range parts {
+		m, err := w.Write(p)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// piece is content starting at byte start.
+type piece struct {
+	start int64
+	data  []byte
+}
+
+// readPieces reads the pieces of content in resp and the content size, -1
+// if unknown.
+func (a *RangeAssembler) readPieces(resp *http.Response) ([]piece, int64, error) {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return a.extract(resp.Body, resp.ContentLength)
+	case http.StatusPartialContent:
+	default:
+		return nil, 0, statusError(resp)
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "multipart/byteranges" {
+		p, size, err := readPiece(resp.Header.Get("Content-Range"), resp.Body)
+		return []piece{p}, size, err
+	}
+	var pieces []piece
+	size := int64(-1)
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return pieces, size, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("download: reading byteranges: %w", err)
+		}
+		p, s, err := readPiece(part.Header.Get("Content-Range"), part)
+		if err != nil {
+			return nil, 0, err
+		}
+		pieces, size = append(pieces, p), s
+	}
+}
+
+// readPiece reads the content of a range with the Content-Range cr.
+func readPiece(cr string, r io.Reader) (piece, int64, error) {
+	start, end, size, err := parseContentRange(cr)
+	if err != nil {
+		return piece{}, 0, err
+	}
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return piece{}, 0, fmt.Errorf("download: range at %d: %w", start, err)
+	}
+	return piece{start: start, data: data}, size, nil
+}
+
+// extract reads the whole content from r and keeps the spans covering the
+// ranges of a.
+func (a *RangeAssembler) extract(r io.Reader, size int64) ([]piece, int64, error) {
+	sorted := slices.SortedFunc(slices.Values(a.ranges), func(x, y Range) int { return cmp.Compare(x.From, y.From) })
+	var spans []Range // merged, by From
+	for _, rg := range sorted {
+		if n := len(spans); n > 0 && (spans[n-1].To < 0 || rg.From <= spans[n-1].To+1) {
+			if spans[n-1].To >= 0 && (rg.To < 0 || rg.To > spans[n-1].To) {
+				spans[n-1].To = rg.To
+			}
+			continue
+		}
+		spans = append(spans, rg)
+	}
+
+	var pieces []piece
+	var offset int64
+	for _, s := range spans {
+		if _, err := io.CopyN(io.Discard, r, s.From-offset); err != nil {
+			return nil, 0, fmt.Errorf("download: skipping to byte %d: %w", s.From, err)
+		}
+		var data []byte
+		var err error
+		if s.To < 0 {
+			data, err = io.ReadAll(r)
+			size = s.From + int64(len(data))
+		} else {
+			data = make([]byte, s.To-s.From+1)
+			var n int
+			n, err = io.ReadFull(r, data)
+			data = data[:n]
+			if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+				err = nil // the content ends inside the span
+			}
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		pieces = append(pieces, piece{start: s.From, data: data})
+		offset = s.From + int64(len(data))
+	}
+	return pieces, size, nil
+}
+
+// cut returns the content of r from the piece holding all of it.
+func cut(pieces []piece, size int64, r Range) ([]byte, bool) {
+	for _, p := range pieces {
+		end := p.start + int64(len(p.data)) // exclusive
+		if r.From < p.start || r.From >= end {
+			continue
+		}
+		if r.To < 0 {
+			if size >= 0 && end == size {
+				return p.data[r.From-p.start:], true
+			}
+			continue
+		}
+		if r.To < end {
+			return p.data[r.From-p.start : r.To-p.start+1], true
+		}
+	}
+	return nil, false
+}
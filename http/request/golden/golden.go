@@ -0,0 +1,204 @@
+// Package golden compares generated multipart bodies with snapshots kept in
+// testdata. Run the tests with -update to (re)write the snapshots.
+//
+// Mismatches are reported per part and per header instead of as one opaque
+// byte diff, so a changed Content-Type or a reordered field is obvious at a
+// glance. Bodies must be generated with a fixed boundary.
+package golden
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// Dir is where golden files are kept, relative to the test's package.
+var Dir = "testdata"
+
+// Path returns the golden file path for name.
+func Path(name string) string {
+	return filepath.Join(Dir, name+".golden")
+}
+
+// AssertMultipart compares body with the golden file for name. With -update
+// the file is written instead. Differences are reported part by part.
+func AssertMultipart(t testing.TB, name string, body []byte, boundary string) {
+	t.Helper()
+	want, ok := load(t, name, body)
+	if !ok {
+		return
+	}
+	if bytes.Equal(body, want) {
+		return
+	}
+	diffs := Diff(body, want, boundary)
+	if len(diffs) == 0 {
+		diffs = []string{"bodies differ outside the parts (preamble, epilogue or line endings)"}
+	}
+	t.Errorf("%s: body does not match golden file (run with -update to accept):\n  %s",
+		Path(name), strings.Join(diffs, "\n  "))
+}
+
+// AssertBytes compares b with the golden file for name byte for byte,
+// reporting the first differing line.
+func AssertBytes(t testing.TB, name string, b []byte) {
+	t.Helper()
+	want, ok := load(t, name, b)
+	if !ok || bytes.Equal(b, want) {
+		return
+	}
+	t.Errorf("%s: output does not match golden file (run with -update to accept):\n  %s",
+		Path(name), lineDiff("", b, want))
+}
+
+// load returns the golden content, writing got first when updating.
+func load(t testing.TB, name string, got []byte) ([]byte, bool) {
+	t.Helper()
+	path := Path(name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return nil, false
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+		return nil, false
+	}
+	return want, true
+}
+
+type part struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+func (p part) String() string {
+	name := p.header.Get("Content-Disposition")
+	if name == "" {
+		return "part"
+	}
+	return fmt.Sprintf("part [%s]", name)
+}
+
+func parse(body []byte, boundary string) ([]part, error) {
+	var parts []part
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, err
+		}
+		b, err := io.ReadAll(p)
+		if err != nil {
+			return parts, err
+		}
+		parts = append(parts, part{header: p.Header, body: b})
+	}
+}
+
+// Diff describes how got differs from want, one line per difference: part
+// counts, headers added, removed or changed, and the first differing line
+// of each part body. If either body cannot be parsed the first differing
+// line of the raw bodies is reported.
+func Diff(got, want []byte, boundary string) []string {
+	gotParts, gotErr := parse(got, boundary)
+	wantParts, wantErr := parse(want, boundary)
+	if gotErr != nil || wantErr != nil {
+		var diffs []string
+		if gotErr != nil {
+			diffs = append(diffs, fmt.Sprintf("got: malformed multipart after %d parts: %v", len(gotParts), gotErr))
+		}
+		if wantErr != nil {
+			diffs = append(diffs, fmt.Sprintf("want: malformed multipart after %d parts: %v", len(wantParts), wantErr))
+		}
+		return append(diffs, lineDiff("", got, want))
+	}
+
+	var diffs []string
+	if len(gotParts) != len(wantParts) {
+		diffs = append(diffs, fmt.Sprintf("got %d parts, want %d", len(gotParts), len(wantParts)))
+	}
+	for i := 0; i < max(len(gotParts), len(wantParts)); i++ {
+		switch {
+		case i >= len(gotParts):
+			diffs = append(diffs, fmt.Sprintf("part %d: missing, want %s", i+1, wantParts[i]))
+			continue
+		case i >= len(wantParts):
+			diffs = append(diffs, fmt.Sprintf("part %d: unexpected %s", i+1, gotParts[i]))
+			continue
+		}
+		g, w := gotParts[i], wantParts[i]
+		prefix := fmt.Sprintf("part %d: ", i+1)
+		diffs = append(diffs, headerDiff(prefix, g.header, w.header)...)
+		if !bytes.Equal(g.body, w.body) {
+			diffs = append(diffs, lineDiff(prefix, g.body, w.body))
+		}
+	}
+	return diffs
+}
+
+func headerDiff(prefix string, got, want textproto.MIMEHeader) []string {
+	keys := map[string]bool{}
+	for k := range got {
+		keys[k] = true
+	}
+	for k := range want {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var diffs []string
+	for _, k := range sorted {
+		g, w := strings.Join(got[k], ", "), strings.Join(want[k], ", ")
+		switch {
+		case got[k] == nil:
+			diffs = append(diffs, fmt.Sprintf("%sheader %s: missing, want %q", prefix, k, w))
+		case want[k] == nil:
+			diffs = append(diffs, fmt.Sprintf("%sheader %s: unexpected %q", prefix, k, g))
+		case g != w:
+			diffs = append(diffs, fmt.Sprintf("%sheader %s: got %q, want %q", prefix, k, g, w))
+		}
+	}
+	return diffs
+}
+
+// lineDiff reports the first line where got and want differ.
+func lineDiff(prefix string, got, want []byte) string {
+	gl := bytes.SplitAfter(got, []byte("\n"))
+	wl := bytes.SplitAfter(want, []byte("\n"))
+	for i := 0; i < max(len(gl), len(wl)); i++ {
+		var g, w []byte
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if !bytes.Equal(g, w) {
+			return fmt.Sprintf("%sbody line %d: got %q, want %q (%d vs %d bytes)", prefix, i+1, g, w, len(got), len(want))
+		}
+	}
+	return fmt.Sprintf("%sbodies differ (%d vs %d bytes)", prefix, len(got), len(want))
+}
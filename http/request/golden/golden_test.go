@@ -0,0 +1,66 @@
+package golden
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+)
+
+func body(t *testing.T, fileType, content string, extra bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.SetBoundary("golden"); err != nil {
+		t.Fatal(err)
+	}
+	mw.WriteField("title", "report")
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="doc"; filename="doc.txt"`)
+	h.Set("Content-Type", fileType)
+	pw, _ := mw.CreatePart(h)
+	pw.Write([]byte(content))
+	if extra {
+		mw.WriteField("extra", "1")
+	}
+	mw.Close()
+	return buf.Bytes()
+}
+
+func TestDiff(t *testing.T) {
+	want := body(t, "text/plain", "line 1\nline 2\n", false)
+	got := body(t, "application/octet-stream", "line 1\nline two\n", true)
+
+	diffs := Diff(got, want, "golden")
+	joined := strings.Join(diffs, "\n")
+	for _, s := range []string{
+		"got 3 parts, want 2",
+		`part 2: header Content-Type: got "application/octet-stream", want "text/plain"`,
+		`part 2: body line 2: got "line two\n", want "line 2\n"`,
+		`part 3: unexpected part [form-data; name="extra"]`,
+	} {
+		if !strings.Contains(joined, s) {
+			t.Errorf("diff missing %q:\n%s", s, joined)
+		}
+	}
+	if d := Diff(want, want, "golden"); len(d) != 0 {
+		t.Errorf("Diff of equal bodies = %q", d)
+	}
+}
+
+func TestAssertMultipartUpdate(t *testing.T) {
+	Dir = t.TempDir()
+	defer func() { Dir = "testdata" }()
+	b := body(t, "text/plain", "x", false)
+
+	*update = true
+	AssertMultipart(t, "snapshot", b, "golden")
+	*update = false
+
+	if got, err := os.ReadFile(Path("snapshot")); err != nil || !bytes.Equal(got, b) {
+		t.Fatalf("golden file not written: %v", err)
+	}
+	AssertMultipart(t, "snapshot", b, "golden")
+}
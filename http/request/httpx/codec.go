@@ -0,0 +1,96 @@
+package httpx
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"sync"
+)
+
+// Codec encodes values of one media type. Implement it to send or decode
+// protobuf, MessagePack or other formats without this package depending
+// on them.
+type Codec interface {
+	// ContentType is the Content-Type of the encoded value.
+	ContentType() string
+	// Encode writes v to w. It should stream rather than buffer where the
+	// format allows.
+	Encode(w io.Writer, v any) error
+}
+
+// Decoder is implemented by codecs that can also decode, as
+// Response.Negotiate requires of the codec of a response.
+type Decoder interface {
+	Decode(r io.Reader, v any) error
+}
+
+// Built-in codecs, registered under their media types.
+var (
+	JSONCodec Codec = jsonCodec{}
+	XMLCodec  Codec = xmlCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Encode(w io.Writer, v any) error { return json.NewEncoder(w).Encode(v) }
+
+func (jsonCodec) Decode(r io.Reader, v any) error { return json.NewDecoder(r).Decode(v) }
+
+type xmlCodec struct{}
+
+func (xmlCodec) ContentType() string { return "application/xml; charset=utf-8" }
+
+func (xmlCodec) Encode(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func (xmlCodec) Decode(r io.Reader, v any) error { return xml.NewDecoder(r).Decode(v) }
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{m: map[string]Codec{}}
+
+func init() {
+	RegisterCodec(JSONCodec)
+	RegisterCodec(XMLCodec)
+}
+
+// RegisterCodec makes c available to LookupCodec under the media type of
+// its ContentType, replacing any codec registered for it before. It is
+// typically called from an init function, e.g. with a protobuf codec for
+// "application/x-protobuf".
+func RegisterCodec(c Codec) {
+	mediaType := MediaType(c.ContentType())
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.m[mediaType] = c
+}
+
+// LookupCodec returns the codec registered for the media type of
+// contentType; parameters such as charset are ignored.
+func LookupCodec(contentType string) (Codec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[MediaType(contentType)]
+	return c, ok
+}
+
+// MediaType returns contentType without its parameters, or contentType
+// itself if it does not parse.
+func MediaType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return contentType
+}
@@ -0,0 +1,173 @@
+// Package httpx builds http.Clients tuned for streaming uploads, so code
+// does not fall back to http.DefaultClient, which has no timeouts at all.
+//
+// The client has no overall Timeout, since that would cut off large
+// uploads; instead every phase that should be quick is bounded: dialing,
+// the TLS handshake and waiting for response headers once the body is
+// sent. Callers bound the upload itself with a context.
+package httpx
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/isauran/go-std-library/http/request/breaker"
+	"github.com/isauran/go-std-library/http/request/dedupe"
+	"github.com/isauran/go-std-library/http/request/digest"
+)
+
+// Defaults of NewClient.
+const (
+	DefaultDialTimeout           = 10 * time.Second
+	DefaultTLSHandshakeTimeout   = 10 * time.Second
+	DefaultResponseHeaderTimeout = 60 * time.Second
+	DefaultIdleConnTimeout       = 90 * time.Second
+	DefaultMaxIdleConnsPerHost   = 16
+	DefaultWriteBufferSize       = 64 << 10
+)
+
+type config struct {
+	timeout               time.Duration
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	idleConnTimeout       time.Duration
+	maxIdleConnsPerHost   int
+	writeBufferSize       int
+	compression           bool
+	tlsConfig             *tls.Config
+	proxy                 bool
+	breaker               *breaker.Breaker
+	dedupe                bool
+	dedupeOpts            []dedupe.Option
+	digest                bool
+	digestOpts            []digest.Option
+}
+
+// Option configures NewClient.
+type Option func(*config)
+
+// Timeout sets http.Client.Timeout, a limit on the whole exchange including
+// the upload. It is off by default; prefer a context deadline per request.
+func Timeout(d time.Duration) Option {
+	return func(c *config) { c.timeout = d }
+}
+
+// DialTimeout limits how long establishing a TCP connection may take.
+func DialTimeout(d time.Duration) Option {
+	return func(c *config) { c.dialTimeout = d }
+}
+
+// TLSHandshakeTimeout limits how long the TLS handshake may take.
+func TLSHandshakeTimeout(d time.Duration) Option {
+	return func(c *config) { c.tlsHandshakeTimeout = d }
+}
+
+// ResponseHeaderTimeout limits how long the server may take to answer once
+// the request body was sent completely, e.g. while it processes an upload.
+func ResponseHeaderTimeout(d time.Duration) Option {
+	return func(c *config) { c.responseHeaderTimeout = d }
+}
+
+// IdleConnTimeout sets how long an unused connection stays in the pool.
+func IdleConnTimeout(d time.Duration) Option {
+	return func(c *config) { c.idleConnTimeout = d }
+}
+
+// MaxIdleConnsPerHost sets how many idle connections are kept per host.
+// The net/http default of 2 makes concurrent uploads to one host dial
+// again and again.
+func MaxIdleConnsPerHost(n int) Option {
+	return func(c *config) { c.maxIdleConnsPerHost = n }
+}
+
+// WriteBufferSize sets the size of the buffer between the request body and
+// the connection. The net/http default of 4 KiB costs a syscall per 4 KiB
+// of upload.
+func WriteBufferSize(n int) Option {
+	return func(c *config) { c.writeBufferSize = n }
+}
+
+// Compression re-enables the transparent gzip of responses, which NewClient
+// turns off because upload replies are small and compressed bodies hide
+// their Content-Length.
+func Compression() Option {
+	return func(c *config) { c.compression = true }
+}
+
+// TLSConfig sets the TLS configuration of the transport.
+func TLSConfig(cfg *tls.Config) Option {
+	return func(c *config) { c.tlsConfig = cfg }
+}
+
+// NoProxy ignores the HTTP_PROXY and HTTPS_PROXY environment variables.
+func NoProxy() Option {
+	return func(c *config) { c.proxy = false }
+}
+
+// CircuitBreaker sends requests through b, so uploads to a host that keeps
+// failing are rejected at once with breaker.ErrOpen.
+func CircuitBreaker(b *breaker.Breaker) Option {
+	return func(c *config) { c.breaker = b }
+}
+
+// Deduplicate collapses concurrent identical requests into one network
+// call, see package dedupe. It applies before CircuitBreaker, so requests
+// answered by a shared call do not count towards failures twice.
+func Deduplicate(opts ...dedupe.Option) Option {
+	return func(c *config) { c.dedupe, c.dedupeOpts = true, opts }
+}
+
+// ContentDigest sends the RFC 9530 Content-Digest of every request body,
+// see package digest. Streamed bodies carry it in a trailer.
+func ContentDigest(opts ...digest.Option) Option {
+	return func(c *config) { c.digest, c.digestOpts = true, opts }
+}
+
+// NewClient returns a client with its own transport configured for
+// streaming uploads. HTTP/2 is attempted where the server supports it.
+func NewClient(opts ...Option) *http.Client {
+	cfg := config{
+		dialTimeout:           DefaultDialTimeout,
+		tlsHandshakeTimeout:   DefaultTLSHandshakeTimeout,
+		responseHeaderTimeout: DefaultResponseHeaderTimeout,
+		idleConnTimeout:       DefaultIdleConnTimeout,
+		maxIdleConnsPerHost:   DefaultMaxIdleConnsPerHost,
+		writeBufferSize:       DefaultWriteBufferSize,
+		proxy:                 true,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.dialTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSClientConfig:       cfg.tlsConfig,
+		TLSHandshakeTimeout:   cfg.tlsHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.responseHeaderTimeout,
+		IdleConnTimeout:       cfg.idleConnTimeout,
+		MaxIdleConns:          max(100, cfg.maxIdleConnsPerHost),
+		MaxIdleConnsPerHost:   cfg.maxIdleConnsPerHost,
+		WriteBufferSize:       cfg.writeBufferSize,
+		DisableCompression:    !cfg.compression,
+		ExpectContinueTimeout: time.Second,
+	}
+	if cfg.proxy {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+	var rt http.RoundTripper = transport
+	if cfg.digest {
+		rt = digest.Transport(rt, cfg.digestOpts...)
+	}
+	if cfg.breaker != nil {
+		rt = cfg.breaker.Transport(rt)
+	}
+	if cfg.dedupe {
+		rt = dedupe.Transport(rt, cfg.dedupeOpts...)
+	}
+	return &http.Client{Transport: rt, Timeout: cfg.timeout}
+}
@@ -0,0 +1,150 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDefaults(t *testing.T) {
+	c := NewClient()
+	tr := c.Transport.(*http.Transport)
+	if c.Timeout != 0 || !tr.ForceAttemptHTTP2 || !tr.DisableCompression ||
+		tr.MaxIdleConnsPerHost != DefaultMaxIdleConnsPerHost || tr.WriteBufferSize != DefaultWriteBufferSize ||
+		tr.ResponseHeaderTimeout != DefaultResponseHeaderTimeout || tr.Proxy == nil {
+		t.Errorf("unexpected defaults: timeout %v, transport %+v", c.Timeout, tr)
+	}
+
+	c = NewClient(Timeout(time.Minute), MaxIdleConnsPerHost(200), Compression(), NoProxy())
+	tr = c.Transport.(*http.Transport)
+	if c.Timeout != time.Minute || tr.MaxIdleConnsPerHost != 200 || tr.MaxIdleConns != 200 ||
+		tr.DisableCompression || tr.Proxy != nil {
+		t.Errorf("options not applied: timeout %v, transport %+v", c.Timeout, tr)
+	}
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := NewClient(ResponseHeaderTimeout(20 * time.Millisecond))
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+	_, err := c.Do(req)
+	var ne interface{ Timeout() bool }
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("Do() = %v, want a timeout", err)
+	}
+}
+
+func TestResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.Header().Set("X-Id", "42")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	resp, err := Wrap(http.Get(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsSuccess() || resp.HeaderValue("X-Id") != "42" {
+		t.Errorf("status %d, X-Id %q", resp.StatusCode, resp.HeaderValue("X-Id"))
+	}
+	if d, ok := resp.RetryAfter(); !ok || d != 2*time.Minute {
+		t.Errorf("RetryAfter = %v, %v", d, ok)
+	}
+	var out struct{ OK bool }
+	if err := resp.JSON(&out); err != nil || !out.OK {
+		t.Errorf("JSON = %+v, %v", out, err)
+	}
+	if err := resp.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+
+	resp, _ = Wrap(http.Get(srv.URL))
+	if _, err := resp.Bytes(4); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("Bytes(4) = %v, want ErrBodyTooLarge", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "body.json")
+	resp, _ = Wrap(http.Get(srv.URL))
+	if err := resp.SaveTo(path); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != `{"ok":true}` {
+		t.Errorf("saved %q", data)
+	}
+
+	if resp, err := Wrap(nil, errors.New("dial failed")); resp != nil || err == nil {
+		t.Errorf("Wrap(nil, err) = %v, %v", resp, err)
+	}
+}
+
+func TestRetryAfterDate(t *testing.T) {
+	resp, _ := Wrap(&http.Response{Header: http.Header{
+		"Retry-After": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)},
+	}}, nil)
+	if d, ok := resp.RetryAfter(); !ok || d < 59*time.Minute || d > time.Hour {
+		t.Errorf("RetryAfter = %v, %v", d, ok)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		w.Write([]byte(r.URL.Query().Get("body")))
+	}))
+	defer srv.Close()
+
+	get := func(accept, typ, body string) *Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.URL.RawQuery = url.Values{"type": {typ}, "body": {body}}.Encode()
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := Wrap(http.DefaultClient.Do(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	type item struct{ Name string }
+	for _, tc := range []struct{ accept, typ, body string }{
+		{AcceptHeader("application/json", "application/xml"), "application/json; charset=utf-8", `{"Name":"gopher"}`},
+		{AcceptHeader("application/json", "application/xml"), "application/xml", `<item><Name>gopher</Name></item>`},
+		{"application/*", "application/json", `{"Name":"gopher"}`},
+		{"", "application/json", `{"Name":"gopher"}`},
+	} {
+		var v item
+		if err := get(tc.accept, tc.typ, tc.body).Decode(&v); err != nil || v.Name != "gopher" {
+			t.Errorf("Accept %q, %s: decoded %+v, %v", tc.accept, tc.typ, v, err)
+		}
+	}
+
+	for _, tc := range []struct{ accept, typ string }{
+		{"application/json", "application/xml"},
+		{"application/json, application/xml;q=0", "application/xml"},
+		{"text/*", "text/plain"},
+	} {
+		if _, err := get(tc.accept, tc.typ, "x").Negotiate(); !errors.Is(err, ErrNotAcceptable) {
+			t.Errorf("Accept %q, %s: err = %v, want ErrNotAcceptable", tc.accept, tc.typ, err)
+		}
+	}
+
+	if got, want := AcceptHeader("a/b", "c/d", "e/f;q=0.2"), "a/b, c/d;q=0.9, e/f;q=0.2"; got != want {
+		t.Errorf("AcceptHeader = %q, want %q", got, want)
+	}
+}
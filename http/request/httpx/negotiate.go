@@ -0,0 +1,78 @@
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ErrNotAcceptable is returned by Response.Negotiate for a response whose
+// Content-Type was not asked for in the Accept header of its request or
+// has no decoding codec.
+var ErrNotAcceptable = errors.New("httpx: response type not acceptable")
+
+// AcceptHeader returns an Accept header value asking for types in order of
+// preference: the first gets quality 1 and each next one a lower quality,
+// unless it carries its own q parameter.
+func AcceptHeader(types ...string) string {
+	values := make([]string, len(types))
+	for i, t := range types {
+		if _, params, err := mime.ParseMediaType(t); err == nil && params["q"] == "" && i > 0 {
+			t += ";q=" + strconv.FormatFloat(max(1-float64(i)/10, 0.1), 'g', 3, 64)
+		}
+		values[i] = t
+	}
+	return strings.Join(values, ", ")
+}
+
+// Negotiate returns the registered codec of the response's Content-Type.
+// It fails with ErrNotAcceptable, closing the body, if the type does not
+// match the Accept header of the request, which may have wildcards such as
+// application/*, or if no registered codec decodes it. A request without
+// Accept takes any type.
+func (r *Response) Negotiate() (Codec, error) {
+	ct := r.Header.Get("Content-Type")
+	codec, ok := LookupCodec(ct)
+	if _, decodes := codec.(Decoder); !ok || !decodes || !r.accepts(MediaType(ct)) {
+		r.Close()
+		return nil, fmt.Errorf("%w: %q", ErrNotAcceptable, ct)
+	}
+	return codec, nil
+}
+
+// Decode decodes the body into v with the codec picked by Negotiate and
+// closes it.
+func (r *Response) Decode(v any) error {
+	codec, err := r.Negotiate()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := codec.(Decoder).Decode(r.Body, v); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", codec.ContentType(), err)
+	}
+	return nil
+}
+
+// accepts reports whether mediaType matches the Accept header of the
+// request. Types given quality 0 are refused.
+func (r *Response) accepts(mediaType string) bool {
+	if r.Request == nil || r.Request.Header.Get("Accept") == "" {
+		return true
+	}
+	for _, v := range r.Request.Header.Values("Accept") {
+		for t := range strings.SplitSeq(v, ",") {
+			pattern, params, err := mime.ParseMediaType(strings.TrimSpace(t))
+			if err != nil || params["q"] == "0" {
+				continue
+			}
+			if ok, _ := path.Match(pattern, mediaType); ok || pattern == "*/*" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package httpx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/isauran/go-std-library/http/request/retry"
+)
+
+// ErrBodyTooLarge is returned by Response.Bytes when the body is longer
+// than the given limit.
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// drainLimit is how much of an unread body Close reads so the connection
+// can be reused; longer bodies are dropped with their connection.
+const drainLimit = 64 << 10
+
+// Response wraps an http.Response with helpers that consume the body. Each
+// of JSON, Bytes and SaveTo reads the body once and closes it, also when
+// they fail, so callers need no defer of their own. Callers that read Body
+// directly still call Close, which is safe to call more than once.
+type Response struct {
+	*http.Response
+
+	once     sync.Once
+	closeErr error
+}
+
+// Wrap wraps the result of http.Client.Do. A nil resp stays nil, so
+// Wrap(client.Do(req)) passes errors through unchanged.
+func Wrap(resp *http.Response, err error) (*Response, error) {
+	if resp == nil {
+		return nil, err
+	}
+	return &Response{Response: resp}, err
+}
+
+// HeaderValue returns the first value of the response header k. It is
+// resp.Header.Get(k) for callers holding the wrapper in an interface or
+// chaining calls.
+func (r *Response) HeaderValue(k string) string {
+	return r.Header.Get(k)
+}
+
+// IsSuccess reports whether the status code is 2xx.
+func (r *Response) IsSuccess() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// RetryAfter returns the delay the server asks for in Retry-After, given
+// in seconds or as an HTTP date, or in RateLimit-Reset, and false when it
+// asks for none. A date in the past yields zero.
+func (r *Response) RetryAfter() (time.Duration, bool) {
+	return retry.After(r.Header, time.Now())
+}
+
+// JSON decodes the body into out and closes it.
+func (r *Response) JSON(out any) error {
+	defer r.Close()
+	if err := json.NewDecoder(r.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// Bytes reads the whole body and closes it. Bodies longer than limit bytes
+// fail with ErrBodyTooLarge; limit <= 0 means no limit.
+func (r *Response) Bytes(limit int64) ([]byte, error) {
+	defer r.Close()
+	body := io.Reader(r.Body)
+	if limit > 0 {
+		body = io.LimitReader(r.Body, limit+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return nil, fmt.Errorf("failed to read response: %w (limit %d bytes)", ErrBodyTooLarge, limit)
+	}
+	return data, nil
+}
+
+// SaveTo writes the body to the file at path and closes it. The body goes
+// to a temporary file in the same directory first, so path never holds a
+// partial download.
+func (r *Response) SaveTo(path string) error {
+	defer r.Close()
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save response: %w", err)
+	}
+	_, err = io.Copy(f, r.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to save response: %w", err)
+	}
+	return nil
+}
+
+// Close drains what is left of a short body and closes it. Only the first
+// call has an effect; later calls return the same error.
+func (r *Response) Close() error {
+	r.once.Do(func() {
+		io.CopyN(io.Discard, r.Body, drainLimit)
+		r.closeErr = r.Body.Close()
+	})
+	return r.closeErr
+}
@@ -0,0 +1,322 @@
+// Package mockhttp provides an in-process http.RoundTripper that matches
+// requests, including their decoded multipart fields and files, against
+// scripted routes. Upload code can be unit tested without binding ports or
+// reaching httpbin.org.
+package mockhttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/isauran/go-std-library/http/request/multipartecho"
+)
+
+// ErrNoMatch is returned by RoundTrip when no route matches the request.
+var ErrNoMatch = errors.New("mockhttp: no route matches request")
+
+// Call is a request seen by the Transport. The body has already been
+// consumed; its bytes and decoded parts are kept here.
+type Call struct {
+	Request *http.Request
+	Body    []byte
+	Parts   []multipartecho.Part // decoded parts of a multipart/form-data body
+	Route   *Route               // matched route, or nil
+}
+
+// Fields returns the values of all non-file parts by name.
+func (c *Call) Fields() map[string][]string {
+	return (&multipartecho.Request{Parts: c.Parts}).Fields()
+}
+
+// Files returns the file parts in arrival order.
+func (c *Call) Files() []multipartecho.Part {
+	return (&multipartecho.Request{Parts: c.Parts}).Files()
+}
+
+// Transport is an http.RoundTripper serving scripted responses. Routes are
+// tried in the order they were added; the first match wins. The zero value
+// is ready to use and answers every request with ErrNoMatch.
+type Transport struct {
+	mu     sync.Mutex
+	routes []*Route
+	calls  []*Call
+}
+
+// Client returns an http.Client using t.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+// On adds a route for method and url. An empty method matches any method.
+// A url without a scheme is compared against the request path only.
+func (t *Transport) On(method, url string) *Route {
+	r := &Route{t: t, method: method, url: url, status: http.StatusOK}
+	t.mu.Lock()
+	t.routes = append(t.routes, r)
+	t.mu.Unlock()
+	return r
+}
+
+// Calls returns every request seen so far, matched or not.
+func (t *Transport) Calls() []*Call {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Call(nil), t.calls...)
+}
+
+// Unmatched returns the routes that were never hit, so tests can assert
+// that every expected request was made.
+func (t *Transport) Unmatched() []*Route {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []*Route
+	for _, r := range t.routes {
+		if r.hits == 0 {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// RoundTrip implements http.RoundTripper. The request body is always read
+// to the end and closed, so streaming writers never block on the mock.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	call := &Call{Request: req}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("mockhttp: reading request body: %w", err)
+		}
+		call.Body = body
+	}
+	if err := call.decode(); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.calls = append(t.calls, call)
+	for _, r := range t.routes {
+		if r.exhausted() || !r.matches(call) {
+			continue
+		}
+		r.hits++
+		call.Route = r
+		break
+	}
+	t.mu.Unlock()
+
+	if call.Route == nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoMatch, req.Method, req.URL)
+	}
+	return call.Route.respond(call)
+}
+
+// decode parses a multipart/form-data body into parts.
+func (c *Call) decode() error {
+	mediaType, _, _ := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return nil
+	}
+	clone := c.Request.Clone(c.Request.Context())
+	clone.Body = io.NopCloser(bytes.NewReader(c.Body))
+	parsed, err := multipartecho.ReadRequest(clone)
+	if err != nil {
+		return fmt.Errorf("mockhttp: decoding multipart body: %w", err)
+	}
+	c.Parts = parsed.Parts
+	return nil
+}
+
+// Route is a request matcher with a scripted response.
+type Route struct {
+	t           *Transport
+	method, url string
+	headers     [][2]string
+	fields      [][2]string
+	files       []fileMatcher
+	times       int
+	hits        int
+
+	status  int
+	header  http.Header
+	body    []byte
+	err     error
+	handler func(*Call) (*http.Response, error)
+}
+
+type fileMatcher struct {
+	field, filename, sha256 string
+}
+
+// WithHeader requires a request header value.
+func (r *Route) WithHeader(key, value string) *Route {
+	r.headers = append(r.headers, [2]string{key, value})
+	return r
+}
+
+// WithField requires a multipart field with the given value.
+func (r *Route) WithField(name, value string) *Route {
+	r.fields = append(r.fields, [2]string{name, value})
+	return r
+}
+
+// WithFile requires a file part for field. An empty filename matches any.
+func (r *Route) WithFile(field, filename string) *Route {
+	r.files = append(r.files, fileMatcher{field: field, filename: filename})
+	return r
+}
+
+// WithFileContent requires a file part for field with exactly content.
+func (r *Route) WithFileContent(field string, content []byte) *Route {
+	sum := sha256.Sum256(content)
+	r.files = append(r.files, fileMatcher{field: field, sha256: hex.EncodeToString(sum[:])})
+	return r
+}
+
+// Times limits how often the route may match; later requests fall through
+// to the next route. Zero means unlimited.
+func (r *Route) Times(n int) *Route {
+	r.times = n
+	return r
+}
+
+// Hits returns how many requests the route has matched.
+func (r *Route) Hits() int {
+	r.t.mu.Lock()
+	defer r.t.mu.Unlock()
+	return r.hits
+}
+
+// Respond scripts a response with status and body.
+func (r *Route) Respond(status int, body string) *Route {
+	r.status, r.body = status, []byte(body)
+	return r
+}
+
+// RespondJSON scripts a JSON response.
+func (r *Route) RespondJSON(status int, v any) *Route {
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("mockhttp: RespondJSON: %v", err))
+	}
+	r.status, r.body = status, body
+	return r.RespondHeader("Content-Type", "application/json")
+}
+
+// RespondHeader adds a response header.
+func (r *Route) RespondHeader(key, value string) *Route {
+	if r.header == nil {
+		r.header = http.Header{}
+	}
+	r.header.Add(key, value)
+	return r
+}
+
+// RespondError makes the round trip fail with err, as a network error would.
+func (r *Route) RespondError(err error) *Route {
+	r.err = err
+	return r
+}
+
+// RespondWith builds the response from the call.
+func (r *Route) RespondWith(fn func(*Call) (*http.Response, error)) *Route {
+	r.handler = fn
+	return r
+}
+
+func (r *Route) String() string {
+	method := r.method
+	if method == "" {
+		method = "*"
+	}
+	return method + " " + r.url
+}
+
+func (r *Route) exhausted() bool {
+	return r.times > 0 && r.hits >= r.times
+}
+
+func (r *Route) matches(c *Call) bool {
+	req := c.Request
+	if r.method != "" && r.method != req.Method {
+		return false
+	}
+	if strings.Contains(r.url, "://") {
+		if r.url != req.URL.String() {
+			return false
+		}
+	} else if r.url != req.URL.Path {
+		return false
+	}
+	for _, h := range r.headers {
+		if req.Header.Get(h[0]) != h[1] {
+			return false
+		}
+	}
+	fields := c.Fields()
+	for _, f := range r.fields {
+		if !contains(fields[f[0]], f[1]) {
+			return false
+		}
+	}
+	for _, m := range r.files {
+		if !m.matchesAny(c.Files()) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m fileMatcher) matchesAny(files []multipartecho.Part) bool {
+	for _, f := range files {
+		if f.Name == m.field &&
+			(m.filename == "" || f.FileName == m.filename) &&
+			(m.sha256 == "" || f.SHA256 == m.sha256) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Route) respond(c *Call) (*http.Response, error) {
+	if r.handler != nil {
+		return r.handler(c)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	header := r.header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.status, http.StatusText(r.status)),
+		StatusCode:    r.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(r.body)),
+		ContentLength: int64(len(r.body)),
+		Request:       c.Request,
+	}, nil
+}
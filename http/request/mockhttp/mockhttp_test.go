@@ -0,0 +1,84 @@
+package mockhttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"testing"
+)
+
+func upload(t *testing.T, client *http.Client, url string, fields map[string]string, file string) (*http.Response, error) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	fw, _ := mw.CreateFormFile("doc", "doc.txt")
+	io.WriteString(fw, file)
+	mw.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, url, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer t")
+	return client.Do(req)
+}
+
+func TestTransportMatchesMultipart(t *testing.T) {
+	mock := &Transport{}
+	ok := mock.On(http.MethodPost, "/upload").
+		WithHeader("Authorization", "Bearer t").
+		WithField("kind", "report").
+		WithFileContent("doc", []byte("hello")).
+		RespondJSON(http.StatusCreated, map[string]string{"id": "42"})
+	mock.On("", "https://api.example.com/upload").Respond(http.StatusBadRequest, "bad")
+	unused := mock.On(http.MethodGet, "/never")
+
+	resp, err := upload(t, mock.Client(), "https://api.example.com/upload", map[string]string{"kind": "report"}, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated || string(body) != `{"id":"42"}` {
+		t.Errorf("response = %d %s", resp.StatusCode, body)
+	}
+
+	resp, err = upload(t, mock.Client(), "https://api.example.com/upload", map[string]string{"kind": "other"}, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("fallback status = %d, want 400", resp.StatusCode)
+	}
+
+	if ok.Hits() != 1 {
+		t.Errorf("hits = %d, want 1", ok.Hits())
+	}
+	calls := mock.Calls()
+	if len(calls) != 2 || calls[1].Fields()["kind"][0] != "other" || calls[1].Files()[0].FileName != "doc.txt" {
+		t.Errorf("calls not recorded: %+v", calls)
+	}
+	if u := mock.Unmatched(); len(u) != 1 || u[0] != unused {
+		t.Errorf("unmatched = %v", u)
+	}
+}
+
+func TestTransportTimesAndErrors(t *testing.T) {
+	mock := &Transport{}
+	netErr := errors.New("connection reset")
+	mock.On(http.MethodPost, "/upload").Times(1).RespondError(netErr)
+	mock.On(http.MethodPost, "/upload").Respond(http.StatusOK, "ok")
+
+	if _, err := upload(t, mock.Client(), "http://x/upload", nil, "a"); !errors.Is(err, netErr) {
+		t.Errorf("first call err = %v, want %v", err, netErr)
+	}
+	resp, err := upload(t, mock.Client(), "http://x/upload", nil, "a")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("second call = %v, %v", resp, err)
+	}
+	if _, err := upload(t, mock.Client(), "http://x/other", nil, "a"); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("unrouted err = %v, want ErrNoMatch", err)
+	}
+}
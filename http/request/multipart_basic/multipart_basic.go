@@ -2,17 +2,29 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"io"
 	"mime/multipart"
 	"net/http"
 	"strings"
+
+	"github.com/isauran/go-std-library/http/request/httpx"
+	"github.com/isauran/go-std-library/http/server/httpbinlite"
 )
 
 func main() {
 	fmt.Println("=== Multipart HTTP Request Demo ===")
 	fmt.Println()
 
+	// A local httpbin stand-in keeps the demo runnable offline
+	httpbin, err := httpbinlite.Start(context.Background())
+	if err != nil {
+		fmt.Printf("Error starting local httpbin: %v\n", err)
+		return
+	}
+	defer httpbin.Shutdown()
+	postURL := httpbin.URL() + "/post"
+
 	// Example 1: Creating multipart form with text fields
 	fmt.Println("1. Creating multipart form with text fields:")
 	createTextFieldsExample()
@@ -27,7 +39,7 @@ func main() {
 
 	// Example 3: Complete example of sending multipart request
 	fmt.Println("3. Complete example of sending multipart request:")
-	sendMultipartRequestExample()
+	sendMultipartRequestExample(postURL)
 }
 
 // createTextFieldsExample demonstrates creating a multipart form with text fields
@@ -80,7 +92,7 @@ func createFileUploadExample() {
 }
 
 // sendMultipartRequestExample demonstrates complete cycle of creating and sending multipart request
-func sendMultipartRequestExample() {
+func sendMultipartRequestExample(url string) {
 	// Create buffer for multipart data
 	var buf bytes.Buffer
 	writer := multipart.NewWriter(&buf)
@@ -112,7 +124,7 @@ Details: All components are working normally`
 	writer.Close()
 
 	// Create HTTP request
-	req, err := http.NewRequest("POST", "https://httpbin.org/post", &buf)
+	req, err := http.NewRequest("POST", url, &buf)
 	if err != nil {
 		fmt.Printf("Error creating request: %v\n", err)
 		return
@@ -127,18 +139,17 @@ Details: All components are working normally`
 	fmt.Printf("Request body size: %d bytes\n", buf.Len())
 
 	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	client := httpx.NewClient()
+	resp, err := httpx.Wrap(client.Do(req))
 	if err != nil {
 		fmt.Printf("Error sending request: %v\n", err)
 		return
 	}
-	defer resp.Body.Close()
 
 	fmt.Printf("Response status: %s\n", resp.Status)
 
 	// Read and display part of response
-	body, err := io.ReadAll(resp.Body)
+	body, err := resp.Bytes(0)
 	if err != nil {
 		fmt.Printf("Error reading response: %v\n", err)
 		return
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Base URLs of the chat APIs.
+const (
+	TelegramBaseURL = "https://api.telegram.org"
+	SlackBaseURL    = "https://slack.com/api/"
+)
+
+// BotError is an error reported by a chat API in its response body.
+type BotError struct {
+	API         string // "telegram" or "slack"
+	Code        int    // Telegram's error_code; 0 for Slack
+	Description string
+}
+
+func (e *BotError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("%s API error %d: %s", e.API, e.Code, e.Description)
+	}
+	return fmt.Sprintf("%s API error: %s", e.API, e.Description)
+}
+
+// Telegram uploads files with the Telegram Bot API.
+type Telegram struct {
+	session *Session
+}
+
+// NewTelegram returns a client for the bot with token, at baseURL, which
+// is TelegramBaseURL outside of tests. A nil client means
+// http.DefaultClient.
+func NewTelegram(client *http.Client, baseURL, token string) (*Telegram, error) {
+	s, err := NewSession(client, strings.TrimSuffix(baseURL, "/")+"/bot"+token+"/")
+	if err != nil {
+		return nil, err
+	}
+	return &Telegram{session: s}, nil
+}
+
+// Session returns the session of the client, e.g. to set a RateLimit
+// within Telegram's limits or read its stats.
+func (t *Telegram) Session() *Session { return t.session }
+
+// TelegramUpload is a file sent to a chat.
+type TelegramUpload struct {
+	ChatID    string // numeric ID or @channelusername
+	Caption   string
+	ParseMode string // "MarkdownV2", "HTML" or empty
+	Filename  string
+	Content   io.Reader
+}
+
+// TelegramMessage is the message a file was sent as.
+type TelegramMessage struct {
+	MessageID int   `json:"message_id"`
+	Date      int64 `json:"date"`
+}
+
+// SendDocument sends u as a general file.
+func (t *Telegram) SendDocument(ctx context.Context, u TelegramUpload) (TelegramMessage, error) {
+	return t.send(ctx, "sendDocument", "document", u)
+}
+
+// SendPhoto sends u as a photo, which Telegram recompresses.
+func (t *Telegram) SendPhoto(ctx context.Context, u TelegramUpload) (TelegramMessage, error) {
+	return t.send(ctx, "sendPhoto", "photo", u)
+}
+
+func (t *Telegram) send(ctx context.Context, method, field string, u TelegramUpload) (TelegramMessage, error) {
+	b := t.session.NewRequest(ctx, http.MethodPost, method).Param("chat_id", u.ChatID)
+	if u.Caption != "" {
+		b.Param("caption", u.Caption)
+	}
+	if u.ParseMode != "" {
+		b.Param("parse_mode", u.ParseMode)
+	}
+	resp, err := b.File(field, u.Filename, u.Content).Send()
+	if err != nil {
+		return TelegramMessage{}, fmt.Errorf("failed to %s: %w", method, err)
+	}
+	var out struct {
+		OK          bool            `json:"ok"`
+		Result      TelegramMessage `json:"result"`
+		ErrorCode   int             `json:"error_code"`
+		Description string          `json:"description"`
+	}
+	if err := decodeResponse(resp.Response, MediaJSON, &out); err != nil {
+		return TelegramMessage{}, fmt.Errorf("failed to %s: %w", method, err)
+	}
+	if !out.OK {
+		return TelegramMessage{}, fmt.Errorf("failed to %s: %w", method,
+			&BotError{API: "telegram", Code: out.ErrorCode, Description: out.Description})
+	}
+	return out.Result, nil
+}
+
+// Slack uploads files with the Slack Web API.
+type Slack struct {
+	session *Session
+}
+
+// NewSlack returns a client using the bot token, at baseURL, which is
+// SlackBaseURL outside of tests. A nil client means http.DefaultClient.
+func NewSlack(client *http.Client, baseURL, token string) (*Slack, error) {
+	s, err := NewSession(client, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	s.Token(func(context.Context) (string, error) { return token, nil })
+	return &Slack{session: s}, nil
+}
+
+// Session returns the session of the client.
+func (s *Slack) Session() *Session { return s.session }
+
+// SlackUpload is a file shared to a channel.
+type SlackUpload struct {
+	ChannelID      string
+	Filename       string
+	Title          string // defaults to Filename
+	InitialComment string
+	Content        io.Reader
+	// Length is the size of Content, which Slack needs up front. It may
+	// be left 0 for contents with a Len method such as *bytes.Reader.
+	Length int64
+}
+
+// SlackFile is an uploaded file.
+type SlackFile struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// UploadFile uploads u in Slack's three steps: it asks for an upload URL,
+// streams the file there and completes the upload, sharing it to the
+// channel.
+func (s *Slack) UploadFile(ctx context.Context, u SlackUpload) (SlackFile, error) {
+	length := u.Length
+	if l, ok := u.Content.(interface{ Len() int }); ok && length == 0 {
+		length = int64(l.Len())
+	}
+	if length <= 0 {
+		return SlackFile{}, fmt.Errorf("failed to upload file: unknown length of %q", u.Filename)
+	}
+	if u.Title == "" {
+		u.Title = u.Filename
+	}
+
+	var target struct {
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	if err := s.call(ctx, "files.getUploadURLExternal", &target, func(b *Multipart) {
+		b.Param("filename", u.Filename).Param("length", fmt.Sprint(length))
+	}); err != nil {
+		return SlackFile{}, err
+	}
+
+	resp, err := s.session.NewRequest(ctx, http.MethodPost, target.UploadURL).
+		File("file", u.Filename, u.Content).
+		Send()
+	if err != nil {
+		return SlackFile{}, fmt.Errorf("failed to upload file content: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return SlackFile{}, fmt.Errorf("failed to upload file content: %w",
+			&StatusError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+
+	files, _ := json.Marshal([]SlackFile{{ID: target.FileID, Title: u.Title}})
+	var done struct {
+		Files []SlackFile `json:"files"`
+	}
+	if err := s.call(ctx, "files.completeUploadExternal", &done, func(b *Multipart) {
+		b.Param("files", string(files)).Param("channel_id", u.ChannelID)
+		if u.InitialComment != "" {
+			b.Param("initial_comment", u.InitialComment)
+		}
+	}); err != nil {
+		return SlackFile{}, err
+	}
+	if len(done.Files) == 0 {
+		return SlackFile{}, fmt.Errorf("failed to complete upload: no file in response")
+	}
+	return done.Files[0], nil
+}
+
+// call sends the parts added by build to a Web API method and decodes the
+// response into dst.
+func (s *Slack) call(ctx context.Context, method string, dst any, build func(b *Multipart)) error {
+	b := s.session.NewRequest(ctx, http.MethodPost, method)
+	build(b)
+	resp, err := b.Send()
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", method, err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", method, err)
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", method, err)
+	}
+	if !status.OK {
+		return fmt.Errorf("failed to call %s: %w", method, &BotError{API: "slack", Description: status.Error})
+	}
+	if err := json.Unmarshal(body, dst); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", method, err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Defaults of BoundaryPolicy.
+const (
+	DefaultBoundaryLength  = 32
+	DefaultBoundaryCharset = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	// MaxBoundaryLength is the limit of RFC 2046 on the whole boundary,
+	// prefix included.
+	MaxBoundaryLength = 70
+)
+
+// BoundaryPolicy describes how a boundary is generated: Prefix followed by
+// Length characters drawn from Charset using Source. Zero fields take the
+// defaults, with Length shortened to fit a long Prefix; Source defaults
+// to crypto/rand, and a seeded math/rand/v2
+// generator such as rand.NewChaCha8 makes boundaries reproducible.
+type BoundaryPolicy struct {
+	Prefix  string
+	Length  int
+	Charset string
+	Source  io.Reader
+}
+
+// generate returns a boundary following p.
+func (p BoundaryPolicy) generate() (string, error) {
+	length, charset, source := p.Length, p.Charset, p.Source
+	if length <= 0 {
+		length = max(min(DefaultBoundaryLength, MaxBoundaryLength-len(p.Prefix)), 1)
+	}
+	if charset == "" {
+		charset = DefaultBoundaryCharset
+	}
+	if source == nil {
+		source = rand.Reader
+	}
+	if n := len(p.Prefix) + length; n > MaxBoundaryLength {
+		return "", fmt.Errorf("boundary of %d characters exceeds %d", n, MaxBoundaryLength)
+	}
+	if len(charset) > 256 {
+		return "", fmt.Errorf("boundary charset of %d characters exceeds 256", len(charset))
+	}
+
+	// Bytes at or above limit are rejected so every character of the
+	// charset is equally likely.
+	limit := 256 - 256%len(charset)
+	var b strings.Builder
+	b.WriteString(p.Prefix)
+	buf := make([]byte, length)
+	for b.Len() < len(p.Prefix)+length {
+		if _, err := io.ReadFull(source, buf); err != nil {
+			return "", fmt.Errorf("failed to read boundary randomness: %w", err)
+		}
+		for _, c := range buf {
+			if int(c) < limit && b.Len() < len(p.Prefix)+length {
+				b.WriteByte(charset[int(c)%len(charset)])
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// BoundaryPolicy replaces the random boundary with one generated by p. Like
+// Boundary, it must be called before any parts are added; a boundary over
+// MaxBoundaryLength or with characters RFC 2046 forbids fails the request.
+func (r *Multipart) BoundaryPolicy(p BoundaryPolicy) *Multipart {
+	boundary, err := p.generate()
+	if err != nil {
+		r.pw.CloseWithError(fmt.Errorf("failed to generate boundary: %w", err))
+		return r
+	}
+	r.Boundary(boundary)
+	r.newBoundary = p.generate
+	return r
+}
+
+// BoundaryPrefix starts the random boundary with prefix, e.g. to grep
+// captured traffic or to match a WAF allow-list. It is BoundaryPolicy with
+// only Prefix set.
+func (r *Multipart) BoundaryPrefix(prefix string) *Multipart {
+	return r.BoundaryPolicy(BoundaryPolicy{Prefix: prefix})
+}
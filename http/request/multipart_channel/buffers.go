@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultCopyBufferSize matches the buffer io.Copy would allocate.
+const DefaultCopyBufferSize = 32 << 10
+
+// copyBuffers holds one sync.Pool per buffer size in use, so builders with
+// different CopyBufferSize settings never hand each other wrongly sized
+// buffers.
+var copyBuffers sync.Map // int -> *sync.Pool
+
+func getBuffer(size int) *[]byte {
+	p, ok := copyBuffers.Load(size)
+	if !ok {
+		p, _ = copyBuffers.LoadOrStore(size, &sync.Pool{New: func() any {
+			b := make([]byte, size)
+			return &b
+		}})
+	}
+	return p.(*sync.Pool).Get().(*[]byte)
+}
+
+func putBuffer(b *[]byte) {
+	if p, ok := copyBuffers.Load(len(*b)); ok {
+		p.(*sync.Pool).Put(b)
+	}
+}
+
+// CopyBufferSize sets the size of the buffer used to copy file contents
+// into the body. Buffers are pooled across requests. Readers that implement
+// io.WriterTo, such as bytes.Reader, bypass the buffer.
+func (r *Multipart) CopyBufferSize(n int) *Multipart {
+	if n > 0 {
+		r.copyBufferSize = n
+	}
+	return r
+}
+
+// copyContent copies src into dst through a pooled buffer.
+func (r *Multipart) copyContent(dst io.Writer, src io.Reader) (int64, error) {
+	size := r.copyBufferSize
+	if size == 0 {
+		size = DefaultCopyBufferSize
+	}
+	buf := getBuffer(size)
+	defer putBuffer(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// CaptureBody keeps the first limit bytes of the outgoing body for
+// debugging and audit, without buffering the rest of it. The captured bytes
+// are available from CapturedBody once Send returns. CaptureBody must be
+// called before any parts are added.
+func (r *Multipart) CaptureBody(limit int64) *Multipart {
+	r.capture = &cappedBuffer{limit: limit}
+	r.out.taps = append(r.out.taps, r.capture)
+	return r
+}
+
+// CapturedBody returns a copy of the body bytes kept by CaptureBody, or nil
+// if capturing is off.
+func (r *Multipart) CapturedBody() []byte {
+	if r.capture == nil {
+		return nil
+	}
+	return append([]byte(nil), r.capture.Bytes()...)
+}
+
+// CapturedSize returns the full size of the body written so far, which
+// exceeds len(CapturedBody()) when the capture was truncated.
+func (r *Multipart) CapturedSize() int64 {
+	if r.capture == nil {
+		return 0
+	}
+	r.capture.mu.Lock()
+	defer r.capture.mu.Unlock()
+	return r.capture.total
+}
+
+// AlsoWriteTo copies the exact request body to w while the upload
+// proceeds, e.g. to a file archiving what was sent. w receives only bytes
+// the transport accepted. A failed write to w fails the request, so the
+// copy is never silently short. AlsoWriteTo must be called before any parts
+// are added; w is complete once Send returns.
+func (r *Multipart) AlsoWriteTo(w io.Writer) *Multipart {
+	r.out.taps = append(r.out.taps, &teeWriter{r: r, w: w})
+	return r
+}
+
+// teeWriter is a tap that fails the body on its first write error.
+type teeWriter struct {
+	r   *Multipart
+	w   io.Writer
+	err error
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	if _, err := t.w.Write(p); err != nil {
+		t.err = fmt.Errorf("failed to write body copy: %w", err)
+		t.r.pw.CloseWithError(t.err)
+		return 0, t.err
+	}
+	return len(p), nil
+}
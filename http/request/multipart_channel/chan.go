@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrChunkTimeout is returned, wrapped, when a FileFromChan part waited
+// longer than its ChunkTimeout for the next chunk.
+var ErrChunkTimeout = errors.New("multipart: no chunk received in time")
+
+// ChunkTimeout fails a FileFromChan part when no chunk arrives for d.
+func ChunkTimeout(d time.Duration) PartOption {
+	return func(t *TRequest) { t.chunkTimeout = d }
+}
+
+// FileFromChan adds a file part written from the chunks received on chunks
+// until it is closed, for producers that generate content incrementally
+// such as log tails or encoder output. The part stays open while it waits,
+// so parts added after it are written once chunks is closed. Without a
+// ChunkTimeout it waits as long as the request context allows; a producer
+// should stop sending once the request failed.
+func (r *Multipart) FileFromChan(field, filename string, chunks <-chan []byte, opts ...PartOption) *Multipart {
+	t := TRequest{Type: FileType, Key: field, Value: filename}
+	t = t.apply(opts)
+	t.generate = func(w io.Writer) error {
+		return r.copyChunks(w, chunks, t.chunkTimeout)
+	}
+	r.send(t)
+	return r
+}
+
+func (r *Multipart) copyChunks(w io.Writer, chunks <-chan []byte, idle time.Duration) error {
+	var timer *time.Timer
+	var timeout <-chan time.Time // nil, never ready, without an idle limit
+	if idle > 0 {
+		timer = time.NewTimer(idle)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				return nil
+			}
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+			if timer != nil {
+				timer.Reset(idle)
+			}
+		case <-timeout:
+			return fmt.Errorf("%w after %v", ErrChunkTimeout, idle)
+		case <-r.request.Context().Done():
+			return r.request.Context().Err()
+		}
+	}
+}
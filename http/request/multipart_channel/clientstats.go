@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+// HostStats are the connection counts of one host.
+type HostStats struct {
+	NewConns    int // connections dialed
+	ReusedConns int // requests served by a connection already open
+	Idle        int // connections currently parked in the idle pool
+}
+
+// ClientStats reports how a TrackedClient used its connections, to tune
+// Transport.MaxIdleConnsPerHost for upload fleets: a low share of reused
+// connections under steady load means the idle pool is too small.
+type ClientStats struct {
+	Requests    int
+	NewConns    int
+	ReusedConns int
+	Hosts       map[string]HostStats // keyed by host:port
+}
+
+// TrackedClient is an http.Client whose transport records connection reuse
+// through httptrace. Share one between builders to see the pool of the
+// whole fleet. Idle counts rely on the HTTP/1 pool callbacks and stay zero
+// for HTTP/2 connections.
+type TrackedClient struct {
+	*http.Client
+
+	mu    sync.Mutex
+	stats ClientStats
+}
+
+// NewTrackedClient returns a copy of client with connection tracking. A nil
+// client means http.DefaultClient.
+func NewTrackedClient(client *http.Client) *TrackedClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	c := &TrackedClient{stats: ClientStats{Hosts: map[string]HostStats{}}}
+	copied := *client
+	base := copied.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	copied.Transport = &trackingTransport{base: base, c: c}
+	c.Client = &copied
+	return c
+}
+
+// ClientStats returns a snapshot of the connection counts so far.
+func (c *TrackedClient) ClientStats() ClientStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Hosts = maps.Clone(c.stats.Hosts)
+	return s
+}
+
+// Warmup opens a connection to each of urls with a HEAD request and parks
+// it in the idle pool, so the first uploads skip the dial and TLS
+// handshake. List a URL several times to open several connections to its
+// host; the requests run concurrently.
+func (c *TrackedClient) Warmup(ctx context.Context, urls ...string) error {
+	errs := make([]error, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer recoverPanic(func(err error) { errs[i] = fmt.Errorf("failed to warm up %s: %w", url, err) })
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to warm up %s: %w", url, err)
+				return
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to warm up %s: %w", url, err)
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (c *TrackedClient) update(host string, fn func(*ClientStats, *HostStats)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := c.stats.Hosts[host]
+	fn(&c.stats, &h)
+	c.stats.Hosts[host] = h
+}
+
+type trackingTransport struct {
+	base http.RoundTripper
+	c    *TrackedClient
+}
+
+func (t *trackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if req.URL.Port() == "" {
+		host += map[string]string{"http": ":80", "https": ":443"}[req.URL.Scheme]
+	}
+	t.c.update(host, func(s *ClientStats, _ *HostStats) { s.Requests++ })
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.c.update(host, func(s *ClientStats, h *HostStats) {
+				if info.Reused {
+					s.ReusedConns++
+					h.ReusedConns++
+				} else {
+					s.NewConns++
+					h.NewConns++
+				}
+				if info.WasIdle && h.Idle > 0 {
+					h.Idle--
+				}
+			})
+		},
+		PutIdleConn: func(err error) {
+			if err == nil {
+				t.c.update(host, func(_ *ClientStats, h *HostStats) { h.Idle++ })
+			}
+		},
+	}
+	return t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// ClientStats returns the statistics of the builder's client when it was
+// created with NewTrackedClient, and false otherwise.
+func (r *Multipart) ClientStats() (ClientStats, bool) {
+	if t, ok := r.client.Transport.(*trackingTransport); ok {
+		return t.c.ClientStats(), true
+	}
+	return ClientStats{}, false
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+)
+
+// ErrNotIdle is returned by Clone for a builder that already has parts.
+var ErrNotIdle = errors.New("multipart: builder already started")
+
+// Clone returns a new builder with the configuration of r, for a template
+// builder that is set up once and cloned per upload in hot loops. r must
+// not have parts yet; its parts could not be replayed, so a template holds
+// only configuration, and shared parts go in a PartSet.
+//
+// The clone gets the client, method, URL, headers and request-level
+// options of r, and sends with the context r was created with. The header
+// map is shared until either builder changes it, so cloning costs no copy
+// of it; r must not be changed or sent while it is being cloned. A
+// boundary set with Boundary is kept and one from BoundaryPolicy
+// is generated anew; otherwise the clone has its own random boundary.
+// Queue and Workers are set up anew with the same sizes, so the clone
+// prepares parts on its own pool. Per-request state is not cloned: Record,
+// CaptureBody, AlsoWriteTo and BufferedPipe apply to r only, and
+// SkipUnchanged starts with no pending hashes.
+func (r *Multipart) Clone() *Multipart {
+	c := NewMultipart(r.parent, r.client, r.request.Method, r.request.URL.String())
+	if r.state.Load() != stateIdle {
+		c.pw.CloseWithError(fmt.Errorf("failed to clone builder: %w", ErrNotIdle))
+		return c
+	}
+	r.sharedHeader.Store(true)
+	c.sharedHeader.Store(true)
+	c.request.Header = r.request.Header
+
+	c.beforeDo = slices.Clip(r.beforeDo)
+	c.afterDo = slices.Clip(r.afterDo)
+	c.copyBufferSize = r.copyBufferSize
+	c.arrayNaming = r.arrayNaming
+	c.filenames = r.filenames
+	c.sanitize = r.sanitize
+	c.collisions = r.collisions
+	c.concurrent = r.concurrent
+	c.limiter = r.limiter
+	c.faults = r.faults
+	c.expectStatus = r.expectStatus
+	c.idempotency = r.idempotency
+	c.conditional = r.conditional
+	c.maxDecoded = r.maxDecoded
+	c.fanoutURLs = slices.Clip(r.fanoutURLs)
+	if r.window != nil {
+		c.Queue(r.window.size)
+	}
+	c.Workers(r.workers)
+	if r.heartbeat != nil {
+		c.heartbeat = &heartbeat{every: r.heartbeat.every, beat: r.heartbeat.beat}
+	}
+	if r.seen != nil {
+		c.seen = &seenTracker{store: r.seen.store}
+	}
+	if r.newBoundary != nil {
+		boundary, err := r.newBoundary()
+		if err != nil {
+			c.pw.CloseWithError(fmt.Errorf("failed to generate boundary: %w", err))
+			return c
+		}
+		if err := c.mw.SetBoundary(boundary); err != nil {
+			c.pw.CloseWithError(fmt.Errorf("failed to set boundary %q: %w", boundary, err))
+			return c
+		}
+		c.newBoundary = r.newBoundary
+	}
+	return c
+}
+
+// ownHeader returns the request header for writing, copying it first if
+// it is still shared with a Clone. The copy gets the builder's own
+// boundary, as the shared Content-Type may carry the other one's.
+func (r *Multipart) ownHeader() http.Header {
+	if r.sharedHeader.Load() {
+		h := r.request.Header.Clone()
+		if mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type")); mediaType == "multipart/form-data" {
+			h.Set("Content-Type", r.mw.FormDataContentType())
+		}
+		r.request.Header = h
+		r.sharedHeader.Store(false)
+	}
+	return r.request.Header
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/isauran/go-std-library/http/request/httpx"
+)
+
+// Codec encodes values for Encoded. It is the httpx codec, so a codec
+// registered here also decodes responses in httpx.Response.Negotiate.
+type Codec = httpx.Codec
+
+// Decoder is implemented by codecs that can also decode, as ParseInto
+// requires of the codecs of the parts it maps to structured fields.
+type Decoder = httpx.Decoder
+
+// Built-in codecs, registered under their media types.
+var (
+	JSONCodec = httpx.JSONCodec
+	XMLCodec  = httpx.XMLCodec
+)
+
+// RegisterCodec makes c available to LookupCodec under the media type of
+// its ContentType, replacing any codec registered for it before. It is
+// typically called from an init function, e.g. with a protobuf codec for
+// "application/x-protobuf".
+func RegisterCodec(c Codec) { httpx.RegisterCodec(c) }
+
+// LookupCodec returns the codec registered for the media type of
+// contentType; parameters such as charset are ignored.
+func LookupCodec(contentType string) (Codec, bool) { return httpx.LookupCodec(contentType) }
+
+func codecMediaType(contentType string) string { return httpx.MediaType(contentType) }
+
+// Encoded adds a file part holding v encoded by codec, with the codec's
+// Content-Type. The encoding is streamed into the part; an error fails the
+// request, possibly after part of the output was sent.
+func (r *Multipart) Encoded(field, filename string, codec Codec, v any, opts ...PartOption) *Multipart {
+	t := TRequest{Type: FileType, Key: field, Value: filename, generate: func(w io.Writer) error {
+		if err := codec.Encode(w, v); err != nil {
+			return fmt.Errorf("%s: %w", codec.ContentType(), err)
+		}
+		return nil
+	}}
+	t.setPartHeader("Content-Type", codec.ContentType())
+	r.send(t.apply(opts))
+	return r
+}
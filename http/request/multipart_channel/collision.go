@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrBoundaryCollision means the content of a part contains the boundary
+// delimiter, so receivers would end the part early and the body would be
+// corrupted. The random boundary makes this unlikely, but not for content
+// that embeds other multipart bodies or for a fixed Boundary.
+var ErrBoundaryCollision = errors.New("multipart: part content contains the boundary")
+
+// DetectBoundaryCollisions scans the content of every part for the
+// boundary delimiter and fails the part with ErrBoundaryCollision before
+// the colliding bytes are written. The body is streamed, so it cannot be
+// re-encoded with another boundary; callers that can rebuild the parts
+// retry with a new Boundary.
+func (r *Multipart) DetectBoundaryCollisions() *Multipart {
+	r.collisions = true
+	return r
+}
+
+// scanPart wraps the writer of a part's content when collisions are
+// detected.
+func (r *Multipart) scanPart(w io.Writer) io.Writer {
+	if !r.collisions {
+		return w
+	}
+	return &boundaryScanner{w: w, delim: []byte("--" + r.mw.Boundary())}
+}
+
+// checkCollision fails a field value that contains the boundary delimiter.
+func (r *Multipart) checkCollision(value string) error {
+	if r.collisions && strings.Contains(value, "--"+r.mw.Boundary()) {
+		return fmt.Errorf("%w %q", ErrBoundaryCollision, r.mw.Boundary())
+	}
+	return nil
+}
+
+// boundaryScanner passes writes on unless the stream written so far would
+// contain delim, also across write boundaries.
+type boundaryScanner struct {
+	w     io.Writer
+	delim []byte
+	tail  []byte // last len(delim)-1 bytes written
+}
+
+func (s *boundaryScanner) Write(p []byte) (int, error) {
+	window := append(s.tail, p...)
+	if bytes.Contains(window, s.delim) {
+		return 0, fmt.Errorf("%w %q", ErrBoundaryCollision, s.delim[2:])
+	}
+	s.tail = append(s.tail[:0], window[max(len(window)-len(s.delim)+1, 0):]...)
+	return s.w.Write(p)
+}
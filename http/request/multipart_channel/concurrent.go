@@ -0,0 +1,40 @@
+package main
+
+// Concurrent makes the methods adding parts safe to call from several
+// goroutines at once, and from goroutines racing Send and Close. It must be
+// called before the builder is shared, next to its other options:
+//
+//	req := NewMultipart(ctx, client, url).Concurrent()
+//	for _, f := range files {
+//		go func() { req.FileFromPath("files", f) }()
+//	}
+//
+// Parts are handed to the body one at a time under a lock. The parts a
+// goroutine adds appear in the order it added them; parts of different
+// goroutines interleave in the order they took the lock. Send and Close take
+// the same lock, so a part is either in the body or, once they ran, dropped
+// and reported by Err as ErrAlreadyClosed; it never hits a closed channel.
+//
+// Only adding parts is made safe. Options such as Header or Boundary must
+// still be set before the builder is shared.
+func (r *Multipart) Concurrent() *Multipart {
+	r.concurrent = true
+	return r
+}
+
+// lockSubmit takes the submission lock in Concurrent mode and returns the
+// function releasing it.
+func (r *Multipart) lockSubmit() func() {
+	if !r.concurrent {
+		return func() {}
+	}
+	r.submitMu.Lock()
+	return r.submitMu.Unlock
+}
+
+// closeSubmit moves the builder to the closed state, returning the previous
+// one. In Concurrent mode it waits for a part being handed over.
+func (r *Multipart) closeSubmit() int32 {
+	defer r.lockSubmit()()
+	return r.state.Swap(stateClosed)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotModified means the server answered 304 Not Modified to a
+	// request with IfNoneMatch. The error is a *ConditionError.
+	ErrNotModified = errors.New("multipart: not modified")
+	// ErrPreconditionFailed means the server answered 412 Precondition
+	// Failed to a conditional request. The error is a *ConditionError.
+	ErrPreconditionFailed = errors.New("multipart: precondition failed")
+)
+
+// ConditionError is returned by Send when the condition of a request made
+// with IfMatch, IfNoneMatch or IfUnmodifiedSince stopped the upload. The
+// response body was closed.
+type ConditionError struct {
+	StatusCode   int
+	ETag         string // the current ETag, if the server sent one
+	LastModified string
+}
+
+func (e *ConditionError) Error() string {
+	if e.StatusCode == http.StatusNotModified {
+		return "upload skipped: not modified"
+	}
+	return "upload rejected: precondition failed"
+}
+
+func (e *ConditionError) Is(target error) bool {
+	if e.StatusCode == http.StatusNotModified {
+		return target == ErrNotModified
+	}
+	return target == ErrPreconditionFailed
+}
+
+// IfMatch makes the upload replace the resource only if its current ETag
+// is one of etags, or if it exists at all for "*", so concurrent writers
+// do not overwrite each other. Otherwise Send fails with
+// ErrPreconditionFailed. Unquoted etags are quoted.
+func (r *Multipart) IfMatch(etags ...string) *Multipart {
+	r.ownHeader().Set("If-Match", etagList(etags))
+	r.conditional = true
+	return r
+}
+
+// IfNoneMatch makes the upload happen only if the resource's current ETag
+// is none of etags, or if it does not exist for "*", e.g. to create a
+// resource only once. Otherwise Send fails with ErrPreconditionFailed, or
+// ErrNotModified if the server answers 304. Unquoted etags are quoted.
+func (r *Multipart) IfNoneMatch(etags ...string) *Multipart {
+	r.ownHeader().Set("If-None-Match", etagList(etags))
+	r.conditional = true
+	return r
+}
+
+// IfUnmodifiedSince makes the upload happen only if the resource was not
+// modified after t. Otherwise Send fails with ErrPreconditionFailed.
+func (r *Multipart) IfUnmodifiedSince(t time.Time) *Multipart {
+	r.ownHeader().Set("If-Unmodified-Since", t.UTC().Format(http.TimeFormat))
+	r.conditional = true
+	return r
+}
+
+// etagList formats etags for an If-Match or If-None-Match header.
+func etagList(etags []string) string {
+	quoted := make([]string, len(etags))
+	for i, etag := range etags {
+		if etag != "*" && !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+			etag = fmt.Sprintf("%q", etag)
+		}
+		quoted[i] = etag
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// checkCondition turns the 304 and 412 answers to a conditional request
+// into a *ConditionError, closing resp.
+func (r *Multipart) checkCondition(resp *http.Response) error {
+	if !r.conditional || (resp.StatusCode != http.StatusNotModified && resp.StatusCode != http.StatusPreconditionFailed) {
+		return nil
+	}
+	resp.Body.Close()
+	return &ConditionError{
+		StatusCode:   resp.StatusCode,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AsCurl renders the configured request as an equivalent curl command.
+//
+// Fields and TextPart fields become --form-string arguments, the latter
+// without their encoding and charset, and FileFromPath parts become
+// -F 'name=@path' references, so large files are never inlined. Parts whose
+// content came from an io.Reader are streamed and not retained; they are
+// referenced by their filename and reported in the returned error, which
+// also covers FileFromPath files that no longer exist. The command is
+// returned even when the error is non-nil.
+func (r *Multipart) AsCurl() (string, error) {
+	defer r.lockSubmit()()
+	var errs []error
+	args := []string{"curl"}
+	if r.request.Method != http.MethodPost {
+		args = append(args, "-X", r.request.Method)
+	}
+
+	keys := make([]string, 0, len(r.request.Header))
+	for k := range r.request.Header {
+		// curl generates its own boundary.
+		if k != "Content-Type" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range r.request.Header[k] {
+			args = append(args, "-H", shellQuote(k+": "+v))
+		}
+	}
+
+	for _, p := range r.specs {
+		switch p.Type {
+		case StringType:
+			args = append(args, "--form-string", shellQuote(p.Key+"="+p.Value))
+		case TextType:
+			errs = append(errs, fmt.Errorf("part %q: sent as plain text, without the quoted-printable encoding and %s", p.Key, p.header.Get("Content-Type")))
+			args = append(args, "--form-string", shellQuote(p.Key+"="+p.Value))
+		case FieldReaderType:
+			errs = append(errs, fmt.Errorf("part %q: value was streamed from a reader, read from stdin", p.Key))
+			args = append(args, "-F", shellQuote(p.Key+"=<-"))
+		case FileType, PreparedType:
+			path := p.Path
+			if path == "" {
+				path = p.Value
+				errs = append(errs, fmt.Errorf("part %q: content was streamed from memory, referenced as @%s", p.Key, p.Value))
+			} else if _, err := os.Stat(path); err != nil {
+				errs = append(errs, fmt.Errorf("part %q: %w", p.Key, err))
+			}
+			arg := p.Key + "=@" + curlFormValue(path)
+			if p.Value != "" && p.Value != filepath.Base(path) {
+				arg += ";filename=" + curlFormValue(p.Value)
+			}
+			args = append(args, "-F", shellQuote(arg))
+		}
+	}
+
+	args = append(args, shellQuote(r.request.URL.String()))
+	return strings.Join(args, " "), errors.Join(errs...)
+}
+
+// curlFormValue quotes a -F value when it contains characters curl treats
+// as separators.
+func curlFormValue(s string) string {
+	if !strings.ContainsAny(s, `;,"`) {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// shellQuote wraps s in single quotes for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
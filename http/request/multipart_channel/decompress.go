@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxDecompressedSize is the response body limit of AcceptEncoding
+// without MaxDecompressedSize.
+const DefaultMaxDecompressedSize = 64 << 20
+
+// ErrDecompressedTooLarge is returned while reading a response body that
+// decodes to more than the limit of MaxDecompressedSize, e.g. a
+// decompression bomb.
+var ErrDecompressedTooLarge = errors.New("multipart: decompressed response too large")
+
+// ContentDecoder returns a reader of the content encoded in r.
+type ContentDecoder func(r io.Reader) (io.ReadCloser, error)
+
+var contentDecoders = struct {
+	sync.RWMutex
+	m map[string]ContentDecoder
+}{m: map[string]ContentDecoder{}}
+
+func init() {
+	RegisterContentDecoder("gzip", func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) })
+	RegisterContentDecoder("deflate", decodeDeflate)
+}
+
+// RegisterContentDecoder makes dec decode responses with the
+// Content-Encoding encoding, replacing any decoder registered for it
+// before. gzip and deflate are built in; register "br" or "zstd" decoders
+// from their libraries to accept those.
+func RegisterContentDecoder(encoding string, dec ContentDecoder) {
+	contentDecoders.Lock()
+	defer contentDecoders.Unlock()
+	contentDecoders.m[strings.ToLower(encoding)] = dec
+}
+
+func lookupContentDecoder(encoding string) (ContentDecoder, bool) {
+	contentDecoders.RLock()
+	defer contentDecoders.RUnlock()
+	dec, ok := contentDecoders.m[strings.ToLower(encoding)]
+	return dec, ok
+}
+
+// decodeDeflate reads "deflate" content, which should be zlib-wrapped but
+// is raw DEFLATE from some servers.
+func decodeDeflate(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err == nil && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 && header[0]&0x0f == 8 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// AcceptEncoding asks for responses compressed with one of encodings, in
+// order of preference, and decodes them, so Send, SendJSON, SendText and
+// SendJSONStream see the decoded body. This replaces the transport's
+// transparent gzip, which covers gzip only. Every encoding needs a
+// registered decoder, or the request fails. Decoded bodies are limited to
+// DefaultMaxDecompressedSize unless MaxDecompressedSize says otherwise.
+// AcceptEncoding must be called before any parts are added.
+func (r *Multipart) AcceptEncoding(encodings ...string) *Multipart {
+	for _, enc := range encodings {
+		if _, ok := lookupContentDecoder(enc); !ok {
+			r.pw.CloseWithError(fmt.Errorf("failed to accept encoding %q: no decoder registered", enc))
+			return r
+		}
+	}
+	r.ownHeader().Set("Accept-Encoding", strings.Join(encodings, ", "))
+	if r.maxDecoded == 0 {
+		r.maxDecoded = DefaultMaxDecompressedSize
+	}
+	r.afterDo = append(r.afterDo, func(m *Multipart, resp *http.Response, err error) (*http.Response, error) {
+		if err != nil {
+			return resp, err
+		}
+		if err := m.decodeBody(resp); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		return resp, nil
+	})
+	return r
+}
+
+// MaxDecompressedSize limits decoded response bodies of AcceptEncoding to
+// n bytes; reading past it fails with ErrDecompressedTooLarge.
+func (r *Multipart) MaxDecompressedSize(n int64) *Multipart {
+	r.maxDecoded = n
+	return r
+}
+
+// decodeBody replaces the body of resp with its decoded content.
+func (r *Multipart) decodeBody(resp *http.Response) error {
+	encoding := strings.TrimSpace(resp.Header.Get("Content-Encoding"))
+	if encoding == "" || strings.EqualFold(encoding, "identity") {
+		return nil
+	}
+	dec, ok := lookupContentDecoder(encoding)
+	if !ok {
+		return fmt.Errorf("failed to decode response: unsupported Content-Encoding %q", encoding)
+	}
+	body, err := dec(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	resp.Body = &decodedBody{r: body, raw: resp.Body, left: r.maxDecoded}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// decodedBody reads a decoded response body up to a limit.
+type decodedBody struct {
+	r    io.ReadCloser
+	raw  io.Closer
+	left int64
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	if d.left <= 0 {
+		// Tell a body of exactly the limit from a larger one.
+		var probe [1]byte
+		if n, _ := d.r.Read(probe[:]); n > 0 {
+			return 0, ErrDecompressedTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > d.left {
+		p = p[:d.left]
+	}
+	n, err := d.r.Read(p)
+	d.left -= int64(n)
+	return n, err
+}
+
+func (d *decodedBody) Close() error {
+	d.r.Close()
+	return d.raw.Close()
+}
+
+// SendJSON is Send decoding a JSON response into dst. A status other than
+// 2xx fails with a *StatusError.
+func (r *Multipart) SendJSON(dst any) error {
+	resp, err := r.Send()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(dst); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// SendText is Send returning the response body as a string. A status
+// other than 2xx fails with a *StatusError.
+func (r *Multipart) SendText() (string, error) {
+	resp, err := r.Send()
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	return string(body), nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnsafeName means a field name or filename contains a character that
+// cannot be sent in a Content-Disposition header without mangling it, such
+// as CR or LF, which would let an attacker-controlled filename inject
+// headers. The error is a *NameError, wrapped in a *PartError.
+var ErrUnsafeName = errors.New("multipart: unsafe name in Content-Disposition")
+
+// NameError reports the name a part could not be written with.
+type NameError struct {
+	Param string // "name" or "filename"
+	Value string
+	Char  rune // the first offending character
+}
+
+func (e *NameError) Error() string {
+	return fmt.Sprintf("%s %q contains %U", e.Param, e.Value, e.Char)
+}
+
+func (e *NameError) Unwrap() error { return ErrUnsafeName }
+
+// NameSanitizer rewrites a field name or filename so it can be sent.
+type NameSanitizer func(name string) string
+
+// HTMLNames encodes names the way browsers do in form submissions: `"`,
+// CR and LF become %22, %0D and %0A, and other control characters are
+// replaced by "_". Quotes and backslashes are thus never escaped, which
+// suits servers that do not unescape them.
+var HTMLNames NameSanitizer = func(name string) string {
+	return strings.Map(func(c rune) rune {
+		if isControl(c) {
+			return '_'
+		}
+		return c
+	}, htmlNameEscaper.Replace(name))
+}
+
+var htmlNameEscaper = strings.NewReplacer(`"`, "%22", "\r", "%0D", "\n", "%0A")
+
+// SanitizeNames rewrites the field names and filenames of the parts added
+// after it with s instead of failing parts whose names are unsafe, e.g.
+// SanitizeNames(HTMLNames) for uploads of user-supplied files. Without it,
+// quotes and backslashes are escaped and control characters fail the part
+// with ErrUnsafeName.
+func (r *Multipart) SanitizeNames(s NameSanitizer) *Multipart {
+	r.sanitize = s
+	return r
+}
+
+// sanitizeNames applies the builder's sanitizer to the names of t.
+func (r *Multipart) sanitizeNames(t *TRequest) {
+	if r.sanitize == nil {
+		return
+	}
+	t.Key = r.sanitize(t.Key)
+	if hasFilename(t.Type) {
+		t.Value = r.sanitize(t.Value)
+	}
+}
+
+// checkNames fails parts whose names cannot be sent as they are.
+func checkNames(b TRequest) error {
+	if c, ok := unsafeChar(b.Key); ok {
+		return &NameError{Param: "name", Value: b.Key, Char: c}
+	}
+	if hasFilename(b.Type) {
+		if c, ok := unsafeChar(b.Value); ok {
+			return &NameError{Param: "filename", Value: b.Value, Char: c}
+		}
+	}
+	return nil
+}
+
+func hasFilename(t RequestType) bool { return t == FileType || t == PreparedType }
+
+func unsafeChar(s string) (rune, bool) {
+	for _, c := range s {
+		if isControl(c) {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// isControl reports C0 controls other than tab, and DEL.
+func isControl(c rune) bool { return (c < 0x20 && c != '\t') || c == 0x7f }
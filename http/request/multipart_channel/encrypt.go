@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/isauran/go-std-library/io/gcmstream"
+)
+
+// FileEncrypted adds a file part whose content is encrypted on the fly with
+// chunked AES-GCM (see package gcmstream), without buffering the file or
+// writing it to a temporary file. key must be 16, 24 or 32 bytes; any other
+// length fails the request. The server decrypts the part with
+// gcmstream.NewReader.
+func (r *Multipart) FileEncrypted(field, filename string, content io.Reader, key []byte, opts ...PartOption) *Multipart {
+	key = bytes.Clone(key)
+	encrypt := func(w io.Writer) (io.WriteCloser, error) {
+		return gcmstream.NewWriter(w, key)
+	}
+	t := TRequest{Type: FileType, Key: field, Value: filename, Content: content, encoders: []partEncoder{encrypt}}
+	r.send(t.apply(opts))
+	return r
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/isauran/go-std-library/http/request/httpx"
+)
+
+// Media types of Endpoint.Accepts and Endpoint.Returns.
+const (
+	MediaMultipart = "multipart/form-data"
+	MediaJSON      = "application/json"
+)
+
+// Endpoint describes one operation of an API, for typed clients built on
+// Call.
+type Endpoint struct {
+	Method string // POST when empty
+	Path   string // resolved against the session's base URL
+	// Accepts is how the request is encoded: MediaMultipart sends it with
+	// Struct, MediaJSON as a JSON document. When empty, requests with form
+	// or file tags anywhere in their struct go as multipart and all others
+	// as JSON.
+	Accepts string
+	// Returns is how the response is decoded: a multipart one with
+	// ParseInto, MediaJSON with encoding/json. When empty the response's
+	// Content-Type decides.
+	Returns string
+}
+
+// Call sends req to ep through s and decodes the response into a Resp,
+// so an API client is a list of Endpoints and request and response types:
+//
+//	var upload = Endpoint{Path: "files"}
+//
+//	func (c *Client) Upload(ctx context.Context, req UploadRequest) (UploadResult, error) {
+//		return Call[UploadRequest, UploadResult](ctx, c.session, upload, req)
+//	}
+//
+// A status other than 2xx, or one the session's ExpectStatus does not
+// accept, fails with a *StatusError. An empty response body leaves Resp
+// zero.
+func Call[Req, Resp any](ctx context.Context, s *Session, ep Endpoint, req Req) (Resp, error) {
+	var out Resp
+	method := ep.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	accepts := ep.Accepts
+	if accepts == "" {
+		accepts = MediaJSON
+		if hasFormTags(reflect.TypeFor[Req]()) {
+			accepts = MediaMultipart
+		}
+	}
+
+	var resp *httpx.Response
+	var err error
+	switch accepts {
+	case MediaMultipart:
+		resp, err = s.NewRequest(ctx, method, ep.Path).Struct(req).Send()
+	case MediaJSON:
+		resp, err = httpx.Wrap(s.sendJSON(ctx, method, ep.Path, req))
+	default:
+		return out, fmt.Errorf("failed to call %s %s: unsupported request type %q", method, ep.Path, accepts)
+	}
+	if err != nil {
+		return out, fmt.Errorf("failed to call %s %s: %w", method, ep.Path, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return out, fmt.Errorf("failed to call %s %s: %w", method, ep.Path,
+			&StatusError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+	if err := decodeResponse(resp.Response, ep.Returns, &out); err != nil {
+		return out, fmt.Errorf("failed to call %s %s: %w", method, ep.Path, err)
+	}
+	return out, nil
+}
+
+// sendJSON sends v as a JSON document with the settings of the session.
+func (s *Session) sendJSON(ctx context.Context, method, path string, v any) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	target, err := s.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	s.mu.Lock()
+	for k, vs := range s.header {
+		req.Header[k] = append([]string{}, vs...)
+	}
+	limiter, expect := s.limiter, s.expect
+	s.mu.Unlock()
+	req.Header.Set("Content-Type", MediaJSON)
+	if limiter != nil {
+		if err := limiter.WaitRequest(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if expect != nil {
+		if err := checkStatus(acceptStatus(expect), resp); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// decodeResponse decodes the body of resp into dst and closes it.
+func decodeResponse(resp *http.Response, returns string, dst any) error {
+	if returns == "" {
+		returns, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	}
+	switch {
+	case strings.HasPrefix(returns, "multipart/"):
+		if reflect.ValueOf(dst).Elem().Kind() != reflect.Struct {
+			resp.Body.Close()
+			return fmt.Errorf("failed to parse response: %T is not a pointer to a struct", dst)
+		}
+		return ParseInto(resp, dst)
+	default:
+		defer resp.Body.Close()
+		err := json.NewDecoder(resp.Body).Decode(dst)
+		if err == io.EOF {
+			return nil // empty body
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		return nil
+	}
+}
+
+// hasFormTags reports whether a field of the struct t, or of a struct it
+// contains, has a form or file tag.
+func hasFormTags(t reflect.Type) bool {
+	return formTagged(t, map[reflect.Type]bool{})
+}
+
+func formTagged(t reflect.Type, seen map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return false
+	}
+	seen[t] = true
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if _, ok := sf.Tag.Lookup("form"); ok {
+			return true
+		}
+		if _, ok := sf.Tag.Lookup("file"); ok {
+			return true
+		}
+		if formTagged(sf.Type, seen) {
+			return true
+		}
+	}
+	return false
+}
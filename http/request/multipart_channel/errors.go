@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"slices"
+)
+
+// Failure classes of Send. Errors returned by Send match one of them with
+// errors.Is, besides the underlying cause, so callers can branch on the
+// class without parsing messages:
+//
+//	switch {
+//	case errors.Is(err, ErrTimeout), errors.Is(err, ErrPipeClosed):
+//		// retry
+//	case errors.Is(err, ErrResponseStatus):
+//		var se *StatusError
+//		errors.As(err, &se)
+//	}
+var (
+	// ErrPartWrite means a part could not be produced, e.g. a file failed
+	// to open or an encoder failed. The error is a *PartError.
+	ErrPartWrite = errors.New("multipart: failed to write part")
+	// ErrPipeClosed means the transport stopped reading the body, usually
+	// because the connection broke.
+	ErrPipeClosed = errors.New("multipart: body pipe closed")
+	// ErrResponseStatus means the response had a status ExpectStatus does
+	// not accept. The error is a *StatusError.
+	ErrResponseStatus = errors.New("multipart: unexpected response status")
+	// ErrTimeout means a deadline or network timeout expired.
+	ErrTimeout = errors.New("multipart: timeout")
+	// ErrCanceled means the request context was canceled.
+	ErrCanceled = errors.New("multipart: canceled")
+	// ErrWorkerPanic means a goroutine of the builder panicked, e.g. in a
+	// user-supplied reader or prepare function. The error is a *PanicError.
+	ErrWorkerPanic = errors.New("multipart: panic in worker")
+)
+
+// PartError reports the part whose writing failed.
+type PartError struct {
+	Part   string // field name
+	Index  int    // position among all parts, from 0
+	Offset int64  // body offset at which the part started
+	Err    error
+}
+
+func (e *PartError) Error() string {
+	return fmt.Sprintf("part %d [%q] at byte %d: %v", e.Index, e.Part, e.Offset, e.Err)
+}
+
+func (e *PartError) Unwrap() []error { return []error{ErrPartWrite, e.Err} }
+
+// PanicError is a panic recovered in a goroutine of the builder.
+type PanicError struct {
+	Value any    // the value passed to panic
+	Stack []byte // stack of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+func (e *PanicError) Is(target error) bool { return target == ErrWorkerPanic }
+
+// recoverPanic turns a panic of the calling goroutine into a *PanicError
+// passed to fail. It must be deferred directly.
+func recoverPanic(fail func(error)) {
+	if v := recover(); v != nil {
+		fail(&PanicError{Value: v, Stack: debug.Stack()})
+	}
+}
+
+// RequestError is returned by Send when the request failed before a
+// response arrived.
+type RequestError struct {
+	Attempt int   // the builder sends once, so always 1
+	Sent    int64 // body bytes handed to the transport
+	Err     error
+	class   error
+}
+
+func newRequestError(err error, sent int64) *RequestError {
+	e := &RequestError{Attempt: 1, Sent: sent, Err: err}
+	var ne net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		e.class = ErrCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		e.class = ErrTimeout
+	case errors.Is(err, io.ErrClosedPipe):
+		e.class = ErrPipeClosed
+	}
+	return e
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("request failed after %d body bytes: %v", e.Sent, e.Err)
+}
+
+func (e *RequestError) Unwrap() []error {
+	if e.class == nil {
+		return []error{e.Err}
+	}
+	return []error{e.class, e.Err}
+}
+
+// StatusError is returned by Send for a response status that ExpectStatus
+// does not accept. The response body was closed.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "unexpected response status " + e.Status
+}
+
+func (e *StatusError) Is(target error) bool { return target == ErrResponseStatus }
+
+// ExpectStatus makes Send fail with a *StatusError when the response status
+// is not one of codes, or not 2xx if codes is empty. By default Send
+// returns every response, whatever its status.
+func (r *Multipart) ExpectStatus(codes ...int) *Multipart {
+	r.expectStatus = acceptStatus(codes)
+	return r
+}
+
+// acceptStatus returns the check of ExpectStatus(codes...).
+func acceptStatus(codes []int) func(code int) bool {
+	return func(code int) bool {
+		if len(codes) == 0 {
+			return code/100 == 2
+		}
+		return slices.Contains(codes, code)
+	}
+}
+
+func (r *Multipart) checkStatus(resp *http.Response) error {
+	return checkStatus(r.expectStatus, resp)
+}
+
+// checkStatus closes resp and returns a *StatusError if accept is set and
+// does not accept its status.
+func checkStatus(accept func(code int) bool, resp *http.Response) error {
+	if accept == nil || accept(resp.StatusCode) {
+		return nil
+	}
+	resp.Body.Close()
+	return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/isauran/go-std-library/sync/waitgroup/taskgroup"
+)
+
+// FanoutResult is the outcome of sending the body to one destination.
+type FanoutResult struct {
+	URL      string
+	Response *http.Response
+	Err      error
+}
+
+// Fanout mirrors the body to urls besides the builder's URL, e.g. to
+// primary and backup storage. The parts are generated once and every
+// chunk is handed to each destination in turn, so the slowest one paces
+// the upload; a mirror whose request fails is dropped and the others go
+// on. Mirrors get the builder's method and headers, but not the hooks of
+// request-level options. Send returns the builder URL's response and
+// closes the mirrors' ones; use SendAll to see them. Fanout must be called
+// before any parts are added.
+func (r *Multipart) Fanout(urls ...string) *Multipart {
+	r.fanoutURLs = append(r.fanoutURLs, urls...)
+	return r
+}
+
+// SendAll is Send for a builder with Fanout destinations. It returns one
+// result per destination, the builder's URL first. The caller must close
+// every Response.
+func (r *Multipart) SendAll() []FanoutResult {
+	resp, err := r.sendPrimary()
+	results := []FanoutResult{{URL: r.request.URL.String(), Response: resp, Err: err}}
+	return append(results, r.collectMirrors()...)
+}
+
+type mirror struct {
+	pw  *io.PipeWriter
+	err error // set once the mirror stopped reading
+}
+
+// startMirrors starts a request per Fanout URL and routes the body to all
+// of them. It runs in startRequest, before the worker writes anything.
+func (r *Multipart) startMirrors() {
+	if len(r.fanoutURLs) == 0 {
+		return
+	}
+	g, _ := taskgroup.New(r.request.Context(), taskgroup.KeepGoing())
+	r.mirrors = taskgroup.Collect[FanoutResult](g)
+	fp := &fanoutPipe{primary: r.pw}
+	for _, u := range r.fanoutURLs {
+		target, err := url.Parse(u)
+		if err != nil {
+			r.mirrors.Go(func(context.Context) (FanoutResult, error) {
+				return FanoutResult{}, fmt.Errorf("failed to parse mirror URL: %w", err)
+			})
+			continue
+		}
+		pr, pw := io.Pipe()
+		fp.mirrors = append(fp.mirrors, &mirror{pw: pw})
+
+		req := r.request.Clone(r.request.Context())
+		req.URL, req.Host = target, ""
+		req.Body, req.GetBody, req.ContentLength = pr, nil, -1
+		r.mirrors.Go(func(context.Context) (FanoutResult, error) {
+			// The transport may return before reading the whole body, or
+			// not at all if it panics.
+			defer pr.CloseWithError(io.ErrClosedPipe)
+			resp, err := r.client.Do(req)
+			return FanoutResult{Response: resp}, err
+		})
+	}
+	r.pw = fp
+	r.out.w = fp
+}
+
+// collectMirrors waits for the mirror requests once.
+func (r *Multipart) collectMirrors() []FanoutResult {
+	r.mirrorsDone.Do(func() {
+		if r.mirrors == nil {
+			return
+		}
+		results, _ := r.mirrors.Wait()
+		for i, res := range results {
+			out := res.Value
+			out.URL, out.Err = r.fanoutURLs[i], res.Err
+			var pe *taskgroup.PanicError
+			if errors.As(res.Err, &pe) {
+				out.Err = &PanicError{Value: pe.Value, Stack: pe.Stack}
+			}
+			r.mirrorResults = append(r.mirrorResults, out)
+		}
+	})
+	return r.mirrorResults
+}
+
+// closeMirrors waits for the mirror requests and closes their responses.
+func (r *Multipart) closeMirrors() {
+	for _, res := range r.collectMirrors() {
+		if res.Response != nil {
+			res.Response.Body.Close()
+		}
+	}
+}
+
+// fanoutPipe writes the body to the primary pipe and each live mirror.
+type fanoutPipe struct {
+	primary bodyPipe
+	mirrors []*mirror
+}
+
+func (f *fanoutPipe) Write(p []byte) (int, error) {
+	n, err := f.primary.Write(p)
+	if err != nil {
+		return n, err
+	}
+	for _, m := range f.mirrors {
+		if m.err == nil {
+			_, m.err = m.pw.Write(p)
+		}
+	}
+	return n, nil
+}
+
+func (f *fanoutPipe) Close() error {
+	for _, m := range f.mirrors {
+		m.pw.Close()
+	}
+	return f.primary.Close()
+}
+
+func (f *fanoutPipe) CloseWithError(err error) error {
+	for _, m := range f.mirrors {
+		m.pw.CloseWithError(err)
+	}
+	return f.primary.CloseWithError(err)
+}
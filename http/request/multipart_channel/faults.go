@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// ErrInjectedFault is the error of a FaultError without its own Err.
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultAction is what InjectFaults does to a matching part.
+type FaultAction int
+
+const (
+	// FaultDrop leaves the part out of the body.
+	FaultDrop FaultAction = iota
+	// FaultDelay waits for Fault.Delay before writing the part, as a slow
+	// producer would.
+	FaultDelay
+	// FaultDuplicate writes the part twice, byte for byte.
+	FaultDuplicate
+	// FaultError aborts the body with Fault.Err instead of writing the part.
+	FaultError
+)
+
+// Fault selects parts by name, or by index when Name is empty, and says
+// what to do to them.
+type Fault struct {
+	Name   string // part name; every part of that name matches
+	Index  int    // position among all parts added, from 0, when Name is ""
+	Action FaultAction
+	Delay  time.Duration // for FaultDelay
+	Err    error         // for FaultError; ErrInjectedFault if nil
+}
+
+// InjectFaults drops, delays, duplicates or fails the parts matched by
+// faults while the body is streamed, so integration tests can check how a
+// server copes with the broken bodies the concurrent_error demos produce
+// by accident. The first matching fault applies. InjectFaults must be
+// called before any parts are added.
+func (r *Multipart) InjectFaults(faults ...Fault) *Multipart {
+	r.faults = &faultInjector{faults: faults}
+	return r
+}
+
+type faultInjector struct {
+	faults []Fault
+}
+
+func (f *faultInjector) match(name string, index int) *Fault {
+	for i := range f.faults {
+		fault := &f.faults[i]
+		if fault.Name == name && name != "" || fault.Name == "" && fault.Index == index {
+			return fault
+		}
+	}
+	return nil
+}
+
+// write writes b, the part at index, with its fault applied.
+func (f *faultInjector) write(r *Multipart, b TRequest, index int) error {
+	fault := f.match(b.Key, index)
+	if fault == nil {
+		return r.writePart(b)
+	}
+	switch fault.Action {
+	case FaultDrop:
+		return nil
+	case FaultDelay:
+		timer := time.NewTimer(fault.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.request.Context().Done():
+			return r.request.Context().Err()
+		}
+		return r.writePart(b)
+	case FaultDuplicate:
+		// The part's bytes run from its boundary to the end of its content,
+		// so replaying them adds an identical part.
+		var raw bytes.Buffer
+		r.out.taps = append(r.out.taps, &raw)
+		err := r.writePart(b)
+		r.out.taps = r.out.taps[:len(r.out.taps)-1]
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(raw.Bytes(), []byte("--")) { // the first part has no leading CRLF
+			r.out.Write([]byte("\r\n"))
+		}
+		_, err = r.out.Write(raw.Bytes())
+		return err
+	case FaultError:
+		err := fault.Err
+		if err == nil {
+			err = ErrInjectedFault
+		}
+		return err
+	}
+	return r.writePart(b)
+}
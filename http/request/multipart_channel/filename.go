@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FilenameEncoding formats the filename parameters of a file part's
+// Content-Disposition, after `form-data; name="..."; `. Servers disagree
+// on non-ASCII filenames; pick the strategy the receiving side reads.
+type FilenameEncoding func(filename string) string
+
+var (
+	// FilenameRaw sends the name as quoted UTF-8, as browsers do and as
+	// RFC 7578 recommends. It is the default.
+	FilenameRaw FilenameEncoding = func(filename string) string {
+		return fmt.Sprintf(`filename="%s"`, quoteEscaper.Replace(filename))
+	}
+	// FilenameExtended adds the RFC 5987 filename* parameter with the
+	// percent-encoded UTF-8 name next to a transliterated filename, for
+	// servers that follow RFC 6266 and mangle raw UTF-8. ASCII names are
+	// sent as with FilenameRaw.
+	FilenameExtended FilenameEncoding = func(filename string) string {
+		if isASCII(filename) {
+			return FilenameRaw(filename)
+		}
+		return FilenameRaw(Transliterate(filename)) + "; filename*=UTF-8''" + extValue(filename)
+	}
+	// FilenameASCII sends only the transliterated name, for legacy
+	// servers that cannot handle anything but ASCII.
+	FilenameASCII FilenameEncoding = func(filename string) string {
+		return FilenameRaw(Transliterate(filename))
+	}
+)
+
+// FilenameEncoding sets how the filenames of file parts are written. It
+// applies to the parts added after it.
+func (r *Multipart) FilenameEncoding(enc FilenameEncoding) *Multipart {
+	r.filenames = enc
+	return r
+}
+
+// translit maps Cyrillic and accented Latin letters to ASCII.
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "ae", 'å': "a", 'æ': "ae", 'ç': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "oe", 'ø': "o", 'ù': "u",
+	'ú': "u", 'û': "u", 'ü': "ue", 'ý': "y", 'ÿ': "y", 'ß': "ss", 'ą': "a", 'ć': "c",
+	'č': "c", 'ď': "d", 'ę': "e", 'ě': "e", 'ł': "l", 'ń': "n", 'ň': "n", 'ř': "r",
+	'ś': "s", 'š': "s", 'ť': "t", 'ů': "u", 'ź': "z", 'ż': "z", 'ž': "z", 'ğ': "g",
+	'ı': "i", 'ş': "s", 'œ': "oe",
+}
+
+// Transliterate returns filename in printable ASCII. Cyrillic and accented
+// Latin letters are spelled out, keeping their case; anything else, such
+// as emoji, becomes "_".
+func Transliterate(filename string) string {
+	var b strings.Builder
+	for _, c := range filename {
+		switch s, ok := translit[unicode.ToLower(c)]; {
+		case c < utf8.RuneSelf && unicode.IsPrint(c):
+			b.WriteRune(c)
+		case ok && unicode.IsUpper(c) && s != "":
+			b.WriteString(strings.ToUpper(s[:1]) + s[1:])
+		case ok:
+			b.WriteString(s)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// extValue percent-encodes s as an RFC 5987 value, keeping only attr-char.
+func extValue(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := range len(s) {
+		c := s[i]
+		if c < utf8.RuneSelf && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) || strings.IndexByte("!#$&+-.^_`|~", c) >= 0) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&15])
+	}
+	return b.String()
+}
+
+func isASCII(s string) bool {
+	for i := range len(s) {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// PartFuture reports whether one part made it into the body, for
+// producers that add parts asynchronously and need a per-part
+// acknowledgement. A part is done once the worker handed it to the body,
+// which says nothing about the server's answer; Send reports that.
+type PartFuture struct {
+	done chan struct{}
+	err  error
+}
+
+func newPartFuture() *PartFuture {
+	return &PartFuture{done: make(chan struct{})}
+}
+
+// Done is closed once the part was written or failed.
+func (f *PartFuture) Done() <-chan struct{} { return f.done }
+
+// Err returns nil while the part is pending or if it was written, and
+// otherwise why it was not: a *PartError for the part that failed, or an
+// error wrapping that one for parts behind it, or ErrAlreadyClosed.
+func (f *PartFuture) Err() error {
+	select {
+	case <-f.done:
+		return f.err
+	default:
+		return nil
+	}
+}
+
+// complete resolves the future of t, if it has one.
+func (t TRequest) complete(err error) {
+	if t.ack != nil {
+		t.ack.err = err
+		close(t.ack.done)
+	}
+}
+
+// SubmitParam is Param, returning the future of the field instead of the
+// builder.
+func (r *Multipart) SubmitParam(key, value string) *PartFuture {
+	t := TRequest{Type: StringType, Key: key, Value: value, ack: newPartFuture()}
+	r.send(t)
+	return t.ack
+}
+
+// SubmitFile is File, returning the future of the part instead of the
+// builder.
+func (r *Multipart) SubmitFile(key, filename string, content io.Reader, opts ...PartOption) *PartFuture {
+	t := TRequest{Type: FileType, Key: key, Value: filename, Content: content}
+	t = t.apply(opts)
+	t.ack = newPartFuture()
+	r.send(t)
+	return t.ack
+}
+
+// skipped is the error of the parts behind a failed one.
+func skipped(failed error) error {
+	return fmt.Errorf("part not written: %w", failed)
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultRecordLimit is how many bytes of the request and response bodies
+// Record keeps by default.
+const DefaultRecordLimit = 64 << 10
+
+// Record captures the outgoing request and its response as a HAR 1.2
+// document written to w once the response body is closed (or the request
+// fails). Bodies are kept up to RecordLimit bytes; sizes are always exact.
+// Record must be called before any parts are added.
+func (r *Multipart) Record(w io.Writer) *Multipart {
+	rec := &harRecorder{w: w, limit: DefaultRecordLimit}
+	r.recorder = rec
+	r.out.taps = append(r.out.taps, &rec.reqBody)
+	r.beforeDo = append(r.beforeDo, func(m *Multipart, req *http.Request) *http.Request {
+		if m.recorder == nil { // a Clone, which records nothing
+			return req
+		}
+		return m.recorder.before(req)
+	})
+	r.afterDo = append(r.afterDo, func(m *Multipart, resp *http.Response, err error) (*http.Response, error) {
+		if m.recorder == nil {
+			return resp, err
+		}
+		return m.recorder.after(resp, err)
+	})
+	return r
+}
+
+// RecordLimit sets how many body bytes Record keeps per direction.
+func (r *Multipart) RecordLimit(n int64) *Multipart {
+	if r.recorder != nil {
+		r.recorder.limit = n
+	}
+	return r
+}
+
+type harRecorder struct {
+	w     io.Writer
+	limit int64
+
+	reqBody  cappedBuffer
+	respBody cappedBuffer
+	req      *http.Request
+	once     sync.Once
+
+	mu                       sync.Mutex
+	start, end               time.Time
+	dnsStart, dnsDone        time.Time
+	connectStart, connectEnd time.Time
+	tlsStart, tlsDone        time.Time
+	gotConn, wroteRequest    time.Time
+	firstByte                time.Time
+}
+
+func (h *harRecorder) mark(t *time.Time) func() {
+	return func() {
+		h.mu.Lock()
+		*t = time.Now()
+		h.mu.Unlock()
+	}
+}
+
+func (h *harRecorder) before(req *http.Request) *http.Request {
+	h.reqBody.limit = h.limit
+	h.respBody.limit = h.limit
+	h.start = time.Now()
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { h.mark(&h.dnsStart)() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { h.mark(&h.dnsDone)() },
+		ConnectStart:         func(string, string) { h.mark(&h.connectStart)() },
+		ConnectDone:          func(string, string, error) { h.mark(&h.connectEnd)() },
+		TLSHandshakeStart:    h.mark(&h.tlsStart),
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { h.mark(&h.tlsDone)() },
+		GotConn:              func(httptrace.GotConnInfo) { h.mark(&h.gotConn)() },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { h.mark(&h.wroteRequest)() },
+		GotFirstResponseByte: h.mark(&h.firstByte),
+	}
+	h.req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	return h.req
+}
+
+func (h *harRecorder) after(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		h.finish(nil, err)
+		return resp, err
+	}
+	resp.Body = &recordedBody{ReadCloser: resp.Body, rec: h, resp: resp}
+	return resp, nil
+}
+
+// recordedBody tees the response body into the recorder and writes the HAR
+// document when the caller is done with it.
+type recordedBody struct {
+	io.ReadCloser
+	rec  *harRecorder
+	resp *http.Response
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.rec.respBody.Write(p[:n])
+	if err == io.EOF {
+		b.rec.finish(b.resp, nil)
+	}
+	return n, err
+}
+
+func (b *recordedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.rec.finish(b.resp, nil)
+	return err
+}
+
+func (h *harRecorder) finish(resp *http.Response, err error) {
+	h.once.Do(func() {
+		h.mu.Lock()
+		h.end = time.Now()
+		h.mu.Unlock()
+		doc := harDocument{Log: harLog{
+			Version: "1.2",
+			Creator: harCreator{Name: "multipart_channel", Version: "1.0"},
+			Entries: []harEntry{h.entry(resp, err)},
+		}}
+		enc := json.NewEncoder(h.w)
+		enc.SetIndent("", "  ")
+		enc.Encode(doc)
+	})
+}
+
+func (h *harRecorder) entry(resp *http.Response, err error) harEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	req := h.req
+	e := harEntry{
+		StartedDateTime: h.start.Format(time.RFC3339Nano),
+		Time:            ms(h.start, h.end),
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(req.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    h.reqBody.total,
+			PostData: &harPostData{
+				MimeType: req.Header.Get("Content-Type"),
+				Text:     strings.ToValidUTF8(h.reqBody.String(), "�"),
+				Comment:  h.reqBody.comment(),
+			},
+		},
+		Response: harResponse{
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Cache:   struct{}{},
+		Timings: h.timings(),
+	}
+	for k, vs := range req.URL.Query() {
+		for _, v := range vs {
+			e.Request.QueryString = append(e.Request.QueryString, harNameValue{Name: k, Value: v})
+		}
+	}
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+
+	e.Response.Status = resp.StatusCode
+	e.Response.StatusText = http.StatusText(resp.StatusCode)
+	e.Response.HTTPVersion = resp.Proto
+	e.Response.Headers = harHeaders(resp.Header)
+	e.Response.RedirectURL = resp.Header.Get("Location")
+	e.Response.BodySize = h.respBody.total
+	e.Response.Content = harContent{
+		Size:     h.respBody.total,
+		MimeType: resp.Header.Get("Content-Type"),
+		Comment:  h.respBody.comment(),
+	}
+	if b := h.respBody.Bytes(); utf8.Valid(b) {
+		e.Response.Content.Text = string(b)
+	} else {
+		e.Response.Content.Text = base64.StdEncoding.EncodeToString(b)
+		e.Response.Content.Encoding = "base64"
+	}
+	return e
+}
+
+// timings splits the request into HAR phases. Phases the transport did not
+// go through (e.g. DNS on a reused connection) are reported as -1.
+func (h *harRecorder) timings() harTimings {
+	t := harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Send: 0, Wait: 0, Receive: 0}
+	if !h.dnsStart.IsZero() {
+		t.DNS = ms(h.dnsStart, h.dnsDone)
+	}
+	if !h.connectStart.IsZero() {
+		t.Connect = ms(h.connectStart, h.connectEnd)
+	}
+	if !h.tlsStart.IsZero() {
+		t.SSL = ms(h.tlsStart, h.tlsDone)
+		t.Connect += t.SSL // HAR counts SSL as part of connect
+	}
+	if !h.gotConn.IsZero() {
+		t.Blocked = ms(h.start, h.gotConn) - max(t.DNS, 0) - max(t.Connect, 0)
+		if t.Blocked < 0 {
+			t.Blocked = 0
+		}
+		t.Send = ms(h.gotConn, h.wroteRequest)
+	}
+	if !h.firstByte.IsZero() {
+		// The server may answer before the streamed body is fully written.
+		t.Wait = ms(maxTime(h.wroteRequest, h.gotConn), h.firstByte)
+		t.Receive = ms(h.firstByte, h.end)
+	}
+	return t
+}
+
+func ms(from, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0
+	}
+	return float64(to.Sub(from).Microseconds()) / 1000
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := []harNameValue{}
+	for _, k := range keys {
+		for _, v := range h[k] {
+			out = append(out, harNameValue{Name: k, Value: v})
+		}
+	}
+	return out
+}
+
+// cappedBuffer keeps up to limit bytes while counting everything written.
+type cappedBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit int64
+	total int64
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total += int64(len(p))
+	if room := c.limit - int64(c.buf.Len()); room > 0 {
+		c.buf.Write(p[:min(room, int64(len(p)))])
+	}
+	return len(p), nil
+}
+
+func (c *cappedBuffer) Bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Bytes()
+}
+
+func (c *cappedBuffer) String() string {
+	return string(c.Bytes())
+}
+
+func (c *cappedBuffer) comment() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.total > int64(c.buf.Len()) {
+		return fmt.Sprintf("truncated to %d of %d bytes", c.buf.Len(), c.total)
+	}
+	return ""
+}
+
+type harDocument struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HeartbeatFunc writes filler to the body while the worker waits for the
+// next part. Bytes may only go into whole parts written with mw: anything
+// else would end up in the content of the previous part.
+type HeartbeatFunc func(mw *multipart.Writer) error
+
+// FillerField is a HeartbeatFunc adding an empty form field called name,
+// which servers are expected to ignore.
+func FillerField(name string) HeartbeatFunc {
+	return func(mw *multipart.Writer) error {
+		return mw.WriteField(name, "")
+	}
+}
+
+// Heartbeat calls beat whenever no body bytes were written for every, so
+// proxies and load balancers with strict idle timeouts keep the connection
+// of a builder whose producers pause between parts. Beats happen only
+// between parts: a part that stalls, such as a FileFromChan one waiting
+// for chunks, holds them off, as nothing can be injected into it. A beat
+// that fails fails the request. Heartbeat must be called before any parts
+// are added.
+func (r *Multipart) Heartbeat(every time.Duration, beat HeartbeatFunc) *Multipart {
+	if every > 0 && beat != nil {
+		r.heartbeat = &heartbeat{every: every, beat: beat}
+	}
+	return r
+}
+
+type heartbeat struct {
+	every time.Duration
+	beat  HeartbeatFunc
+	mu    sync.Mutex // held by the worker while it writes a part
+}
+
+// startHeartbeat runs the heartbeat until the returned func is called. It
+// runs in the worker, which owns the multipart writer.
+func (r *Multipart) startHeartbeat() (stop func()) {
+	hb := r.heartbeat
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(hb.every)
+		defer ticker.Stop()
+		last := r.out.written.Load()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if written := r.out.written.Load(); written != last {
+				last = written
+				continue
+			}
+			if !hb.mu.TryLock() {
+				continue // the worker is in a part
+			}
+			err := hb.beat(r.mw)
+			last = r.out.written.Load()
+			hb.mu.Unlock()
+			if err != nil {
+				r.pw.CloseWithError(fmt.Errorf("failed to write heartbeat: %w", err))
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// NewKeepAliveClient returns a copy of client whose connections send TCP
+// keepalive probes after idle of inactivity and every idle after that, for
+// load balancers that count probes as activity. It only applies to an
+// *http.Transport, which is cloned, so the copy does not share the
+// connection pool of client; other transports are kept as they are. A nil
+// client means http.DefaultClient.
+func NewKeepAliveClient(client *http.Client, idle time.Duration) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	copied := *client
+	base := copied.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if t, ok := base.(*http.Transport); ok {
+		t = t.Clone()
+		dialer := &net.Dialer{
+			Timeout:         30 * time.Second,
+			KeepAliveConfig: net.KeepAliveConfig{Enable: true, Idle: idle, Interval: idle, Count: -1},
+		}
+		t.DialContext = dialer.DialContext
+		copied.Transport = t
+	}
+	return &copied
+}
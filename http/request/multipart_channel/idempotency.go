@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// IdempotencyHeader is the header Idempotent sets.
+const IdempotencyHeader = "Idempotency-Key"
+
+// DefaultReplayLimit is the largest response body ReplayFor caches.
+const DefaultReplayLimit = 1 << 20
+
+// ErrKeyInFlight is returned by Send when a request with the same
+// idempotency key is still running.
+var ErrKeyInFlight = errors.New("multipart: idempotency key in flight")
+
+// KeyFunc returns the idempotency key of a request. Keys derived from
+// what the upload is, such as a job ID, let a retried upload reuse the key
+// of the first attempt.
+type KeyFunc func(req *http.Request) string
+
+// RandomKey is a KeyFunc returning a new random key, which protects
+// against duplicates only on retries made by the transport or a proxy.
+func RandomKey(*http.Request) string {
+	b := make([]byte, 16)
+	rand.Read(b) // never fails
+	return hex.EncodeToString(b)
+}
+
+// CachedResponse is a response kept by an IdempotencyStore.
+type CachedResponse struct {
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	Expires    time.Time   `json:"expires"`
+}
+
+// IdempotencyStore tracks the idempotency keys of requests.
+type IdempotencyStore interface {
+	// Begin marks key in flight. For a key that completed and has not
+	// expired it returns the cached response instead, and for a key in
+	// flight it fails with ErrKeyInFlight.
+	Begin(key string) (*CachedResponse, error)
+	// Complete ends the flight of key and caches res until res.Expires. A
+	// nil res forgets key so the request can be tried again.
+	Complete(key string, res *CachedResponse) error
+}
+
+// IdempotencyOption configures Idempotent.
+type IdempotencyOption func(*idempotency)
+
+// KeyStore tracks keys in store instead of a store shared by the builders
+// of the process, e.g. to persist them with a DirIdempotencyStore.
+func KeyStore(store IdempotencyStore) IdempotencyOption {
+	return func(i *idempotency) { i.store = store }
+}
+
+// ReplayFor caches 2xx and 4xx responses for ttl: sending again with the
+// same key within ttl returns a copy of the cached response without a
+// request, so a retried upload does not create the resource twice. Statuses
+// that ask for a retry, 408, 425 and 429, and 5xx forget the key instead,
+// so the retry reaches the server. Bodies over DefaultReplayLimit are not
+// cached.
+func ReplayFor(ttl time.Duration) IdempotencyOption {
+	return func(i *idempotency) { i.ttl = ttl }
+}
+
+// defaultIdempotencyStore is the store of builders without a KeyStore.
+var defaultIdempotencyStore = NewMemoryIdempotencyStore()
+
+// Idempotent sets an Idempotency-Key header from key, RandomKey if nil,
+// and fails Send with ErrKeyInFlight while a request with the same key to
+// the same host is running. With ReplayFor, duplicates of a completed
+// request are answered from the store. The parts of a replayed request are
+// still consumed, but nothing is sent. Fanout mirrors are not covered.
+// Idempotent must be called before any parts are added.
+func (r *Multipart) Idempotent(key KeyFunc, opts ...IdempotencyOption) *Multipart {
+	if key == nil {
+		key = RandomKey
+	}
+	i := &idempotency{key: key, store: defaultIdempotencyStore}
+	for _, opt := range opts {
+		opt(i)
+	}
+	r.idempotency = i
+	return r
+}
+
+type idempotency struct {
+	key   KeyFunc
+	store IdempotencyStore
+	ttl   time.Duration
+}
+
+// do sends req with client under its idempotency key.
+func (i *idempotency) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	key := i.key(req)
+	req.Header.Set(IdempotencyHeader, key)
+	storeKey := req.URL.Host + " " + key
+
+	cached, err := i.store.Begin(storeKey)
+	if err == nil && cached != nil {
+		// Let the worker finish writing the parts nobody reads.
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+		return cached.response(req), nil
+	}
+	if err != nil {
+		req.Body.Close()
+		return nil, fmt.Errorf("failed to begin idempotent request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil || !replayable(resp.StatusCode) || i.ttl <= 0 {
+		i.store.Complete(storeKey, nil)
+		return resp, err
+	}
+	body, rerr := io.ReadAll(io.LimitReader(resp.Body, DefaultReplayLimit+1))
+	if rerr != nil || len(body) > DefaultReplayLimit {
+		// Too large or broken to cache: hand on what was read and the rest.
+		i.store.Complete(storeKey, nil)
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	res := &CachedResponse{StatusCode: resp.StatusCode, Header: resp.Header.Clone(), Body: body, Expires: time.Now().Add(i.ttl)}
+	if err := i.store.Complete(storeKey, res); err != nil {
+		return nil, fmt.Errorf("failed to cache idempotent response: %w", err)
+	}
+	return resp, nil
+}
+
+// replayable reports whether a response with status code may be replayed
+// to duplicates: a success or a client error that a retry would repeat.
+func replayable(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return false
+	}
+	return code/100 == 2 || code/100 == 4
+}
+
+// response returns a new response with the cached status, header and body.
+func (c *CachedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", c.StatusCode, http.StatusText(c.StatusCode)),
+		StatusCode:    c.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        c.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore kept in memory, for the
+// lifetime of one process. Expired responses are dropped whenever the
+// number of keys has doubled since the last sweep, so memory stays bounded
+// by the responses cached within their TTL.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*CachedResponse // nil while in flight
+	sweepAt int                        // number of keys of the next sweep
+}
+
+// minSweep is the number of keys below which MemoryIdempotencyStore does
+// not sweep.
+const minSweep = 64
+
+// NewMemoryIdempotencyStore returns an empty MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: map[string]*CachedResponse{}, sweepAt: minSweep}
+}
+
+func (m *MemoryIdempotencyStore) Begin(key string) (*CachedResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res, ok := m.entries[key]
+	switch {
+	case ok && res == nil:
+		return nil, ErrKeyInFlight
+	case ok && time.Now().Before(res.Expires):
+		return res, nil
+	}
+	if len(m.entries) >= m.sweepAt {
+		m.sweep(time.Now())
+	}
+	m.entries[key] = nil
+	return nil, nil
+}
+
+// sweep drops the responses expired at now.
+func (m *MemoryIdempotencyStore) sweep(now time.Time) {
+	for k, res := range m.entries {
+		if res != nil && !now.Before(res.Expires) {
+			delete(m.entries, k)
+		}
+	}
+	m.sweepAt = max(2*len(m.entries), minSweep)
+}
+
+func (m *MemoryIdempotencyStore) Complete(key string, res *CachedResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if res == nil {
+		delete(m.entries, key)
+	} else {
+		m.entries[key] = res
+	}
+	return nil
+}
+
+// DirIdempotencyStore is an IdempotencyStore persisted as one JSON file
+// per key in a directory, which processes on the same host may share.
+type DirIdempotencyStore struct {
+	dir   string
+	stale time.Duration
+}
+
+// dirEntry is the file of a key: in flight since Started while Response
+// is nil.
+type dirEntry struct {
+	Key      string          `json:"key"`
+	Started  time.Time       `json:"started"`
+	Response *CachedResponse `json:"response,omitempty"`
+}
+
+// OpenDirIdempotencyStore opens the store in dir, creating the directory
+// if needed. Keys in flight for longer than stale are taken to belong to
+// a process that died and may be begun again.
+func OpenDirIdempotencyStore(dir string, stale time.Duration) (*DirIdempotencyStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create idempotency store: %w", err)
+	}
+	return &DirIdempotencyStore{dir: dir, stale: stale}, nil
+}
+
+func (d *DirIdempotencyStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Begin claims key by writing the entry to a temporary file and linking
+// it into place, which fails if the key's file exists, so other processes
+// never see the claim half-written.
+func (d *DirIdempotencyStore) Begin(key string) (*CachedResponse, error) {
+	path := d.path(key)
+	data, err := json.Marshal(dirEntry{Key: key, Started: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(d.dir, "claim-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	for range 2 {
+		err := os.Link(tmp.Name(), path)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		var e dirEntry
+		raw, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(raw, &e)
+		}
+		switch {
+		case err != nil:
+			// A corrupt entry: treat it as gone.
+		case e.Response == nil && time.Since(e.Started) < d.stale:
+			return nil, ErrKeyInFlight
+		case e.Response != nil && time.Now().Before(e.Response.Expires):
+			return e.Response, nil
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, ErrKeyInFlight // another process took the key meanwhile
+}
+
+// Complete writes the entry to a temporary file and renames it, so readers
+// never see it half-written.
+func (d *DirIdempotencyStore) Complete(key string, res *CachedResponse) error {
+	path := d.path(key)
+	if res == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(dirEntry{Key: key, Response: res})
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
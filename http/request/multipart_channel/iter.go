@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"iter"
+	"path/filepath"
+)
+
+// PartSpec describes a part yielded to PartsFrom. With Content it is a file
+// part, with Path a file opened when its turn comes as with FileFromPath,
+// and otherwise a form field holding Value.
+type PartSpec struct {
+	Field    string
+	Value    string
+	Filename string // for Content; defaults to the base of Path
+	Content  io.Reader
+	Path     string
+	Options  []PartOption // for file parts
+}
+
+// PartsFrom adds the parts yielded by seq, pulling them lazily as the
+// worker takes them, so producers such as database cursors or paginated
+// APIs never hold more than one part in memory. The first error yielded
+// aborts the stream: the request fails with it and seq is not resumed.
+func (r *Multipart) PartsFrom(seq iter.Seq2[PartSpec, error]) *Multipart {
+	for spec, err := range seq {
+		if err != nil {
+			r.pw.CloseWithError(fmt.Errorf("failed to produce part: %w", err))
+			return r
+		}
+		switch {
+		case spec.Content != nil:
+			r.File(spec.Field, spec.Filename, spec.Content, spec.Options...)
+		case spec.Path != "":
+			t := TRequest{Type: FileType, Key: spec.Field, Value: spec.Filename, Path: spec.Path}
+			if t.Value == "" {
+				t.Value = filepath.Base(spec.Path)
+			}
+			r.send(t.apply(spec.Options))
+		default:
+			r.Param(spec.Field, spec.Value)
+		}
+		if r.misuse != nil {
+			return r
+		}
+	}
+	return r
+}
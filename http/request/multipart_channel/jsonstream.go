@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SendJSONStream is Send for bulk APIs answering with a large JSON array or
+// NDJSON: it decodes the response one element at a time and passes each to
+// fn, so the response is never held in memory as a whole. The body is read
+// only as fast as fn returns. An error from fn stops the decoding and is
+// returned. A status other than 2xx fails with a *StatusError.
+func (r *Multipart) SendJSONStream(fn func(json.RawMessage) error) error {
+	resp, err := r.Send()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return decodeJSONStream(resp.Body, fn)
+}
+
+// decodeJSONStream passes the elements of a JSON array, or the values of an
+// NDJSON stream, read from body to fn.
+func decodeJSONStream(body io.Reader, fn func(json.RawMessage) error) error {
+	br := bufio.NewReader(body)
+	first, err := peekNonSpace(br)
+	if errors.Is(err, io.EOF) {
+		return nil // empty body
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	dec := json.NewDecoder(br)
+
+	if first == '[' {
+		if _, err := dec.Token(); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		for dec.More() {
+			var v json.RawMessage
+			if err := dec.Decode(&v); err != nil {
+				return fmt.Errorf("failed to decode response element: %w", err)
+			}
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		return nil
+	}
+
+	for {
+		var v json.RawMessage
+		err := dec.Decode(&v)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode response line: %w", err)
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+}
+
+// peekNonSpace returns the first byte of br that is not JSON whitespace,
+// leaving it unread.
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
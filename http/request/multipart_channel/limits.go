@@ -0,0 +1,13 @@
+package main
+
+import "github.com/isauran/go-std-library/http/request/ratelimit"
+
+// RateLimit sends the request under l, shared with any other builder or
+// client using it: the upload waits for l's request quota and its body is
+// throttled to l's bandwidth. Without RateLimit the limiter registered for
+// the URL's host with ratelimit.Register applies, if any. RateLimit must be
+// called before any parts are added.
+func (r *Multipart) RateLimit(l *ratelimit.Limiter) *Multipart {
+	r.limiter = l
+	return r
+}
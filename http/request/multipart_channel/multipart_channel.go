@@ -2,13 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/isauran/go-std-library/http/request/httpx"
+	"github.com/isauran/go-std-library/http/request/ratelimit"
+	"github.com/isauran/go-std-library/sync/waitgroup/taskgroup"
 )
 
 type RequestType int
@@ -18,6 +28,9 @@ const (
 	StringType
 	FileType
 	JSONType
+	PreparedType
+	TextType
+	FieldReaderType
 )
 
 type TRequest struct {
@@ -25,87 +38,380 @@ type TRequest struct {
 	Key     string
 	Value   string
 	Content io.Reader
+	Path    string
+
+	index        int
+	result       chan prepareResult
+	encoders     []partEncoder
+	header       textproto.MIMEHeader    // extra part headers set by PartOptions
+	generate     func(w io.Writer) error // writes the content instead of Content
+	chunkTimeout time.Duration           // for FileFromChan
+	priority     int                     // set by WithPriority
+	abort        AbortPolicy             // set by OnAbort
+	ack          *PartFuture             // for SubmitParam and SubmitFile
+	filenames    FilenameEncoding        // set by FilenameEncoding
 }
 
+// partEncoder wraps the writer of a file part, e.g. to encrypt or encode
+// the content on its way into the body.
+type partEncoder func(w io.Writer) (io.WriteCloser, error)
+
 type Multipart struct {
+	parent  context.Context // of NewMultipart, for Clone
 	client  *http.Client
 	request *http.Request
 	wg      sync.WaitGroup
 	mw      *multipart.Writer
-	pr      *io.PipeReader
-	pw      *io.PipeWriter
+	pr      io.ReadCloser
+	pw      bodyPipe
+	out     *bodyWriter
 	body    chan TRequest
 	resp    chan *http.Response
 	err     chan error
+	start   sync.Once
+	cancel  context.CancelCauseFunc
+	state   atomic.Int32
+	misuse  error // first part added after Send or Close
+
+	concurrent bool       // set by Concurrent
+	submitMu   sync.Mutex // serializes part submission in Concurrent mode
+
+	submitted int
+	workers   int              // set by Workers
+	prep      *taskgroup.Group // set by Workers
+	window    *partQueue       // set by Queue
+
+	// Hooks installed by request-level options. They must be registered
+	// before the first part is added, as that is when the request starts.
+	// They get the builder they run for, which differs from the one that
+	// installed them in builders made by Clone.
+	beforeDo []func(*Multipart, *http.Request) *http.Request
+	afterDo  []func(*Multipart, *http.Response, error) (*http.Response, error)
+
+	recorder *harRecorder
+	capture  *cappedBuffer
+	specs    []TRequest // parts submitted so far, without their content
+
+	copyBufferSize int
+	arrayNaming    ArrayNaming
+	filenames      FilenameEncoding
+	newBoundary    func() (string, error) // set by Boundary and BoundaryPolicy
+	sharedHeader   atomic.Bool            // header map shared with a Clone
+	sanitize       NameSanitizer
+	collisions     bool // set by DetectBoundaryCollisions
+	limiter        *ratelimit.Limiter
+	seen           *seenTracker
+	faults         *faultInjector
+	expectStatus   func(code int) bool
+	heartbeat      *heartbeat
+	idempotency    *idempotency
+	conditional    bool  // set by IfMatch, IfNoneMatch and IfUnmodifiedSince
+	maxDecoded     int64 // response body limit of AcceptEncoding
+
+	fanoutURLs    []string
+	mirrors       *taskgroup.Results[FanoutResult]
+	mirrorsDone   sync.Once
+	mirrorResults []FanoutResult
 }
 
+// Builder states. A builder is idle until its first part starts the
+// request, streaming while parts are written and closed once Send or Close
+// was called.
+const (
+	stateIdle int32 = iota
+	stateStreaming
+	stateClosed
+)
+
+// ErrAlreadyClosed is returned by Send and Err when the builder was used
+// after Send or Close.
+var ErrAlreadyClosed = errors.New("multipart: builder already closed")
+
 func NewMultipart(ctx context.Context, client *http.Client, method, url string) *Multipart {
+	ctx, cancel := context.WithCancelCause(ctx)
 	pipeReader, pipeWriter := io.Pipe()
 	ch := make(chan TRequest) // Unbuffered channel to preserve the order of operations.
+	out := &bodyWriter{w: pipeWriter}
 	r := &Multipart{
+		parent: ctx,
 		client: client,
 		body:   ch,
 		pr:     pipeReader,
 		pw:     pipeWriter,
-		mw:     multipart.NewWriter(pipeWriter),
+		out:    out,
+		mw:     multipart.NewWriter(out),
 		resp:   make(chan *http.Response, 1),
 		err:    make(chan error, 1),
+		cancel: cancel,
 	}
 
 	// Create HTTP request with pipe reader
 	r.request, _ = http.NewRequestWithContext(ctx, method, url, pipeReader)
 	r.request.Header.Set("Content-Type", r.mw.FormDataContentType())
 
-	// Start worker that will write to pipe
-	r.wg.Add(1)
-	go r.worker()
+	return r
+}
 
-	// Start HTTP request in background immediately
-	go func() {
-		resp, err := r.client.Do(r.request)
-		if err != nil {
-			r.err <- err
-			return
+// startRequest starts the HTTP request in the background. It runs once,
+// when the first part is added or on Send, so that options configured
+// right after NewMultipart still apply to the request.
+func (r *Multipart) startRequest() {
+	r.start.Do(func() {
+		r.state.CompareAndSwap(stateIdle, stateStreaming)
+		r.ownHeader()
+		req := r.request
+		limiter := r.limiter
+		if limiter == nil {
+			limiter = ratelimit.ForHost(req.URL.Host)
+		}
+		if limiter != nil {
+			req.Body = limiter.Body(req.Context(), req.Body)
 		}
-		r.resp <- resp
-	}()
+		for _, hook := range r.beforeDo {
+			req = hook(r, req)
+		}
+		r.startMirrors()
+		// Start worker that will write to pipe. It starts with the request
+		// so that it sees the Queue and the pipe of Fanout.
+		r.wg.Add(1)
+		go r.worker()
+		go func() {
+			defer recoverPanic(func(err error) {
+				// Stop the worker, which may be blocked on the pipe.
+				r.pr.Close()
+				r.err <- err
+			})
+			resp, err := r.do(req)
+			for _, hook := range r.afterDo {
+				resp, err = hook(r, resp, err)
+			}
+			if err != nil {
+				r.err <- err
+				return
+			}
+			r.resp <- resp
+		}()
+	})
+}
+
+// do sends req with the builder's client.
+func (r *Multipart) do(req *http.Request) (*http.Response, error) {
+	if r.idempotency != nil {
+		return r.idempotency.do(r.client, req)
+	}
+	return r.client.Do(req)
+}
 
-	// Give HTTP client time to start
-	time.Sleep(50 * time.Millisecond)
+// bodyPipe is the writing end of the pipe feeding the request body: an
+// io.Pipe by default, or a pipe installed by BufferedPipe.
+type bodyPipe interface {
+	io.WriteCloser
+	CloseWithError(err error) error
+}
 
-	return r
+// bodyWriter sits between the multipart writer and the pipe so options can
+// observe the exact bytes of the request body.
+type bodyWriter struct {
+	w       io.Writer
+	taps    []io.Writer
+	written atomic.Int64 // bytes handed to the pipe so far
+}
+
+func (b *bodyWriter) Write(p []byte) (int, error) {
+	n, err := b.w.Write(p)
+	b.written.Add(int64(n))
+	for _, tap := range b.taps {
+		tap.Write(p[:n])
+	}
+	return n, err
 }
 
 func (r *Multipart) worker() {
 	defer r.wg.Done()
-	for b := range r.body {
-		switch b.Type {
-		case StringType:
-			{
-				err := r.mw.WriteField(b.Key, b.Value)
-				if err != nil {
-					r.pw.CloseWithError(fmt.Errorf("failed to write form field [%q] value %s: %w", b.Key, b.Value, err))
-					return
-				}
+	if r.heartbeat != nil {
+		defer r.startHeartbeat()()
+	}
+	for index := 0; ; index++ {
+		b, ok := r.next()
+		if !ok {
+			return
+		}
+		offset := r.out.written.Load()
+		if err := r.writeLocked(b, index); err != nil {
+			var aborted *abortedPart
+			if errors.As(err, &aborted) {
+				b.complete(&PartError{Part: b.Key, Index: index, Offset: offset, Err: aborted.err})
+				continue
 			}
-		case FileType:
-			{
-				part, err := r.mw.CreateFormFile(b.Key, b.Value)
-				if err != nil {
-					r.pw.CloseWithError(fmt.Errorf("failed to create form file: %w", err))
-					return
-				}
-				if _, err := io.Copy(part, b.Content); err != nil {
-					r.pw.CloseWithError(fmt.Errorf("failed to copy file content: %w", err))
-					return
-				}
+			partErr := &PartError{Part: b.Key, Index: index, Offset: offset, Err: err}
+			b.complete(partErr)
+			r.pw.CloseWithError(partErr)
+			// Keep receiving so later parts do not block their callers.
+			for b, ok := r.next(); ok; b, ok = r.next() {
+				b.complete(skipped(partErr))
+			}
+			return
+		}
+		b.complete(nil)
+	}
+}
+
+// next returns the next part to write, from the queue if there is one.
+func (r *Multipart) next() (TRequest, bool) {
+	if r.window != nil {
+		return r.window.pop()
+	}
+	b, ok := <-r.body
+	return b, ok
+}
+
+// writeLocked is writeIndexed holding off heartbeats.
+func (r *Multipart) writeLocked(b TRequest, index int) error {
+	if r.heartbeat == nil {
+		return r.writeIndexed(b, index)
+	}
+	r.heartbeat.mu.Lock()
+	defer r.heartbeat.mu.Unlock()
+	return r.writeIndexed(b, index)
+}
+
+// writeIndexed writes b, the part at index, reporting a panic while doing
+// so as an error so the request fails instead of hanging.
+func (r *Multipart) writeIndexed(b TRequest, index int) (err error) {
+	defer recoverPanic(func(perr error) { err = perr })
+	if r.faults != nil {
+		return r.faults.write(r, b, index)
+	}
+	return r.writePart(b)
+}
+
+func (r *Multipart) writePart(b TRequest) error {
+	if err := checkNames(b); err != nil {
+		return err
+	}
+	switch b.Type {
+	case StringType:
+		if err := r.checkCollision(b.Value); err != nil {
+			return err
+		}
+		if err := r.mw.WriteField(b.Key, b.Value); err != nil {
+			return fmt.Errorf("failed to write form field [%q] value %s: %w", b.Key, b.Value, err)
+		}
+	case FileType:
+		return r.writeFile(b)
+	case PreparedType:
+		return r.writePrepared(b)
+	case TextType:
+		return r.writeText(b)
+	case FieldReaderType:
+		return r.writeFieldReader(b)
+	}
+	return nil
+}
+
+func (r *Multipart) writeFile(b TRequest) error {
+	if b.Content == nil && b.Path != "" {
+		// FileFromPath parts are opened only when their turn comes.
+		f, err := os.Open(b.Path)
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		defer f.Close()
+		b.Content = f
+	}
+	if r.seen != nil && b.generate == nil {
+		if sent, err := r.writeContentRef(&b); sent || err != nil {
+			return err
+		}
+	}
+	part, err := r.mw.CreatePart(fileHeader(b))
+	if err != nil {
+		return fmt.Errorf("failed to create form file: %w", err)
+	}
+
+	// Content flows through the encoders in order, so the chain is built
+	// from the part outwards and closed from the content inwards.
+	dst := r.scanPart(part)
+	encoders := make([]io.WriteCloser, len(b.encoders))
+	for i := len(b.encoders) - 1; i >= 0; i-- {
+		enc, err := b.encoders[i](dst)
+		if err != nil {
+			return fmt.Errorf("failed to encode file [%q]: %w", b.Key, err)
+		}
+		encoders[i], dst = enc, enc
+	}
+	if b.generate != nil {
+		if err := b.generate(dst); err != nil {
+			if b.abort == AbortPart && errors.Is(err, ErrPartAborted) {
+				return r.abortPart(b, err)
 			}
+			return fmt.Errorf("failed to generate file [%q]: %w", b.Key, err)
+		}
+	} else if _, err := r.copyContent(dst, b.Content); err != nil {
+		return fmt.Errorf("failed to copy file content: %w", err)
+	}
+	for _, enc := range encoders {
+		if err := enc.Close(); err != nil {
+			return fmt.Errorf("failed to encode file [%q]: %w", b.Key, err)
+		}
+	}
+	return nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// fileHeader builds the header CreateFormFile would use, plus the extra
+// headers of the part.
+func fileHeader(b TRequest) textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	enc := b.filenames
+	if enc == nil {
+		enc = FilenameRaw
+	}
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; %s`, quoteEscaper.Replace(b.Key), enc(b.Value)))
+	h.Set("Content-Type", "application/octet-stream")
+	for k, vs := range b.header {
+		h[k] = vs
+	}
+	return h
+}
+
+// send hands a part to the worker, going through the preparation window
+// when Workers is enabled so ordering is preserved across all part kinds.
+func (r *Multipart) send(t TRequest) {
+	defer r.lockSubmit()()
+	r.submit(t)
+}
+
+// submit is send for callers that hold the submission lock.
+func (r *Multipart) submit(t TRequest) {
+	if r.state.Load() == stateClosed {
+		err := fmt.Errorf("failed to add part [%q]: %w", t.Key, ErrAlreadyClosed)
+		if r.misuse == nil {
+			r.misuse = err
 		}
+		t.complete(err)
+		return
+	}
+	r.startRequest()
+	r.submitted++
+	t.filenames = r.filenames
+	r.sanitizeNames(&t)
+	spec := t
+	spec.Content, spec.result, spec.encoders, spec.generate, spec.ack = nil, nil, nil, nil, nil
+	r.specs = append(r.specs, spec)
+	if r.window != nil {
+		r.window.push(t)
+		return
 	}
+	r.body <- t
 }
 
-func (r *Multipart) Param(key, value string) *Multipart {
-	r.body <- TRequest{Type: StringType, Key: key, Value: value}
+// Param adds a form field. Of the part options, fields only heed
+// WithPriority.
+func (r *Multipart) Param(key, value string, opts ...PartOption) *Multipart {
+	t := TRequest{Type: StringType, Key: key, Value: value}
+	r.send(t.apply(opts))
 	return r
 }
 
@@ -117,32 +423,112 @@ func (r *Multipart) Float(fieldName string, value float64) *Multipart {
 	return r.Param(fieldName, strconv.FormatFloat(value, 'f', -1, 64))
 }
 
-func (r *Multipart) File(key, filename string, content io.Reader) *Multipart {
-	r.body <- TRequest{Type: FileType, Key: key, Value: filename, Content: content}
+func (r *Multipart) File(key, filename string, content io.Reader, opts ...PartOption) *Multipart {
+	t := TRequest{Type: FileType, Key: key, Value: filename, Content: content}
+	r.send(t.apply(opts))
+	return r
+}
+
+// FileFromPath adds a file part read from path. The file is opened by the
+// worker when the part is written, not when it is added.
+func (r *Multipart) FileFromPath(key, path string, opts ...PartOption) *Multipart {
+	t := TRequest{Type: FileType, Key: key, Value: filepath.Base(path), Path: path}
+	r.send(t.apply(opts))
 	return r
 }
 
 func (r *Multipart) Header(key, value string) *Multipart {
-	r.request.Header.Set(key, value)
+	r.ownHeader().Set(key, value)
+	return r
+}
+
+// Boundary replaces the random multipart boundary, e.g. to make bodies
+// reproducible in golden tests. It must be called before any parts are
+// added; an invalid boundary fails the request.
+func (r *Multipart) Boundary(boundary string) *Multipart {
+	if err := r.mw.SetBoundary(boundary); err != nil {
+		r.pw.CloseWithError(fmt.Errorf("failed to set boundary %q: %w", boundary, err))
+		return r
+	}
+	r.ownHeader().Set("Content-Type", r.mw.FormDataContentType())
+	r.newBoundary = func() (string, error) { return boundary, nil }
 	return r
 }
 
-func (r *Multipart) Close() {
+// Close releases the builder without sending it. A request already
+// started by the parts added so far is canceled and its goroutines are
+// waited for. Close may be called in any state and more than once; after
+// Send it does nothing. It must not run concurrently with methods adding
+// parts unless the builder is Concurrent.
+func (r *Multipart) Close() error {
+	switch r.closeSubmit() {
+	case stateClosed:
+		return nil
+	case stateIdle:
+		// Nobody reads the body, so fail the writes of the final boundary.
+		r.pr.Close()
+		r.finish()
+	case stateStreaming:
+		r.cancel(ErrAlreadyClosed)
+		r.pr.Close()
+		r.finish()
+		select {
+		case resp := <-r.resp:
+			resp.Body.Close()
+		case <-r.err:
+		}
+		r.closeMirrors()
+	}
+	return nil
+}
+
+// Err returns ErrAlreadyClosed, wrapped, if parts were added after Send or
+// Close. Such parts are dropped.
+func (r *Multipart) Err() error {
+	defer r.lockSubmit()()
+	return r.misuse
+}
+
+// finish stops the worker and the pool and ends the body.
+func (r *Multipart) finish() {
+	r.closePool()
 	close(r.body)
 	r.wg.Wait()
 	r.mw.Close()
 	r.pw.Close()
 }
 
-func (r *Multipart) Send() (*http.Response, error) {
-	// Close to signal worker to finish and wait
-	r.Close()
+// Send ends the body and returns the response of the builder's URL,
+// wrapped in an httpx.Response whose helpers read and close the body in one
+// call. The embedded *http.Response keeps Body, StatusCode and the other
+// fields at hand.
+func (r *Multipart) Send() (*httpx.Response, error) {
+	resp, err := r.sendPrimary()
+	r.closeMirrors()
+	return httpx.Wrap(resp, err)
+}
+
+// sendPrimary ends the body and waits for the response of the builder's
+// URL.
+func (r *Multipart) sendPrimary() (*http.Response, error) {
+	if r.closeSubmit() == stateClosed {
+		return nil, ErrAlreadyClosed
+	}
+	// Finish the body and wait for the worker
+	r.startRequest()
+	r.finish()
 
 	// Wait for HTTP response
 	select {
 	case resp := <-r.resp:
+		if err := r.checkCondition(resp); err != nil {
+			return nil, err
+		}
+		if err := r.checkStatus(resp); err != nil {
+			return nil, err
+		}
 		return resp, nil
 	case err := <-r.err:
-		return nil, err
+		return nil, newRequestError(err, r.out.written.Load())
 	}
 }
@@ -0,0 +1,2641 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"image"
+	"image/png"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"testing/iotest"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/isauran/go-std-library/http/request/golden"
+	"github.com/isauran/go-std-library/http/request/httpx"
+	"github.com/isauran/go-std-library/http/request/mockhttp"
+	"github.com/isauran/go-std-library/http/request/multiparttest"
+	"github.com/isauran/go-std-library/http/request/pipeline"
+	"github.com/isauran/go-std-library/http/request/ratelimit"
+	"github.com/isauran/go-std-library/http/request/slowtransport"
+	"github.com/isauran/go-std-library/io/bufpipe"
+	"github.com/isauran/go-std-library/io/gcmstream"
+)
+
+// partNames renders the parts of the last request as "name=content".
+func partNames(srv *multiparttest.EchoServer) string {
+	var got []string
+	for _, p := range srv.Parts() {
+		got = append(got, p.Name+"="+string(p.Content))
+	}
+	return strings.Join(got, " ")
+}
+
+func TestPreparedFileOrder(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).Workers(4)
+	m.Param("first", "a")
+	for i := 0; i < 5; i++ {
+		delay := time.Duration(5-i) * 10 * time.Millisecond
+		m.PreparedFile(fmt.Sprintf("p%d", i), "p.txt", func() ([]byte, error) {
+			time.Sleep(delay) // earlier parts finish last
+			return []byte(fmt.Sprint(i)), nil
+		})
+	}
+	resp, err := m.Param("last", "z").Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := "first=a p0=0 p1=1 p2=2 p3=3 p4=4 last=z"
+	if s := partNames(srv); s != want {
+		t.Errorf("parts = %q, want %q", s, want)
+	}
+}
+
+func TestWithPriority(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	f0 := &gatedReader{r: strings.NewReader("big"), started: make(chan struct{}), release: make(chan struct{})}
+	m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).Queue(4)
+	m.File("f0", "f0.bin", f0)
+	<-f0.started // f0 is being written; the rest wait in the queue
+	m.File("f1", "f1.bin", strings.NewReader("1"))
+	m.File("f2", "f2.bin", strings.NewReader("2"), WithPriority(1))
+	m.Param("meta", "m", WithPriority(2))
+	close(f0.release)
+	resp, err := m.Param("last", "z").Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := "f0=big meta=m f2=2 f1=1 last=z"
+	if s := partNames(srv); s != want {
+		t.Errorf("parts = %q, want %q", s, want)
+	}
+}
+
+// gatedReader closes started on its first Read and then waits for release.
+type gatedReader struct {
+	r       io.Reader
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (g *gatedReader) Read(p []byte) (int, error) {
+	g.once.Do(func() { close(g.started) })
+	<-g.release
+	return g.r.Read(p)
+}
+
+func TestFileCtx(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	// stalled returns a source that sends "head" and then never ends.
+	stalled := func() io.Reader {
+		pr, pw := io.Pipe()
+		go pw.Write([]byte("head"))
+		return pr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		FileCtx(ctx, "slow", "slow.bin", stalled(), OnAbort(AbortPart)).
+		Param("after", "1").
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	want := "slow=head slow.error=multipart: part aborted: context deadline exceeded after=1"
+	if got := partNames(srv); got != want {
+		t.Errorf("parts = %q, want %q", got, want)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		FileCtx(ctx, "slow", "slow.bin", stalled()).
+		Param("after", "1").
+		Send()
+	if !errors.Is(err, ErrPartAborted) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want ErrPartAborted and DeadlineExceeded", err)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	client := NewKeepAliveClient(srv.Client(), time.Second)
+	if client.Transport == srv.Client().Transport {
+		t.Error("NewKeepAliveClient shares the transport of its client")
+	}
+	m := NewMultipart(context.Background(), client, http.MethodPost, srv.URL).
+		Heartbeat(10*time.Millisecond, FillerField("_hb"))
+	m.Param("a", "1")
+	time.Sleep(100 * time.Millisecond) // the producer pauses
+	resp, err := m.Param("b", "2").Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	got := partNames(srv)
+	if !strings.HasPrefix(got, "a=1 _hb=") || !strings.HasSuffix(got, "_hb= b=2") {
+		t.Errorf("parts = %q, want heartbeats between a and b", got)
+	}
+}
+
+func TestPreparedFileError(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	_, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Workers(2).
+		PreparedFile("bad", "bad.bin", func() ([]byte, error) {
+			return nil, multipart.ErrMessageTooLarge
+		}).
+		Send()
+	if err == nil {
+		t.Fatal("expected error from failed preparation")
+	}
+
+	// A failure cancels the preparations that have not started.
+	var later atomic.Int32
+	m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Workers(1).
+		PreparedFile("bad", "bad.bin", func() ([]byte, error) { panic("boom") })
+	for range 3 {
+		m.PreparedFile("later", "later.bin", func() ([]byte, error) {
+			later.Add(1)
+			return []byte("x"), nil
+		})
+	}
+	if _, err := m.Send(); !errors.Is(err, ErrWorkerPanic) {
+		t.Errorf("Send() = %v, want ErrWorkerPanic", err)
+	}
+	if n := later.Load(); n != 0 {
+		t.Errorf("%d preparations ran after the failure", n)
+	}
+}
+
+func TestRecordHAR(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	var har bytes.Buffer
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL+"?debug=1").
+		Record(&har).
+		RecordLimit(32).
+		Header("X-Test", "yes").
+		Param("field", strings.Repeat("v", 100)).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	var doc struct {
+		Log struct {
+			Version string
+			Entries []struct {
+				Request struct {
+					Method      string
+					BodySize    int64
+					QueryString []struct{ Name, Value string }
+					PostData    struct{ Text, Comment string }
+				}
+				Response struct{ Status int }
+			}
+		}
+	}
+	if err := json.Unmarshal(har.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid HAR: %v\n%s", err, har.String())
+	}
+	if doc.Log.Version != "1.2" || len(doc.Log.Entries) != 1 {
+		t.Fatalf("unexpected HAR: %s", har.String())
+	}
+	e := doc.Log.Entries[0]
+	if e.Request.Method != http.MethodPost || e.Response.Status != http.StatusOK {
+		t.Errorf("method/status = %s/%d", e.Request.Method, e.Response.Status)
+	}
+	if len(e.Request.PostData.Text) != 32 || e.Request.BodySize <= 100 || e.Request.PostData.Comment == "" {
+		t.Errorf("body not capped: len=%d size=%d comment=%q", len(e.Request.PostData.Text), e.Request.BodySize, e.Request.PostData.Comment)
+	}
+	if len(e.Request.QueryString) != 1 || e.Request.QueryString[0].Name != "debug" {
+		t.Errorf("queryString = %+v", e.Request.QueryString)
+	}
+}
+
+func TestAsCurl(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	path := filepath.Join(t.TempDir(), "report.txt")
+	if err := os.WriteFile(path, []byte("from disk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMultipart(context.Background(), srv.Client(), http.MethodPut, srv.URL).
+		Header("Authorization", "Bearer it's").
+		Param("name", "@not-a-file").
+		FileFromPath("report", path).
+		File("inline", "a.txt", strings.NewReader("x"))
+
+	cmd, err := m.AsCurl()
+	want := "curl -X PUT -H 'Authorization: Bearer it'\\''s' --form-string 'name=@not-a-file' " +
+		"-F 'report=@" + path + "' -F 'inline=@a.txt' '" + srv.URL + "'"
+	if cmd != want {
+		t.Errorf("AsCurl() =\n%s\nwant\n%s", cmd, want)
+	}
+	if err == nil || !strings.Contains(err.Error(), `"inline"`) {
+		t.Errorf("AsCurl() error = %v, want error about reader part", err)
+	}
+
+	resp, err := m.Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if s := partNames(srv); s != "name=@not-a-file report=from disk inline=x" {
+		t.Errorf("parts = %q", s)
+	}
+}
+
+func TestAsCurlTextPart(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		TextPart("note", "grüße", "iso-8859-1")
+	defer m.Close()
+
+	cmd, err := m.AsCurl()
+	if want := "curl --form-string 'note=grüße' '" + srv.URL + "'"; cmd != want {
+		t.Errorf("AsCurl() =\n%s\nwant\n%s", cmd, want)
+	}
+	if err == nil || !strings.Contains(err.Error(), `"note"`) || !strings.Contains(err.Error(), "iso-8859-1") {
+		t.Errorf("AsCurl() error = %v, want a note about the text part's encoding", err)
+	}
+}
+
+func TestSendWithMockTransport(t *testing.T) {
+	mock := &mockhttp.Transport{}
+	mock.On(http.MethodPost, "/upload").
+		WithHeader("X-Custom-Header", "custom-value").
+		WithField("key1", "1").
+		WithFile("file", "hello.html").
+		Respond(http.StatusCreated, "stored")
+
+	resp, err := NewMultipart(context.Background(), mock.Client(), http.MethodPost, "http://uploads.test/upload").
+		Header("X-Custom-Header", "custom-value").
+		Param("key1", "1").
+		File("file", "hello.html", strings.NewReader("<h1>hi</h1>")).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("status = %d, want 201", resp.StatusCode)
+	}
+}
+
+func TestGoldenBody(t *testing.T) {
+	mock := &mockhttp.Transport{}
+	mock.On(http.MethodPost, "/upload").Respond(http.StatusOK, "")
+
+	resp, err := NewMultipart(context.Background(), mock.Client(), http.MethodPost, "http://uploads.test/upload").
+		Boundary("golden-boundary").
+		Param("key1", "1").
+		Bool("flag", true).
+		Float("ratio", 0.5).
+		File("file", "hello.html", strings.NewReader("<h1>hi</h1>\n")).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	call := mock.Calls()[0]
+	if ct := call.Request.Header.Get("Content-Type"); ct != "multipart/form-data; boundary=golden-boundary" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	golden.AssertMultipart(t, "basic", call.Body, "golden-boundary")
+}
+
+// readSizes records the length of every buffer passed to Read.
+type readSizes struct {
+	r     io.Reader
+	sizes []int
+}
+
+func (s *readSizes) Read(p []byte) (int, error) {
+	s.sizes = append(s.sizes, len(p))
+	return s.r.Read(p)
+}
+
+func TestCopyBufferSize(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	content := strings.Repeat("0123456789", 100)
+	for _, size := range []int{7, 0} {
+		src := &readSizes{r: strings.NewReader(content)}
+		m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL)
+		if size > 0 {
+			m.CopyBufferSize(size)
+		}
+		resp, err := m.File("file", "digits.txt", src).Send()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		srv.AssertFile("file", "digits.txt", content)
+
+		want := cmp.Or(size, DefaultCopyBufferSize)
+		if len(src.sizes) == 0 {
+			t.Fatalf("buffer %d: content was never read", want)
+		}
+		for _, n := range src.sizes {
+			if n != want {
+				t.Errorf("buffer %d: Read got %d bytes of buffer", want, n)
+				break
+			}
+		}
+	}
+}
+
+// BenchmarkFileCopy measures the allocations of streaming a file part. The
+// reader hides io.WriterTo so the copy buffer is always used.
+func BenchmarkFileCopy(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer srv.Close()
+	payload := bytes.Repeat([]byte("x"), 1<<20)
+
+	for _, size := range []int{4 << 10, DefaultCopyBufferSize, 256 << 10} {
+		b.Run(fmt.Sprintf("buffer=%dKB", size>>10), func(b *testing.B) {
+			b.SetBytes(int64(len(payload)))
+			b.ReportAllocs()
+			for b.Loop() {
+				resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+					CopyBufferSize(size).
+					File("file", "payload.bin", io.LimitReader(bytes.NewReader(payload), int64(len(payload)))).
+					Send()
+				if err != nil {
+					b.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+		})
+	}
+}
+
+func TestBufferedPipe(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	var highs atomic.Int32
+	content := strings.Repeat("buffered ", 10000)
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		BufferedPipe(4<<10, bufpipe.HighWatermark(3<<10, func(int) { highs.Add(1) })).
+		Param("name", "value").
+		File("file", "big.txt", strings.NewReader(content)).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	srv.AssertField("name", "value")
+	srv.AssertFile("file", "big.txt", content)
+	if highs.Load() == 0 {
+		t.Error("high watermark never reached")
+	}
+}
+
+// stallTransport reads the first bytes of the body, then stops reading for
+// a while, like a server that is stuck.
+type stallTransport struct{ stall time.Duration }
+
+func (s stallTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	defer req.Body.Close()
+	io.ReadFull(req.Body, make([]byte, 10))
+	time.Sleep(s.stall)
+	if _, err := io.ReadAll(req.Body); err != nil {
+		return nil, err
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func TestIdleTimeout(t *testing.T) {
+	client := &http.Client{Transport: stallTransport{stall: 200 * time.Millisecond}}
+
+	start := time.Now()
+	_, err := NewMultipart(context.Background(), client, http.MethodPost, "http://uploads.test/").
+		IdleTimeout(30*time.Millisecond).
+		File("file", "big.bin", strings.NewReader(strings.Repeat("x", 1<<20))).
+		Send()
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Send() = %v, want ErrDeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Send took %v", d)
+	}
+
+	resp, err := NewMultipart(context.Background(), http.DefaultClient, http.MethodPost, multiparttest.NewEchoServer(t).URL).
+		IdleTimeout(time.Second).
+		Param("name", "value").
+		Send()
+	if err != nil {
+		t.Fatalf("Send() with a healthy server = %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestCaptureBody(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Boundary("captured").
+		CaptureBody(20).
+		Param("field", strings.Repeat("v", 100))
+	resp, err := m.Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := string(m.CapturedBody()); got != "--captured\r\nContent-" {
+		t.Errorf("CapturedBody() = %q", got)
+	}
+	if m.CapturedSize() <= 100 {
+		t.Errorf("CapturedSize() = %d, want full body size", m.CapturedSize())
+	}
+	srv.AssertField("field", strings.Repeat("v", 100))
+}
+
+type limitedWriter struct {
+	n   int
+	err error
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, w.err
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestAlsoWriteTo(t *testing.T) {
+	var received []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	var archive bytes.Buffer
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		AlsoWriteTo(&archive).
+		Param("a", "1").
+		File("b", "b.bin", bytes.NewReader(bytes.Repeat([]byte("x"), 1<<20))).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if archive.Len() < 1<<20 || !bytes.Equal(archive.Bytes(), received) {
+		t.Errorf("archive has %d bytes, server received %d", archive.Len(), len(received))
+	}
+
+	errDiskFull := errors.New("disk full")
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		AlsoWriteTo(&limitedWriter{n: 1 << 10, err: errDiskFull}).
+		File("b", "b.bin", bytes.NewReader(bytes.Repeat([]byte("x"), 1<<20))).
+		Send()
+	if !errors.Is(err, errDiskFull) {
+		t.Errorf("err = %v, want %v", err, errDiskFull)
+	}
+}
+
+func TestFileEncrypted(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	key := bytes.Repeat([]byte{42}, 32)
+	secret := strings.Repeat("top secret ", 20000)
+
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		FileEncrypted("doc", "secret.txt", strings.NewReader(secret), key).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	f := srv.Files()[0]
+	if bytes.Contains(f.Content, []byte("top secret")) {
+		t.Fatal("part content is not encrypted")
+	}
+	dec, err := gcmstream.NewReader(bytes.NewReader(f.Content), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(dec)
+	if err != nil || string(plain) != secret {
+		t.Errorf("decrypted %d bytes, err %v", len(plain), err)
+	}
+
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		FileEncrypted("doc", "secret.txt", strings.NewReader(secret), []byte("short")).
+		Send()
+	if err == nil {
+		t.Error("Send() succeeded with an invalid key")
+	}
+}
+
+func TestBase64Part(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	binary := make([]byte, 1000)
+	for i := range binary {
+		binary[i] = byte(i)
+	}
+
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		File("blob", `a "quoted".bin`, bytes.NewReader(binary), Base64()).
+		File("raw", "raw.bin", strings.NewReader("as is")).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	blob := srv.Files()[0]
+	if blob.FileName != `a "quoted".bin` || blob.Header.Get("Content-Transfer-Encoding") != "base64" {
+		t.Errorf("blob header = %v", blob.Header)
+	}
+	lines := strings.Split(string(blob.Content), "\r\n")
+	for i, line := range lines {
+		if len(line) > 76 || (i < len(lines)-1 && len(line) != 76) {
+			t.Fatalf("line %d has %d characters", i+1, len(line))
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+	if err != nil || !bytes.Equal(decoded, binary) {
+		t.Errorf("decoded %d bytes, err %v", len(decoded), err)
+	}
+	if raw := srv.Files()[1]; raw.Header.Get("Content-Transfer-Encoding") != "" || string(raw.Content) != "as is" {
+		t.Errorf("raw part changed: %v %q", raw.Header, raw.Content)
+	}
+}
+
+func TestTextPart(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	long := strings.Repeat("très long ", 12)
+
+	m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		CaptureBody(4<<10).
+		TextPart("note", "café = 5€", "").
+		TextPart("latin", "déjà vu", "iso-8859-1").
+		TextPart("long", long, "utf-8")
+	resp, err := m.Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	raw := string(m.CapturedBody())
+	for _, want := range []string{
+		"Content-Transfer-Encoding: quoted-printable\r\nContent-Type: text/plain; charset=utf-8\r\n",
+		"caf=C3=A9 =3D 5=E2=82=AC",
+		"d=E9j=E0 vu",
+		"=\r\n", // soft line break in the long value
+	} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("body lacks %q:\n%s", want, raw)
+		}
+	}
+	// mime/multipart decodes quoted-printable transparently.
+	srv.AssertField("note", "café = 5€")
+	srv.AssertField("latin", "d\xe9j\xe0 vu")
+	srv.AssertField("long", long)
+
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		TextPart("note", "€", "us-ascii").
+		Send()
+	if err == nil || !strings.Contains(err.Error(), "not representable") {
+		t.Errorf("Send() = %v, want charset error", err)
+	}
+}
+
+func TestParamReader(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	config := strings.Repeat(`{"key":"value"},`, 64<<10)
+
+	m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		CaptureBody(1<<10).
+		ParamReader("config", strings.NewReader(config)).
+		Param("after", "1")
+	resp, err := m.Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if raw := string(m.CapturedBody()); !strings.Contains(raw, "Content-Disposition: form-data; name=\"config\"\r\n\r\n") {
+		t.Errorf("config is not a plain field:\n%s", raw)
+	}
+	srv.AssertField("config", config)
+	srv.AssertField("after", "1")
+}
+
+func TestFilenameEncoding(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	name := "Отчёт 2026 🚀.pdf"
+
+	m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		CaptureBody(4<<10).
+		File("raw", name, strings.NewReader("r")).
+		FilenameEncoding(FilenameExtended).
+		File("ext", name, strings.NewReader("e")).
+		File("plain", "report.pdf", strings.NewReader("p")).
+		FilenameEncoding(FilenameASCII).
+		File("ascii", name, strings.NewReader("a"))
+	resp, err := m.Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	raw := string(m.CapturedBody())
+	for _, want := range []string{
+		`name="raw"; filename="Отчёт 2026 🚀.pdf"`,
+		`name="ext"; filename="Otchet 2026 _.pdf"; filename*=UTF-8''%D0%9E%D1%82%D1%87%D1%91%D1%82%202026%20%F0%9F%9A%80.pdf`,
+		`name="plain"; filename="report.pdf"` + "\r\n",
+		`name="ascii"; filename="Otchet 2026 _.pdf"` + "\r\n",
+	} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("body lacks %q:\n%s", want, raw)
+		}
+	}
+	// mime/multipart prefers filename* over filename.
+	srv.AssertFile("raw", name, "r")
+	srv.AssertFile("ext", name, "e")
+	srv.AssertFile("ascii", "Otchet 2026 _.pdf", "a")
+}
+
+func TestUnsafeNames(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	evil := "evil\"\r\nX-Injected: 1.txt"
+
+	_, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		File("file", evil, strings.NewReader("x")).
+		Send()
+	var nameErr *NameError
+	if !errors.Is(err, ErrUnsafeName) || !errors.As(err, &nameErr) || nameErr.Param != "filename" || nameErr.Char != '\r' {
+		t.Fatalf("Send() = %v, want NameError for the filename", err)
+	}
+
+	m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		CaptureBody(4<<10).
+		Param(`quoted"name`, "kept").
+		SanitizeNames(HTMLNames).
+		File("file", evil, strings.NewReader("x"))
+	resp, err := m.Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	raw := string(m.CapturedBody())
+	if strings.Contains(raw, "\r\nX-Injected") || !strings.Contains(raw, `filename="evil%22%0D%0AX-Injected: 1.txt"`) {
+		t.Errorf("sanitized filename missing or header injected:\n%s", raw)
+	}
+	srv.AssertField(`quoted"name`, "kept")
+}
+
+func TestBoundaryCollision(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	send := func(add func(*Multipart) *Multipart) error {
+		m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+			Boundary("fixed-boundary").
+			DetectBoundaryCollisions()
+		resp, err := add(m).Send()
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// The delimiter is split across two reads of the content.
+	content := io.MultiReader(strings.NewReader("header\r\n--fixed-"), strings.NewReader("boundary\r\nrest"))
+	err := send(func(m *Multipart) *Multipart { return m.File("file", "a.bin", content) })
+	var partErr *PartError
+	if !errors.Is(err, ErrBoundaryCollision) || !errors.As(err, &partErr) || partErr.Part != "file" {
+		t.Errorf("file: Send() = %v, want ErrBoundaryCollision", err)
+	}
+	if err := send(func(m *Multipart) *Multipart { return m.Param("note", "--fixed-boundary") }); !errors.Is(err, ErrBoundaryCollision) {
+		t.Errorf("param: Send() = %v, want ErrBoundaryCollision", err)
+	}
+	if err := send(func(m *Multipart) *Multipart { return m.File("file", "b.bin", strings.NewReader("--fixed-bound")) }); err != nil {
+		t.Errorf("near miss: Send() = %v", err)
+	}
+}
+
+func TestBoundaryPolicy(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		BoundaryPrefix("acme-upload-").
+		Param("a", "1")
+	resp, err := m.Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	_, params, _ := mime.ParseMediaType(srv.Headers().Get("Content-Type"))
+	if b := params["boundary"]; !strings.HasPrefix(b, "acme-upload-") || len(b) != len("acme-upload-")+DefaultBoundaryLength {
+		t.Errorf("boundary = %q", b)
+	}
+	srv.AssertField("a", "1")
+
+	// A seeded source makes the boundary reproducible.
+	policy := func() BoundaryPolicy {
+		return BoundaryPolicy{Length: 12, Charset: "ab", Source: rand.NewChaCha8([32]byte{1})}
+	}
+	b1, _ := policy().generate()
+	b2, _ := policy().generate()
+	if b1 != b2 || len(b1) != 12 || strings.Trim(b1, "ab") != "" {
+		t.Errorf("seeded boundaries %q and %q", b1, b2)
+	}
+
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		BoundaryPolicy(BoundaryPolicy{Prefix: "p", Length: 70}).
+		Param("a", "1").
+		Send()
+	if err == nil || !strings.Contains(err.Error(), "exceeds 70") {
+		t.Errorf("Send() = %v, want length error", err)
+	}
+}
+
+func TestPartSet(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	pem := []byte("-----BEGIN CERTIFICATE-----")
+	common := PartSet{}.
+		Param("device_id", "dev-1").
+		File("cert", "device.pem", pem, ContentType("application/x-pem-file"))
+	pem[0] = 'X' // the set holds its own copy
+	extended := common.Param("fleet", "eu")
+	if common.Len() != 2 || extended.Len() != 3 {
+		t.Fatalf("Len = %d and %d, want 2 and 3", common.Len(), extended.Len())
+	}
+
+	for i := range 2 {
+		resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+			Include(extended).
+			Param("seq", strconv.Itoa(i)).
+			Send()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		srv.AssertField("device_id", "dev-1")
+		srv.AssertField("fleet", "eu")
+		srv.AssertField("seq", strconv.Itoa(i))
+		srv.AssertFile("cert", "device.pem", "-----BEGIN CERTIFICATE-----")
+	}
+}
+
+func TestClone(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]string{} // upload id -> boundary
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/started" {
+			return
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+		}
+		if r.Header.Get("X-Template") != "yes" || r.Header.Get("X-Upload") != r.FormValue("id") {
+			t.Errorf("upload %s: headers %v", r.FormValue("id"), r.Header)
+		}
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mu.Lock()
+		seen[r.FormValue("id")] = params["boundary"]
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	template := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Header("X-Template", "yes").
+		ExpectStatus(http.StatusCreated).
+		Workers(2)
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		m := template.Clone()
+		if m.workers != 2 || m.prep == nil || m.prep == template.prep || m.window == nil || m.window == template.window {
+			t.Fatalf("clone %d has no pool of its own: workers %d", i, m.workers)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := strconv.Itoa(i)
+			resp, err := m.Header("X-Upload", id).
+				Param("id", id).
+				PreparedFile("data", "data.bin", func() ([]byte, error) { return []byte(id), nil }).
+				Send()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != 4 {
+		t.Fatalf("server saw %d uploads, want 4", len(seen))
+	}
+	boundaries := map[string]bool{}
+	for _, b := range seen {
+		boundaries[b] = true
+	}
+	if len(boundaries) != 4 {
+		t.Errorf("clones share boundaries: %v", seen)
+	}
+	if template.request.Header.Get("X-Upload") != "" {
+		t.Errorf("clone header leaked into the template: %v", template.request.Header)
+	}
+
+	started := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL+"/started").
+		Param("id", "started")
+	defer started.Close()
+	if _, err := started.Clone().Send(); !errors.Is(err, ErrNotIdle) {
+		t.Errorf("Clone of a started builder: Send() = %v, want ErrNotIdle", err)
+	}
+}
+
+func TestParamsAndArrays(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Params(url.Values{"z": {"last"}, "a": {"1", "2"}}).
+		ParamSlice("plain", []string{"x", "y"}).
+		ArrayNaming(ArrayBrackets).
+		ParamSlice("tag", []string{"go", "http"}).
+		ArrayNaming(ArrayIndexed).
+		ParamSlice("id", []string{"7", "8"}).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := "a=1 a=2 z=last plain=x plain=y tag[]=go tag[]=http id[0]=7 id[1]=8"
+	if s := partNames(srv); s != want {
+		t.Errorf("parts = %q, want %q", s, want)
+	}
+}
+
+func TestParamsFromMapAndValues(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		ParamsFromMap(map[string]string{"c": "3", "a": "1", "b": "2"}).
+		ParamsFromValues(url.Values{"y": {"2"}, "x": {"1", "11"}}).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if s, want := partNames(srv), "a=1 b=2 c=3 x=1 x=11 y=2"; s != want {
+		t.Errorf("parts = %q, want %q", s, want)
+	}
+}
+
+func TestStruct(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	type Address struct {
+		City string `form:"city"`
+		Zip  string `form:"zip,omitempty"`
+	}
+	type Meta struct {
+		Source string `form:"source"`
+	}
+	path := filepath.Join(t.TempDir(), "report.csv")
+	os.WriteFile(path, []byte("a,b"), 0o644)
+	doc, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	age := 42
+	v := struct {
+		Meta
+		Name     string    `form:"name"`
+		Age      *int      `form:"age"`
+		Nickname *string   `form:"nick"`
+		Score    float64   `form:"score"`
+		Admin    bool      `form:"admin,omitempty"`
+		Tags     []string  `form:"tag"`
+		Addr     Address   `form:"addr"`
+		Joined   time.Time `form:"joined"`
+		Secret   string    `form:"-"`
+		Note     string
+		Avatar   io.Reader `file:"avatar,me.png"`
+		Doc      *os.File  `file:"doc"`
+		Raw      []byte    `file:"raw,omitempty"`
+		internal string
+	}{
+		Meta:   Meta{Source: "api"},
+		Name:   "Ann",
+		Age:    &age,
+		Score:  9.5,
+		Tags:   []string{"a", "b"},
+		Addr:   Address{City: "Oslo"},
+		Joined: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Secret: "hidden",
+		Note:   "untagged",
+		Avatar: strings.NewReader("png"),
+		Doc:    doc,
+	}
+
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		ArrayNaming(ArrayBrackets).
+		Struct(&v).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := "source=api name=Ann age=42 score=9.5 tag[]=a tag[]=b addr.city=Oslo " +
+		"joined=2024-01-02T03:04:05Z Note=untagged avatar=png doc=a,b"
+	if s := partNames(srv); s != want {
+		t.Errorf("parts = %q, want %q", s, want)
+	}
+	files := srv.Files()
+	if len(files) != 2 || files[0].FileName != "me.png" || files[1].FileName != "report.csv" {
+		t.Errorf("files = %+v", files)
+	}
+
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Struct(struct{ C chan int }{}).
+		Send()
+	if err == nil || !strings.Contains(err.Error(), "unsupported type chan int") {
+		t.Errorf("Send() = %v, want unsupported type error", err)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	page := htmltemplate.Must(htmltemplate.New("page").Parse("<h1>{{.}}</h1>"))
+	report := texttemplate.Must(texttemplate.New("report").Parse("{{range .}}{{.}};{{end}}"))
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Template("page", "page.html", page, "<b>").
+		Template("report", "report.data", report, []int{1, 2, 3}).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if s, want := partNames(srv), "page=<h1>&lt;b&gt;</h1> report=1;2;3;"; s != want {
+		t.Errorf("parts = %q, want %q", s, want)
+	}
+	files := srv.Files()
+	if len(files) != 2 || !strings.HasPrefix(files[0].ContentType, "text/html") ||
+		files[1].ContentType != "application/octet-stream" {
+		t.Errorf("files = %+v", files)
+	}
+
+	broken := texttemplate.Must(texttemplate.New("broken").Parse("{{.Missing}}"))
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Template("report", "report.txt", broken, 1).
+		Send()
+	if err == nil || !strings.Contains(err.Error(), "failed to generate file") {
+		t.Errorf("Send() = %v, want template error", err)
+	}
+}
+
+func TestXML(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	type Item struct {
+		XMLName xml.Name `xml:"item"`
+		ID      int      `xml:"id,attr"`
+		Name    string   `xml:"name"`
+	}
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		XML("compact", "a.xml", Item{ID: 1, Name: "a&b"}).
+		XML("pretty", "b.xml", Item{ID: 2, Name: "b"}, XMLHeader(""), XMLIndent("", " ")).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := xml.Header + `<item id="1"><name>a&amp;b</name></item>` +
+		" pretty=<item id=\"2\">\n <name>b</name>\n</item>"
+	if s := partNames(srv); s != "compact="+want {
+		t.Errorf("parts = %q, want %q", s, "compact="+want)
+	}
+	if files := srv.Files(); len(files) != 2 || files[0].ContentType != XMLContentType {
+		t.Errorf("files = %+v", files)
+	}
+
+	body, err := io.ReadAll(XMLBody(Item{ID: 3}, XMLHeader("")))
+	if err != nil || string(body) != `<item id="3"><name></name></item>` {
+		t.Errorf("XMLBody = %q, %v", body, err)
+	}
+	if _, err := io.ReadAll(XMLBody(make(chan int))); err == nil {
+		t.Error("XMLBody of a channel succeeded")
+	}
+}
+
+// lengthCodec stands in for a binary codec such as protobuf.
+type lengthCodec struct{}
+
+func (lengthCodec) ContentType() string { return "application/x-length" }
+
+func (lengthCodec) Encode(w io.Writer, v any) error {
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("cannot encode %T", v)
+	}
+	_, err := fmt.Fprintf(w, "%d:%s", len(s), s)
+	return err
+}
+
+func TestEncoded(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	RegisterCodec(lengthCodec{})
+	custom, ok := LookupCodec("application/x-length; v=1")
+	if !ok {
+		t.Fatal("registered codec not found")
+	}
+	if c, _ := LookupCodec("application/json"); c != JSONCodec {
+		t.Errorf("LookupCodec(json) = %v", c)
+	}
+
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Encoded("meta", "meta.json", JSONCodec, map[string]int{"n": 1}).
+		Encoded("blob", "blob.bin", custom, "hello").
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if s, want := partNames(srv), "meta={\"n\":1}\n blob=5:hello"; s != want {
+		t.Errorf("parts = %q, want %q", s, want)
+	}
+	if files := srv.Files(); len(files) != 2 || files[0].ContentType != "application/json" ||
+		files[1].ContentType != "application/x-length" {
+		t.Errorf("files = %+v", files)
+	}
+
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Encoded("blob", "blob.bin", custom, 42).
+		Send()
+	if err == nil || !strings.Contains(err.Error(), "cannot encode int") {
+		t.Errorf("Send() = %v, want encoding error", err)
+	}
+}
+
+func TestParseInto(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+		for _, p := range []struct{ id, ct, body string }{
+			{"<status>", "text/plain", "ok"},
+			{"<meta>", "application/json", `{"n":3}`},
+			{"<item>", "application/xml", `<item id="7"></item>`},
+			{"", "application/octet-stream", "big file"},
+			{"<ignored>", "text/plain", "x"},
+		} {
+			h := textproto.MIMEHeader{"Content-Type": {p.ct}}
+			if p.id != "" {
+				h.Set("Content-ID", p.id)
+			} else {
+				h.Set("Content-Disposition", `form-data; name="file"; filename="f.bin"`)
+			}
+			part, _ := mw.CreatePart(h)
+			io.WriteString(part, p.body)
+		}
+		mw.Close()
+	}))
+	defer srv.Close()
+
+	var file bytes.Buffer
+	var got struct {
+		Status string `part:"status"`
+		Meta   struct {
+			N int `json:"n"`
+		} `part:"meta"`
+		Item struct {
+			ID int `xml:"id,attr"`
+		} `part:"item"`
+		File io.Writer `part:"file"`
+	}
+	got.File = &file
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ParseInto(resp, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "ok" || got.Meta.N != 3 || got.Item.ID != 7 || file.String() != "big file" {
+		t.Errorf("got %+v, file %q", got, file.String())
+	}
+
+	var bad struct {
+		Status int `part:"status"`
+	}
+	resp, err = srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ParseInto(resp, &bad); err == nil || !strings.Contains(err.Error(), `no codec registered for "text/plain"`) {
+		t.Errorf("ParseInto = %v, want codec error", err)
+	}
+}
+
+func TestClientStats(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	client := NewTrackedClient(srv.Client())
+	if err := client.Warmup(context.Background(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	host := strings.TrimPrefix(srv.URL, "http://")
+	if s := client.ClientStats(); s.NewConns != 1 || s.Hosts[host].Idle != 1 {
+		t.Errorf("after warmup: %+v", s)
+	}
+
+	m := NewMultipart(context.Background(), client.Client, http.MethodPost, srv.URL)
+	resp, err := m.Param("a", "1").Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	s, ok := m.ClientStats()
+	if !ok {
+		t.Fatal("ClientStats not available for a tracked client")
+	}
+	if s.Requests != 2 || s.NewConns != 1 || s.ReusedConns != 1 || s.Hosts[host].ReusedConns != 1 {
+		t.Errorf("after upload: %+v", s)
+	}
+	untracked := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL)
+	defer untracked.Close()
+	if _, ok := untracked.ClientStats(); ok {
+		t.Error("ClientStats available for an untracked client")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	ratelimit.Register(host, ratelimit.New(0, 64<<10))
+	defer ratelimit.Register(host, nil)
+
+	content := bytes.Repeat([]byte("x"), 96<<10)
+	start := time.Now()
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		File("file", "a.bin", bytes.NewReader(content)).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// 64 KiB of burst, then about half a second for the rest.
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("upload under the host limit took %v", d)
+	}
+	srv.AssertFileSHA256("file", fmt.Sprintf("%x", sha256.Sum256(content)))
+
+	// An explicit limiter replaces the registered one.
+	start = time.Now()
+	resp, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		RateLimit(ratelimit.New(0, 10<<20)).
+		File("file", "a.bin", bytes.NewReader(content)).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("upload under a generous limit took %v", d)
+	}
+}
+
+func TestSkipUnchanged(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	storePath := filepath.Join(t.TempDir(), "seen")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	os.WriteFile(path, []byte("unchanged"), 0o644)
+
+	upload := func() {
+		t.Helper()
+		store, err := OpenFileSeenStore(storePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+			SkipUnchanged(store).
+			FileFromPath("a", path).
+			File("b", "b.txt", io.MultiReader(strings.NewReader("new "), strings.NewReader("content"))).
+			Send()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	upload()
+	if s, want := partNames(srv), "a=unchanged b=new content"; s != want {
+		t.Errorf("first upload: parts = %q, want %q", s, want)
+	}
+
+	// A later run with the reopened store sends references only.
+	upload()
+	sum := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("unchanged")))
+	files := srv.Files()
+	if len(files) != 2 || string(files[0].Content) != sum || files[0].ContentType != ContentRefType ||
+		files[0].FileName != "a.txt" || files[1].ContentType != ContentRefType {
+		t.Errorf("second upload: files = %+v", files)
+	}
+
+	// Failed uploads are not recorded.
+	fail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer fail.Close()
+	store := NewMemorySeenStore()
+	resp, err := NewMultipart(context.Background(), fail.Client(), http.MethodPost, fail.URL).
+		SkipUnchanged(store).
+		File("c", "c.txt", strings.NewReader("c")).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if seen, _ := store.Seen(fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("c")))); seen {
+		t.Error("content of a failed upload marked as seen")
+	}
+}
+
+func TestSlowNetwork(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	content := bytes.Repeat([]byte("x"), 256<<10)
+
+	client := &http.Client{Transport: slowtransport.New(srv.Client().Transport, slowtransport.ResetUploadAfter(64<<10))}
+	_, err := NewMultipart(context.Background(), client, http.MethodPost, srv.URL).
+		File("file", "big.bin", bytes.NewReader(content)).
+		Send()
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("Send() through a reset = %v", err)
+	}
+
+	client = &http.Client{Transport: slowtransport.New(srv.Client().Transport, slowtransport.UploadBandwidth(1<<20))}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = NewMultipart(ctx, client, http.MethodPost, srv.URL).
+		File("file", "big.bin", bytes.NewReader(content)).
+		Send()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Send() on a slow link = %v, want deadline exceeded", err)
+	}
+}
+
+func TestInjectFaults(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	start := time.Now()
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		InjectFaults(
+			Fault{Index: 0, Action: FaultDuplicate},
+			Fault{Name: "drop", Action: FaultDrop},
+			Fault{Name: "slow", Action: FaultDelay, Delay: 30 * time.Millisecond},
+			Fault{Name: "file", Action: FaultDuplicate},
+		).
+		Param("first", "1").
+		Param("drop", "x").
+		Param("slow", "2").
+		File("file", "f.txt", strings.NewReader("content")).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if s, want := partNames(srv), "first=1 first=1 slow=2 file=content file=content"; s != want {
+		t.Errorf("parts = %q, want %q", s, want)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("delayed part sent after %v", d)
+	}
+
+	boom := errors.New("boom")
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		InjectFaults(Fault{Index: 1, Action: FaultError, Err: boom}).
+		Param("a", "1").
+		Param("b", "2").
+		Send()
+	if !errors.Is(err, boom) || !strings.Contains(err.Error(), `part 1 ["b"]`) {
+		t.Errorf("Send() = %v, want injected error", err)
+	}
+}
+
+func TestErrorTaxonomy(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	_, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Param("a", "1").
+		FileFromPath("doc", filepath.Join(t.TempDir(), "missing.txt")).
+		Send()
+	var partErr *PartError
+	if !errors.Is(err, ErrPartWrite) || !errors.Is(err, os.ErrNotExist) || !errors.As(err, &partErr) ||
+		partErr.Part != "doc" || partErr.Index != 1 || partErr.Offset == 0 {
+		t.Errorf("missing file: err = %v, part error %+v", err, partErr)
+	}
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || reqErr.Attempt != 1 || reqErr.Sent < partErr.Offset {
+		t.Errorf("missing file: request error %+v", reqErr)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = NewMultipart(ctx, srv.Client(), http.MethodPost, srv.URL).Param("a", "1").Send()
+	if !errors.Is(err, ErrCanceled) || errors.Is(err, ErrTimeout) {
+		t.Errorf("canceled: err = %v", err)
+	}
+
+	reject := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer reject.Close()
+	_, err = NewMultipart(context.Background(), reject.Client(), http.MethodPost, reject.URL).
+		ExpectStatus().
+		Param("a", "1").
+		Send()
+	var statusErr *StatusError
+	if !errors.Is(err, ErrResponseStatus) || !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusConflict {
+		t.Errorf("rejected: err = %v", err)
+	}
+	resp, err := NewMultipart(context.Background(), reject.Client(), http.MethodPost, reject.URL).
+		ExpectStatus(http.StatusOK, http.StatusConflict).
+		Param("a", "1").
+		Send()
+	if err != nil {
+		t.Fatalf("accepted status: %v", err)
+	}
+	resp.Body.Close()
+}
+
+type panicReader struct{}
+
+func (panicReader) Read([]byte) (int, error) { panic("boom") }
+
+type panicTransport struct{}
+
+func (panicTransport) RoundTrip(*http.Request) (*http.Response, error) { panic("boom") }
+
+func TestWorkerPanic(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	_, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Param("a", "1").
+		File("doc", "doc.txt", panicReader{}).
+		Send()
+	var panicErr *PanicError
+	if !errors.Is(err, ErrWorkerPanic) || !errors.Is(err, ErrPartWrite) || !errors.As(err, &panicErr) ||
+		panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+		t.Errorf("reader: err = %v", err)
+	}
+
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Workers(2).
+		PreparedFile("doc", "doc.txt", func() ([]byte, error) { panic("boom") }).
+		Send()
+	if !errors.Is(err, ErrWorkerPanic) {
+		t.Errorf("prepare: err = %v", err)
+	}
+
+	client := &http.Client{Transport: panicTransport{}}
+	_, err = NewMultipart(context.Background(), client, http.MethodPost, srv.URL).
+		File("doc", "doc.txt", strings.NewReader(strings.Repeat("x", 1<<20))).
+		Send()
+	if !errors.Is(err, ErrWorkerPanic) {
+		t.Errorf("transport: err = %v", err)
+	}
+}
+
+// checkGoroutines fails t if goroutines started during the test are still
+// running once its cleanups, registered later, have run.
+func checkGoroutines(t *testing.T) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<20)
+				t.Errorf("leaked %d goroutines:\n%s", runtime.NumGoroutine()-before, buf[:runtime.Stack(buf, true)])
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestLifecycle(t *testing.T) {
+	checkGoroutines(t)
+	srv := multiparttest.NewEchoServer(t)
+	t.Cleanup(srv.Client().CloseIdleConnections)
+	newBuilder := func() *Multipart {
+		return NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL)
+	}
+
+	// Sent: Close is a no-op and later use is reported.
+	m := newBuilder().Param("a", "1")
+	resp, err := m.Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := m.Close(); err != nil {
+		t.Errorf("Close after Send: %v", err)
+	}
+	m.Param("b", "2").File("c", "c.txt", strings.NewReader("x"))
+	if err := m.Err(); !errors.Is(err, ErrAlreadyClosed) || !strings.Contains(err.Error(), `"b"`) {
+		t.Errorf("Err after Send = %v", err)
+	}
+	if _, err := m.Send(); !errors.Is(err, ErrAlreadyClosed) {
+		t.Errorf("second Send: err = %v", err)
+	}
+
+	// Idle: nothing was started, Close must not block.
+	m = newBuilder().Workers(2)
+	m.Close()
+	m.Close()
+	if _, err := m.Send(); !errors.Is(err, ErrAlreadyClosed) {
+		t.Errorf("Send after Close: err = %v", err)
+	}
+
+	// Streaming: the request in flight is canceled.
+	block := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices a closed connection once the body is read.
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(block.Close)
+	m = NewMultipart(context.Background(), block.Client(), http.MethodPost, block.URL).
+		Workers(2).
+		Param("a", "1").
+		PreparedFile("b", "b.bin", func() ([]byte, error) { return make([]byte, 1<<20), nil }).
+		File("c", "c.txt", strings.NewReader("x"))
+	if err := m.Close(); err != nil {
+		t.Errorf("Close while streaming: %v", err)
+	}
+	block.Client().CloseIdleConnections()
+
+	// A failed part does not block the parts added after it.
+	_, err = newBuilder().
+		FileFromPath("a", filepath.Join(t.TempDir(), "missing")).
+		Param("b", "2").
+		Param("c", "3").
+		Send()
+	if !errors.Is(err, ErrPartWrite) {
+		t.Errorf("failed part: err = %v", err)
+	}
+}
+
+func TestPartsFrom(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	path := filepath.Join(t.TempDir(), "c.txt")
+	if err := os.WriteFile(path, []byte("from disk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	specs := []PartSpec{
+		{Field: "a", Value: "1"},
+		{Field: "b", Filename: "b.txt", Content: strings.NewReader("from memory")},
+		{Field: "c", Path: path},
+	}
+
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		PartsFrom(func(yield func(PartSpec, error) bool) {
+			for _, spec := range specs {
+				if !yield(spec, nil) {
+					return
+				}
+			}
+		}).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := partNames(srv), "a=1 b=from memory c=from disk"; got != want {
+		t.Errorf("parts = %q, want %q", got, want)
+	}
+
+	errCursor := errors.New("cursor closed")
+	resumed := false
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		PartsFrom(func(yield func(PartSpec, error) bool) {
+			if !yield(specs[0], nil) || !yield(PartSpec{}, errCursor) {
+				return
+			}
+			resumed = true
+		}).
+		Send()
+	if !errors.Is(err, errCursor) {
+		t.Errorf("err = %v, want %v", err, errCursor)
+	}
+	if resumed {
+		t.Error("iterator resumed after an error")
+	}
+}
+
+func TestFileFromChan(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	chunks := make(chan []byte)
+	go func() {
+		defer close(chunks)
+		for _, c := range []string{"tail ", "of ", "log"} {
+			chunks <- []byte(c)
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		FileFromChan("log", "app.log", chunks, ChunkTimeout(time.Second)).
+		Param("after", "1").
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := partNames(srv), "log=tail of log after=1"; got != want {
+		t.Errorf("parts = %q, want %q", got, want)
+	}
+
+	stalled := make(chan []byte, 1)
+	stalled <- []byte("start")
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		FileFromChan("log", "app.log", stalled, ChunkTimeout(20*time.Millisecond)).
+		Send()
+	if !errors.Is(err, ErrChunkTimeout) || !errors.Is(err, ErrPartWrite) {
+		t.Errorf("stalled: err = %v", err)
+	}
+}
+
+func TestFileFunc(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		FileFunc("image", "dot.png", func(w io.Writer) error { return png.Encode(w, img) }).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	files := srv.Files()
+	if len(files) != 1 || files[0].Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("files = %+v", files)
+	}
+	if _, err := png.Decode(bytes.NewReader(files[0].Content)); err != nil {
+		t.Errorf("decode uploaded image: %v", err)
+	}
+
+	errRender := errors.New("render failed")
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		FileFunc("doc", "doc.pdf", func(w io.Writer) error { return errRender }).
+		Send()
+	if !errors.Is(err, errRender) {
+		t.Errorf("err = %v, want %v", err, errRender)
+	}
+}
+
+func TestFanout(t *testing.T) {
+	primary := multiparttest.NewEchoServer(t)
+	backup := multiparttest.NewEchoServer(t)
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInsufficientStorage)
+	}))
+	defer broken.Close()
+
+	results := NewMultipart(context.Background(), primary.Client(), http.MethodPost, primary.URL).
+		Fanout(backup.URL, broken.URL, "://bad").
+		Param("a", "1").
+		File("b", "b.bin", bytes.NewReader(bytes.Repeat([]byte("x"), 1<<20))).
+		SendAll()
+	if len(results) != 4 {
+		t.Fatalf("got %d results", len(results))
+	}
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusInsufficientStorage} {
+		res := results[i]
+		if res.Err != nil {
+			t.Fatalf("%s: %v", res.URL, res.Err)
+		}
+		res.Response.Body.Close()
+		if res.Response.StatusCode != want {
+			t.Errorf("%s: status %d, want %d", res.URL, res.Response.StatusCode, want)
+		}
+	}
+	if results[3].Err == nil {
+		t.Error("invalid mirror URL: no error")
+	}
+	if got, want := partNames(backup), partNames(primary); got != want || !strings.HasPrefix(got, "a=1 b=xxx") {
+		t.Errorf("backup parts = %.20q, primary %.20q", got, want)
+	}
+
+	resp, err := NewMultipart(context.Background(), primary.Client(), http.MethodPost, primary.URL).
+		Fanout(backup.URL).
+		Param("c", "3").
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := partNames(backup); got != "c=3" {
+		t.Errorf("backup parts after Send = %q", got)
+	}
+}
+
+func TestPartFutures(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+
+	m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL)
+	field := m.SubmitParam("a", "1")
+	file := m.SubmitFile("b", "b.txt", strings.NewReader("content"))
+	<-field.Done()
+	resp, err := m.Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	<-file.Done()
+	if field.Err() != nil || file.Err() != nil {
+		t.Errorf("errors = %v, %v", field.Err(), file.Err())
+	}
+	if late := m.SubmitParam("c", "3"); !errors.Is(late.Err(), ErrAlreadyClosed) {
+		t.Errorf("after Send: err = %v", late.Err())
+	}
+
+	m = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL)
+	missing := m.SubmitFile("d", "d.txt", iotest.ErrReader(os.ErrNotExist))
+	behind := m.SubmitParam("e", "5")
+	m.Send()
+	<-missing.Done()
+	<-behind.Done()
+	var partErr *PartError
+	if !errors.As(missing.Err(), &partErr) || partErr.Part != "d" {
+		t.Errorf("failed part: err = %v", missing.Err())
+	}
+	if !errors.As(behind.Err(), &partErr) || partErr.Part != "d" {
+		t.Errorf("part behind: err = %v", behind.Err())
+	}
+}
+
+// dialBridge opens a client WebSocket to a Bridge served by srv.
+func dialBridge(t *testing.T, url string) *wsConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	const key = "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: bridge\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", key)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		t.Fatalf("handshake: %s %v", resp.Status, resp.Header)
+	}
+	return &wsConn{conn: conn, r: br, w: bufio.NewWriter(conn), limit: 1 << 20}
+}
+
+// uploadOverBridge sends content in chunks, waiting for each
+// acknowledgement, and returns the final message.
+func uploadOverBridge(t *testing.T, mc messageConn, content []byte, chunk int) bridgeMessage {
+	t.Helper()
+	if err := reply(mc, bridgeMessage{Filename: "data.bin", Fields: map[string]string{"b": "2", "a": "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	for seq := 1; len(content) > 0; seq++ {
+		n := min(chunk, len(content))
+		if err := mc.WriteMessage(false, content[:n]); err != nil {
+			t.Fatal(err)
+		}
+		content = content[n:]
+		ack, err := readControl(mc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ack.Error != "" {
+			return ack
+		}
+		if ack.Ack != seq {
+			t.Fatalf("ack = %+v, want %d", ack, seq)
+		}
+	}
+	if err := reply(mc, bridgeMessage{End: true}); err != nil {
+		t.Fatal(err)
+	}
+	done, err := readControl(mc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return done
+}
+
+func TestBridgeWebSocket(t *testing.T) {
+	upstream := multiparttest.NewEchoServer(t)
+	bridge := httptest.NewServer(NewBridge(upstream.Client(), http.MethodPost, upstream.URL).
+		Field("upload").
+		Header("X-Relay", "ws"))
+	defer bridge.Close()
+
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	done := uploadOverBridge(t, dialBridge(t, bridge.URL), content, 16<<10)
+	if !done.Done || done.Status != http.StatusOK {
+		t.Fatalf("final message = %+v", done)
+	}
+	if s := partNames(upstream); !strings.HasPrefix(s, "a=1 b=2 upload=") {
+		t.Errorf("parts = %.40q", s)
+	}
+	if f := upstream.Files()[0]; f.FileName != "data.bin" || !bytes.Equal(f.Content, content) {
+		t.Errorf("file %q has %d bytes, want %d", f.FileName, len(f.Content), len(content))
+	}
+	if h := upstream.Headers().Get("X-Relay"); h != "ws" {
+		t.Errorf("X-Relay = %q", h)
+	}
+}
+
+// dialTCPBridge serves one raw TCP connection with bridge and returns the
+// client end and the result of ServeConn.
+func dialTCPBridge(t *testing.T, bridge *Bridge) (*tcpConn, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			errc <- err
+			return
+		}
+		errc <- bridge.ServeConn(context.Background(), conn)
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc := newTCPConn(conn, 1<<10)
+	t.Cleanup(func() { mc.Close() })
+	return mc, errc
+}
+
+func TestBridgeTCP(t *testing.T) {
+	upstream := multiparttest.NewEchoServer(t)
+	bridge := NewBridge(upstream.Client(), http.MethodPost, upstream.URL).MaxChunk(1 << 10)
+
+	mc, errc := dialTCPBridge(t, bridge)
+
+	content := bytes.Repeat([]byte("x"), 5000)
+	if done := uploadOverBridge(t, mc, content, 1<<10); !done.Done {
+		t.Fatalf("final message = %+v", done)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if f := upstream.Files()[0]; !bytes.Equal(f.Content, content) {
+		t.Errorf("file has %d bytes, want %d", len(f.Content), len(content))
+	}
+}
+
+func TestBridgeChunkTooLarge(t *testing.T) {
+	upstream := multiparttest.NewEchoServer(t)
+	bridge := NewBridge(upstream.Client(), http.MethodPost, upstream.URL).MaxChunk(100)
+
+	mc, errc := dialTCPBridge(t, bridge)
+
+	done := uploadOverBridge(t, mc, make([]byte, 200), 200)
+	if !strings.Contains(done.Error, "too large") {
+		t.Fatalf("final message = %+v", done)
+	}
+	if err := <-errc; !errors.Is(err, errMessageTooLarge) {
+		t.Errorf("ServeConn = %v", err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		cookie, _ := r.Cookie("sid")
+		mu.Lock()
+		seen = append(seen, fmt.Sprintf("%s %s %s [%v]", r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("X-Tenant"), cookie))
+		mu.Unlock()
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "42"})
+		if r.URL.Path == "/v1/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := NewSession(srv.Client(), srv.URL+"/v1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := 0
+	s.Header("X-Tenant", "acme").
+		ExpectStatus().
+		Token(func(context.Context) (string, error) {
+			tokens++
+			return fmt.Sprint("t", tokens), nil
+		})
+
+	for _, path := range []string{"upload", "upload", "missing"} {
+		resp, err := s.NewBuilder(path).Param("a", "1").Send()
+		if path == "missing" {
+			if !errors.Is(err, ErrResponseStatus) {
+				t.Errorf("err = %v, want ErrResponseStatus", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	want := []string{
+		"/v1/upload Bearer t1 acme []",
+		"/v1/upload Bearer t2 acme [sid=42]",
+		"/v1/missing Bearer t3 acme [sid=42]",
+	}
+	if !slices.Equal(seen, want) {
+		t.Errorf("requests = %q, want %q", seen, want)
+	}
+	stats := s.Stats()
+	if stats.Requests != 3 || stats.Statuses[http.StatusOK] != 2 || stats.Statuses[http.StatusNotFound] != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	s.Token(func(context.Context) (string, error) { return "", errors.New("expired") })
+	if _, err := s.NewBuilder("upload").Param("a", "1").Send(); err == nil {
+		t.Error("expected error from failing token source")
+	}
+	if stats := s.Stats(); stats.Errors != 1 {
+		t.Errorf("errors = %d, want 1", stats.Errors)
+	}
+}
+
+func TestCall(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch r.URL.Path {
+		case "/v1/files":
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			f, _, _ := r.FormFile("doc")
+			data, _ := io.ReadAll(f)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"id":%q,"size":%d,"type":%q}`, r.FormValue("title"), len(data), ct)
+		case "/v1/tags":
+			var in struct{ Tags []string }
+			json.NewDecoder(r.Body).Decode(&in)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"id":%q,"size":%d,"type":%q}`, strings.Join(in.Tags, "+"), len(in.Tags), ct)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	s, err := NewSession(srv.Client(), srv.URL+"/v1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		ID   string `json:"id"`
+		Size int    `json:"size"`
+		Type string `json:"type"`
+	}
+	type upload struct {
+		Title string    `form:"title"`
+		Doc   io.Reader `file:"doc,doc.txt"`
+	}
+	got, err := Call[upload, result](context.Background(), s, Endpoint{Path: "files"},
+		upload{Title: "report", Doc: strings.NewReader("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (result{ID: "report", Size: 5, Type: MediaMultipart}); got != want {
+		t.Errorf("multipart call = %+v, want %+v", got, want)
+	}
+
+	type tags struct{ Tags []string }
+	got, err = Call[tags, result](context.Background(), s, Endpoint{Method: http.MethodPut, Path: "tags"},
+		tags{Tags: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (result{ID: "a+b", Size: 2, Type: MediaJSON}); got != want {
+		t.Errorf("JSON call = %+v, want %+v", got, want)
+	}
+
+	_, err = Call[tags, result](context.Background(), s, Endpoint{Path: "missing"}, tags{})
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusNotFound {
+		t.Errorf("err = %v, want 404 StatusError", err)
+	}
+}
+
+func TestOperation(t *testing.T) {
+	f, err := os.Open("testdata/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	op, err := LoadOperation(f, "uploadPhoto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Method != http.MethodPost || op.Path != "/pets/{id}/photos" {
+		t.Errorf("operation = %s %s", op.Method, op.Path)
+	}
+
+	bad := []PartSpec{
+		{Field: "rating", Value: "five"},
+		{Field: "kind", Value: "macro"},
+		{Field: "photo", Value: "not a file"},
+		{Field: "caption", Value: "a"},
+		{Field: "caption", Value: "b"},
+		{Field: "meta", Filename: "meta.txt", Content: strings.NewReader("{}"), Options: []PartOption{ContentType("text/plain")}},
+		{Field: "extra", Value: "x"},
+	}
+	err = op.Validate(bad)
+	for _, want := range []string{
+		`"rating": "five" is not a valid integer`,
+		`"kind": "macro" not one of`,
+		`"photo": must be a file`,
+		`"caption": repeated but not an array`,
+		`"meta": content type "text/plain" not one of ["application/json"]`,
+		`"extra": not in schema`,
+	} {
+		if !errors.Is(err, ErrSchemaMismatch) || !strings.Contains(fmt.Sprint(err), want) {
+			t.Errorf("Validate error %v does not mention %s", err, want)
+		}
+	}
+
+	srv := multiparttest.NewEchoServer(t)
+	resp, err := NewMultipart(context.Background(), srv.Client(), op.Method, srv.URL+"/pets/1/photos").
+		Operation(op, []PartSpec{
+			{Field: "caption", Value: "Rex"},
+			{Field: "tags", Value: "dog"},
+			{Field: "tags", Value: "good"},
+			{Field: "photo", Filename: "rex.png", Content: strings.NewReader("png")},
+			{Field: "meta", Filename: "meta", Content: strings.NewReader("{}")},
+		}).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	var types []string
+	for _, p := range srv.Parts() {
+		types = append(types, p.Name+":"+p.Header.Get("Content-Type"))
+	}
+	if got, want := strings.Join(types, " "), "caption: tags: tags: photo:image/png meta:application/json"; got != want {
+		t.Errorf("part types = %q, want %q", got, want)
+	}
+
+	_, err = NewMultipart(context.Background(), srv.Client(), op.Method, srv.URL).
+		Operation(op, []PartSpec{{Field: "caption", Value: "Rex"}}).
+		Send()
+	if !errors.Is(err, ErrSchemaMismatch) || !strings.Contains(err.Error(), `"photo": required`) {
+		t.Errorf("err = %v, want missing photo", err)
+	}
+}
+
+func TestBotAPI(t *testing.T) {
+	tg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path != "/botT0KEN/sendPhoto" || r.FormValue("chat_id") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`)
+			return
+		}
+		f, h, _ := r.FormFile("photo")
+		data, _ := io.ReadAll(f)
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d,"date":1}}`, len(data)+len(h.Filename)+len(r.FormValue("caption")))
+	}))
+	defer tg.Close()
+
+	bot, err := NewTelegram(tg.Client(), tg.URL, "T0KEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := bot.SendPhoto(context.Background(), TelegramUpload{
+		ChatID: "42", Caption: "cat", Filename: "cat.jpg", Content: strings.NewReader("jpeg"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.MessageID != len("jpeg")+len("cat.jpg")+len("cat") {
+		t.Errorf("message = %+v", msg)
+	}
+	_, err = bot.SendDocument(context.Background(), TelegramUpload{ChatID: "42", Filename: "a.txt", Content: strings.NewReader("a")})
+	var be *BotError
+	if !errors.As(err, &be) || be.Code != 400 {
+		t.Errorf("err = %v, want telegram BotError", err)
+	}
+
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Error("token sent to the upload host")
+		}
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(f)
+		if string(data) != "report" {
+			t.Errorf("uploaded %q", data)
+		}
+	}))
+	defer files.Close()
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xoxb" {
+			fmt.Fprint(w, `{"ok":false,"error":"not_authed"}`)
+			return
+		}
+		r.ParseMultipartForm(1 << 20)
+		switch r.URL.Path {
+		case "/api/files.getUploadURLExternal":
+			if r.FormValue("length") != "6" {
+				fmt.Fprint(w, `{"ok":false,"error":"invalid_length"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"ok":true,"upload_url":%q,"file_id":"F1"}`, files.URL+"/upload/F1")
+		case "/api/files.completeUploadExternal":
+			fmt.Fprintf(w, `{"ok":true,"files":%s}`, r.FormValue("files"))
+		}
+	}))
+	defer slack.Close()
+
+	sc, err := NewSlack(nil, slack.URL+"/api/", "xoxb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := sc.UploadFile(context.Background(), SlackUpload{
+		ChannelID: "C1", Filename: "report.txt", Content: strings.NewReader("report"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SlackFile{ID: "F1", Title: "report.txt"}); file != want {
+		t.Errorf("file = %+v, want %+v", file, want)
+	}
+	_, err = sc.UploadFile(context.Background(), SlackUpload{ChannelID: "C1", Filename: "r.txt", Content: strings.NewReader("r"), Length: 9})
+	if !errors.As(err, &be) || be.Description != "invalid_length" {
+		t.Errorf("err = %v, want slack BotError", err)
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	release := make(chan struct{})
+	arrived := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		key := r.Header.Get(IdempotencyHeader)
+		mu.Lock()
+		calls[key]++
+		n := calls[key]
+		mu.Unlock()
+		switch {
+		case key == "slow":
+			arrived <- struct{}{}
+			<-release
+		case key == "flaky" && n == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case key == "throttled" && n == 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "created %s #%d", key, n)
+	}))
+	defer srv.Close()
+
+	fixed := func(key string) KeyFunc { return func(*http.Request) string { return key } }
+	send := func(key string, opts ...IdempotencyOption) (string, error) {
+		resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+			Idempotent(fixed(key), opts...).
+			Param("a", "1").
+			Send()
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Sprintf("%d %s", resp.StatusCode, body), nil
+	}
+
+	for range 2 {
+		got, err := send("job", ReplayFor(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "201 created job #1" {
+			t.Errorf("response = %q, want the first one replayed", got)
+		}
+	}
+	for i, want := range []string{"429 ", "201 created throttled #2", "201 created throttled #2"} {
+		got, err := send("throttled", ReplayFor(time.Hour)) // 429 is retried, not replayed
+		if err != nil || got != want {
+			t.Errorf("throttled send %d = %q, %v, want %q", i, got, err, want)
+		}
+	}
+	for i, want := range []string{"503 ", "201 created flaky #2", "201 created flaky #3"} {
+		got, err := send("flaky") // without ReplayFor nothing is cached
+		if err != nil || got != want {
+			t.Errorf("send %d = %q, %v, want %q", i, got, err, want)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := send("slow")
+		done <- err
+	}()
+	<-arrived
+	if _, err := send("slow"); !errors.Is(err, ErrKeyInFlight) {
+		t.Errorf("duplicate in flight = %v, want ErrKeyInFlight", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for range 2 {
+		store, err := OpenDirIdempotencyStore(dir, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := send("persisted", KeyStore(store), ReplayFor(time.Hour))
+		if err != nil || got != "201 created persisted #1" {
+			t.Errorf("send = %q, %v, want the first response", got, err)
+		}
+	}
+}
+
+func TestMemoryIdempotencyStoreSweep(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	expired := &CachedResponse{StatusCode: http.StatusCreated, Expires: time.Now().Add(-time.Second)}
+	for i := range 1000 {
+		key := strconv.Itoa(i)
+		if _, err := store.Begin(key); err != nil {
+			t.Fatal(err)
+		}
+		store.Complete(key, expired)
+	}
+	if n := len(store.entries); n > 2*minSweep {
+		t.Errorf("%d entries kept, want expired ones dropped", n)
+	}
+
+	live := &CachedResponse{StatusCode: http.StatusCreated, Expires: time.Now().Add(time.Hour)}
+	store.Begin("live")
+	store.Complete("live", live)
+	for i := range 1000 {
+		store.Begin("x" + strconv.Itoa(i))
+	}
+	if res, err := store.Begin("live"); err != nil || res != live {
+		t.Errorf("live entry = %v, %v, want it kept by sweeps", res, err)
+	}
+}
+
+func TestDirIdempotencyStoreClaim(t *testing.T) {
+	dir := t.TempDir()
+	var claimed atomic.Int32
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Go(func() {
+			store, err := OpenDirIdempotencyStore(dir, time.Minute)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			switch res, err := store.Begin("key"); {
+			case err == nil && res == nil:
+				claimed.Add(1)
+			case !errors.Is(err, ErrKeyInFlight):
+				t.Errorf("Begin = %v, %v", res, err)
+			}
+		})
+	}
+	wg.Wait()
+	if n := claimed.Load(); n != 1 {
+		t.Errorf("%d processes claimed the key, want 1", n)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d files left, want only the entry", len(entries))
+	}
+}
+
+func TestConditional(t *testing.T) {
+	var mu sync.Mutex
+	etag := `"v1"`
+	modified := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("ETag", etag)
+		if m := r.Header.Get("If-Match"); m != "" && !strings.Contains(m, etag) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if m := r.Header.Get("If-None-Match"); m == "*" || strings.Contains(m, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if s := r.Header.Get("If-Unmodified-Since"); s != "" {
+			if since, _ := http.ParseTime(s); modified.After(since) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+		}
+		etag = fmt.Sprintf(`"v%d"`, len(etag))
+		w.Header().Set("ETag", etag)
+	}))
+	defer srv.Close()
+	newBuilder := func() *Multipart {
+		return NewMultipart(context.Background(), srv.Client(), http.MethodPut, srv.URL)
+	}
+
+	resp, err := newBuilder().IfMatch("v1").Param("a", "1").Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	_, err = newBuilder().IfMatch("v1").Param("a", "2").Send()
+	var ce *ConditionError
+	if !errors.Is(err, ErrPreconditionFailed) || !errors.As(err, &ce) || ce.ETag != resp.Header.Get("ETag") {
+		t.Errorf("stale If-Match = %v, want ErrPreconditionFailed with the current ETag", err)
+	}
+	if _, err := newBuilder().IfNoneMatch("*").Param("a", "3").Send(); !errors.Is(err, ErrNotModified) {
+		t.Errorf("If-None-Match * = %v, want ErrNotModified", err)
+	}
+	if _, err := newBuilder().IfUnmodifiedSince(modified.Add(-time.Hour)).Param("a", "4").Send(); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("If-Unmodified-Since = %v, want ErrPreconditionFailed", err)
+	}
+	if got := etagList([]string{"a", `"b"`, `W/"c"`, "*"}); got != `"a", "b", W/"c", *` {
+		t.Errorf("etagList = %s", got)
+	}
+}
+
+func TestSendJSONStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		switch r.URL.Query().Get("format") {
+		case "array":
+			io.WriteString(w, ` [ {"id":1}, {"id":2} ,{"id":3}] `)
+		case "ndjson":
+			io.WriteString(w, "{\"id\":1}\n{\"id\":2}\n\n{\"id\":3}\n")
+		case "broken":
+			io.WriteString(w, `[{"id":1}, {"id":`)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+	stream := func(format string, fn func(json.RawMessage) error) error {
+		return NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL+"?format="+format).
+			Param("a", "1").
+			SendJSONStream(fn)
+	}
+
+	for _, format := range []string{"array", "ndjson"} {
+		var ids []int
+		err := stream(format, func(raw json.RawMessage) error {
+			var v struct{ ID int }
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return err
+			}
+			ids = append(ids, v.ID)
+			return nil
+		})
+		if err != nil || !slices.Equal(ids, []int{1, 2, 3}) {
+			t.Errorf("%s: ids = %v, err = %v", format, ids, err)
+		}
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err := stream("array", func(json.RawMessage) error {
+		n++
+		return stop
+	})
+	if !errors.Is(err, stop) || n != 1 {
+		t.Errorf("stopping = %v after %d elements", err, n)
+	}
+	if err := stream("broken", func(json.RawMessage) error { return nil }); err == nil {
+		t.Error("expected error for truncated array")
+	}
+	if err := stream("status", func(json.RawMessage) error { return nil }); !errors.Is(err, ErrResponseStatus) {
+		t.Errorf("err = %v, want ErrResponseStatus", err)
+	}
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	payload := `{"msg":"` + strings.Repeat("a", 1000) + `"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		enc := r.URL.Query().Get("enc")
+		if enc != "" && !strings.Contains(r.Header.Get("Accept-Encoding"), strings.TrimPrefix(enc, "raw-")) {
+			t.Errorf("Accept-Encoding = %q", r.Header.Get("Accept-Encoding"))
+		}
+		var zw io.WriteCloser
+		switch enc {
+		case "gzip":
+			zw = gzip.NewWriter(w)
+		case "deflate":
+			zw = zlib.NewWriter(w)
+		case "raw-deflate":
+			zw, _ = flate.NewWriter(w, flate.DefaultCompression)
+			enc = "deflate"
+		}
+		w.Header().Set("Content-Encoding", enc)
+		zw.Write([]byte(payload))
+		zw.Close()
+	}))
+	defer srv.Close()
+	newBuilder := func(enc string) *Multipart {
+		return NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL+"?enc="+enc).
+			AcceptEncoding("gzip", "deflate")
+	}
+
+	for _, enc := range []string{"gzip", "deflate", "raw-deflate"} {
+		got, err := newBuilder(enc).Param("a", "1").SendText()
+		if err != nil || got != payload {
+			t.Errorf("%s: got %d bytes, err = %v", enc, len(got), err)
+		}
+	}
+	var v struct{ Msg string }
+	if err := newBuilder("gzip").Param("a", "1").SendJSON(&v); err != nil || len(v.Msg) != 1000 {
+		t.Errorf("SendJSON = %d bytes, %v", len(v.Msg), err)
+	}
+	if _, err := newBuilder("gzip").MaxDecompressedSize(100).Param("a", "1").SendText(); !errors.Is(err, ErrDecompressedTooLarge) {
+		t.Errorf("bomb err = %v, want ErrDecompressedTooLarge", err)
+	}
+	if _, err := newBuilder("gzip").MaxDecompressedSize(int64(len(payload))).Param("a", "1").SendText(); err != nil {
+		t.Errorf("body of exactly the limit: %v", err)
+	}
+	_, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL+"?enc=gzip").
+		AcceptEncoding("br").
+		Param("a", "1").
+		Send()
+	if err == nil || !strings.Contains(err.Error(), `"br": no decoder registered`) {
+		t.Errorf("err = %v, want missing br decoder", err)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	var accept string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		accept = r.Header.Get("Accept")
+		switch {
+		case strings.HasPrefix(accept, "application/json"):
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			io.WriteString(w, `{"Name":"gopher"}`)
+		case strings.HasPrefix(accept, "application/xml"):
+			w.Header().Set("Content-Type", "application/xml")
+			io.WriteString(w, `<item><Name>gopher</Name></item>`)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "gopher")
+		}
+	}))
+	defer srv.Close()
+
+	type item struct{ Name string }
+	for _, types := range [][]string{
+		{"application/json", "application/xml"},
+		{"application/xml", "application/json"},
+	} {
+		resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+			Accept(types...).
+			Param("a", "1").
+			Send()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v item
+		if err := resp.Decode(&v); err != nil || v.Name != "gopher" {
+			t.Errorf("%s: decoded %+v, %v", types[0], v, err)
+		}
+	}
+	if want := "application/xml, application/json;q=0.9"; accept != want {
+		t.Errorf("Accept = %q, want %q", accept, want)
+	}
+
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Accept("text/*").
+		Param("a", "1").
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resp.Negotiate(); !errors.Is(err, httpx.ErrNotAcceptable) {
+		t.Errorf("err = %v, want ErrNotAcceptable for text/plain without codec", err)
+	}
+}
+
+func TestSendWrapsResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseMultipartForm(1 << 20)
+		fmt.Fprintf(w, `{"name":%q}`, r.FormValue("name"))
+	}))
+	defer srv.Close()
+
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		Param("name", "gopher").
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct{ Name string }
+	if err := resp.JSON(&out); err != nil || out.Name != "gopher" || !resp.IsSuccess() {
+		t.Errorf("JSON = %+v, %v (status %d)", out, err, resp.StatusCode)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+			return
+		}
+		// Every goroutine's parts arrive in the order it added them.
+		for g := range 4 {
+			values := r.MultipartForm.Value["g"+strconv.Itoa(g)]
+			for i, v := range values {
+				if v != strconv.Itoa(i) {
+					t.Errorf("g%d: part %d is %s", g, i, v)
+				}
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	m := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).Concurrent()
+	var wg sync.WaitGroup
+	for g := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				m.Param("g"+strconv.Itoa(g), strconv.Itoa(i))
+			}
+		}()
+	}
+	// Send races the adders; late parts must be dropped, not panic.
+	resp, err := m.Send()
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := m.Err(); err != nil && !errors.Is(err, ErrAlreadyClosed) {
+		t.Errorf("Err = %v", err)
+	}
+
+	m = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).Concurrent()
+	m.Param("g0", "0")
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	m.Param("g0", "1")
+	if !errors.Is(m.Err(), ErrAlreadyClosed) {
+		t.Errorf("Err after Close = %v", m.Err())
+	}
+
+	// AsCurl reads the parts while another goroutine adds one.
+	m = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).Concurrent()
+	wg.Go(func() { m.Param("g0", "0") })
+	wg.Go(func() { m.AsCurl() })
+	wg.Wait()
+	if cmd, _ := m.AsCurl(); !strings.Contains(cmd, "g0=0") {
+		t.Errorf("AsCurl = %s", cmd)
+	}
+	m.Close()
+}
+
+func TestFileThrough(t *testing.T) {
+	srv := multiparttest.NewEchoServer(t)
+	key := bytes.Repeat([]byte{42}, 32)
+	plain := strings.Repeat("compress me ", 20000)
+	sum := sha256.New()
+	gz := pipeline.Gzip(gzip.BestSpeed)
+
+	resp, err := NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		FileThrough("backup", "db.gz.enc", strings.NewReader(plain), gz, pipeline.Encrypt(key), pipeline.Hash(sum)).
+		Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	f := srv.Files()[0]
+	if got := sha256.Sum256(f.Content); !bytes.Equal(got[:], sum.Sum(nil)) {
+		t.Error("hash does not cover the part content")
+	}
+	dec, err := gcmstream.NewReader(bytes.NewReader(f.Content), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(zr); err != nil || string(got) != plain {
+		t.Errorf("decoded %d bytes, err %v", len(got), err)
+	}
+	if m := gz.Metrics(); m.Runs != 1 || m.BytesIn != int64(len(plain)) {
+		t.Errorf("gzip metrics = %+v", m)
+	}
+
+	scan := pipeline.Scan(func() pipeline.Scanner { return pipeline.Signatures([]byte("MALWARE")) })
+	_, err = NewMultipart(context.Background(), srv.Client(), http.MethodPost, srv.URL).
+		FileThrough("doc", "doc.txt", strings.NewReader(plain+"MALWARE"), scan).
+		Send()
+	if !errors.Is(err, pipeline.ErrContentRejected) {
+		t.Errorf("Send() with rejected content = %v", err)
+	}
+}
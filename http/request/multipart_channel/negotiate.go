@@ -0,0 +1,14 @@
+package main
+
+import "github.com/isauran/go-std-library/http/request/httpx"
+
+// Accept asks for a response in one of types, in order of preference:
+// the first gets quality 1 and each next one a lower quality, unless it
+// carries its own q parameter. Decode on the response of Send then decodes
+// it with the codec registered for the type the server picked, e.g.
+// Accept("application/json", "application/xml") for servers speaking
+// either.
+func (r *Multipart) Accept(types ...string) *Multipart {
+	r.ownHeader().Set("Accept", httpx.AcceptHeader(types...))
+	return r
+}
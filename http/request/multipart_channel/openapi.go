@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrSchemaMismatch is returned, wrapped, for parts that do not match the
+// request body schema of an Operation.
+var ErrSchemaMismatch = errors.New("multipart: parts do not match schema")
+
+// Operation is the multipart/form-data request body of an OpenAPI 3
+// operation, as read by LoadOperation.
+type Operation struct {
+	ID     string
+	Method string // upper case, e.g. POST
+	Path   string // path template, e.g. /pets/{id}/photos
+
+	Required   []string
+	Properties map[string]Property
+	Closed     bool // additionalProperties is false
+}
+
+// Property is one property of the request body schema.
+type Property struct {
+	Type   string // string, integer, number, boolean, object or array
+	Binary bool   // a file part
+	Array  bool   // may repeat; Type and Binary are those of the items
+	Enum   []string
+	// ContentTypes are the media types allowed by the encoding object,
+	// possibly with wildcards such as image/*. Without one, files may have
+	// any type.
+	ContentTypes []string
+}
+
+// LoadOperation reads the operation with operationID from the OpenAPI 3
+// document doc, in JSON. Its request body must have a multipart/form-data
+// schema; references to components of the same document are followed.
+func LoadOperation(doc io.Reader, operationID string) (*Operation, error) {
+	var spec openAPIDoc
+	if err := json.NewDecoder(doc).Decode(&spec); err != nil {
+		return nil, fmt.Errorf("failed to decode OpenAPI document: %w", err)
+	}
+	for p, item := range spec.Paths {
+		for method, raw := range item {
+			var op openAPIOperation
+			if !slices.Contains(openAPIMethods, method) || json.Unmarshal(raw, &op) != nil || op.OperationID != operationID {
+				continue
+			}
+			media, ok := op.RequestBody.Content["multipart/form-data"]
+			if !ok {
+				return nil, fmt.Errorf("failed to load operation %q: no multipart/form-data request body", operationID)
+			}
+			o, err := spec.operation(media)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load operation %q: %w", operationID, err)
+			}
+			o.ID, o.Method, o.Path = operationID, strings.ToUpper(method), p
+			return o, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to load operation %q: not found", operationID)
+}
+
+// Validate checks parts against the schema of op: every required property
+// must be present, only array properties may repeat, binary properties
+// must be file parts with an allowed Content-Type and other properties
+// fields holding a value of their type.
+func (op *Operation) Validate(parts []PartSpec) error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: field %q: %s", ErrSchemaMismatch, field, fmt.Sprintf(format, args...)))
+	}
+	counts := map[string]int{}
+	for _, spec := range parts {
+		counts[spec.Field]++
+		prop, ok := op.Properties[spec.Field]
+		if !ok {
+			if op.Closed {
+				fail(spec.Field, "not in schema")
+			}
+			continue
+		}
+		if counts[spec.Field] == 2 && !prop.Array {
+			fail(spec.Field, "repeated but not an array")
+		}
+		isFile := spec.Content != nil || spec.Path != ""
+		switch {
+		case prop.Binary && !isFile:
+			fail(spec.Field, "must be a file")
+		case !prop.Binary && isFile:
+			fail(spec.Field, "must be a %s field, not a file", prop.Type)
+		case isFile:
+			if ct := partContentType(spec); !matchMediaType(prop.ContentTypes, ct) {
+				fail(spec.Field, "content type %q not one of %q", ct, prop.ContentTypes)
+			}
+		default:
+			if err := prop.checkValue(spec.Value); err != nil {
+				fail(spec.Field, "%v", err)
+			}
+		}
+	}
+	for _, name := range op.Required {
+		if counts[name] == 0 {
+			fail(name, "required")
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Operation adds parts as PartsFrom does after checking them with
+// op.Validate; a mismatch fails the request without adding any part. File
+// parts without a Content-Type get the one of their property's encoding
+// when it names a single type, and otherwise one guessed from the filename
+// extension. The builder must have been created with op's method and URL.
+func (r *Multipart) Operation(op *Operation, parts []PartSpec) *Multipart {
+	parts = slices.Clone(parts)
+	for i, spec := range parts {
+		if prop, ok := op.Properties[spec.Field]; ok && prop.Binary && partContentType(spec) == "" {
+			if ct := prop.defaultContentType(spec); ct != "" {
+				parts[i].Options = append(slices.Clip(spec.Options), ContentType(ct))
+			}
+		}
+	}
+	if err := op.Validate(parts); err != nil {
+		r.pw.CloseWithError(fmt.Errorf("failed to build operation %q: %w", op.ID, err))
+		return r
+	}
+	return r.PartsFrom(func(yield func(PartSpec, error) bool) {
+		for _, spec := range parts {
+			if !yield(spec, nil) {
+				return
+			}
+		}
+	})
+}
+
+// partContentType returns the Content-Type set on a file part by its
+// options, or "" if there is none.
+func partContentType(spec PartSpec) string {
+	var t TRequest
+	return t.apply(spec.Options).header.Get("Content-Type")
+}
+
+// defaultContentType picks the Content-Type of a file part without one.
+func (p Property) defaultContentType(spec PartSpec) string {
+	if len(p.ContentTypes) == 1 && !strings.Contains(p.ContentTypes[0], "*") {
+		return p.ContentTypes[0]
+	}
+	filename := spec.Filename
+	if filename == "" {
+		filename = filepath.Base(spec.Path)
+	}
+	ct, _, _ := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(filename)))
+	return ct
+}
+
+func (p Property) checkValue(v string) error {
+	if len(p.Enum) > 0 && !slices.Contains(p.Enum, v) {
+		return fmt.Errorf("%q not one of %q", v, p.Enum)
+	}
+	var err error
+	switch p.Type {
+	case "integer":
+		_, err = strconv.ParseInt(v, 10, 64)
+	case "number":
+		_, err = strconv.ParseFloat(v, 64)
+	case "boolean":
+		_, err = strconv.ParseBool(v)
+	case "object", "array":
+		if !json.Valid([]byte(v)) {
+			err = errors.New("invalid JSON")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%q is not a valid %s", v, p.Type)
+	}
+	return nil
+}
+
+// matchMediaType reports whether ct is one of allowed, which may contain
+// wildcards such as image/*. An empty allowed list matches anything, and
+// the octet-stream default of a file part stands for any type.
+func matchMediaType(allowed []string, ct string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	ct, _, _ = mime.ParseMediaType(ct)
+	for _, pattern := range allowed {
+		if ok, _ := path.Match(pattern, ct); ok || pattern == "*/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// openAPIDoc is the part of an OpenAPI 3 document LoadOperation reads.
+type openAPIDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+// openAPIMethods are the keys of a path item that hold operations.
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+type openAPIOperation struct {
+	OperationID string `json:"operationId"`
+	RequestBody struct {
+		Content map[string]openAPIMedia `json:"content"`
+	} `json:"requestBody"`
+}
+
+type openAPIMedia struct {
+	Schema   *openAPISchema `json:"schema"`
+	Encoding map[string]struct {
+		ContentType string `json:"contentType"`
+	} `json:"encoding"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref"`
+	Type                 string                    `json:"type"`
+	Format               string                    `json:"format"`
+	ContentMediaType     string                    `json:"contentMediaType"`
+	Enum                 []any                     `json:"enum"`
+	Required             []string                  `json:"required"`
+	Properties           map[string]*openAPISchema `json:"properties"`
+	Items                *openAPISchema            `json:"items"`
+	AdditionalProperties json.RawMessage           `json:"additionalProperties"`
+}
+
+// operation converts the multipart media object of an operation.
+func (d *openAPIDoc) operation(media openAPIMedia) (*Operation, error) {
+	body, err := d.resolve(media.Schema)
+	if err != nil {
+		return nil, err
+	}
+	op := &Operation{
+		Required:   body.Required,
+		Properties: map[string]Property{},
+		Closed:     string(body.AdditionalProperties) == "false",
+	}
+	for name, s := range body.Properties {
+		s, err := d.resolve(s)
+		if err != nil {
+			return nil, fmt.Errorf("property %q: %w", name, err)
+		}
+		var prop Property
+		if s.Type == "array" && s.Items != nil {
+			prop.Array = true
+			if s, err = d.resolve(s.Items); err != nil {
+				return nil, fmt.Errorf("property %q: %w", name, err)
+			}
+		}
+		prop.Type = s.Type
+		prop.Binary = s.Type == "string" && (s.Format == "binary" || s.ContentMediaType != "")
+		for _, v := range s.Enum {
+			prop.Enum = append(prop.Enum, fmt.Sprint(v))
+		}
+		if enc, ok := media.Encoding[name]; ok && enc.ContentType != "" {
+			for ct := range strings.SplitSeq(enc.ContentType, ",") {
+				prop.ContentTypes = append(prop.ContentTypes, strings.TrimSpace(ct))
+			}
+		} else if s.ContentMediaType != "" {
+			prop.ContentTypes = []string{s.ContentMediaType}
+		}
+		op.Properties[name] = prop
+	}
+	return op, nil
+}
+
+// resolve follows $ref to a schema of the document's components.
+func (d *openAPIDoc) resolve(s *openAPISchema) (*openAPISchema, error) {
+	for range 32 { // bounds reference cycles
+		if s == nil {
+			return nil, errors.New("missing schema")
+		}
+		if s.Ref == "" {
+			return s, nil
+		}
+		name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/")
+		if !ok {
+			return nil, fmt.Errorf("unsupported reference %q", s.Ref)
+		}
+		s = d.Components.Schemas[name]
+	}
+	return nil, errors.New("reference cycle")
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// ParamReader adds a plain form field whose value is streamed from
+// content, for APIs that take megabyte-sized text fields such as
+// serialized configs. Unlike File, the part has no filename or
+// Content-Type, so servers read it as a regular form value.
+func (r *Multipart) ParamReader(key string, content io.Reader, opts ...PartOption) *Multipart {
+	t := TRequest{Type: FieldReaderType, Key: key, Content: content}
+	r.send(t.apply(opts))
+	return r
+}
+
+func (r *Multipart) writeFieldReader(b TRequest) error {
+	part, err := r.mw.CreateFormField(b.Key)
+	if err != nil {
+		return fmt.Errorf("failed to create form field [%q]: %w", b.Key, err)
+	}
+	if _, err := r.copyContent(r.scanPart(part), b.Content); err != nil {
+		return fmt.Errorf("failed to write form field [%q]: %w", b.Key, err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// ArrayNaming names the field of the i-th value of an array field sent with
+// ParamSlice. Servers disagree on how arrays are spelled in forms; pick the
+// strategy the receiving framework understands.
+type ArrayNaming func(key string, i int) string
+
+var (
+	// ArrayRepeat sends every value under the bare key ("tag", "tag"),
+	// as read by url.Values, Express and most Go servers. It is the default.
+	ArrayRepeat ArrayNaming = func(key string, _ int) string { return key }
+	// ArrayBrackets appends empty brackets ("tag[]", "tag[]"), the
+	// convention of PHP and Rails.
+	ArrayBrackets ArrayNaming = func(key string, _ int) string { return key + "[]" }
+	// ArrayIndexed appends the index in brackets ("tag[0]", "tag[1]"), as
+	// bound by ASP.NET and Spring and accepted by PHP.
+	ArrayIndexed ArrayNaming = func(key string, i int) string { return key + "[" + strconv.Itoa(i) + "]" }
+)
+
+// ArrayNaming sets how ParamSlice names array values. It applies to the
+// ParamSlice calls that follow it.
+func (r *Multipart) ArrayNaming(naming ArrayNaming) *Multipart {
+	r.arrayNaming = naming
+	return r
+}
+
+// ParamSlice adds one field per value, named by the ArrayNaming strategy.
+func (r *Multipart) ParamSlice(key string, values []string) *Multipart {
+	naming := r.arrayNaming
+	if naming == nil {
+		naming = ArrayRepeat
+	}
+	for i, v := range values {
+		r.Param(naming(key, i), v)
+	}
+	return r
+}
+
+// Params adds every value of values as a field under its key, repeating
+// keys that have several values as url.Values does. Keys are sent in sorted
+// order so the body is reproducible.
+func (r *Multipart) Params(values url.Values) *Multipart {
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		for _, v := range values[key] {
+			r.Param(key, v)
+		}
+	}
+	return r
+}
+
+// ParamsFromMap adds one field per entry of m, in sorted key order so the
+// body is reproducible.
+func (r *Multipart) ParamsFromMap(m map[string]string) *Multipart {
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		r.Param(key, m[key])
+	}
+	return r
+}
+
+// ParamsFromValues is Params under the name that pairs with ParamsFromMap,
+// for code converting from net/http form types.
+func (r *Multipart) ParamsFromValues(values url.Values) *Multipart {
+	return r.Params(values)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+var writerType = reflect.TypeFor[io.Writer]()
+
+// ParseInto reads a multipart response, such as the reply of a batch API,
+// into the struct dst points to, and closes the body. Each part is matched
+// to the field tagged `part:"name"` (or named name when untagged) by its
+// Content-ID without angle brackets, or else by its form name; unmatched
+// parts are skipped.
+//
+// A field that is a non-nil io.Writer, such as an *os.File, receives the
+// part streamed into it, so large files are never held in memory. string
+// and []byte fields get the raw content. Any other field is decoded by the
+// codec registered for the part's Content-Type, which must implement
+// Decoder.
+func ParseInto(resp *http.Response, dst any) error {
+	defer resp.Body.Close()
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("failed to parse response: %T is not a pointer to a struct", dst)
+	}
+	fields := partFields(rv.Elem())
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("failed to parse response content type: %w", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return fmt.Errorf("failed to parse response: %q is not multipart", mediaType)
+	}
+
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read response part: %w", err)
+		}
+		key := strings.Trim(part.Header.Get("Content-ID"), "<>")
+		fv, ok := fields[key]
+		if !ok || key == "" {
+			key = part.FormName()
+			fv, ok = fields[key]
+		}
+		if ok && key != "" {
+			if err := decodePart(part, fv); err != nil {
+				return fmt.Errorf("failed to decode response part [%q]: %w", key, err)
+			}
+		}
+		part.Close()
+	}
+}
+
+// partFields maps the part names of the exported fields of rv to them.
+func partFields(rv reflect.Value) map[string]reflect.Value {
+	fields := map[string]reflect.Value{}
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		sf := rt.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := sf.Tag.Get("part")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields[name] = rv.Field(i)
+	}
+	return fields
+}
+
+func decodePart(part *multipart.Part, fv reflect.Value) error {
+	if fv.Type().Implements(writerType) && !fv.IsZero() {
+		_, err := io.Copy(fv.Interface().(io.Writer), part)
+		return err
+	}
+	switch {
+	case fv.Kind() == reflect.String:
+		b, err := io.ReadAll(part)
+		fv.SetString(string(b))
+		return err
+	case fv.Type() == bytesType:
+		b, err := io.ReadAll(part)
+		fv.SetBytes(b)
+		return err
+	}
+	contentType := part.Header.Get("Content-Type")
+	codec, ok := LookupCodec(contentType)
+	if !ok {
+		return fmt.Errorf("no codec registered for %q", contentType)
+	}
+	dec, ok := codec.(Decoder)
+	if !ok {
+		return fmt.Errorf("codec for %q cannot decode", contentType)
+	}
+	return dec.Decode(part, fv.Addr().Interface())
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrPartAborted is returned, wrapped with the context's cause, when the
+// context of a FileCtx part ended before its content did.
+var ErrPartAborted = errors.New("multipart: part aborted")
+
+// AbortSuffix is appended to the field name of a part aborted under
+// AbortPart to name the field that records why.
+const AbortSuffix = ".error"
+
+// AbortPolicy says what an aborted FileCtx part does to the request.
+type AbortPolicy int
+
+const (
+	// AbortRequest fails the request, as any failing part does.
+	AbortRequest AbortPolicy = iota
+	// AbortPart ends the part with what was read so far, adds a field
+	// named after it with AbortSuffix holding the error, and goes on with
+	// the next part. Servers must check for that field to tell a truncated
+	// part from a complete one.
+	AbortPart
+)
+
+// OnAbort sets the policy of a FileCtx part; the default is AbortRequest.
+func OnAbort(p AbortPolicy) PartOption {
+	return func(t *TRequest) { t.abort = p }
+}
+
+// FileCtx is File for a source that may stall, such as a network stream:
+// once ctx ends the part stops waiting for content and is aborted per its
+// OnAbort policy, instead of holding up the upload. If content is an
+// io.Closer it is closed to unblock a pending Read; otherwise that Read is
+// left to return on its own.
+func (r *Multipart) FileCtx(ctx context.Context, field, filename string, content io.Reader, opts ...PartOption) *Multipart {
+	t := TRequest{Type: FileType, Key: field, Value: filename}
+	t = t.apply(opts)
+	t.generate = func(w io.Writer) error {
+		return copyCtx(ctx, w, content)
+	}
+	r.send(t)
+	return r
+}
+
+// copyCtx copies content to w until ctx ends. Reads happen in a goroutine
+// feeding a pipe, so that closing the pipe frees the caller of a Read that
+// never returns.
+func copyCtx(ctx context.Context, w io.Writer, content io.Reader) error {
+	pr, pw := io.Pipe()
+	go func() {
+		defer recoverPanic(func(err error) { pw.CloseWithError(err) })
+		_, err := io.Copy(pw, content)
+		pw.CloseWithError(err)
+	}()
+	stop := context.AfterFunc(ctx, func() {
+		pw.CloseWithError(ErrPartAborted)
+		if c, ok := content.(io.Closer); ok {
+			c.Close()
+		}
+	})
+	defer stop()
+	_, err := io.Copy(w, pr)
+	pr.Close() // stop the reading goroutine if w failed
+	if errors.Is(err, ErrPartAborted) {
+		return fmt.Errorf("%w: %w", ErrPartAborted, context.Cause(ctx))
+	}
+	return err
+}
+
+// abortedPart is returned by writeFile for a part aborted under
+// AbortPart, after its marker field was written, so the worker goes on.
+type abortedPart struct {
+	err error
+}
+
+func (e *abortedPart) Error() string { return e.err.Error() }
+
+func (e *abortedPart) Unwrap() error { return e.err }
+
+// abortPart records why b was aborted in its marker field.
+func (r *Multipart) abortPart(b TRequest, cause error) error {
+	if err := r.mw.WriteField(b.Key+AbortSuffix, cause.Error()); err != nil {
+		return fmt.Errorf("failed to write abort marker [%q]: %w", b.Key, err)
+	}
+	return &abortedPart{err: cause}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"net/textproto"
+)
+
+// PartOption configures a single file part.
+type PartOption func(*TRequest)
+
+// base64LineLength is the maximum encoded line length allowed by MIME
+// (RFC 2045, section 6.8).
+const base64LineLength = 76
+
+// Base64 encodes the part content as base64 in CRLF-separated lines of 76
+// characters and sets "Content-Transfer-Encoding: base64", for legacy
+// consumers that cannot take raw binary parts.
+func Base64() PartOption {
+	return func(t *TRequest) {
+		t.setPartHeader("Content-Transfer-Encoding", "base64")
+		t.encoders = append(t.encoders, func(w io.Writer) (io.WriteCloser, error) {
+			return base64.NewEncoder(base64.StdEncoding, &lineWrapper{w: w, width: base64LineLength}), nil
+		})
+	}
+}
+
+// ContentType sets the Content-Type of a file part, which is
+// application/octet-stream by default.
+func ContentType(ct string) PartOption {
+	return func(t *TRequest) { t.setPartHeader("Content-Type", ct) }
+}
+
+func (t *TRequest) setPartHeader(key, value string) {
+	if t.header == nil {
+		t.header = textproto.MIMEHeader{}
+	}
+	t.header.Set(key, value)
+}
+
+func (t *TRequest) apply(opts []PartOption) TRequest {
+	for _, opt := range opts {
+		opt(t)
+	}
+	return *t
+}
+
+// lineWrapper inserts a CRLF after every width bytes written.
+type lineWrapper struct {
+	w     io.Writer
+	width int
+	col   int
+}
+
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if l.col == l.width {
+			if _, err := l.w.Write([]byte("\r\n")); err != nil {
+				return n, err
+			}
+			l.col = 0
+		}
+		c := min(len(p), l.width-l.col)
+		m, err := l.w.Write(p[:c])
+		n += m
+		l.col += m
+		if err != nil {
+			return n, err
+		}
+		p = p[c:]
+	}
+	return n, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+)
+
+// PartSet is an immutable list of predefined parts, such as auth blobs,
+// manifest fields or device metadata, that many requests share. Build it
+// once and attach it to any builder with Include:
+//
+//	common := PartSet{}.
+//		Param("device_id", id).
+//		File("cert", "device.pem", pem)
+//	NewMultipart(ctx, client, http.MethodPost, url).Include(common).File(...)
+//
+// Every method returns a new set and leaves the receiver unchanged, so a
+// set can be extended per fleet and used from many goroutines. The zero
+// value is an empty set.
+type PartSet struct {
+	parts []setPart
+}
+
+type setPart struct {
+	field    string
+	value    string
+	filename string
+	data     []byte
+	path     string
+	file     bool
+	opts     []PartOption
+}
+
+// Param returns a copy of s with a form field appended.
+func (s PartSet) Param(key, value string) PartSet {
+	return s.with(setPart{field: key, value: value})
+}
+
+// File returns a copy of s with a file part holding data appended. data
+// is copied, so the caller may reuse it.
+func (s PartSet) File(field, filename string, data []byte, opts ...PartOption) PartSet {
+	return s.with(setPart{field: field, filename: filename, data: bytes.Clone(data), file: true, opts: opts})
+}
+
+// FileFromPath returns a copy of s with a file part appended that is read
+// from path each time the set is included, when the part is written.
+func (s PartSet) FileFromPath(field, path string, opts ...PartOption) PartSet {
+	return s.with(setPart{field: field, filename: filepath.Base(path), path: path, file: true, opts: opts})
+}
+
+// Len returns the number of parts in s.
+func (s PartSet) Len() int { return len(s.parts) }
+
+func (s PartSet) with(p setPart) PartSet {
+	return PartSet{parts: append(slices.Clip(s.parts), p)}
+}
+
+// Include adds the parts of set, in order, at this point of the body.
+func (r *Multipart) Include(set PartSet) *Multipart {
+	for _, p := range set.parts {
+		switch {
+		case !p.file:
+			r.Param(p.field, p.value)
+		case p.path != "":
+			t := TRequest{Type: FileType, Key: p.field, Value: p.filename, Path: p.path}
+			r.send(t.apply(p.opts))
+		default:
+			r.File(p.field, p.filename, bytes.NewReader(p.data), p.opts...)
+		}
+	}
+	return r
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/isauran/go-std-library/io/bufpipe"
+	"github.com/isauran/go-std-library/io/ctxpipe"
+)
+
+// BufferedPipe replaces the synchronous io.Pipe feeding the request body
+// with a ring-buffered pipe of size bytes, so the worker can run ahead of a
+// slow network instead of stalling on every write. Watermark options report
+// when the buffer fills up and drains. BufferedPipe must be called before
+// any parts are added.
+func (r *Multipart) BufferedPipe(size int, opts ...bufpipe.Option) *Multipart {
+	pr, pw := bufpipe.New(size, opts...)
+	r.replacePipe(pr, pw)
+	return r
+}
+
+// IdleTimeout fails the request when the transport stops consuming the body
+// for d, e.g. because the server stopped reading, instead of blocking the
+// worker forever. The pipe is also bound to the request context.
+// IdleTimeout must be called before any parts are added and replaces any
+// BufferedPipe.
+func (r *Multipart) IdleTimeout(d time.Duration) *Multipart {
+	pr, pw := ctxpipe.New(r.request.Context())
+	r.replacePipe(pr, &idleWriter{PipeWriter: pw, timeout: d})
+	return r
+}
+
+// idleWriter extends the write deadline before every write.
+type idleWriter struct {
+	*ctxpipe.PipeWriter
+	timeout time.Duration
+}
+
+func (w *idleWriter) Write(p []byte) (int, error) {
+	w.SetWriteDeadline(time.Now().Add(w.timeout))
+	n, err := w.PipeWriter.Write(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = fmt.Errorf("request body idle for %v: %w", w.timeout, err)
+	}
+	return n, err
+}
+
+// replacePipe swaps the pipe between the multipart writer and the request
+// body. Only valid before the request starts.
+func (r *Multipart) replacePipe(pr io.ReadCloser, pw bodyPipe) {
+	r.pr.Close()
+	r.pr, r.pw = pr, pw
+	r.out.w = pw
+	r.request.Body = pr
+}
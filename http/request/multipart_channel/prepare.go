@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/isauran/go-std-library/sync/waitgroup/taskgroup"
+)
+
+// prepareResult is the outcome of a prepared part. It is delivered on the
+// part's own buffered channel so pool goroutines never block on the writer.
+type prepareResult struct {
+	payload []byte
+	err     error
+}
+
+// Workers enables ordered concurrent preparation: payloads submitted with
+// PreparedFile are produced by up to n goroutines of a taskgroup in
+// parallel, while the worker still writes every part to the multipart
+// stream in submission order. A failing payload cancels the preparation
+// of the parts that have not started yet. Workers sets up a Queue of n if
+// there is none. Workers must be called before any parts are added.
+func (r *Multipart) Workers(n int) *Multipart {
+	if n < 1 || r.prep != nil {
+		return r
+	}
+	r.workers = n
+	r.prep, _ = taskgroup.New(r.request.Context(), taskgroup.Limit(n))
+
+	// Submissions wait in a queue of n so callers can run ahead of the
+	// writer while preparation is in flight.
+	r.Queue(n)
+	return r
+}
+
+// prepareTask runs prepare on the pool. A failing prepare cancels the pool,
+// and parts still waiting for a slot are then failed with its error instead
+// of being prepared, as the request fails anyway.
+func prepareTask(prepare func() ([]byte, error), result chan<- prepareResult) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if ctx.Err() != nil {
+			result <- prepareResult{err: context.Cause(ctx)}
+			return nil
+		}
+		res := runPrepare(prepare)
+		result <- res
+		return res.err
+	}
+}
+
+// runPrepare calls prepare, reporting a panic in it as the result's error.
+func runPrepare(prepare func() ([]byte, error)) (res prepareResult) {
+	defer recoverPanic(func(err error) { res = prepareResult{err: err} })
+	payload, err := prepare()
+	return prepareResult{payload: payload, err: err}
+}
+
+// PreparedFile adds a file part whose payload is produced by prepare, e.g.
+// compression, encryption or serialization. With Workers enabled prepare
+// runs on the pool; otherwise it runs in the calling goroutine.
+func (r *Multipart) PreparedFile(key, filename string, prepare func() ([]byte, error), opts ...PartOption) *Multipart {
+	defer r.lockSubmit()()
+	result := make(chan prepareResult, 1)
+	switch {
+	case r.state.Load() == stateClosed:
+		// submit reports the misuse; the pool is already stopped.
+	case r.prep != nil:
+		r.prep.GoErr(prepareTask(prepare, result))
+	default:
+		result <- runPrepare(prepare)
+	}
+	t := TRequest{Type: PreparedType, Key: key, Value: filename, index: r.submitted, result: result}
+	r.submit(t.apply(opts))
+	return r
+}
+
+// writePrepared waits for the indexed result of a prepared part and writes it.
+func (r *Multipart) writePrepared(b TRequest) error {
+	res := <-b.result
+	if res.err != nil {
+		return fmt.Errorf("failed to prepare part #%d [%q]: %w", b.index, b.Key, res.err)
+	}
+	b.Content = bytes.NewReader(res.payload)
+	return r.writeFile(b)
+}
+
+// closePool closes the queue and stops the preparation pool.
+func (r *Multipart) closePool() {
+	if r.window != nil {
+		r.window.close()
+	}
+	if r.prep != nil {
+		// Failures reach the parts through their results.
+		r.prep.Wait()
+	}
+}
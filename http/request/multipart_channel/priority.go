@@ -0,0 +1,106 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// WithPriority lets a part overtake parts of lower priority that are still
+// waiting in the queue, e.g. so metadata fields a server reads to route or
+// authorize the upload arrive before large files added earlier. Parts of
+// equal priority keep their order; the default priority is 0. A part being
+// written is never interrupted, so priorities only matter for parts that
+// wait, which needs a queue from Queue or Workers.
+func WithPriority(n int) PartOption {
+	return func(t *TRequest) { t.priority = n }
+}
+
+// Queue lets up to n parts wait for the worker, so callers run ahead of
+// the body instead of blocking on every part, and WithPriority can reorder
+// the waiting parts. Queue must be called before any parts are added.
+func (r *Multipart) Queue(n int) *Multipart {
+	if n > 0 && r.window == nil {
+		r.window = newPartQueue(n)
+	}
+	return r
+}
+
+// partQueue is a bounded priority queue of parts, FIFO among equal
+// priorities.
+type partQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  queuedParts
+	size   int
+	seq    int
+	closed bool
+}
+
+func newPartQueue(size int) *partQueue {
+	q := &partQueue{size: size}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push blocks while the queue is full.
+func (q *partQueue) push(t TRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) >= q.size && !q.closed {
+		q.cond.Wait()
+	}
+	heap.Push(&q.items, queuedPart{t: t, seq: q.seq})
+	q.seq++
+	q.cond.Broadcast()
+}
+
+// pop blocks until a part is queued and returns false once the queue is
+// closed and empty.
+func (q *partQueue) pop() (TRequest, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.items) == 0 {
+		return TRequest{}, false
+	}
+	p := heap.Pop(&q.items).(queuedPart)
+	q.cond.Broadcast()
+	return p.t, true
+}
+
+func (q *partQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+type queuedPart struct {
+	t   TRequest
+	seq int
+}
+
+// queuedParts implements heap.Interface.
+type queuedParts []queuedPart
+
+func (h queuedParts) Len() int { return len(h) }
+
+func (h queuedParts) Less(i, j int) bool {
+	if h[i].t.priority != h[j].t.priority {
+		return h[i].t.priority > h[j].t.priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h queuedParts) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *queuedParts) Push(x any) { *h = append(*h, x.(queuedPart)) }
+
+func (h *queuedParts) Pop() any {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
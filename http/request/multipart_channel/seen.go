@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// ContentRefType is the Content-Type of the reference parts SkipUnchanged
+// sends instead of files the server already has. The body of such a part
+// is the content hash, "sha256:<hex>".
+const ContentRefType = "application/x-content-ref"
+
+// SeenStore remembers the hashes of file contents that were uploaded
+// successfully.
+type SeenStore interface {
+	// Seen reports whether content with the hash sum was uploaded before.
+	Seen(sum string) (bool, error)
+	// MarkSeen records hashes of contents that were uploaded.
+	MarkSeen(sums ...string) error
+}
+
+// SkipUnchanged makes file parts whose content store has seen before go
+// out as small reference parts of type ContentRefType, for sync-style
+// uploaders whose server keeps files by content hash. The hashes of the
+// files sent in full are added to store once the server answers with a 2xx
+// status. Hashing reads each file twice, or buffers it in memory when its
+// reader cannot seek. Generated parts such as Template are always sent.
+// SkipUnchanged must be called before any parts are added.
+func (r *Multipart) SkipUnchanged(store SeenStore) *Multipart {
+	r.seen = &seenTracker{store: store}
+	r.afterDo = append(r.afterDo, func(m *Multipart, resp *http.Response, err error) (*http.Response, error) {
+		if err == nil && resp.StatusCode/100 == 2 {
+			if merr := m.seen.commit(); merr != nil {
+				resp.Body.Close()
+				return nil, fmt.Errorf("failed to record uploaded files: %w", merr)
+			}
+		}
+		return resp, err
+	})
+	return r
+}
+
+type seenTracker struct {
+	store SeenStore
+
+	mu      sync.Mutex
+	pending []string // hashes of files sent in full
+}
+
+func (s *seenTracker) commit() error {
+	s.mu.Lock()
+	sums := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(sums) == 0 {
+		return nil
+	}
+	return s.store.MarkSeen(sums...)
+}
+
+// writeContentRef hashes the content of b and writes a reference part if
+// the store has seen it. Otherwise it returns false and b's content is
+// positioned at its start again.
+func (r *Multipart) writeContentRef(b *TRequest) (bool, error) {
+	sum, err := hashContent(b)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash file [%q]: %w", b.Key, err)
+	}
+	seen, err := r.seen.store.Seen(sum)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up file [%q]: %w", b.Key, err)
+	}
+	if !seen {
+		r.seen.mu.Lock()
+		r.seen.pending = append(r.seen.pending, sum)
+		r.seen.mu.Unlock()
+		return false, nil
+	}
+
+	h := fileHeader(*b)
+	h.Set("Content-Type", ContentRefType)
+	h.Del("Content-Transfer-Encoding")
+	part, err := r.mw.CreatePart(h)
+	if err != nil {
+		return false, fmt.Errorf("failed to create form file: %w", err)
+	}
+	_, err = io.WriteString(part, sum)
+	return true, err
+}
+
+// hashContent returns the "sha256:<hex>" hash of b.Content, rewinding it
+// or replacing it with a buffered copy.
+func hashContent(b *TRequest) (string, error) {
+	h := sha256.New()
+	if rs, ok := b.Content.(io.ReadSeeker); ok {
+		start, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(h, rs); err != nil {
+			return "", err
+		}
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return "", err
+		}
+	} else {
+		data, err := io.ReadAll(io.TeeReader(b.Content, h))
+		if err != nil {
+			return "", err
+		}
+		b.Content = bytes.NewReader(data)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// MemorySeenStore is a SeenStore kept in memory, for the lifetime of one
+// process.
+type MemorySeenStore struct {
+	mu   sync.RWMutex
+	sums map[string]struct{}
+}
+
+// NewMemorySeenStore returns an empty MemorySeenStore.
+func NewMemorySeenStore() *MemorySeenStore {
+	return &MemorySeenStore{sums: map[string]struct{}{}}
+}
+
+func (m *MemorySeenStore) Seen(sum string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.sums[sum]
+	return ok, nil
+}
+
+func (m *MemorySeenStore) MarkSeen(sums ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, sum := range sums {
+		m.sums[sum] = struct{}{}
+	}
+	return nil
+}
+
+// FileSeenStore is a SeenStore persisted as an append-only file with one
+// hash per line, loaded into memory when opened.
+type FileSeenStore struct {
+	mem MemorySeenStore
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenFileSeenStore opens or creates the store file at path.
+func OpenFileSeenStore(path string) (*FileSeenStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open seen store: %w", err)
+	}
+	s := &FileSeenStore{mem: MemorySeenStore{sums: map[string]struct{}{}}, f: f}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" {
+			s.mem.sums[line] = struct{}{}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read seen store: %w", err)
+	}
+	return s, nil
+}
+
+func (s *FileSeenStore) Seen(sum string) (bool, error) {
+	return s.mem.Seen(sum)
+}
+
+// MarkSeen appends the hashes not yet in the store and syncs the file.
+func (s *FileSeenStore) MarkSeen(sums ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var buf bytes.Buffer
+	for _, sum := range sums {
+		if ok, _ := s.mem.Seen(sum); !ok {
+			buf.WriteString(sum + "\n")
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	if _, err := s.f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	return s.mem.MarkSeen(sums...)
+}
+
+// Close closes the store file.
+func (s *FileSeenStore) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+
+	"github.com/isauran/go-std-library/http/request/ratelimit"
+)
+
+// TokenSource returns the current auth token, e.g. from a cache that
+// refreshes it before it expires. It is called for every request.
+type TokenSource func(ctx context.Context) (string, error)
+
+// SessionStats are the request counts of a session.
+type SessionStats struct {
+	Requests int         // requests handed to the transport
+	Errors   int         // requests that got no response
+	Statuses map[int]int // responses by status code
+}
+
+// Session holds what many uploads to the same API have in common: the base
+// URL, headers, an auth token, cookies, a rate limiter and status checks.
+// Builders made with NewBuilder inherit all of it, and the session counts
+// their requests. Settings changed later apply to builders made after the
+// change. A Session is safe for concurrent use.
+type Session struct {
+	client *TrackedClient
+	base   *url.URL
+
+	mu      sync.Mutex
+	header  http.Header
+	token   TokenSource
+	limiter *ratelimit.Limiter
+	expect  []int
+	stats   SessionStats
+}
+
+// NewSession returns a session sending to paths under baseURL with a copy
+// of client. The copy gets a cookie jar unless client has one, so cookies
+// set by the API are sent back on later requests, and tracks connection
+// reuse as with NewTrackedClient. A nil client means http.DefaultClient.
+func NewSession(client *http.Client, baseURL string) (*Session, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse base URL: %w", err)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	copied := *client
+	if copied.Jar == nil {
+		copied.Jar, _ = cookiejar.New(nil) // never fails without options
+	}
+	s := &Session{base: base, header: http.Header{}, stats: SessionStats{Statuses: map[int]int{}}}
+	s.client = NewTrackedClient(&copied)
+	s.client.Transport = &sessionTransport{base: s.client.Transport, s: s}
+	return s, nil
+}
+
+// Header sets a header on every request of the session.
+func (s *Session) Header(key, value string) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header.Set(key, value)
+	return s
+}
+
+// Token sends "Authorization: Bearer <token>" with every request to the
+// host of the base URL, asking src for the token each time so a refreshed
+// one is picked up. Requests to other hosts, such as presigned upload URLs
+// returned by the API, go without it. A failing src fails the request.
+func (s *Session) Token(src TokenSource) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = src
+	return s
+}
+
+// RateLimit sends every request of the session under l, as the builder's
+// RateLimit does.
+func (s *Session) RateLimit(l *ratelimit.Limiter) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limiter = l
+	return s
+}
+
+// ExpectStatus makes Send of every builder fail on other statuses, as the
+// builder's ExpectStatus does.
+func (s *Session) ExpectStatus(codes ...int) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expect = append([]int{}, codes...) // non-nil even without codes
+	return s
+}
+
+// NewBuilder returns a builder POSTing to path, resolved against the base
+// URL, with the settings of the session.
+func (s *Session) NewBuilder(path string) *Multipart {
+	return s.NewRequest(context.Background(), http.MethodPost, path)
+}
+
+// NewRequest is NewBuilder with a context and method.
+func (s *Session) NewRequest(ctx context.Context, method, path string) *Multipart {
+	target, err := s.resolve(path)
+	if err != nil {
+		target = s.base.String()
+	}
+	r := NewMultipart(ctx, s.client.Client, method, target)
+	if err != nil {
+		r.pw.CloseWithError(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, vs := range s.header {
+		r.request.Header[k] = append([]string{}, vs...)
+	}
+	if s.limiter != nil {
+		r.RateLimit(s.limiter)
+	}
+	if s.expect != nil {
+		r.ExpectStatus(s.expect...)
+	}
+	return r
+}
+
+// resolve returns the URL of path under the base URL.
+func (s *Session) resolve(path string) (string, error) {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse path %q: %w", path, err)
+	}
+	return s.base.ResolveReference(ref).String(), nil
+}
+
+// Stats returns a snapshot of the request counts so far.
+func (s *Session) Stats() SessionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Statuses = maps.Clone(s.stats.Statuses)
+	return stats
+}
+
+// ClientStats returns the connection counts of the session's client.
+func (s *Session) ClientStats() ClientStats {
+	return s.client.ClientStats()
+}
+
+// sessionTransport adds the session token and counts requests.
+type sessionTransport struct {
+	base http.RoundTripper
+	s    *Session
+}
+
+func (t *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.s.mu.Lock()
+	token := t.s.token
+	t.s.mu.Unlock()
+	if token != nil && req.URL.Host == t.s.base.Host {
+		tok, err := token(req.Context())
+		if err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			t.s.record(nil)
+			return nil, fmt.Errorf("failed to get session token: %w", err)
+		}
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	resp, err := t.base.RoundTrip(req)
+	t.s.record(resp)
+	return resp, err
+}
+
+// record counts a request and its response, nil if it got none.
+func (s *Session) record(resp *http.Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Requests++
+	if resp == nil {
+		s.stats.Errors++
+		return
+	}
+	s.stats.Statuses[resp.StatusCode]++
+}
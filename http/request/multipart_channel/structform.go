@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	readerType        = reflect.TypeFor[io.Reader]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+	bytesType         = reflect.TypeFor[[]byte]()
+)
+
+// Struct adds the fields of the struct v (or pointer to one) as parts,
+// in field order:
+//
+//	Name   string    `form:"name"`             // field "name"
+//	Tags   []string  `form:"tag,omitempty"`    // one field per value, see ArrayNaming
+//	Addr   Address   `form:"addr"`             // nested: "addr.city", "addr.zip"
+//	Avatar io.Reader `file:"avatar,me.png"`    // file part "avatar" named me.png
+//	Doc    *os.File  `file:"doc"`              // filename from the file
+//	Raw    []byte    `file:"raw,omitempty"`    // file part, skipped when empty
+//
+// As with encoding/json, untagged exported fields use the Go field name,
+// a "-" tag skips the field, embedded structs of exported types without a
+// tag are flattened and nil pointers are skipped. Scalars are formatted with
+// strconv and encoding.TextMarshaler types such as time.Time with
+// MarshalText; a []byte is sent as a string unless tagged as a file.
+// A field of any other type fails the request without adding any part.
+func (r *Multipart) Struct(v any) *Multipart {
+	var parts []func()
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		r.pw.CloseWithError(fmt.Errorf("failed to encode struct: %T is not a struct", v))
+		return r
+	}
+	if err := r.collectStruct(rv, "", &parts); err != nil {
+		r.pw.CloseWithError(fmt.Errorf("failed to encode struct %T: %w", v, err))
+		return r
+	}
+	for _, add := range parts {
+		add()
+	}
+	return r
+}
+
+// collectStruct appends the part adders of the fields of rv, with field
+// names prefixed by prefix.
+func (r *Multipart) collectStruct(rv reflect.Value, prefix string, parts *[]func()) error {
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		sf := rt.Field(i)
+		fv := rv.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		if tag, ok := sf.Tag.Lookup("file"); ok {
+			if err := r.collectFile(fv, sf, prefix, tag, parts); err != nil {
+				return err
+			}
+			continue
+		}
+
+		tag, tagged := sf.Tag.Lookup("form")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		omitEmpty := opts == "omitempty"
+		if omitEmpty && fv.IsZero() {
+			continue
+		}
+		for fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				break
+			}
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Pointer {
+			continue // nil
+		}
+		if sf.Anonymous && !tagged && fv.Kind() == reflect.Struct {
+			if err := r.collectStruct(fv, prefix, parts); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		name = prefix + name
+
+		if s, ok, err := formatScalar(fv); err != nil {
+			return fmt.Errorf("field %s: %w", sf.Name, err)
+		} else if ok {
+			*parts = append(*parts, func() { r.Param(name, s) })
+			continue
+		}
+		switch fv.Kind() {
+		case reflect.Struct:
+			if err := r.collectStruct(fv, name+".", parts); err != nil {
+				return err
+			}
+		case reflect.Slice, reflect.Array:
+			values := make([]string, fv.Len())
+			for j := range values {
+				s, ok, err := formatScalar(fv.Index(j))
+				if err != nil {
+					return fmt.Errorf("field %s[%d]: %w", sf.Name, j, err)
+				}
+				if !ok {
+					return fmt.Errorf("field %s: unsupported element type %s", sf.Name, fv.Type().Elem())
+				}
+				values[j] = s
+			}
+			*parts = append(*parts, func() { r.ParamSlice(name, values) })
+		default:
+			return fmt.Errorf("field %s: unsupported type %s", sf.Name, fv.Type())
+		}
+	}
+	return nil
+}
+
+// collectFile appends the file part of a field tagged `file:"name[,filename][,omitempty]"`.
+func (r *Multipart) collectFile(fv reflect.Value, sf reflect.StructField, prefix, tag string, parts *[]func()) error {
+	if tag == "-" {
+		return nil
+	}
+	opts := strings.Split(tag, ",")
+	name, filename, omitEmpty := opts[0], "", false
+	for _, opt := range opts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		} else {
+			filename = opt
+		}
+	}
+	if name == "" {
+		name = sf.Name
+	}
+	name = prefix + name
+	if fv.IsZero() && (omitEmpty || fv.Kind() == reflect.Pointer || fv.Kind() == reflect.Interface) {
+		return nil
+	}
+
+	var content io.Reader
+	switch {
+	case fv.Type() == bytesType:
+		content = bytes.NewReader(fv.Bytes())
+	case fv.Type().Implements(readerType):
+		content = fv.Interface().(io.Reader)
+		if f, ok := content.(*os.File); ok && filename == "" {
+			filename = filepath.Base(f.Name())
+		}
+	default:
+		return fmt.Errorf("field %s: file part of unsupported type %s", sf.Name, fv.Type())
+	}
+	if filename == "" {
+		filename = name
+	}
+	*parts = append(*parts, func() { r.File(name, filename, content) })
+	return nil
+}
+
+// formatScalar formats a value that maps to a single field. ok is false
+// for kinds that are not scalars.
+func formatScalar(v reflect.Value) (s string, ok bool, err error) {
+	if v.Type() == bytesType {
+		return string(v.Bytes()), true, nil
+	}
+	if v.Type().Implements(textMarshalerType) {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return "", true, nil
+		}
+		b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b), true, err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true, nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), true, nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), true, nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), true, nil
+	}
+	return "", false, nil
+}
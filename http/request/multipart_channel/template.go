@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"path/filepath"
+)
+
+// templateExecutor is implemented by both *text/template.Template and
+// *html/template.Template.
+type templateExecutor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// Template adds a file part produced by executing tmpl with data straight
+// into the part, so generated reports and pages are never built in memory.
+// tmpl may be a text/template or an html/template. The Content-Type is
+// guessed from the filename extension. An execution error fails the
+// request, possibly after part of the output was sent.
+func (r *Multipart) Template(field, filename string, tmpl templateExecutor, data any, opts ...PartOption) *Multipart {
+	t := TRequest{Type: FileType, Key: field, Value: filename, generate: func(w io.Writer) error {
+		return tmpl.Execute(w, data)
+	}}
+	if ct := mime.TypeByExtension(filepath.Ext(filename)); ct != "" {
+		t.setPartHeader("Content-Type", ct)
+	}
+	r.send(t.apply(opts))
+	return r
+}
+
+// FileFunc adds a file part written by fn straight into the part from the
+// worker, for libraries that can only write themselves to an io.Writer,
+// such as image encoders or PDF generators. The Content-Type is guessed
+// from the filename extension. An error returned by fn fails the request,
+// possibly after part of the output was sent.
+func (r *Multipart) FileFunc(field, filename string, fn func(w io.Writer) error, opts ...PartOption) *Multipart {
+	t := TRequest{Type: FileType, Key: field, Value: filename, generate: fn}
+	if ct := mime.TypeByExtension(filepath.Ext(filename)); ct != "" {
+		t.setPartHeader("Content-Type", ct)
+	}
+	r.send(t.apply(opts))
+	return r
+}
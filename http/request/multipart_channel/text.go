@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+)
+
+// TextPart adds a text field encoded as quoted-printable, with the charset
+// in its Content-Type, for mail-oriented multipart consumers. An empty
+// charset means utf-8. The value is converted to utf-8, us-ascii or
+// iso-8859-1; other charsets, or characters the charset cannot represent,
+// fail the request.
+func (r *Multipart) TextPart(field, value, charset string) *Multipart {
+	if charset == "" {
+		charset = "utf-8"
+	}
+	r.send(TRequest{Type: TextType, Key: field, Value: value, header: textproto.MIMEHeader{
+		"Content-Type": {mime.FormatMediaType("text/plain", map[string]string{"charset": charset})},
+	}})
+	return r
+}
+
+func (r *Multipart) writeText(b TRequest) error {
+	_, params, err := mime.ParseMediaType(b.header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("failed to write text part [%q]: %w", b.Key, err)
+	}
+	encoded, err := encodeCharset(b.Value, params["charset"])
+	if err != nil {
+		return fmt.Errorf("failed to write text part [%q]: %w", b.Key, err)
+	}
+
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(b.Key)))
+	h.Set("Content-Type", b.header.Get("Content-Type"))
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	part, err := r.mw.CreatePart(h)
+	if err != nil {
+		return fmt.Errorf("failed to create text part [%q]: %w", b.Key, err)
+	}
+	qp := quotedprintable.NewWriter(r.scanPart(part))
+	if _, err := qp.Write(encoded); err != nil {
+		return fmt.Errorf("failed to write text part [%q]: %w", b.Key, err)
+	}
+	return qp.Close()
+}
+
+// encodeCharset converts a Go (utf-8) string to the bytes of charset.
+func encodeCharset(s, charset string) ([]byte, error) {
+	var limit rune
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8":
+		return []byte(s), nil
+	case "us-ascii", "ascii":
+		limit = utf8.RuneSelf - 1
+	case "iso-8859-1", "latin1":
+		limit = 0xff
+	default:
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	out := make([]byte, 0, len(s))
+	for i, c := range s {
+		if c > limit || c == utf8.RuneError {
+			return nil, fmt.Errorf("character %q at byte %d not representable in %s", c, i, charset)
+		}
+		out = append(out, byte(c))
+	}
+	return out, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+
+	"github.com/isauran/go-std-library/http/request/pipeline"
+)
+
+// FileThrough adds a file part whose content passes through stages on its
+// way into the body, in the order given, e.g. compressed, then encrypted,
+// then hashed:
+//
+//	sum := sha256.New()
+//	r.FileThrough("backup", "db.gz.enc", f,
+//		pipeline.Gzip(gzip.BestSpeed), pipeline.Encrypt(key), pipeline.Hash(sum))
+//
+// The stages run while the part is written, without buffering it, and
+// their metrics are complete once Send returns. A stage that fails fails
+// the part.
+func (r *Multipart) FileThrough(field, filename string, content io.Reader, stages ...*pipeline.Stage) *Multipart {
+	t := TRequest{Type: FileType, Key: field, Value: filename, Content: content}
+	for _, s := range stages {
+		t.encoders = append(t.encoders, s.Wrap)
+	}
+	r.send(t)
+	return r
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// messageConn is a connection carrying whole messages, either over
+// WebSocket or over raw TCP with length-prefixed frames. Text messages hold
+// JSON control messages, binary ones file chunks.
+type messageConn interface {
+	ReadMessage() (text bool, data []byte, err error)
+	WriteMessage(text bool, data []byte) error
+	Close() error
+}
+
+// errMessageTooLarge is returned when a peer sends a message larger than
+// the connection's limit.
+var errMessageTooLarge = errors.New("message too large")
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// wsGUID is the key suffix of RFC 6455, section 1.3.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsAccept computes Sec-WebSocket-Accept for a Sec-WebSocket-Key.
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// wsUpgrade performs the server side of the WebSocket opening handshake
+// and takes over the connection. It supports just enough of RFC 6455 for
+// the bridge: no extensions and no subprotocols.
+func wsUpgrade(w http.ResponseWriter, r *http.Request, limit int) (*wsConn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: not an upgrade request")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "bad websocket handshake", http.StatusBadRequest)
+		return nil, errors.New("websocket: bad handshake")
+	}
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, fmt.Errorf("websocket: %w", err)
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: %w", err)
+	}
+	return &wsConn{conn: conn, r: rw.Reader, w: rw.Writer, limit: limit, server: true}, nil
+}
+
+func headerContains(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is a WebSocket connection. server selects which side masks its
+// frames: clients must, servers must not.
+type wsConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	mu     sync.Mutex // serializes frame writes, e.g. pongs and replies
+	w      *bufio.Writer
+	limit  int
+	server bool
+}
+
+// ReadMessage reads the next data message, reassembling fragments and
+// answering pings along the way. A close frame is answered and reported as
+// io.EOF.
+func (c *wsConn) ReadMessage() (bool, []byte, error) {
+	var (
+		msg    []byte
+		opcode byte
+	)
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return false, nil, err
+		}
+		switch op {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return false, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.writeFrame(wsClose, payload[:min(len(payload), 2)])
+			return false, nil, io.EOF
+		case wsText, wsBinary:
+			if opcode != 0 {
+				return false, nil, errors.New("websocket: new message inside a fragmented one")
+			}
+			opcode = op
+		case wsContinuation:
+			if opcode == 0 {
+				return false, nil, errors.New("websocket: continuation without a message")
+			}
+		default:
+			return false, nil, fmt.Errorf("websocket: unknown opcode %#x", op)
+		}
+		if len(msg)+len(payload) > c.limit {
+			return false, nil, errMessageTooLarge
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return opcode == wsText, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = head[0]&0x80 != 0, head[0]&0x0f
+	masked := head[1]&0x80 != 0
+	if masked != c.server {
+		return false, 0, nil, errors.New("websocket: wrong frame masking")
+	}
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > uint64(c.limit) {
+		return false, 0, nil, errMessageTooLarge
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *wsConn) WriteMessage(text bool, data []byte) error {
+	if text {
+		return c.writeFrame(wsText, data)
+	}
+	return c.writeFrame(wsBinary, data)
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	head := []byte{0x80 | opcode, 0}
+	var maskBit byte
+	if !c.server {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		head[1] = maskBit | byte(n)
+	case n <= 0xffff:
+		head[1] = maskBit | 126
+		head = binary.BigEndian.AppendUint16(head, uint16(n))
+	default:
+		head[1] = maskBit | 127
+		head = binary.BigEndian.AppendUint64(head, uint64(n))
+	}
+	if !c.server {
+		var mask [4]byte
+		rand.Read(mask[:])
+		head = append(head, mask[:]...)
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ mask[i%4]
+		}
+		payload = masked
+	}
+	c.w.Write(head)
+	c.w.Write(payload)
+	return c.w.Flush()
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+// tcpConn carries messages over a raw stream: a type byte (1 for text, 2
+// for binary, the WebSocket opcodes) and a 4-byte big-endian length before
+// every payload.
+type tcpConn struct {
+	conn  io.ReadWriteCloser
+	r     *bufio.Reader
+	mu    sync.Mutex
+	limit int
+}
+
+func newTCPConn(conn io.ReadWriteCloser, limit int) *tcpConn {
+	return &tcpConn{conn: conn, r: bufio.NewReader(conn), limit: limit}
+}
+
+func (c *tcpConn) ReadMessage() (bool, []byte, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return false, nil, err
+	}
+	if head[0] != wsText && head[0] != wsBinary {
+		return false, nil, fmt.Errorf("unknown message type %d", head[0])
+	}
+	n := binary.BigEndian.Uint32(head[1:])
+	if uint64(n) > uint64(c.limit) {
+		return false, nil, errMessageTooLarge
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return false, nil, err
+	}
+	return head[0] == wsText, data, nil
+}
+
+func (c *tcpConn) WriteMessage(text bool, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	head := [5]byte{wsBinary}
+	if text {
+		head[0] = wsText
+	}
+	binary.BigEndian.PutUint32(head[1:], uint32(len(data)))
+	if _, err := c.conn.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(data)
+	return err
+}
+
+func (c *tcpConn) Close() error {
+	return c.conn.Close()
+}
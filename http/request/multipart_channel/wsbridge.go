@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+
+	"github.com/isauran/go-std-library/http/request/httpx"
+)
+
+// DefaultBridgeChunkSize is the largest chunk a Bridge accepts by default.
+const DefaultBridgeChunkSize = 1 << 20
+
+// Bridge relays files sent in chunks over a WebSocket or raw TCP connection
+// into multipart uploads made with the builder, e.g. from a browser through
+// a backend to object storage, without holding the file anywhere.
+//
+// The client drives one upload per connection with text (JSON) and binary
+// messages:
+//
+//	→ {"filename": "a.bin", "fields": {"k": "v"}}   start; fields are sent first
+//	→ <binary chunk>                               any number of times
+//	← {"ack": 1, "bytes": 65536}                   after each chunk
+//	→ {"end": true}
+//	← {"done": true, "status": 201}                or {"error": "..."}
+//
+// A chunk is acknowledged only once the upload has taken it, so a client
+// that waits for acknowledgements before running too far ahead gets flow
+// control from the upstream server all the way back to the browser.
+type Bridge struct {
+	client   *http.Client
+	method   string
+	url      string
+	field    string
+	header   http.Header
+	maxChunk int
+}
+
+// NewBridge returns a bridge uploading to url with client.
+func NewBridge(client *http.Client, method, url string) *Bridge {
+	return &Bridge{
+		client:   client,
+		method:   method,
+		url:      url,
+		field:    "file",
+		header:   http.Header{},
+		maxChunk: DefaultBridgeChunkSize,
+	}
+}
+
+// Field sets the form field name of the file part ("file" by default).
+func (b *Bridge) Field(name string) *Bridge {
+	b.field = name
+	return b
+}
+
+// Header sets a header on every upload request, e.g. for the storage
+// backend's credentials.
+func (b *Bridge) Header(key, value string) *Bridge {
+	b.header.Set(key, value)
+	return b
+}
+
+// MaxChunk limits the size of a single chunk message. Larger chunks fail the
+// upload.
+func (b *Bridge) MaxChunk(n int) *Bridge {
+	b.maxChunk = n
+	return b
+}
+
+// ServeHTTP upgrades the request to a WebSocket and relays one upload.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrade(w, r, b.maxChunk)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	b.relay(r.Context(), conn)
+}
+
+// ServeConn relays one upload over a raw stream connection framed as
+// described at tcpConn, and closes it.
+func (b *Bridge) ServeConn(ctx context.Context, conn net.Conn) error {
+	mc := newTCPConn(conn, b.maxChunk)
+	defer mc.Close()
+	return b.relay(ctx, mc)
+}
+
+// bridgeMessage is the union of the bridge's control messages.
+type bridgeMessage struct {
+	Filename string            `json:"filename,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	End      bool              `json:"end,omitempty"`
+
+	Ack    int    `json:"ack,omitempty"`
+	Bytes  int64  `json:"bytes,omitempty"`
+	Done   bool   `json:"done,omitempty"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type uploadResult struct {
+	resp *httpx.Response
+	err  error
+}
+
+// relay runs the protocol on one connection. Errors are reported to the
+// client as well as returned.
+func (b *Bridge) relay(ctx context.Context, mc messageConn) (err error) {
+	defer func() {
+		if err != nil {
+			reply(mc, bridgeMessage{Error: err.Error()})
+		}
+	}()
+
+	start, err := readControl(mc)
+	if err != nil {
+		return err
+	}
+	if start.Filename == "" {
+		return errors.New("bridge: start message without filename")
+	}
+
+	pr, pw := io.Pipe()
+	m := NewMultipart(ctx, b.client, b.method, b.url)
+	for k := range b.header {
+		m.Header(k, b.header.Get(k))
+	}
+	for _, k := range slices.Sorted(maps.Keys(start.Fields)) {
+		m.Param(k, start.Fields[k])
+	}
+	m.File(b.field, start.Filename, pr)
+	done := make(chan uploadResult, 1)
+	go func() {
+		resp, err := m.Send()
+		// Unblock the relay if the upload ended before the file did.
+		if err != nil {
+			pr.CloseWithError(fmt.Errorf("bridge: upload failed: %w", err))
+		} else {
+			resp.Body.Close()
+			pr.CloseWithError(fmt.Errorf("bridge: upload ended with %s", resp.Status))
+		}
+		done <- uploadResult{resp, err}
+	}()
+
+	if err := b.copyChunks(mc, pw); err != nil {
+		pw.CloseWithError(err)
+		<-done
+		return err
+	}
+	pw.Close()
+	res := <-done
+	if res.err != nil {
+		return res.err
+	}
+	return reply(mc, bridgeMessage{Done: true, Status: res.resp.StatusCode})
+}
+
+// copyChunks writes chunks into the file part until the end message,
+// acknowledging each one after the upload has consumed it.
+func (b *Bridge) copyChunks(mc messageConn, pw io.Writer) error {
+	var total int64
+	for seq := 1; ; {
+		text, data, err := mc.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("bridge: reading chunk %d: %w", seq, err)
+		}
+		if text {
+			var msg bridgeMessage
+			if err := json.Unmarshal(data, &msg); err != nil || !msg.End {
+				return fmt.Errorf("bridge: unexpected control message %q", data)
+			}
+			return nil
+		}
+		n, err := pw.Write(data)
+		total += int64(n)
+		if err != nil {
+			return err
+		}
+		if err := reply(mc, bridgeMessage{Ack: seq, Bytes: total}); err != nil {
+			return err
+		}
+		seq++
+	}
+}
+
+func readControl(mc messageConn) (bridgeMessage, error) {
+	var msg bridgeMessage
+	text, data, err := mc.ReadMessage()
+	if err != nil {
+		return msg, fmt.Errorf("bridge: reading start message: %w", err)
+	}
+	if !text {
+		return msg, errors.New("bridge: expected a start message, got a chunk")
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, fmt.Errorf("bridge: bad start message: %w", err)
+	}
+	return msg, nil
+}
+
+func reply(mc messageConn, msg bridgeMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return mc.WriteMessage(true, data)
+}
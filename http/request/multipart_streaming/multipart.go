@@ -1,28 +1,41 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"strings"
+
+	"github.com/isauran/go-std-library/http/request/httpx"
+	"github.com/isauran/go-std-library/http/server/httpbinlite"
 )
 
 func main() {
 	fmt.Println("=== Streaming Multipart HTTP Request Demo ===")
 	fmt.Println()
 
+	// A local httpbin stand-in keeps the demo runnable offline
+	httpbin, err := httpbinlite.Start(context.Background())
+	if err != nil {
+		fmt.Printf("Error starting local httpbin: %v\n", err)
+		return
+	}
+	defer httpbin.Shutdown()
+	postURL := httpbin.URL() + "/post"
+
 	// Example of streaming multipart for large files
-	streamingMultipartExample()
+	streamingMultipartExample(postURL)
 }
 
 // streamingMultipartExample demonstrates using io.Pipe for streaming multipart
-func streamingMultipartExample() {
+func streamingMultipartExample(url string) {
 	// Create pipe for streaming
 	pr, pw := io.Pipe()
 
 	// Create HTTP request with reader part of pipe
-	req, err := http.NewRequest("POST", "https://httpbin.org/post", pr)
+	req, err := http.NewRequest("POST", url, pr)
 	if err != nil {
 		fmt.Printf("Error creating request: %v\n", err)
 		return
@@ -80,18 +93,17 @@ func streamingMultipartExample() {
 	}()
 
 	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	client := httpx.NewClient()
+	resp, err := httpx.Wrap(client.Do(req))
 	if err != nil {
 		fmt.Printf("Error sending request: %v\n", err)
 		return
 	}
-	defer resp.Body.Close()
 
 	fmt.Printf("Response status: %s\n", resp.Status)
 
 	// Read response
-	body, err := io.ReadAll(resp.Body)
+	body, err := resp.Bytes(0)
 	if err != nil {
 		fmt.Printf("Error reading response: %v\n", err)
 		return
@@ -0,0 +1,359 @@
+// Package multipartcheck scans multipart streams for structural problems.
+//
+// It automates the manual "boundary counting" done in the concurrent_error
+// demos: instead of counting "--" markers by hand, Validate walks the stream
+// line by line and reports every place where it stops being well-formed.
+//
+// The checks are heuristics for corrupted streams, not a full RFC 2046
+// parser: a file part whose content happens to contain a
+// "Content-Disposition: form-data" line is reported as InterleavedHeaders.
+package multipartcheck
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+)
+
+// Kind classifies a structural problem.
+type Kind string
+
+const (
+	// MissingFinalBoundary means the stream ended without "--boundary--".
+	MissingFinalBoundary Kind = "missing-final-boundary"
+	// NoParts means no boundary line was found at all.
+	NoParts Kind = "no-parts"
+	// MalformedBoundary means a boundary line has trailing garbage.
+	MalformedBoundary Kind = "malformed-boundary"
+	// MisplacedBoundary means the boundary appears in the middle of a line.
+	MisplacedBoundary Kind = "misplaced-boundary"
+	// BareLF means a structural line ends in "\n" instead of "\r\n".
+	BareLF Kind = "bare-lf"
+	// MalformedHeader means a header line is not "Name: value".
+	MalformedHeader Kind = "malformed-header"
+	// UnterminatedHeaders means a boundary arrived before the blank line ending the headers.
+	UnterminatedHeaders Kind = "unterminated-headers"
+	// InterleavedHeaders means a part header line showed up inside another part's body.
+	InterleavedHeaders Kind = "interleaved-headers"
+	// DuplicateDisposition means a part carries more than one Content-Disposition.
+	DuplicateDisposition Kind = "duplicate-content-disposition"
+	// MissingDisposition means a part carries no Content-Disposition.
+	MissingDisposition Kind = "missing-content-disposition"
+	// DataAfterClose means non-empty data follows the final boundary.
+	DataAfterClose Kind = "data-after-close"
+)
+
+// Issue is a single problem found in the stream.
+type Issue struct {
+	Kind   Kind
+	Part   int   // 1-based part number, 0 if outside any part
+	Line   int   // 1-based line number
+	Offset int64 // byte offset of the start of the line
+	Detail string
+}
+
+func (i Issue) String() string {
+	s := fmt.Sprintf("line %d (offset %d)", i.Line, i.Offset)
+	if i.Part > 0 {
+		s += fmt.Sprintf(", part %d", i.Part)
+	}
+	return s + ": " + string(i.Kind) + ": " + i.Detail
+}
+
+// Report summarizes a validated stream.
+type Report struct {
+	Parts  int   // number of parts opened by a boundary line
+	Bytes  int64 // total bytes read
+	Closed bool  // whether the final boundary was seen
+	Issues []Issue
+}
+
+// Valid reports whether no issues were found.
+func (r Report) Valid() bool {
+	return len(r.Issues) == 0
+}
+
+// Has reports whether at least one issue of the given kind was found.
+func (r Report) Has(k Kind) bool {
+	for _, i := range r.Issues {
+		if i.Kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d parts, %d bytes, closed=%t, %d issues", r.Parts, r.Bytes, r.Closed, len(r.Issues))
+	for _, i := range r.Issues {
+		b.WriteString("\n  ")
+		b.WriteString(i.String())
+	}
+	return b.String()
+}
+
+type state int
+
+const (
+	preamble state = iota
+	headers
+	body
+	epilogue
+)
+
+// maxLine bounds how much of a single line is buffered; longer body lines
+// are scanned in chunks.
+const maxLine = 64 << 10
+
+// Validate reads r to the end and reports structural problems of the
+// multipart stream delimited by boundary. The returned error is non-nil only
+// when reading fails; malformed input is described by the Report.
+func Validate(r io.Reader, boundary string) (Report, error) {
+	if boundary == "" {
+		return Report{}, errors.New("multipartcheck: empty boundary")
+	}
+	v := &validator{
+		delim: []byte("--" + boundary),
+		br:    bufio.NewReaderSize(r, maxLine),
+	}
+	err := v.run()
+	return v.report, err
+}
+
+type validator struct {
+	delim  []byte
+	br     *bufio.Reader
+	report Report
+
+	state       state
+	line        int
+	offset      int64
+	prevCRLF    bool // whether the previous body line ended in CRLF
+	disposition int  // Content-Disposition headers in the current part
+	sawHeader   bool // whether the current part had a header line yet
+	trailing    bool // whether DataAfterClose was already reported
+}
+
+func (v *validator) issue(k Kind, format string, args ...any) {
+	part := v.report.Parts
+	if v.state == preamble || v.state == epilogue {
+		part = 0
+	}
+	v.report.Issues = append(v.report.Issues, Issue{
+		Kind:   k,
+		Part:   part,
+		Line:   v.line,
+		Offset: v.offset,
+		Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) run() error {
+	atLineStart := true
+	for {
+		chunk, err := v.br.ReadSlice('\n')
+		if len(chunk) > 0 {
+			if atLineStart {
+				v.line++
+				v.processLine(chunk, err == nil)
+			} else {
+				v.scanBody(chunk)
+				if err == nil {
+					_, v.prevCRLF = trimEOL(chunk)
+				}
+			}
+			v.offset += int64(len(chunk))
+			v.report.Bytes += int64(len(chunk))
+		}
+		switch {
+		case err == nil:
+			atLineStart = true
+		case errors.Is(err, bufio.ErrBufferFull):
+			atLineStart = false
+		case err == io.EOF:
+			v.finish()
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+func (v *validator) finish() {
+	switch v.state {
+	case preamble:
+		v.issue(NoParts, "no boundary line found")
+	case headers, body:
+		v.issue(MissingFinalBoundary, "stream ended inside part %d", v.report.Parts)
+	}
+}
+
+// processLine handles a chunk that starts at the beginning of a line.
+// complete is false when the line was cut by the buffer or EOF.
+func (v *validator) processLine(line []byte, complete bool) {
+	content, crlf := trimEOL(line)
+
+	if bytes.HasPrefix(content, v.delim) {
+		v.boundaryLine(content, crlf, complete)
+		return
+	}
+
+	switch v.state {
+	case headers:
+		v.headerLine(content, crlf)
+	case body:
+		v.scanBody(content)
+		if textproto.CanonicalMIMEHeaderKey(headerName(content)) == "Content-Disposition" &&
+			bytes.Contains(bytes.ToLower(content), []byte("form-data")) {
+			v.issue(InterleavedHeaders, "part header %q inside part body", string(content))
+		}
+		v.prevCRLF = crlf
+	case epilogue:
+		if len(bytes.TrimSpace(content)) > 0 && !v.trailing {
+			v.issue(DataAfterClose, "%d bytes after final boundary", len(content))
+			v.trailing = true
+		}
+	default:
+		v.scanBody(content)
+	}
+}
+
+func (v *validator) boundaryLine(content []byte, crlf, complete bool) {
+	rest := content[len(v.delim):]
+	closing := bytes.HasPrefix(rest, []byte("--"))
+	if closing {
+		rest = rest[2:]
+	}
+	if len(bytes.TrimRight(rest, " \t")) > 0 {
+		v.issue(MalformedBoundary, "unexpected %q after boundary", string(rest))
+	}
+	if complete && !crlf {
+		v.issue(BareLF, "boundary line ends with bare LF")
+	}
+	if !complete && !closing {
+		v.issue(MalformedBoundary, "boundary line not terminated")
+	}
+
+	switch v.state {
+	case headers:
+		v.issue(UnterminatedHeaders, "boundary before end of headers")
+	case body:
+		if !v.prevCRLF {
+			v.issue(BareLF, "line before boundary ends with bare LF")
+		}
+	case preamble:
+	default:
+		v.issue(DataAfterClose, "boundary after final boundary")
+		return
+	}
+
+	if closing {
+		v.report.Closed = true
+		v.state = epilogue
+		return
+	}
+	v.report.Parts++
+	v.state = headers
+	v.disposition = 0
+	v.sawHeader = false
+}
+
+func (v *validator) headerLine(content []byte, crlf bool) {
+	if !crlf {
+		v.issue(BareLF, "header line ends with bare LF")
+	}
+	if len(content) == 0 {
+		if v.disposition == 0 {
+			v.issue(MissingDisposition, "part has no Content-Disposition header")
+		}
+		v.state = body
+		v.prevCRLF = true
+		return
+	}
+	if content[0] == ' ' || content[0] == '\t' {
+		// Obsolete line folding; allowed by textproto except on the first line.
+		if !v.sawHeader {
+			v.issue(MalformedHeader, "first header line %q is a continuation", string(content))
+		} else if !validValue(content) {
+			v.issue(MalformedHeader, "invalid byte in header continuation %q", string(content))
+		}
+		return
+	}
+	v.sawHeader = true
+	name := headerName(content)
+	if name == "" {
+		v.issue(MalformedHeader, "%q is not a header", string(content))
+		return
+	}
+	if !validValue(content[len(name)+1:]) {
+		v.issue(MalformedHeader, "invalid byte in header value %q", string(content))
+		return
+	}
+	if textproto.CanonicalMIMEHeaderKey(name) == "Content-Disposition" {
+		v.disposition++
+		if v.disposition == 2 {
+			v.issue(DuplicateDisposition, "second Content-Disposition %q", string(content))
+		}
+	}
+}
+
+// scanBody looks for a boundary that does not start at a line boundary,
+// which is how interleaved writers typically corrupt a stream.
+func (v *validator) scanBody(b []byte) {
+	if i := bytes.Index(b, v.delim); i > 0 {
+		v.issue(MisplacedBoundary, "boundary at column %d", i+1)
+	}
+}
+
+// headerName returns the header field name of line, or "" if line is not a
+// syntactically valid header.
+func headerName(line []byte) string {
+	i := bytes.IndexByte(line, ':')
+	if i <= 0 {
+		return ""
+	}
+	for _, c := range line[:i] {
+		if !isTokenByte(c) {
+			return ""
+		}
+	}
+	return string(line[:i])
+}
+
+// isTokenByte reports whether c may appear in a header field name
+// (RFC 7230 tchar), matching what net/textproto accepts.
+func isTokenByte(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// validValue reports whether v holds only bytes allowed in a header value:
+// visible characters, space, tab and obs-text.
+func validValue(v []byte) bool {
+	for _, c := range v {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// trimEOL strips the line terminator and reports whether it was CRLF.
+func trimEOL(line []byte) ([]byte, bool) {
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		return line, false
+	}
+	line = line[:len(line)-1]
+	if bytes.HasSuffix(line, []byte("\r")) {
+		return line[:len(line)-1], true
+	}
+	return line, false
+}
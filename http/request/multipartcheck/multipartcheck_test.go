@@ -0,0 +1,149 @@
+package multipartcheck
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+const boundary = "xyz"
+
+func wellFormed(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.SetBoundary(boundary); err != nil {
+		t.Fatal(err)
+	}
+	mw.WriteField("a", "1")
+	fw, _ := mw.CreateFormFile("file", "f.txt")
+	fw.Write([]byte("line one\nline two\n"))
+	mw.Close()
+	return buf.Bytes()
+}
+
+func TestValidateWellFormed(t *testing.T) {
+	report, err := Validate(bytes.NewReader(wellFormed(t)), boundary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Fatalf("unexpected issues: %s", report)
+	}
+	if report.Parts != 2 || !report.Closed {
+		t.Errorf("Parts = %d, Closed = %t; want 2, true", report.Parts, report.Closed)
+	}
+}
+
+func TestValidateIssues(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Kind
+	}{
+		{"no parts", "hello\r\n", NoParts},
+		{"missing final boundary", "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n", MissingFinalBoundary},
+		{"bare LF in headers", "--xyz\r\nContent-Disposition: form-data; name=\"a\"\n\r\n1\r\n--xyz--\r\n", BareLF},
+		{"bare LF before boundary", "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\n--xyz--\r\n", BareLF},
+		{"duplicate disposition", "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n" +
+			"Content-Disposition: form-data; name=\"b\"\r\n\r\n1\r\n--xyz--\r\n", DuplicateDisposition},
+		{"missing disposition", "--xyz\r\nContent-Type: text/plain\r\n\r\n1\r\n--xyz--\r\n", MissingDisposition},
+		{"malformed header", "--xyz\r\nnot a header\r\n\r\n1\r\n--xyz--\r\n", MalformedHeader},
+		{"unterminated headers", "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n--xyz--\r\n", UnterminatedHeaders},
+		{"interleaved headers", "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n" +
+			"Content-Disposition: form-data; name=\"b\"\r\n--xyz--\r\n", InterleavedHeaders},
+		{"misplaced boundary", "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nvalue--xyz\r\n--xyz--\r\n", MisplacedBoundary},
+		{"malformed boundary", "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n--xyzgarbage\r\n--xyz--\r\n", MalformedBoundary},
+		{"data after close", "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n--xyz--\r\ntrailing\r\n", DataAfterClose},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Validate(strings.NewReader(tt.input), boundary)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.Has(tt.want) {
+				t.Errorf("want %s, got: %s", tt.want, report)
+			}
+		})
+	}
+}
+
+func TestValidateLongLines(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.SetBoundary(boundary)
+	fw, _ := mw.CreateFormFile("big", "big.bin")
+	fw.Write(bytes.Repeat([]byte("x"), 3*maxLine))
+	mw.Close()
+
+	report, err := Validate(&buf, boundary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Fatalf("unexpected issues: %s", report)
+	}
+}
+
+// FuzzValidateMultipart mutates boundaries, headers and line endings. A
+// report without issues must describe a stream that mime/multipart reads
+// cleanly, part for part.
+func FuzzValidateMultipart(f *testing.F) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.SetBoundary(boundary)
+	mw.WriteField("a", "1")
+	fw, _ := mw.CreateFormFile("file", "f.txt")
+	fw.Write([]byte("line one\nline two\n"))
+	mw.Close()
+	f.Add(buf.Bytes(), boundary)
+
+	// Streams as produced by the concurrent_error demos: a second writer's
+	// boundary and headers land inside the first writer's part.
+	f.Add([]byte("--xyz\r\nContent-Disposition: form-data; name=\"f1\"\r\n\r\nval--xyz\r\n"+
+		"Content-Disposition: form-data; name=\"f2\"\r\n\r\nvalue2ue1\r\n--xyz--\r\n"), boundary)
+	f.Add([]byte("--xyz\r\n--xyz\r\nContent-Disposition: form-data; name=\"f1\"\r\n"+
+		"Content-Disposition: form-data; name=\"f2\"\r\n\r\nvalue1value2\r\n--xyz--\r\n"), boundary)
+	f.Add([]byte("--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n"), boundary)
+	f.Add([]byte("--b\r\nContent-Disposition: form-data; name=\"a\"\n\r\n1\n--b--\n"), "b")
+
+	f.Fuzz(func(t *testing.T, data []byte, boundary string) {
+		if boundary == "" {
+			return
+		}
+		report, err := Validate(bytes.NewReader(data), boundary)
+		if err != nil {
+			t.Fatalf("Validate returned read error on in-memory input: %v", err)
+		}
+		if report.Bytes != int64(len(data)) {
+			t.Fatalf("Bytes = %d, want %d", report.Bytes, len(data))
+		}
+		if !report.Valid() {
+			return
+		}
+		if !report.Closed {
+			t.Fatalf("valid report for unclosed stream: %s", report)
+		}
+		mr := multipart.NewReader(bytes.NewReader(data), boundary)
+		parts := 0
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("valid report (%s) but mime/multipart fails after %d parts: %v", report, parts, err)
+			}
+			if _, err := io.Copy(io.Discard, p); err != nil {
+				t.Fatalf("valid report (%s) but reading part %d fails: %v", report, parts+1, err)
+			}
+			parts++
+		}
+		if parts != report.Parts {
+			t.Fatalf("mime/multipart read %d parts, report says %d", parts, report.Parts)
+		}
+	})
+}
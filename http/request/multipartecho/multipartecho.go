@@ -0,0 +1,140 @@
+// Package multipartecho records multipart requests and echoes a JSON
+// summary of each one back to the client. It does not depend on the
+// testing package, so runnable examples can serve it; tests use the
+// multiparttest wrappers around it.
+package multipartecho
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sync"
+
+	"github.com/isauran/go-std-library/http/server/serverx"
+)
+
+// Part is one received part, in arrival order.
+type Part struct {
+	Name        string               `json:"name"`
+	FileName    string               `json:"filename,omitempty"`
+	ContentType string               `json:"content_type,omitempty"`
+	Value       string               `json:"value,omitempty"` // set for fields only
+	Header      textproto.MIMEHeader `json:"-"`
+	Content     []byte               `json:"-"`
+	Size        int                  `json:"size"`
+	SHA256      string               `json:"sha256"`
+}
+
+// IsFile reports whether the part was sent as a file.
+func (p Part) IsFile() bool {
+	return p.FileName != ""
+}
+
+// Request is one received request.
+type Request struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Header http.Header `json:"headers"`
+	Parts  []Part      `json:"parts"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Fields returns the values of all non-file parts by name.
+func (r *Request) Fields() map[string][]string {
+	fields := map[string][]string{}
+	for _, p := range r.Parts {
+		if !p.IsFile() {
+			fields[p.Name] = append(fields[p.Name], string(p.Content))
+		}
+	}
+	return fields
+}
+
+// Files returns the file parts in arrival order.
+func (r *Request) Files() []Part {
+	var files []Part
+	for _, p := range r.Parts {
+		if p.IsFile() {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// Recorder is an http.Handler that records multipart requests and echoes a
+// JSON summary of each one back to the client.
+type Recorder struct {
+	mu       sync.Mutex
+	requests []*Request
+}
+
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := ReadRequest(r)
+	status := http.StatusOK
+	if err != nil {
+		status = serverx.StatusCode(err)
+	}
+
+	rec.mu.Lock()
+	rec.requests = append(rec.requests, req)
+	rec.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(req)
+}
+
+// ReadRequest consumes the multipart body of r. It works on both server and
+// client requests. On error the returned Request holds the parts read so
+// far and the error text.
+func ReadRequest(r *http.Request) (*Request, error) {
+	req := &Request{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone()}
+	err := serverx.StreamParts(r, func(p *multipart.Part) error {
+		content, err := io.ReadAll(p)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		part := Part{
+			Name:        p.FormName(),
+			FileName:    p.FileName(),
+			ContentType: p.Header.Get("Content-Type"),
+			Header:      p.Header,
+			Content:     content,
+			Size:        len(content),
+			SHA256:      hex.EncodeToString(sum[:]),
+		}
+		if !part.IsFile() {
+			part.Value = string(content)
+		}
+		req.Parts = append(req.Parts, part)
+		return nil
+	})
+	if err != nil {
+		req.Error = err.Error()
+	}
+	return req, err
+}
+
+// Requests returns all requests received so far.
+func (rec *Recorder) Requests() []*Request {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]*Request(nil), rec.requests...)
+}
+
+// Last returns the most recent request, or nil.
+func (rec *Recorder) Last() *Request {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.requests) == 0 {
+		return nil
+	}
+	return rec.requests[len(rec.requests)-1]
+}
@@ -0,0 +1,52 @@
+package multipartecho
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("name", "gopher")
+	fw, _ := mw.CreateFormFile("avatar", "gopher.png")
+	fw.Write([]byte("png bytes"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	rec := &Recorder{}
+	rec.ServeHTTP(w, req)
+
+	var echo Request
+	if err := json.NewDecoder(w.Body).Decode(&echo); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || echo.Path != "/upload" || len(echo.Parts) != 2 {
+		t.Fatalf("echo = %d %+v", w.Code, echo)
+	}
+	last := rec.Last()
+	if last == nil || len(rec.Requests()) != 1 {
+		t.Fatalf("recorded %d requests", len(rec.Requests()))
+	}
+	if f := last.Fields()["name"]; len(f) != 1 || f[0] != "gopher" {
+		t.Errorf("fields = %v", last.Fields())
+	}
+	if files := last.Files(); len(files) != 1 || string(files[0].Content) != "png bytes" {
+		t.Errorf("files = %+v", files)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	rec.ServeHTTP(w, req)
+	if w.Code == http.StatusOK || rec.Last().Error == "" {
+		t.Errorf("non-multipart request: status %d, error %q", w.Code, rec.Last().Error)
+	}
+}
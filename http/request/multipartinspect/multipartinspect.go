@@ -0,0 +1,265 @@
+// Package multipartinspect parses captured multipart bodies into a tree of
+// parts for debugging corrupted uploads.
+package multipartinspect
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Part describes one part of an inspected body. Parts whose Content-Type is
+// itself multipart/* are expanded into Children.
+type Part struct {
+	ID       string // position in the tree, e.g. "2" or "2.1"
+	Header   textproto.MIMEHeader
+	FormName string
+	FileName string
+	Size     int64
+	Preview  []byte
+	Path     string // set when the part was extracted to disk
+	Children []Part
+}
+
+// Options controls inspection.
+type Options struct {
+	// PreviewBytes is how many leading content bytes to keep in Part.Preview.
+	PreviewBytes int
+	// ExtractDir, when set, receives the content of leaf parts.
+	ExtractDir string
+	// Extract limits extraction to the given part IDs; empty means all.
+	Extract []string
+}
+
+func (o Options) shouldExtract(id string) bool {
+	if o.ExtractDir == "" {
+		return false
+	}
+	if len(o.Extract) == 0 {
+		return true
+	}
+	for _, e := range o.Extract {
+		if e == id {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectBoundary peeks at br and returns the boundary of the first delimiter
+// line, skipping any preamble. It does not consume input.
+func DetectBoundary(br *bufio.Reader) (string, error) {
+	data, err := br.Peek(br.Size())
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return "", err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r \t")
+		if bytes.HasPrefix(line, []byte("--")) && len(line) > 2 {
+			return string(bytes.TrimSuffix(line[2:], []byte("--"))), nil
+		}
+	}
+	return "", errors.New("multipartinspect: no boundary line found")
+}
+
+// Inspect parses the multipart body in r delimited by boundary. It returns
+// the parts parsed so far together with any parse error, so a corrupted
+// body still yields everything before the corruption.
+func Inspect(r io.Reader, boundary string, opts Options) ([]Part, error) {
+	if opts.PreviewBytes == 0 {
+		opts.PreviewBytes = 64
+	}
+	if opts.ExtractDir != "" {
+		if err := os.MkdirAll(opts.ExtractDir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return inspect(multipart.NewReader(r, boundary), "", opts)
+}
+
+func inspect(mr *multipart.Reader, prefix string, opts Options) ([]Part, error) {
+	var parts []Part
+	for i := 1; ; i++ {
+		// NextRawPart keeps quoted-printable bodies as they were sent.
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, fmt.Errorf("part %s%d: %w", prefix, i, err)
+		}
+		part := Part{
+			ID:       prefix + strconv.Itoa(i),
+			Header:   p.Header,
+			FormName: p.FormName(),
+			FileName: p.FileName(),
+		}
+
+		mediaType, params, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+			counter := &countingReader{r: p}
+			part.Children, err = inspect(multipart.NewReader(counter, params["boundary"]), part.ID+".", opts)
+			part.Size = counter.n
+			parts = append(parts, part)
+			if err != nil {
+				return parts, err
+			}
+			continue
+		}
+
+		err = readPart(&part, p, opts)
+		parts = append(parts, part)
+		if err != nil {
+			return parts, fmt.Errorf("part %s: %w", part.ID, err)
+		}
+	}
+}
+
+func readPart(part *Part, r io.Reader, opts Options) error {
+	preview := &limitedBuffer{max: opts.PreviewBytes}
+	var dst io.Writer = preview
+	if opts.shouldExtract(part.ID) {
+		part.Path = filepath.Join(opts.ExtractDir, extractName(*part))
+		f, err := os.Create(part.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dst = io.MultiWriter(preview, f)
+	}
+	n, err := io.Copy(dst, r)
+	part.Size = n
+	part.Preview = preview.Bytes()
+	return err
+}
+
+// extractName builds a file name that cannot escape the extraction
+// directory: the part ID followed by the base of the client file name, or
+// of the form name for parts without one.
+func extractName(p Part) string {
+	name := baseName(p.FileName)
+	if name == "" {
+		name = baseName(p.FormName)
+	}
+	if name == "" {
+		name = "part"
+	}
+	return strings.ReplaceAll(p.ID, ".", "_") + "_" + name
+}
+
+// baseName reduces a client-supplied name to its last path element, or ""
+// if nothing usable is left.
+func baseName(name string) string {
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
+
+// Print writes the part tree in a human-readable form.
+func Print(w io.Writer, parts []Part) error {
+	bw := bufio.NewWriter(w)
+	printParts(bw, parts, "")
+	return bw.Flush()
+}
+
+func printParts(w *bufio.Writer, parts []Part, indent string) {
+	for _, p := range parts {
+		fmt.Fprintf(w, "%spart %s", indent, p.ID)
+		if p.FormName != "" {
+			fmt.Fprintf(w, " name=%q", p.FormName)
+		}
+		if p.FileName != "" {
+			fmt.Fprintf(w, " filename=%q", p.FileName)
+		}
+		fmt.Fprintf(w, " (%s)", formatSize(p.Size))
+		if p.Path != "" {
+			fmt.Fprintf(w, " -> %s", p.Path)
+		}
+		w.WriteString("\n")
+
+		keys := make([]string, 0, len(p.Header))
+		for k := range p.Header {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range p.Header[k] {
+				fmt.Fprintf(w, "%s  %s: %s\n", indent, k, v)
+			}
+		}
+
+		if len(p.Children) > 0 {
+			printParts(w, p.Children, indent+"  ")
+			continue
+		}
+		if len(p.Preview) > 0 {
+			fmt.Fprintf(w, "%s  preview: %s\n", indent, formatPreview(p.Preview, p.Size))
+		}
+	}
+}
+
+func formatPreview(b []byte, size int64) string {
+	// A preview can end in the middle of a rune; don't treat that as binary.
+	text := b
+	for i := 0; i < utf8.UTFMax && len(text) > 0 && !utf8.Valid(text); i++ {
+		text = text[:len(text)-1]
+	}
+	s := ""
+	if utf8.Valid(text) && len(b)-len(text) < utf8.UTFMax {
+		s = strconv.Quote(string(text))
+	} else {
+		s = fmt.Sprintf("% x", b)
+	}
+	if int64(len(b)) < size {
+		s += "..."
+	}
+	return s
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// limitedBuffer keeps the first max bytes written and discards the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,99 @@
+package multipartinspect
+
+import (
+	"bufio"
+	"bytes"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInspectNestedAndExtract(t *testing.T) {
+	var inner bytes.Buffer
+	imw := multipart.NewWriter(&inner)
+	imw.SetBoundary("inner")
+	fw, _ := imw.CreateFormFile("doc", "../../etc/passwd")
+	fw.Write([]byte("secret"))
+	imw.Close()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.SetBoundary("outer")
+	mw.WriteField("title", "hello")
+	pw, _ := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="files"`},
+		"Content-Type":        {"multipart/mixed; boundary=inner"},
+	})
+	pw.Write(inner.Bytes())
+	mw.Close()
+
+	br := bufio.NewReader(&buf)
+	boundary, err := DetectBoundary(br)
+	if err != nil || boundary != "outer" {
+		t.Fatalf("DetectBoundary = %q, %v", boundary, err)
+	}
+
+	dir := t.TempDir()
+	parts, err := Inspect(br, boundary, Options{ExtractDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 || len(parts[1].Children) != 1 {
+		t.Fatalf("unexpected tree: %+v", parts)
+	}
+	child := parts[1].Children[0]
+	if child.ID != "2.1" || string(child.Preview) != "secret" {
+		t.Errorf("child = %+v", child)
+	}
+	if filepath.Dir(child.Path) != dir {
+		t.Errorf("extracted outside of dir: %s", child.Path)
+	}
+	if b, _ := os.ReadFile(child.Path); string(b) != "secret" {
+		t.Errorf("extracted content = %q", b)
+	}
+
+	var out strings.Builder
+	Print(&out, parts)
+	if !strings.Contains(out.String(), `  part 2.1 name="doc" filename="passwd"`) {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestInspectTruncated(t *testing.T) {
+	body := "--b\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n--b\r\nContent-Disposition: form-data; name=\"c\"\r\n\r\ncut"
+	parts, err := Inspect(strings.NewReader(body), "b", Options{})
+	if err == nil {
+		t.Fatal("expected error for truncated body")
+	}
+	if len(parts) != 2 || parts[0].FormName != "a" {
+		t.Errorf("parts before corruption = %+v", parts)
+	}
+}
+
+func TestExtractFormNameTraversal(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.SetBoundary("b")
+	for _, name := range []string{"../../../../x", "/", "."} {
+		fw, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Disposition": {`form-data; name="` + name + `"`},
+		})
+		fw.Write([]byte("payload"))
+	}
+	mw.Close()
+
+	dir := t.TempDir()
+	parts, err := Inspect(&buf, "b", Options{ExtractDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1_x", "2_part", "3_part"}
+	for i, p := range parts {
+		if filepath.Dir(p.Path) != dir || filepath.Base(p.Path) != want[i] {
+			t.Errorf("part %s extracted to %s, want %s", p.ID, p.Path, filepath.Join(dir, want[i]))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Package multiparttest provides an echo server for multipart round-trip
+// tests, in the spirit of net/http/httptest.
+package multiparttest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/isauran/go-std-library/http/request/multipartecho"
+)
+
+// Part is one received part, in arrival order.
+type Part = multipartecho.Part
+
+// Request is one received request.
+type Request = multipartecho.Request
+
+// Recorder is an http.Handler that records multipart requests and echoes a
+// JSON summary of each one back to the client. Code outside tests should
+// import it from multipartecho, which does not link the testing package.
+type Recorder = multipartecho.Recorder
+
+// ReadRequest consumes the multipart body of r; see
+// multipartecho.ReadRequest.
+func ReadRequest(r *http.Request) (*Request, error) {
+	return multipartecho.ReadRequest(r)
+}
+
+// EchoServer is an httptest.Server backed by a Recorder, with accessors and
+// assertions on the most recent request.
+type EchoServer struct {
+	*httptest.Server
+	*Recorder
+	t testing.TB
+}
+
+// NewEchoServer starts an EchoServer that is closed when the test ends.
+func NewEchoServer(t testing.TB) *EchoServer {
+	t.Helper()
+	rec := &Recorder{}
+	s := &EchoServer{Server: httptest.NewServer(rec), Recorder: rec, t: t}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *EchoServer) last() *Request {
+	s.t.Helper()
+	req := s.Last()
+	if req == nil {
+		s.t.Fatal("multiparttest: no request received")
+	}
+	return req
+}
+
+// Fields returns the fields of the most recent request.
+func (s *EchoServer) Fields() map[string][]string {
+	s.t.Helper()
+	return s.last().Fields()
+}
+
+// Files returns the file parts of the most recent request.
+func (s *EchoServer) Files() []Part {
+	s.t.Helper()
+	return s.last().Files()
+}
+
+// Parts returns all parts of the most recent request in arrival order.
+func (s *EchoServer) Parts() []Part {
+	s.t.Helper()
+	return s.last().Parts
+}
+
+// Headers returns the headers of the most recent request.
+func (s *EchoServer) Headers() http.Header {
+	s.t.Helper()
+	return s.last().Header
+}
+
+// AssertField checks that the most recent request has a field name whose
+// first value is want.
+func (s *EchoServer) AssertField(name, want string) {
+	s.t.Helper()
+	values, ok := s.Fields()[name]
+	switch {
+	case !ok:
+		s.t.Errorf("multiparttest: field %q not received", name)
+	case values[0] != want:
+		s.t.Errorf("multiparttest: field %q = %q, want %q", name, values[0], want)
+	}
+}
+
+// AssertFile checks the name and content of the first file part of field.
+func (s *EchoServer) AssertFile(field, filename, content string) {
+	s.t.Helper()
+	f, ok := s.file(field)
+	if !ok {
+		return
+	}
+	if f.FileName != filename {
+		s.t.Errorf("multiparttest: file %q filename = %q, want %q", field, f.FileName, filename)
+	}
+	if string(f.Content) != content {
+		s.t.Errorf("multiparttest: file %q content = %q, want %q", field, f.Content, content)
+	}
+}
+
+// AssertFileSHA256 checks the hex SHA-256 of the first file part of field.
+func (s *EchoServer) AssertFileSHA256(field, want string) {
+	s.t.Helper()
+	f, ok := s.file(field)
+	if ok && f.SHA256 != want {
+		s.t.Errorf("multiparttest: file %q sha256 = %s, want %s", field, f.SHA256, want)
+	}
+}
+
+// AssertHeader checks a request header of the most recent request.
+func (s *EchoServer) AssertHeader(key, want string) {
+	s.t.Helper()
+	if got := s.Headers().Get(key); got != want {
+		s.t.Errorf("multiparttest: header %s = %q, want %q", key, got, want)
+	}
+}
+
+func (s *EchoServer) file(field string) (Part, bool) {
+	s.t.Helper()
+	for _, f := range s.Files() {
+		if f.Name == field {
+			return f, true
+		}
+	}
+	s.t.Errorf("multiparttest: file %q not received", field)
+	return Part{}, false
+}
@@ -0,0 +1,69 @@
+package multiparttest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"testing"
+)
+
+func TestEchoServer(t *testing.T) {
+	srv := NewEchoServer(t)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("name", "gopher")
+	mw.WriteField("tag", "a")
+	mw.WriteField("tag", "b")
+	fw, _ := mw.CreateFormFile("avatar", "gopher.png")
+	fw.Write([]byte("png bytes"))
+	mw.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Test", "yes")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var echo Request
+	if err := json.NewDecoder(resp.Body).Decode(&echo); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || echo.Path != "/upload" || len(echo.Parts) != 4 {
+		t.Fatalf("echo = %d %+v", resp.StatusCode, echo)
+	}
+
+	sum := sha256.Sum256([]byte("png bytes"))
+	srv.AssertField("name", "gopher")
+	srv.AssertHeader("X-Test", "yes")
+	srv.AssertFile("avatar", "gopher.png", "png bytes")
+	srv.AssertFileSHA256("avatar", hex.EncodeToString(sum[:]))
+	if tags := srv.Fields()["tag"]; len(tags) != 2 || tags[1] != "b" {
+		t.Errorf("tag = %q", tags)
+	}
+	if files := srv.Files(); len(files) != 1 || files[0].Size != 9 {
+		t.Errorf("files = %+v", files)
+	}
+}
+
+func TestEchoServerRejectsNonMultipart(t *testing.T) {
+	srv := NewEchoServer(t)
+
+	resp, err := srv.Client().Post(srv.URL, "text/plain", bytes.NewReader([]byte("hi")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.StatusCode)
+	}
+	if last := srv.Last(); last == nil || last.Error == "" {
+		t.Errorf("last = %+v, want recorded error", last)
+	}
+}
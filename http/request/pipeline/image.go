@@ -0,0 +1,183 @@
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+)
+
+// DefaultMaxPixels is the largest image, in pixels, the Image stage
+// decodes by default: 8192 by 8192.
+const DefaultMaxPixels = 8192 * 8192
+
+// ErrImageTooLarge is returned by the Image stage for an image with more
+// pixels than MaxPixels allows.
+var ErrImageTooLarge = errors.New("pipeline: image too large")
+
+type imageConfig struct {
+	format    string
+	quality   int
+	maxW      int
+	maxH      int
+	maxPixels int
+}
+
+// ImageOption configures the Image stage.
+type ImageOption func(*imageConfig)
+
+// Format sets the format images are re-encoded in: "png", "jpeg" or
+// "gif". By default an image keeps the format it came in.
+func Format(name string) ImageOption {
+	return func(c *imageConfig) { c.format = name }
+}
+
+// Quality sets the JPEG quality, from 1 to 100. It defaults to
+// jpeg.DefaultQuality.
+func Quality(q int) ImageOption {
+	return func(c *imageConfig) { c.quality = q }
+}
+
+// MaxSize downscales images larger than width by height pixels to fit,
+// keeping their aspect ratio. Zero leaves that dimension unbounded. Smaller
+// images are not enlarged.
+func MaxSize(width, height int) ImageOption {
+	return func(c *imageConfig) { c.maxW, c.maxH = width, height }
+}
+
+// MaxPixels sets the largest image, in width times height pixels, that is
+// decoded; larger ones fail with ErrImageTooLarge before their pixels are
+// allocated, so a small file declaring huge dimensions cannot exhaust
+// memory. It defaults to DefaultMaxPixels; zero or less removes the limit.
+func MaxPixels(n int) ImageOption {
+	return func(c *imageConfig) { c.maxPixels = n }
+}
+
+// Image returns a stage transcoding PNG, JPEG and GIF images, e.g. so a
+// photo upload is downscaled on the client:
+//
+//	r.FileThrough("photo", "photo.jpg", f,
+//		pipeline.Image(pipeline.Format("jpeg"), pipeline.Quality(80), pipeline.MaxSize(1600, 1600)))
+//
+// The image is decoded as it is written to the stage and re-encoded once
+// the stage is closed, so only the decoded pixels are held in memory and no
+// temporary file is written. Metadata such as EXIF is dropped, animated
+// GIFs keep their first frame only, and JPEG output loses transparency.
+// Input that is no image fails the stage.
+func Image(opts ...ImageOption) *Stage {
+	cfg := imageConfig{quality: jpeg.DefaultQuality, maxPixels: DefaultMaxPixels}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return New("image", func(w io.Writer) (io.WriteCloser, error) {
+		switch cfg.format {
+		case "", "png", "jpeg", "gif":
+		default:
+			return nil, fmt.Errorf("unsupported image format %q", cfg.format)
+		}
+		pr, pw := io.Pipe()
+		t := &transcoder{pw: pw, done: make(chan error, 1)}
+		go func() {
+			err := cfg.transcode(w, pr)
+			// Fail the writes of the rest of an image that did not decode.
+			pr.CloseWithError(err)
+			t.done <- err
+		}()
+		return t, nil
+	})
+}
+
+// transcoder feeds the stage's input to the goroutine decoding it.
+type transcoder struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (t *transcoder) Write(p []byte) (int, error) { return t.pw.Write(p) }
+
+func (t *transcoder) Close() error {
+	t.pw.Close()
+	return <-t.done
+}
+
+// transcode decodes an image from r and encodes it into w. The header is
+// read first to check the dimensions, then replayed to the decoder.
+func (c imageConfig) transcode(w io.Writer, r io.Reader) error {
+	var head bytes.Buffer
+	size, _, err := image.DecodeConfig(io.TeeReader(r, &head))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	if c.maxPixels > 0 && int64(size.Width)*int64(size.Height) > int64(c.maxPixels) {
+		return fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrImageTooLarge, size.Width, size.Height, c.maxPixels)
+	}
+	img, format, err := image.Decode(io.MultiReader(&head, r))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	// Skip trailing bytes; this also waits for the stage to be closed.
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	img = downscale(img, c.maxW, c.maxH)
+	if c.format != "" {
+		format = c.format
+	}
+	switch format {
+	case "png":
+		err = png.Encode(w, img)
+	case "jpeg":
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: c.quality})
+	case "gif":
+		err = gif.Encode(w, img, nil)
+	default:
+		return fmt.Errorf("unsupported image format %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode %s image: %w", format, err)
+	}
+	return nil
+}
+
+// downscale shrinks src to fit maxW by maxH, averaging the source pixels
+// each destination pixel covers. It returns src if it already fits.
+func downscale(src image.Image, maxW, maxH int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	scale := 1.0
+	if maxW > 0 && w > maxW {
+		scale = float64(maxW) / float64(w)
+	}
+	if maxH > 0 && h > maxH {
+		scale = min(scale, float64(maxH)/float64(h))
+	}
+	if scale == 1 {
+		return src
+	}
+	dw := max(1, int(math.Round(float64(w)*scale)))
+	dh := max(1, int(math.Round(float64(h)*scale)))
+	dst := image.NewRGBA64(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		y0, y1 := bounds.Min.Y+y*h/dh, bounds.Min.Y+max((y+1)*h/dh, y*h/dh+1)
+		for x := range dw {
+			x0, x1 := bounds.Min.X+x*w/dw, bounds.Min.X+max((x+1)*w/dw, x*w/dw+1)
+			// RGBA returns premultiplied values, which average correctly.
+			var sr, sg, sb, sa, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, a := src.At(sx, sy).RGBA()
+					sr, sg, sb, sa = sr+uint64(r), sg+uint64(g), sb+uint64(b), sa+uint64(a)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(sr / n), G: uint16(sg / n), B: uint16(sb / n), A: uint16(sa / n)})
+		}
+	}
+	return dst
+}
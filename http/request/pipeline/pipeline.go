@@ -0,0 +1,242 @@
+// Package pipeline transforms a stream through composable stages, such as
+// compressing, encrypting, hashing and throttling a file on its way into an
+// upload, without buffering it:
+//
+//	sum := sha256.New()
+//	gz := pipeline.Gzip(gzip.BestSpeed)
+//	body := pipeline.Reader(f, gz, pipeline.Encrypt(key), pipeline.Hash(sum))
+//	// ... once body was read to the end:
+//	log.Printf("gzip: %+v, sha-256: %x", gz.Metrics(), sum.Sum(nil))
+//
+// Data flows through the stages in the order given: above, the hash covers
+// the encrypted, compressed content. Every stage is a writer wrapping the
+// next one, and Writer and Reader chain them in front of a destination or
+// behind a source. Stages keep metrics of the data they saw, so a slow or
+// ineffective stage shows up without profiling.
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/isauran/go-std-library/http/request/ratelimit"
+	"github.com/isauran/go-std-library/io/gcmstream"
+)
+
+// Metrics are the totals of all runs of a stage.
+type Metrics struct {
+	Runs     int           // streams closed through the stage
+	BytesIn  int64         // bytes written into the stage
+	BytesOut int64         // bytes the stage passed on
+	Duration time.Duration // time spent in the stage, without later stages
+}
+
+// Stage is one step of a pipeline. A stage may be used in any number of
+// pipelines, also concurrently, unless it writes to state passed in by the
+// caller, as Hash does; its metrics add up over all of them.
+type Stage struct {
+	name string
+	wrap func(w io.Writer) (io.WriteCloser, error)
+
+	mu      sync.Mutex
+	metrics Metrics
+}
+
+// New returns a stage named name. wrap returns a writer transforming what
+// is written to it into w; closing it must flush its output, but not
+// close w.
+func New(name string, wrap func(w io.Writer) (io.WriteCloser, error)) *Stage {
+	return &Stage{name: name, wrap: wrap}
+}
+
+// Name returns the name the stage was created with.
+func (s *Stage) Name() string { return s.name }
+
+// Metrics returns the stage's metrics so far. A run is counted once its
+// writer is closed.
+func (s *Stage) Metrics() Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.metrics
+}
+
+// Wrap returns a writer running the stage in front of w.
+func (s *Stage) Wrap(w io.Writer) (io.WriteCloser, error) {
+	out := &meter{w: w}
+	inner, err := s.wrap(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s stage: %w", s.name, err)
+	}
+	return &stageWriter{s: s, w: inner, out: out}, nil
+}
+
+// meter counts the bytes a stage passes on and the time later stages take
+// to accept them.
+type meter struct {
+	w io.Writer
+	n int64
+	d time.Duration
+}
+
+func (m *meter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := m.w.Write(p)
+	m.d += time.Since(start)
+	m.n += int64(n)
+	return n, err
+}
+
+type stageWriter struct {
+	s   *Stage
+	w   io.WriteCloser
+	out *meter
+	in  int64
+	d   time.Duration
+}
+
+func (w *stageWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.w.Write(p)
+	w.d += time.Since(start)
+	w.in += int64(n)
+	return n, err
+}
+
+func (w *stageWriter) Close() error {
+	start := time.Now()
+	err := w.w.Close()
+	w.d += time.Since(start)
+	w.s.mu.Lock()
+	w.s.metrics.Runs++
+	w.s.metrics.BytesIn += w.in
+	w.s.metrics.BytesOut += w.out.n
+	w.s.metrics.Duration += w.d - w.out.d
+	w.s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to close %s stage: %w", w.s.name, err)
+	}
+	return nil
+}
+
+// Writer returns a writer passing what is written to it through stages
+// into dst. Close flushes every stage, from the first to the last; it does
+// not close dst.
+func Writer(dst io.Writer, stages ...*Stage) (io.WriteCloser, error) {
+	// The chain is built from dst outwards, so the first stage is on top.
+	writers := make([]io.WriteCloser, len(stages))
+	for i := len(stages) - 1; i >= 0; i-- {
+		w, err := stages[i].Wrap(dst)
+		if err != nil {
+			return nil, err
+		}
+		writers[i], dst = w, w
+	}
+	return &chain{w: dst, writers: writers}, nil
+}
+
+type chain struct {
+	w       io.Writer
+	writers []io.WriteCloser
+}
+
+func (c *chain) Write(p []byte) (int, error) { return c.w.Write(p) }
+
+func (c *chain) Close() error {
+	for _, w := range c.writers {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reader returns the content of src passed through stages. The stages run
+// in a goroutine as the result is read; closing it stops them.
+func Reader(src io.Reader, stages ...*Stage) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := Writer(pw, stages...)
+		if err == nil {
+			_, err = io.Copy(w, src)
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// nopCloser turns a writer that needs no flushing into a stage's writer.
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// Gzip returns a stage compressing with gzip at level, e.g.
+// gzip.DefaultCompression.
+func Gzip(level int) *Stage {
+	return New("gzip", func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	})
+}
+
+// Encrypt returns a stage encrypting with chunked AES-GCM (see package
+// gcmstream). key must be 16, 24 or 32 bytes. The receiver decrypts with
+// gcmstream.NewReader.
+func Encrypt(key []byte) *Stage {
+	key = bytes.Clone(key)
+	return New("encrypt", func(w io.Writer) (io.WriteCloser, error) {
+		return gcmstream.NewWriter(w, key)
+	})
+}
+
+// Hash returns a stage feeding the data passing by into h, unchanged. Read
+// the sum from h once the pipeline is closed; h is not reset between runs.
+// All runs share h, so the stage must not be used by pipelines running at
+// the same time; create one Hash stage per concurrent stream.
+func Hash(h hash.Hash) *Stage {
+	return New("hash", func(w io.Writer) (io.WriteCloser, error) {
+		return nopCloser{io.MultiWriter(h, w)}, nil
+	})
+}
+
+// Throttle returns a stage limiting the data passing by to the bandwidth
+// of l. Writes fail with ctx's error once it is done.
+func Throttle(ctx context.Context, l *ratelimit.Limiter) *Stage {
+	return New("throttle", func(w io.Writer) (io.WriteCloser, error) {
+		return nopCloser{&throttle{ctx: ctx, w: w, l: l}}, nil
+	})
+}
+
+// maxChunk bounds a single throttled write, so large writes do not wait
+// for a long reservation at once.
+const maxChunk = 32 << 10
+
+type throttle struct {
+	ctx context.Context
+	w   io.Writer
+	l   *ratelimit.Limiter
+}
+
+func (t *throttle) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), maxChunk)]
+		if err := t.l.WaitBytes(t.ctx, len(chunk)); err != nil {
+			return n, err
+		}
+		m, err := t.w.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
@@ -0,0 +1,219 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/isauran/go-std-library/http/request/ratelimit"
+	"github.com/isauran/go-std-library/io/gcmstream"
+)
+
+var key = bytes.Repeat([]byte{7}, 32)
+
+func TestReader(t *testing.T) {
+	plain := bytes.Repeat([]byte("pipeline "), 20000)
+	sum := sha256.New()
+	gz := Gzip(gzip.BestSpeed)
+	enc := Encrypt(key)
+	hs := Hash(sum)
+	out, err := io.ReadAll(Reader(bytes.NewReader(plain), gz, enc, hs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sha256.Sum256(out); !bytes.Equal(got[:], sum.Sum(nil)) {
+		t.Error("hash stage did not see the final output")
+	}
+	dr, err := gcmstream.NewReader(bytes.NewReader(out), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("round trip: %d bytes, %v", len(got), err)
+	}
+
+	m := gz.Metrics()
+	if m.Runs != 1 || m.BytesIn != int64(len(plain)) || m.BytesOut >= m.BytesIn || m.BytesOut == 0 {
+		t.Errorf("gzip metrics = %+v", m)
+	}
+	if e := enc.Metrics(); e.BytesIn != m.BytesOut || e.BytesOut <= e.BytesIn {
+		t.Errorf("encrypt metrics = %+v after gzip %+v", e, m)
+	}
+	if h := hs.Metrics(); h.BytesIn != int64(len(out)) || h.BytesOut != h.BytesIn {
+		t.Errorf("hash metrics = %+v", h)
+	}
+}
+
+func TestWriterStageError(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := Writer(&buf, Gzip(gzip.BestSpeed), Encrypt([]byte("short"))); err == nil {
+		t.Error("invalid key: no error")
+	}
+	_, err := io.ReadAll(Reader(bytes.NewReader([]byte("x")), Encrypt([]byte("short"))))
+	if err == nil {
+		t.Error("Reader with invalid key: no error")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w, err := Writer(io.Discard, Throttle(ctx, ratelimit.New(0, 10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, 1000)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Write = %v, want context.Canceled", err)
+	}
+
+	s := Throttle(context.Background(), ratelimit.New(0, 1<<30))
+	w, _ = Writer(io.Discard, s)
+	if n, err := w.Write(make([]byte, 3*maxChunk+1)); n != 3*maxChunk+1 || err != nil {
+		t.Errorf("Write = %d, %v", n, err)
+	}
+	w.Close()
+	if m := s.Metrics(); m.BytesIn != 3*maxChunk+1 || m.BytesOut != m.BytesIn {
+		t.Errorf("throttle metrics = %+v", m)
+	}
+}
+
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func TestImage(t *testing.T) {
+	var src bytes.Buffer
+	png.Encode(&src, testImage(400, 200))
+	src.WriteString("trailing bytes")
+
+	s := Image(Format("jpeg"), Quality(70), MaxSize(100, 100))
+	out, err := io.ReadAll(Reader(bytes.NewReader(src.Bytes()), s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(out))
+	if err != nil || format != "jpeg" || cfg.Width != 100 || cfg.Height != 50 {
+		t.Errorf("output = %s %dx%d, %v", format, cfg.Width, cfg.Height, err)
+	}
+	if m := s.Metrics(); m.BytesIn != int64(src.Len()) || m.BytesOut != int64(len(out)) {
+		t.Errorf("image metrics = %+v", m)
+	}
+
+	// Small images keep their size and, by default, their format.
+	var small bytes.Buffer
+	png.Encode(&small, testImage(20, 10))
+	out, err = io.ReadAll(Reader(&small, Image(MaxSize(100, 100))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg, format, err := image.DecodeConfig(bytes.NewReader(out)); err != nil || format != "png" || cfg.Width != 20 {
+		t.Errorf("output = %s %dx%d, %v", format, cfg.Width, cfg.Height, err)
+	}
+
+	if _, err := io.ReadAll(Reader(strings.NewReader(strings.Repeat("not an image", 10000)), Image())); err == nil {
+		t.Error("non-image input: no error")
+	}
+	if _, err := Writer(io.Discard, Image(Format("bmp"))); err == nil {
+		t.Error("unknown format: no error")
+	}
+
+	// The dimensions are checked before any pixels are allocated.
+	_, err = io.ReadAll(Reader(bytes.NewReader(src.Bytes()), Image(MaxPixels(400*200-1))))
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("over MaxPixels: err = %v, want ErrImageTooLarge", err)
+	}
+	huge := bytes.Clone(src.Bytes()[:33]) // signature and IHDR
+	binary.BigEndian.PutUint32(huge[16:], 1<<20)
+	binary.BigEndian.PutUint32(huge[20:], 1<<20)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	if _, err := io.ReadAll(Reader(bytes.NewReader(huge), Image())); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("declared 1Mx1M: err = %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for x := range 4 {
+		c := uint8(0)
+		if x%2 == 1 {
+			c = 200
+		}
+		src.Set(x, 0, color.NRGBA{R: c, A: 255})
+		src.Set(x, 1, color.NRGBA{R: c, A: 255})
+	}
+	dst := downscale(src, 2, 0)
+	if b := dst.Bounds(); b.Dx() != 2 || b.Dy() != 1 {
+		t.Fatalf("size = %v", b)
+	}
+	if r, _, _, _ := dst.At(0, 0).RGBA(); r>>8 != 100 {
+		t.Errorf("averaged red = %d, want 100", r>>8)
+	}
+}
+
+func TestScan(t *testing.T) {
+	eicar := []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")
+	scan := Scan(func() Scanner { return Signatures(eicar) })
+
+	var out bytes.Buffer
+	w, _ := Writer(&out, scan)
+	w.Write([]byte("clean data, then EICAR-STANDARD-"))
+	_, err := w.Write([]byte("ANTIVIRUS-TEST-FILE and more"))
+	var rejected *RejectedError
+	if !errors.Is(err, ErrContentRejected) || !errors.As(err, &rejected) || rejected.Offset != 32 {
+		t.Fatalf("Write = %v", err)
+	}
+	if _, err := w.Write([]byte("x")); !errors.Is(err, ErrContentRejected) {
+		t.Errorf("Write after rejection = %v", err)
+	}
+	if out.String() != "clean data, then EICAR-STANDARD-" {
+		t.Errorf("passed on %q", out.String())
+	}
+
+	// Every stream gets a fresh scanner.
+	if got, err := io.ReadAll(Reader(strings.NewReader("TEST-FILE"), scan)); err != nil || string(got) != "TEST-FILE" {
+		t.Errorf("clean stream = %q, %v", got, err)
+	}
+
+	tooShort := errors.New("too short")
+	done := Scan(func() Scanner { return &lengthScanner{min: 10, err: tooShort} })
+	if _, err := io.ReadAll(Reader(strings.NewReader("short"), done)); !errors.Is(err, tooShort) || !errors.Is(err, ErrContentRejected) {
+		t.Errorf("Done rejection = %v", err)
+	}
+}
+
+type lengthScanner struct {
+	n, min int
+	err    error
+}
+
+func (s *lengthScanner) Scan(chunk []byte) error {
+	s.n += len(chunk)
+	return nil
+}
+
+func (s *lengthScanner) Done() error {
+	if s.n < s.min {
+		return s.err
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrContentRejected matches the *RejectedError of content a Scanner
+// rejected.
+var ErrContentRejected = errors.New("pipeline: content rejected")
+
+// RejectedError is returned by the Scan stage when its Scanner rejects the
+// content. The rejected chunk and anything after it is not passed on.
+type RejectedError struct {
+	Offset int64 // bytes accepted before the rejected chunk
+	Err    error // the Scanner's reason
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("pipeline: content rejected at byte %d: %v", e.Offset, e.Err)
+}
+
+func (e *RejectedError) Is(target error) bool { return target == ErrContentRejected }
+
+func (e *RejectedError) Unwrap() error { return e.Err }
+
+// Scanner examines content on its way into an upload, such as a virus
+// scanner or a data-loss filter, the client-side counterpart of an
+// uploadserver.Inspector. Scan sees every chunk in order before it is passed
+// on, and Done is called once the content ended; an error from either
+// rejects the content. A Scanner is used for one stream at a time.
+type Scanner interface {
+	Scan(chunk []byte) error
+	Done() error
+}
+
+// ScanFunc adapts a function to the Scanner interface; Done accepts.
+type ScanFunc func(chunk []byte) error
+
+func (f ScanFunc) Scan(chunk []byte) error { return f(chunk) }
+
+func (f ScanFunc) Done() error { return nil }
+
+// Scan returns a stage passing content through s. Content s rejects fails
+// the stage with a *RejectedError, which aborts an upload mid-stream.
+// newScanner is called for every stream, so stateful scanners start fresh.
+func Scan(newScanner func() Scanner) *Stage {
+	return New("scan", func(w io.Writer) (io.WriteCloser, error) {
+		return &scanWriter{w: w, s: newScanner()}, nil
+	})
+}
+
+type scanWriter struct {
+	w   io.Writer
+	s   Scanner
+	n   int64
+	err error // the rejection, once there was one
+}
+
+func (sw *scanWriter) Write(p []byte) (int, error) {
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	if err := sw.s.Scan(p); err != nil {
+		sw.err = &RejectedError{Offset: sw.n, Err: err}
+		return 0, sw.err
+	}
+	n, err := sw.w.Write(p)
+	sw.n += int64(n)
+	return n, err
+}
+
+func (sw *scanWriter) Close() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	if err := sw.s.Done(); err != nil {
+		sw.err = &RejectedError{Offset: sw.n, Err: err}
+		return sw.err
+	}
+	return nil
+}
+
+// Signatures returns a Scanner rejecting content that contains any of
+// sigs, also when one spans chunks, e.g. for the EICAR test string.
+func Signatures(sigs ...[]byte) Scanner {
+	longest := 0
+	for _, sig := range sigs {
+		longest = max(longest, len(sig))
+	}
+	return &signatures{sigs: sigs, keep: max(longest-1, 0)}
+}
+
+type signatures struct {
+	sigs [][]byte
+	keep int    // bytes of the previous chunks a signature may start in
+	tail []byte // the last keep bytes seen
+}
+
+func (s *signatures) Scan(chunk []byte) error {
+	window := append(s.tail, chunk...)
+	for _, sig := range s.sigs {
+		if len(sig) > 0 && bytes.Contains(window, sig) {
+			return fmt.Errorf("found signature %q", sig)
+		}
+	}
+	s.tail = append(s.tail[:0], window[max(len(window)-s.keep, 0):]...)
+	return nil
+}
+
+func (s *signatures) Done() error { return nil }
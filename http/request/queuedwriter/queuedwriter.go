@@ -0,0 +1,243 @@
+// Package queuedwriter makes a multipart.Writer safe for concurrent
+// callers. Where safewriter rejects overlapping calls, a queued Writer
+// accepts them: every part takes a place in a FIFO queue when it is
+// created and reaches the underlying writer as one piece, so code that
+// writes parts from several goroutines produces a well-formed body without
+// being restructured.
+//
+// The part at the head of the queue is streamed straight through; the
+// content of parts behind it is buffered until their turn comes. A part
+// is finished when it is closed, or at the latest when the Writer is.
+package queuedwriter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// ErrClosed is returned for operations after Close.
+var ErrClosed = errors.New("queuedwriter: writer closed")
+
+// Writer is a concurrency-safe facade over a *multipart.Writer with the
+// same methods.
+type Writer struct {
+	mw *multipart.Writer
+
+	mu     sync.Mutex
+	queue  []*Part // parts not yet written in full; queue[0] is being written
+	err    error   // first failure of the underlying writer
+	closed bool
+}
+
+// New returns a Writer serializing the use of mw.
+func New(mw *multipart.Writer) *Writer {
+	return &Writer{mw: mw}
+}
+
+// Boundary returns the boundary of the underlying writer.
+func (w *Writer) Boundary() string {
+	return w.mw.Boundary()
+}
+
+// FormDataContentType returns the Content-Type for an HTTP multipart/form-data
+// with the writer's boundary.
+func (w *Writer) FormDataContentType() string {
+	return w.mw.FormDataContentType()
+}
+
+// Future reports the completion of a queued part.
+type Future struct {
+	done chan struct{}
+	err  error
+}
+
+// Done is closed once the part was written to the underlying writer, or
+// failed.
+func (f *Future) Done() <-chan struct{} { return f.done }
+
+// Err returns the error of the part once Done is closed, and nil before.
+func (f *Future) Err() error {
+	select {
+	case <-f.done:
+		return f.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until Done is closed and returns Err.
+func (f *Future) Wait() error {
+	<-f.done
+	return f.err
+}
+
+// Part is a queued part. It embeds the Future of its completion.
+type Part struct {
+	*Future
+	w      *Writer
+	header textproto.MIMEHeader
+	buf    bytes.Buffer
+	out    io.Writer // the underlying part, once at the head of the queue
+	closed bool
+}
+
+// Write adds p to the part. It never blocks on other parts.
+func (p *Part) Write(b []byte) (int, error) {
+	w := p.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case p.closed:
+		return 0, fmt.Errorf("write to closed part: %w", ErrClosed)
+	case w.err != nil:
+		return 0, w.err
+	case p.out != nil:
+		n, err := p.out.Write(b)
+		if err != nil {
+			w.fail(err)
+		}
+		return n, err
+	}
+	return p.buf.Write(b)
+}
+
+// Close finishes the part, which lets the parts queued after it through.
+func (p *Part) Close() error {
+	w := p.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	p.closed = true
+	w.advance()
+	return nil
+}
+
+// enqueue adds a part to the queue.
+func (w *Writer) enqueue(header textproto.MIMEHeader, content string, closed bool) (*Part, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil, ErrClosed
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	p := &Part{Future: &Future{done: make(chan struct{})}, w: w, header: header, closed: closed}
+	p.buf.WriteString(content)
+	w.queue = append(w.queue, p)
+	w.advance()
+	return p, nil
+}
+
+// advance writes the parts at the head of the queue as far as possible.
+// It is called with w.mu held.
+func (w *Writer) advance() {
+	for len(w.queue) > 0 && w.err == nil {
+		p := w.queue[0]
+		if p.out == nil {
+			out, err := w.mw.CreatePart(p.header)
+			if err != nil {
+				w.fail(err)
+				return
+			}
+			p.out = out
+			if _, err := p.buf.WriteTo(out); err != nil {
+				w.fail(err)
+				return
+			}
+		}
+		if !p.closed {
+			return
+		}
+		close(p.done)
+		w.queue = w.queue[1:]
+	}
+}
+
+// fail records err and fails every queued part with it.
+func (w *Writer) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+	for _, p := range w.queue {
+		p.err = w.err
+		p.closed = true
+		close(p.done)
+	}
+	w.queue = nil
+}
+
+// SubmitField queues a form field and returns the Future of its writing.
+func (w *Writer) SubmitField(fieldname, value string) (*Future, error) {
+	p, err := w.enqueue(fieldHeader(fieldname, ""), value, true)
+	if err != nil {
+		return nil, err
+	}
+	return p.Future, nil
+}
+
+// WriteField queues a form field. It does not wait for the field to be
+// written; use SubmitField for that.
+func (w *Writer) WriteField(fieldname, value string) error {
+	_, err := w.SubmitField(fieldname, value)
+	return err
+}
+
+// CreateFormFile queues a file part. The returned writer is a *Part.
+func (w *Writer) CreateFormFile(fieldname, filename string) (io.Writer, error) {
+	h := fieldHeader(fieldname, filename)
+	h.Set("Content-Type", "application/octet-stream")
+	return w.CreatePart(h)
+}
+
+// CreateFormField queues a form field whose value is written to the
+// returned *Part.
+func (w *Writer) CreateFormField(fieldname string) (io.Writer, error) {
+	return w.CreatePart(fieldHeader(fieldname, ""))
+}
+
+// CreatePart queues a part with the given header. The returned writer is a
+// *Part.
+func (w *Writer) CreatePart(header textproto.MIMEHeader) (io.Writer, error) {
+	p, err := w.enqueue(header, "", false)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Close finishes every queued part in order and closes the underlying
+// writer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = true
+	for _, p := range w.queue {
+		p.closed = true
+	}
+	w.advance()
+	if w.err != nil {
+		return w.err
+	}
+	return w.mw.Close()
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func fieldHeader(fieldname, filename string) textproto.MIMEHeader {
+	disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(fieldname))
+	if filename != "" {
+		disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(filename))
+	}
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", disposition)
+	return h
+}
@@ -0,0 +1,125 @@
+package queuedwriter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func readParts(t *testing.T, body []byte, boundary string) map[string]string {
+	t.Helper()
+	parts := map[string]string{}
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(p)
+		parts[p.FormName()] = string(data)
+	}
+}
+
+func TestConcurrentParts(t *testing.T) {
+	var buf bytes.Buffer
+	w := New(multipart.NewWriter(&buf))
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("file%d", i)
+			fw, err := w.CreateFormFile(name, name+".txt")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for range 10 {
+				fw.Write([]byte(name + ";"))
+				runtime.Gosched()
+			}
+			if i%2 == 0 {
+				// Odd parts are left open, as careless callers do.
+				fw.(*Part).Close()
+			}
+			w.WriteField(fmt.Sprintf("field%d", i), "v")
+		}()
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	parts := readParts(t, buf.Bytes(), w.Boundary())
+	if len(parts) != 40 {
+		t.Fatalf("got %d parts", len(parts))
+	}
+	for i := range 20 {
+		name := fmt.Sprintf("file%d", i)
+		if got, want := parts[name], strings.Repeat(name+";", 10); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestFutures(t *testing.T) {
+	var buf bytes.Buffer
+	w := New(multipart.NewWriter(&buf))
+
+	first, _ := w.CreateFormFile("first", "1.txt")
+	field, err := w.SubmitField("second", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-field.Done():
+		t.Fatal("field written before the open part ahead of it")
+	default:
+	}
+	first.Write([]byte("one"))
+	first.(*Part).Close()
+	if err := field.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.(*Part).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Write([]byte("late")); !errors.Is(err, ErrClosed) {
+		t.Errorf("write to closed part: err = %v", err)
+	}
+	w.Close()
+	if err := w.WriteField("late", "x"); !errors.Is(err, ErrClosed) {
+		t.Errorf("WriteField after Close: err = %v", err)
+	}
+	if got := readParts(t, buf.Bytes(), w.Boundary()); got["first"] != "one" || got["second"] != "2" {
+		t.Errorf("parts = %v", got)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestUnderlyingError(t *testing.T) {
+	w := New(multipart.NewWriter(failingWriter{}))
+	open, _ := w.CreateFormFile("a", "a.txt")
+	if err := open.(*Part).Wait(); err == nil {
+		t.Error("part did not fail")
+	}
+	if _, err := w.SubmitField("b", "2"); err == nil {
+		t.Error("field after the failure was queued")
+	}
+	if err := w.Close(); err == nil {
+		t.Error("Close did not fail")
+	}
+}
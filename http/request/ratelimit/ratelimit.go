@@ -0,0 +1,203 @@
+// Package ratelimit shares request and bandwidth quotas between all the
+// uploads of a process. A Limiter allows a number of requests per second
+// and bytes per second; any number of builders and clients can reference
+// the same one, so a bulk-upload job respects an API's global quota rather
+// than throttling each request on its own.
+//
+// Limiters registered for a host with Register are picked up by every
+// upload builder sending to that host:
+//
+//	ratelimit.Register("api.example.com", ratelimit.New(10, 50<<20))
+//
+// The limits use token buckets with a burst of one second of traffic.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limiter limits requests per second and bytes per second. It is safe for
+// concurrent use.
+type Limiter struct {
+	requests *bucket
+	bytes    *bucket
+}
+
+// New returns a Limiter allowing requestsPerSec requests and bytesPerSec
+// body bytes per second. Zero or less means that dimension is unlimited.
+func New(requestsPerSec float64, bytesPerSec int64) *Limiter {
+	return &Limiter{requests: newBucket(requestsPerSec), bytes: newBucket(float64(bytesPerSec))}
+}
+
+// WaitRequest blocks until another request may start or ctx is done.
+func (l *Limiter) WaitRequest(ctx context.Context) error {
+	return l.requests.wait(ctx, 1)
+}
+
+// WaitBytes blocks until n more bytes may be sent or ctx is done.
+func (l *Limiter) WaitBytes(ctx context.Context, n int) error {
+	return l.bytes.wait(ctx, float64(n))
+}
+
+// Reader returns r throttled to the limiter's bandwidth. Reads fail with
+// ctx's error once it is done.
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l.bytes == nil {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, l: l}
+}
+
+// Transport wraps base (http.DefaultTransport if nil) so every request
+// waits for the request quota and its body is throttled to the bandwidth.
+func (l *Limiter) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base, l: l}
+}
+
+// Body wraps a request body so the first Read waits for the request quota
+// and every Read for the bandwidth, for callers that cannot swap the
+// transport, such as the upload builder.
+func (l *Limiter) Body(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	return &requestBody{ReadCloser: body, r: l.Reader(ctx, body), ctx: ctx, l: l}
+}
+
+type requestBody struct {
+	io.ReadCloser
+	r       io.Reader
+	ctx     context.Context
+	l       *Limiter
+	started bool
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	if !b.started {
+		b.started = true
+		if err := b.l.WaitRequest(b.ctx); err != nil {
+			return 0, err
+		}
+	}
+	return b.r.Read(p)
+}
+
+type transport struct {
+	base http.RoundTripper
+	l    *Limiter
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.l.WaitRequest(req.Context()); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && t.l.bytes != nil {
+		req = req.Clone(req.Context())
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{t.l.Reader(req.Context(), req.Body), req.Body}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// maxChunk bounds a single throttled read, so large reads do not wait for
+// a long reservation at once.
+const maxChunk = 32 << 10
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > maxChunk {
+		p = p[:maxChunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.WaitBytes(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// bucket is a token bucket that lets callers go into debt: a wait for n
+// tokens reserves them at once and sleeps until the debt is repaid, which
+// serves concurrent callers in order and handles n above the burst.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newBucket returns nil, an unlimited bucket, for rate <= 0.
+func newBucket(rate float64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := max(rate, 1)
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *bucket) wait(ctx context.Context, n float64) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= n
+	debt := -b.tokens
+	b.mu.Unlock()
+	if debt <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(debt / b.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens += n // give the reservation back
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+var registry = struct {
+	sync.RWMutex
+	m map[string]*Limiter
+}{m: map[string]*Limiter{}}
+
+// Register sets the process-wide limiter for host, as in a URL's Host
+// ("api.example.com" or "api.example.com:8443"). A nil l removes it.
+func Register(host string, l *Limiter) {
+	registry.Lock()
+	defer registry.Unlock()
+	if l == nil {
+		delete(registry.m, host)
+		return
+	}
+	registry.m[host] = l
+}
+
+// ForHost returns the limiter registered for host, or nil.
+func ForHost(host string) *Limiter {
+	registry.RLock()
+	defer registry.RUnlock()
+	return registry.m[host]
+}
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestRate(t *testing.T) {
+	l := New(50, 0)
+	start := time.Now()
+	for range 60 { // the burst of 50, then 10 at 20ms intervals
+		if err := l.WaitRequest(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("60 requests at 50/s took %v", d)
+	}
+}
+
+func TestReaderBandwidth(t *testing.T) {
+	l := New(0, 100<<10)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, l.Reader(context.Background(), bytes.NewReader(make([]byte, 150<<10))))
+	if err != nil || n != 150<<10 {
+		t.Fatalf("copied %d, %v", n, err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond || d > 2*time.Second {
+		t.Errorf("150 KiB at 100 KiB/s with a 100 KiB burst took %v", d)
+	}
+}
+
+func TestCanceledWait(t *testing.T) {
+	l := New(1, 0)
+	l.WaitRequest(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.WaitRequest(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitRequest = %v", err)
+	}
+}
+
+func TestSharedTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer srv.Close()
+
+	// Two clients sharing one limiter split its quota.
+	l := New(10, 0)
+	a := &http.Client{Transport: l.Transport(srv.Client().Transport)}
+	b := &http.Client{Transport: l.Transport(srv.Client().Transport)}
+	start := time.Now()
+	for i := range 14 {
+		c := a
+		if i%2 == 1 {
+			c = b
+		}
+		resp, err := c.Post(srv.URL, "text/plain", strings.NewReader("x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("14 requests at a shared 10/s took %v", d)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	l := New(1, 1)
+	Register("api.example.com", l)
+	if ForHost("api.example.com") != l || ForHost("other.example.com") != nil {
+		t.Error("registry lookup failed")
+	}
+	Register("api.example.com", nil)
+	if ForHost("api.example.com") != nil {
+		t.Error("limiter not removed")
+	}
+}
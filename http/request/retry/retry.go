@@ -0,0 +1,130 @@
+// Package retry computes the delay between attempts of a failed request.
+// The delay doubles with every attempt, up to a cap, and is jittered so a
+// fleet of clients does not retry in lockstep. When the server says when
+// to come back, in Retry-After or RateLimit-Reset, its answer wins over
+// the computed backoff:
+//
+//	p := retry.New(retry.BaseDelay(time.Second), retry.MaxDelay(time.Minute),
+//		retry.OnRetry(func(attempt int, wait time.Duration, cause error) { ... }))
+//	err := p.Wait(ctx, attempt, resp.Header, err)
+package retry
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of New.
+const (
+	DefaultBaseDelay = time.Second
+	DefaultJitter    = 0.2
+)
+
+// Option configures a Policy.
+type Option func(*Policy)
+
+// BaseDelay sets the delay before the first retry. It doubles with every
+// failed attempt.
+func BaseDelay(d time.Duration) Option {
+	return func(p *Policy) { p.base = d }
+}
+
+// MaxDelay caps every delay, including those asked for by the server. It
+// defaults to 64 times the base delay.
+func MaxDelay(d time.Duration) Option {
+	return func(p *Policy) { p.cap = d }
+}
+
+// Jitter sets the random share of a delay, between 0 and 1. Computed
+// delays are shortened by up to that share; delays asked for by the server
+// are lengthened by it, so retries never come earlier than asked.
+func Jitter(f float64) Option {
+	return func(p *Policy) { p.jitter = min(max(f, 0), 1) }
+}
+
+// OnRetry registers fn to be called with every delay the policy hands out,
+// e.g. for logging. attempt is the number of failed attempts so far and
+// cause the error of the last one.
+func OnRetry(fn func(attempt int, wait time.Duration, cause error)) Option {
+	return func(p *Policy) { p.onRetry = fn }
+}
+
+// Policy is an exponential backoff that honors server hints. It is safe
+// for concurrent use.
+type Policy struct {
+	base    time.Duration
+	cap     time.Duration
+	jitter  float64
+	onRetry func(attempt int, wait time.Duration, cause error)
+	now     func() time.Time
+}
+
+// New returns a policy with the given options.
+func New(opts ...Option) *Policy {
+	p := &Policy{base: DefaultBaseDelay, jitter: DefaultJitter, now: time.Now}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.cap <= 0 {
+		p.cap = p.base << 6
+	}
+	return p
+}
+
+// Next returns how long to wait after attempt failed attempts, reading
+// server hints from h, which may be nil, and reports the delay to the
+// OnRetry callback.
+func (p *Policy) Next(attempt int, h http.Header, cause error) time.Duration {
+	wait, hinted := After(h, p.now())
+	if hinted {
+		wait += time.Duration(float64(wait) * p.jitter * rand.Float64())
+		wait = min(wait, p.cap)
+	} else {
+		wait = p.base
+		for i := 1; i < attempt && wait < p.cap; i++ {
+			wait *= 2
+		}
+		wait = min(wait, p.cap)
+		wait -= time.Duration(float64(wait) * p.jitter * rand.Float64())
+	}
+	if p.onRetry != nil {
+		p.onRetry(attempt, wait, cause)
+	}
+	return wait
+}
+
+// Wait sleeps for Next(attempt, h, cause), or until ctx is done.
+func (p *Policy) Wait(ctx context.Context, attempt int, h http.Header, cause error) error {
+	timer := time.NewTimer(p.Next(attempt, h, cause))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// After returns the delay a server asks for in h, and false when it asks
+// for none. Retry-After is read as seconds or an HTTP date; RateLimit-Reset
+// as the seconds until the quota resets. Dates in the past yield zero.
+func After(h http.Header, now time.Time) (time.Duration, bool) {
+	if v := strings.TrimSpace(h.Get("Retry-After")); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second, true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return max(t.Sub(now), 0), true
+		}
+	}
+	if v := strings.TrimSpace(h.Get("RateLimit-Reset")); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,73 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	var calls []time.Duration
+	p := New(BaseDelay(time.Second), MaxDelay(5*time.Second), Jitter(0),
+		OnRetry(func(attempt int, wait time.Duration, cause error) { calls = append(calls, wait) }))
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := p.Next(attempt+1, nil, nil); got != want {
+			t.Errorf("attempt %d: Next = %v, want %v", attempt+1, got, want)
+		}
+	}
+	if len(calls) != 5 {
+		t.Errorf("OnRetry called %d times, want 5", len(calls))
+	}
+
+	if got := p.Next(1, http.Header{"Retry-After": {"3"}}, nil); got != 3*time.Second {
+		t.Errorf("Retry-After: Next = %v, want 3s", got)
+	}
+	if got := p.Next(1, http.Header{"Retry-After": {"3600"}}, nil); got != 5*time.Second {
+		t.Errorf("capped Retry-After: Next = %v, want 5s", got)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	p := New(BaseDelay(time.Second), Jitter(0.5))
+	for range 100 {
+		if got := p.Next(1, nil, nil); got < 500*time.Millisecond || got > time.Second {
+			t.Fatalf("computed delay %v outside [0.5s, 1s]", got)
+		}
+		if got := p.Next(1, http.Header{"Retry-After": {"2"}}, nil); got < 2*time.Second || got > 3*time.Second {
+			t.Fatalf("hinted delay %v outside [2s, 3s]", got)
+		}
+	}
+}
+
+func TestAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		header http.Header
+		want   time.Duration
+		ok     bool
+	}{
+		{http.Header{"Retry-After": {"120"}}, 2 * time.Minute, true},
+		{http.Header{"Retry-After": {now.Add(time.Minute).Format(http.TimeFormat)}}, time.Minute, true},
+		{http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}}, 0, true},
+		{http.Header{"Ratelimit-Reset": {"30"}}, 30 * time.Second, true},
+		{http.Header{"Retry-After": {"soon"}}, 0, false},
+		{nil, 0, false},
+	} {
+		if got, ok := After(tc.header, now); got != tc.want || ok != tc.ok {
+			t.Errorf("After(%v) = %v, %v, want %v, %v", tc.header, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := New(BaseDelay(time.Hour)).Wait(ctx, 1, nil, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait = %v, want context.Canceled", err)
+	}
+	if err := New(BaseDelay(time.Millisecond)).Wait(context.Background(), 1, nil, nil); err != nil {
+		t.Errorf("Wait = %v", err)
+	}
+}
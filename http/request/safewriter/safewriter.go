@@ -0,0 +1,133 @@
+// Package safewriter provides a guard around multipart.Writer that detects
+// concurrent use instead of silently corrupting the multipart boundaries.
+package safewriter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"sync/atomic"
+)
+
+// ErrConcurrentWrite is returned when an operation is attempted while another
+// goroutine is still using the same multipart.Writer or one of its parts.
+var ErrConcurrentWrite = errors.New("safewriter: concurrent use of multipart writer")
+
+// SafeWriter wraps a *multipart.Writer. Every operation takes ownership of
+// the writer with an atomic compare-and-swap; a caller that finds the writer
+// already owned gets ErrConcurrentWrite and nothing is written.
+type SafeWriter struct {
+	mw       *multipart.Writer
+	busy     atomic.Bool
+	misuses  atomic.Int64
+	lastPart *part
+}
+
+// New returns a SafeWriter guarding mw.
+func New(mw *multipart.Writer) *SafeWriter {
+	return &SafeWriter{mw: mw}
+}
+
+// Misuses reports how many operations were rejected because of concurrent use.
+func (w *SafeWriter) Misuses() int64 {
+	return w.misuses.Load()
+}
+
+// Boundary returns the boundary of the underlying writer.
+func (w *SafeWriter) Boundary() string {
+	return w.mw.Boundary()
+}
+
+// FormDataContentType returns the Content-Type for an HTTP multipart/form-data
+// with the writer's boundary.
+func (w *SafeWriter) FormDataContentType() string {
+	return w.mw.FormDataContentType()
+}
+
+func (w *SafeWriter) acquire(op string) error {
+	if !w.busy.CompareAndSwap(false, true) {
+		w.misuses.Add(1)
+		return fmt.Errorf("%s: %w", op, ErrConcurrentWrite)
+	}
+	return nil
+}
+
+func (w *SafeWriter) release() {
+	w.busy.Store(false)
+}
+
+// WriteField calls WriteField on the underlying writer.
+func (w *SafeWriter) WriteField(fieldname, value string) error {
+	if err := w.acquire("WriteField " + fieldname); err != nil {
+		return err
+	}
+	defer w.release()
+	w.lastPart = nil
+	return w.mw.WriteField(fieldname, value)
+}
+
+// CreateFormFile calls CreateFormFile on the underlying writer. Writes to the
+// returned part are guarded as well.
+func (w *SafeWriter) CreateFormFile(fieldname, filename string) (io.Writer, error) {
+	return w.create("CreateFormFile "+fieldname, func() (io.Writer, error) {
+		return w.mw.CreateFormFile(fieldname, filename)
+	})
+}
+
+// CreateFormField calls CreateFormField on the underlying writer.
+func (w *SafeWriter) CreateFormField(fieldname string) (io.Writer, error) {
+	return w.create("CreateFormField "+fieldname, func() (io.Writer, error) {
+		return w.mw.CreateFormField(fieldname)
+	})
+}
+
+// CreatePart calls CreatePart on the underlying writer.
+func (w *SafeWriter) CreatePart(header textproto.MIMEHeader) (io.Writer, error) {
+	return w.create("CreatePart", func() (io.Writer, error) {
+		return w.mw.CreatePart(header)
+	})
+}
+
+func (w *SafeWriter) create(op string, fn func() (io.Writer, error)) (io.Writer, error) {
+	if err := w.acquire(op); err != nil {
+		return nil, err
+	}
+	defer w.release()
+	pw, err := fn()
+	if err != nil {
+		return nil, err
+	}
+	w.lastPart = &part{sw: w, w: pw}
+	return w.lastPart, nil
+}
+
+// Close calls Close on the underlying writer.
+func (w *SafeWriter) Close() error {
+	if err := w.acquire("Close"); err != nil {
+		return err
+	}
+	defer w.release()
+	w.lastPart = nil
+	return w.mw.Close()
+}
+
+type part struct {
+	sw *SafeWriter
+	w  io.Writer
+}
+
+func (p *part) Write(b []byte) (int, error) {
+	if err := p.sw.acquire("Write"); err != nil {
+		return 0, err
+	}
+	defer p.sw.release()
+	if p.sw.lastPart != p {
+		// Writing to a part after another one was started lands the bytes
+		// inside the wrong part; treat it as the same class of misuse.
+		p.sw.misuses.Add(1)
+		return 0, fmt.Errorf("write to finished part: %w", ErrConcurrentWrite)
+	}
+	return p.w.Write(b)
+}
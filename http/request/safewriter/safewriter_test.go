@@ -0,0 +1,67 @@
+package safewriter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"testing"
+)
+
+func TestSequentialUse(t *testing.T) {
+	var buf bytes.Buffer
+	w := New(multipart.NewWriter(&buf))
+
+	if err := w.WriteField("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	fw, err := w.CreateFormFile("file", "f.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Misuses() != 0 {
+		t.Errorf("Misuses() = %d, want 0", w.Misuses())
+	}
+}
+
+func TestConcurrentUseDetected(t *testing.T) {
+	pr, pw := io.Pipe()
+	w := New(multipart.NewWriter(pw))
+
+	// The pipe has no reader yet, so the first WriteField blocks while it
+	// owns the writer.
+	started := make(chan error, 1)
+	go func() { started <- w.WriteField("slow", "value") }()
+	for !w.busy.Load() {
+	}
+
+	if err := w.WriteField("racing", "value"); !errors.Is(err, ErrConcurrentWrite) {
+		t.Fatalf("WriteField error = %v, want ErrConcurrentWrite", err)
+	}
+	if w.Misuses() != 1 {
+		t.Errorf("Misuses() = %d, want 1", w.Misuses())
+	}
+
+	go io.Copy(io.Discard, pr)
+	if err := <-started; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteToFinishedPart(t *testing.T) {
+	w := New(multipart.NewWriter(io.Discard))
+
+	first, _ := w.CreateFormFile("first", "1.txt")
+	if _, err := w.CreateFormFile("second", "2.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Write([]byte("late")); !errors.Is(err, ErrConcurrentWrite) {
+		t.Errorf("Write error = %v, want ErrConcurrentWrite", err)
+	}
+}
@@ -0,0 +1,194 @@
+// Package slowtransport simulates a slow or flaky network in tests. It
+// wraps an http.RoundTripper, such as an httptest server's or a
+// mockhttp.Transport, and adds latency, jitter, bandwidth caps and
+// connection resets in the middle of a body:
+//
+//	client := &http.Client{Transport: slowtransport.New(srv.Client().Transport,
+//		slowtransport.Latency(50*time.Millisecond),
+//		slowtransport.UploadBandwidth(64<<10),
+//		slowtransport.ResetUploadAfter(1<<20))}
+//
+// Jitter is drawn from a generator seeded with Seed, so runs are
+// reproducible.
+package slowtransport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrReset is the error of a simulated connection reset. It matches
+// syscall.ECONNRESET with errors.Is, like a real reset would.
+var ErrReset = fmt.Errorf("slowtransport: %w", syscall.ECONNRESET)
+
+type config struct {
+	latency       time.Duration
+	jitter        time.Duration
+	seed          uint64
+	upload        int64 // bytes per second, 0 for unlimited
+	download      int64
+	resetUpload   int64 // byte offset, -1 for never
+	resetDownload int64
+}
+
+// Option configures New.
+type Option func(*config)
+
+// Latency delays every request before it is sent and every response
+// before it is returned, like a round trip on a distant network.
+func Latency(d time.Duration) Option {
+	return func(c *config) { c.latency = d }
+}
+
+// Jitter adds a random delay in [0, d) to each latency.
+func Jitter(d time.Duration) Option {
+	return func(c *config) { c.jitter = d }
+}
+
+// Seed seeds the generator of Jitter. The default seed is 1.
+func Seed(seed uint64) Option {
+	return func(c *config) { c.seed = seed }
+}
+
+// UploadBandwidth caps request bodies to bytesPerSec.
+func UploadBandwidth(bytesPerSec int64) Option {
+	return func(c *config) { c.upload = bytesPerSec }
+}
+
+// DownloadBandwidth caps response bodies to bytesPerSec.
+func DownloadBandwidth(bytesPerSec int64) Option {
+	return func(c *config) { c.download = bytesPerSec }
+}
+
+// ResetUploadAfter fails every request with ErrReset once n bytes of its
+// body were sent.
+func ResetUploadAfter(n int64) Option {
+	return func(c *config) { c.resetUpload = n }
+}
+
+// ResetDownloadAfter fails reading every response body with ErrReset once
+// n bytes were read.
+func ResetDownloadAfter(n int64) Option {
+	return func(c *config) { c.resetDownload = n }
+}
+
+// Transport is the RoundTripper returned by New.
+type Transport struct {
+	base http.RoundTripper
+	cfg  config
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// New returns a Transport sending requests through base
+// (http.DefaultTransport if nil) under the simulated conditions.
+func New(base http.RoundTripper, opts ...Option) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	cfg := config{seed: 1, resetUpload: -1, resetDownload: -1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &Transport{base: base, cfg: cfg, rng: rand.New(rand.NewPCG(cfg.seed, cfg.seed))}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if err := sleep(ctx, t.delay()); err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && (t.cfg.upload > 0 || t.cfg.resetUpload >= 0) {
+		req = req.Clone(ctx)
+		req.Body = &body{ReadCloser: req.Body, ctx: ctx, rate: t.cfg.upload, resetAt: t.cfg.resetUpload}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := sleep(ctx, t.delay()); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if t.cfg.download > 0 || t.cfg.resetDownload >= 0 {
+		resp.Body = &body{ReadCloser: resp.Body, ctx: ctx, rate: t.cfg.download, resetAt: t.cfg.resetDownload}
+	}
+	return resp, nil
+}
+
+func (t *Transport) delay() time.Duration {
+	d := t.cfg.latency
+	if t.cfg.jitter > 0 {
+		t.mu.Lock()
+		d += time.Duration(t.rng.Int64N(int64(t.cfg.jitter)))
+		t.mu.Unlock()
+	}
+	return d
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// maxChunk bounds a single read so throttled bodies flow smoothly.
+const maxChunk = 4 << 10
+
+// body paces reads to rate bytes per second and fails at resetAt.
+type body struct {
+	io.ReadCloser
+	ctx     context.Context
+	rate    int64
+	resetAt int64
+
+	start time.Time
+	n     int64
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.resetAt >= 0 {
+		if b.n >= b.resetAt {
+			return 0, ErrReset
+		}
+		p = p[:min(int64(len(p)), b.resetAt-b.n)]
+	}
+	if b.rate > 0 && len(p) > maxChunk {
+		p = p[:maxChunk]
+	}
+	if b.start.IsZero() {
+		b.start = time.Now()
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if b.rate > 0 && n > 0 {
+		due := time.Duration(float64(b.n) / float64(b.rate) * float64(time.Second))
+		if serr := sleep(b.ctx, due-time.Since(b.start)); serr != nil {
+			return n, serr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,78 @@
+package slowtransport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func echoServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body) // HTTP/1 cannot answer while the body is still arriving
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestLatencyAndBandwidth(t *testing.T) {
+	srv := echoServer(t)
+	client := &http.Client{Transport: New(srv.Client().Transport,
+		Latency(50*time.Millisecond), UploadBandwidth(100<<10), DownloadBandwidth(100<<10))}
+
+	payload := bytes.Repeat([]byte("x"), 20<<10)
+	start := time.Now()
+	resp, err := client.Post(srv.URL, "application/octet-stream", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("echo = %d bytes, %v", len(got), err)
+	}
+	// 2×50ms latency plus 200ms each way for 20 KiB at 100 KiB/s, partly overlapping.
+	if d := time.Since(start); d < 250*time.Millisecond || d > 2*time.Second {
+		t.Errorf("round trip took %v", d)
+	}
+}
+
+func TestJitterIsSeeded(t *testing.T) {
+	delays := func() []time.Duration {
+		tr := New(nil, Jitter(time.Second), Seed(42))
+		return []time.Duration{tr.delay(), tr.delay(), tr.delay()}
+	}
+	a, b := delays(), delays()
+	for i := range a {
+		if a[i] != b[i] || a[i] >= time.Second {
+			t.Fatalf("delays %v and %v differ or exceed the jitter", a, b)
+		}
+	}
+}
+
+func TestResets(t *testing.T) {
+	srv := echoServer(t)
+	payload := bytes.Repeat([]byte("x"), 64<<10)
+
+	client := &http.Client{Transport: New(srv.Client().Transport, ResetUploadAfter(1000))}
+	_, err := client.Post(srv.URL, "application/octet-stream", io.MultiReader(bytes.NewReader(payload)))
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("upload reset: err = %v", err)
+	}
+
+	client = &http.Client{Transport: New(srv.Client().Transport, ResetDownloadAfter(1000))}
+	resp, err := client.Post(srv.URL, "application/octet-stream", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(got) != 1000 || !errors.Is(err, ErrReset) {
+		t.Errorf("download reset: read %d bytes, err = %v", len(got), err)
+	}
+}
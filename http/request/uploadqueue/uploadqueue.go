@@ -0,0 +1,459 @@
+// Package uploadqueue spools multipart uploads to a directory and sends
+// them in the background, retrying until they succeed. Jobs survive
+// process restarts, which makes it suitable for edge agents with
+// intermittent connectivity:
+//
+//	q, err := uploadqueue.Open("/var/spool/uploads", client, uploadqueue.Workers(2))
+//	id, err := q.Enqueue(uploadqueue.Job{URL: url, Field: "file", Filename: "log.gz"}, body)
+//	go q.Run(ctx)
+//
+// Each job is stored as two files: <id>.json with its metadata and
+// <id>.body with the file content. Both are written to temporary names and
+// renamed, so a crash never leaves a half-written job behind.
+package uploadqueue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isauran/go-std-library/http/request/retry"
+	"github.com/isauran/go-std-library/sync/waitgroup/taskgroup"
+)
+
+// Defaults of Open.
+const (
+	DefaultWorkers     = 1
+	DefaultRetryDelay  = 5 * time.Second
+	DefaultMaxAttempts = 0 // retry forever
+)
+
+// ErrNotFound is returned for unknown or already finished job IDs.
+var ErrNotFound = errors.New("uploadqueue: job not found")
+
+// State is the state of a job.
+type State string
+
+const (
+	// Pending jobs wait for their next attempt.
+	Pending State = "pending"
+	// Running jobs are being uploaded.
+	Running State = "running"
+	// Failed jobs were rejected by the server or ran out of attempts. They
+	// are kept for inspection until canceled.
+	Failed State = "failed"
+)
+
+// Job describes an upload: the body is sent as file part Field named
+// Filename, after the form fields in Fields.
+type Job struct {
+	ID       string            `json:"id"`
+	Method   string            `json:"method"` // POST if empty
+	URL      string            `json:"url"`
+	Header   http.Header       `json:"header,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Field    string            `json:"field"`
+	Filename string            `json:"filename"`
+
+	State       State     `json:"state"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+type config struct {
+	workers     int
+	retryDelay  time.Duration
+	maxAttempts int
+	backoff     *retry.Policy
+}
+
+// Option configures a Queue.
+type Option func(*config)
+
+// Workers sets how many uploads run at once.
+func Workers(n int) Option {
+	return func(c *config) { c.workers = max(n, 1) }
+}
+
+// RetryDelay sets the delay before the first retry. It doubles with every
+// failed attempt, up to 64 times the initial delay.
+func RetryDelay(d time.Duration) Option {
+	return func(c *config) { c.retryDelay = d }
+}
+
+// Backoff sets the policy spacing retries, replacing RetryDelay. The
+// default policy already waits as long as a 429 or 503 response asks for
+// in Retry-After or RateLimit-Reset.
+func Backoff(p *retry.Policy) Option {
+	return func(c *config) { c.backoff = p }
+}
+
+// MaxAttempts marks jobs as Failed after n failed attempts. Zero retries
+// forever.
+func MaxAttempts(n int) Option {
+	return func(c *config) { c.maxAttempts = n }
+}
+
+// Queue is a directory of upload jobs. It is safe for concurrent use.
+type Queue struct {
+	dir    string
+	client *http.Client
+	cfg    config
+
+	mu      sync.Mutex
+	jobs    map[string]*entry
+	changed chan struct{} // closed and replaced when jobs change
+}
+
+type entry struct {
+	job    Job
+	cancel context.CancelFunc // set while running
+}
+
+// Open opens the queue in dir, creating the directory if needed, and loads
+// the jobs left by a previous process. Jobs that were running when it
+// stopped are pending again. A nil client means http.DefaultClient.
+func Open(dir string, client *http.Client, opts ...Option) (*Queue, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	cfg := config{workers: DefaultWorkers, retryDelay: DefaultRetryDelay, maxAttempts: DefaultMaxAttempts}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.backoff == nil {
+		cfg.backoff = retry.New(retry.BaseDelay(cfg.retryDelay))
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+	q := &Queue{dir: dir, client: client, cfg: cfg, jobs: map[string]*entry{}, changed: make(chan struct{})}
+
+	metas, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range metas {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load job: %w", err)
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			return nil, fmt.Errorf("failed to load job %s: %w", filepath.Base(path), err)
+		}
+		if job.State == Running {
+			job.State = Pending
+		}
+		q.jobs[job.ID] = &entry{job: job}
+	}
+	return q, nil
+}
+
+// Enqueue stores a job with the content of body and returns its ID. The
+// ID, state and attempt fields of job are set by the queue.
+func (q *Queue) Enqueue(job Job, body io.Reader) (string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+	job.ID, job.State, job.Attempts, job.LastError = id, Pending, 0, ""
+	job.Created = time.Now()
+	job.NextAttempt = job.Created
+	if job.Method == "" {
+		job.Method = http.MethodPost
+	}
+
+	if err := writeAtomic(q.bodyPath(id), func(f *os.File) error {
+		_, err := io.Copy(f, body)
+		return err
+	}); err != nil {
+		return "", fmt.Errorf("failed to spool job body: %w", err)
+	}
+	if err := q.save(job); err != nil {
+		os.Remove(q.bodyPath(id))
+		return "", err
+	}
+
+	q.mu.Lock()
+	q.jobs[id] = &entry{job: job}
+	q.notifyLocked()
+	q.mu.Unlock()
+	return id, nil
+}
+
+// Jobs returns the jobs in the queue, oldest first. Finished jobs are
+// removed from the queue and not reported.
+func (q *Queue) Jobs() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]Job, 0, len(q.jobs))
+	for _, e := range q.jobs {
+		jobs = append(jobs, e.job)
+	}
+	slices.SortFunc(jobs, func(a, b Job) int { return a.Created.Compare(b.Created) })
+	return jobs
+}
+
+// Job returns the job with the given ID.
+func (q *Queue) Job(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return e.job, nil
+}
+
+// Cancel removes a job from the queue, aborting its upload if it is
+// running.
+func (q *Queue) Cancel(id string) error {
+	q.mu.Lock()
+	e, ok := q.jobs[id]
+	if ok {
+		delete(q.jobs, id)
+		if e.cancel != nil {
+			e.cancel()
+		}
+	}
+	q.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	return q.remove(id)
+}
+
+// Run uploads jobs with the configured number of workers until ctx is
+// canceled. Uploads in progress are aborted and stay in the queue. A panic
+// in a worker stops the others and is returned as a *taskgroup.PanicError.
+func (q *Queue) Run(ctx context.Context) error {
+	g, _ := taskgroup.New(ctx)
+	for range q.cfg.workers {
+		g.GoErr(func(ctx context.Context) error {
+			q.work(ctx)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, jobCtx, wait, changed := q.next(ctx)
+		if job == nil {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+			case <-changed:
+			case <-timer.C:
+			}
+			timer.Stop()
+			continue
+		}
+		header, err := q.upload(jobCtx, job)
+		q.finish(ctx, job, header, err)
+	}
+}
+
+// next claims the pending job that is due first. If none is due it
+// returns how long to wait and a channel closed when jobs change.
+func (q *Queue) next(ctx context.Context) (*Job, context.Context, time.Duration, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var due *entry
+	wait := time.Hour
+	now := time.Now()
+	for _, e := range q.jobs {
+		if e.job.State != Pending {
+			continue
+		}
+		if d := e.job.NextAttempt.Sub(now); d > 0 {
+			wait = min(wait, d)
+			continue
+		}
+		if due == nil || e.job.Created.Before(due.job.Created) {
+			due = e
+		}
+	}
+	if due == nil {
+		return nil, nil, wait, q.changed
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	due.job.State, due.cancel = Running, cancel
+	job := due.job
+	return &job, jobCtx, 0, nil
+}
+
+// finish records the outcome of an attempt. header is the response
+// header of a failed attempt, nil if there was no response.
+func (q *Queue) finish(ctx context.Context, job *Job, header http.Header, err error) {
+	q.mu.Lock()
+	e, ok := q.jobs[job.ID]
+	if !ok { // canceled meanwhile
+		q.mu.Unlock()
+		return
+	}
+	e.cancel()
+	e.cancel = nil
+	if err == nil {
+		delete(q.jobs, job.ID)
+		q.mu.Unlock()
+		q.remove(job.ID)
+		return
+	}
+
+	j := &e.job
+	j.State = Pending
+	if ctx.Err() == nil { // not a shutdown
+		j.Attempts++
+		j.LastError = err.Error()
+		j.NextAttempt = time.Now().Add(q.cfg.backoff.Next(j.Attempts, header, err))
+		var perm permanentError
+		if errors.As(err, &perm) || (q.cfg.maxAttempts > 0 && j.Attempts >= q.cfg.maxAttempts) {
+			j.State = Failed
+		}
+	}
+	// Saved under the lock so a concurrent Cancel cannot be undone.
+	q.save(*j)
+	q.notifyLocked()
+	q.mu.Unlock()
+}
+
+// permanentError is a rejection that retrying cannot fix.
+type permanentError struct{ status string }
+
+func (e permanentError) Error() string { return "upload rejected: " + e.status }
+
+func (q *Queue) upload(ctx context.Context, job *Job) (http.Header, error) {
+	f, err := os.Open(q.bodyPath(job.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeForm(mw, job, f))
+	}()
+	req, err := http.NewRequestWithContext(ctx, job.Method, job.URL, pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	for k, vs := range job.Header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return resp.Header, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return resp.Header, fmt.Errorf("upload failed: %s", resp.Status)
+	default:
+		return resp.Header, permanentError{resp.Status}
+	}
+}
+
+func writeForm(mw *multipart.Writer, job *Job, body io.Reader) error {
+	keys := make([]string, 0, len(job.Fields))
+	for k := range job.Fields {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if err := mw.WriteField(k, job.Fields[k]); err != nil {
+			return err
+		}
+	}
+	part, err := mw.CreateFormFile(job.Field, job.Filename)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, body); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+func (q *Queue) notifyLocked() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func (q *Queue) save(job Job) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeAtomic(q.metaPath(job.ID), func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to save job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+func (q *Queue) remove(id string) error {
+	// The metadata goes first: a body without metadata is ignored by Open.
+	err := os.Remove(q.metaPath(id))
+	return errors.Join(err, os.Remove(q.bodyPath(id)))
+}
+
+func (q *Queue) metaPath(id string) string { return filepath.Join(q.dir, id+".json") }
+func (q *Queue) bodyPath(id string) string { return filepath.Join(q.dir, id+".body") }
+
+// writeAtomic writes a file through a temporary name in the same directory.
+func writeAtomic(path string, write func(*os.File) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,141 @@
+package uploadqueue
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// uploadServer records the uploaded files and fails the first failures
+// requests with status.
+func uploadServer(t *testing.T, failures int32, status int) (*httptest.Server, chan string) {
+	received := make(chan string, 10)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		f, fh, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(f)
+		received <- r.FormValue("kind") + " " + fh.Filename + " " + string(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+func waitFor(t *testing.T, ch <-chan string) string {
+	t.Helper()
+	select {
+	case s := <-ch:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for upload")
+		return ""
+	}
+}
+
+func waitEmpty(t *testing.T, q *Queue) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); len(q.Jobs()) > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("jobs left: %+v", q.Jobs())
+		}
+	}
+}
+
+func TestResumeAfterRestart(t *testing.T) {
+	srv, received := uploadServer(t, 0, 0)
+	dir := t.TempDir()
+
+	q, err := Open(dir, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := Job{URL: srv.URL, Field: "file", Filename: "log.txt", Fields: map[string]string{"kind": "log"}}
+	id, err := q.Enqueue(job, strings.NewReader("line 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new process finds the job on disk.
+	q, err = Open(dir, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j, err := q.Job(id); err != nil || j.State != Pending || j.Method != http.MethodPost {
+		t.Fatalf("Job(%s) = %+v, %v", id, j, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	if got := waitFor(t, received); got != "log log.txt line 1" {
+		t.Errorf("received %q", got)
+	}
+	waitEmpty(t, q)
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("files left in queue: %v", entries)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	srv, received := uploadServer(t, 2, http.StatusServiceUnavailable)
+	q, err := Open(t.TempDir(), srv.Client(), RetryDelay(time.Millisecond), Workers(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	q.Enqueue(Job{URL: srv.URL, Field: "file", Filename: "a.bin"}, strings.NewReader("data"))
+	if got := waitFor(t, received); got != " a.bin data" {
+		t.Errorf("received %q", got)
+	}
+	waitEmpty(t, q)
+}
+
+func TestPermanentFailureAndCancel(t *testing.T) {
+	srv, _ := uploadServer(t, 100, http.StatusBadRequest)
+	q, err := Open(t.TempDir(), srv.Client(), RetryDelay(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	id, _ := q.Enqueue(Job{URL: srv.URL, Field: "file", Filename: "a.bin"}, strings.NewReader("data"))
+	var job Job
+	for deadline := time.Now().Add(5 * time.Second); job.State != Failed; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("job = %+v, want failed", job)
+		}
+		job, _ = q.Job(id)
+	}
+	if job.Attempts != 1 || !strings.Contains(job.LastError, "400") {
+		t.Errorf("job = %+v", job)
+	}
+
+	if err := q.Cancel(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Job(id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Job after Cancel = %v", err)
+	}
+	if err := q.Cancel(id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Cancel = %v", err)
+	}
+}
@@ -0,0 +1,329 @@
+// Package webhook sends webhook deliveries signed with an HMAC of their
+// timestamp and body, and verifies them on the receiving side:
+//
+//	s := webhook.NewSender(client, secret, webhook.MaxAttempts(5))
+//	p, err := webhook.JSON(event)
+//	d, err := s.Send(ctx, "https://example.com/hooks", p)
+//
+//	body, err := webhook.Verify(r, secret) // in the receiver's handler
+//
+// The signature covers "<timestamp>.<body>", so a captured delivery cannot
+// be replayed once the timestamp is older than the receiver's tolerance.
+// Failed deliveries are retried with the same idempotency key, which lets
+// receivers drop duplicates of a delivery that arrived but whose response
+// got lost.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isauran/go-std-library/http/request/retry"
+)
+
+// Defaults of NewSender and Verify.
+const (
+	DefaultSignatureHeader   = "X-Webhook-Signature"
+	DefaultTimestampHeader   = "X-Webhook-Timestamp"
+	DefaultIdempotencyHeader = "Idempotency-Key"
+	DefaultMaxAttempts       = 3
+	DefaultRetryDelay        = time.Second
+	DefaultTolerance         = 5 * time.Minute
+)
+
+var (
+	// ErrRejected is returned, wrapped, when the receiver answered with a
+	// status that retrying cannot fix.
+	ErrRejected = errors.New("webhook: delivery rejected")
+	// ErrSignature is returned by Verify for a missing or wrong signature.
+	ErrSignature = errors.New("webhook: invalid signature")
+	// ErrTimestamp is returned by Verify for a missing timestamp or one
+	// further from now than the tolerance.
+	ErrTimestamp = errors.New("webhook: timestamp outside tolerance")
+)
+
+type config struct {
+	algorithm       string
+	newHash         func() hash.Hash
+	signatureHeader string
+	timestampHeader string
+	maxAttempts     int
+	retryDelay      time.Duration
+	backoff         *retry.Policy
+	tolerance       time.Duration
+	now             func() time.Time
+}
+
+func newConfig(opts []Option) config {
+	c := config{
+		algorithm:       "sha256",
+		newHash:         sha256.New,
+		signatureHeader: DefaultSignatureHeader,
+		timestampHeader: DefaultTimestampHeader,
+		maxAttempts:     DefaultMaxAttempts,
+		retryDelay:      DefaultRetryDelay,
+		tolerance:       DefaultTolerance,
+		now:             time.Now,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.backoff == nil {
+		c.backoff = retry.New(retry.BaseDelay(c.retryDelay))
+	}
+	return c
+}
+
+// Option configures a Sender or Verify. Both sides of a webhook must use
+// the same algorithm and header names.
+type Option func(*config)
+
+// Algorithm signs with the HMAC of newHash, e.g. sha512.New, named name in
+// the signature header ("sha256" by default).
+func Algorithm(name string, newHash func() hash.Hash) Option {
+	return func(c *config) { c.algorithm, c.newHash = name, newHash }
+}
+
+// SignatureHeader sets the header carrying "<algorithm>=<hex HMAC>".
+func SignatureHeader(name string) Option {
+	return func(c *config) { c.signatureHeader = name }
+}
+
+// TimestampHeader sets the header carrying the Unix time of signing.
+func TimestampHeader(name string) Option {
+	return func(c *config) { c.timestampHeader = name }
+}
+
+// MaxAttempts sets how many times a delivery is tried.
+func MaxAttempts(n int) Option {
+	return func(c *config) { c.maxAttempts = max(n, 1) }
+}
+
+// RetryDelay sets the delay before the first retry. It doubles with every
+// failed attempt, up to 64 times the initial delay.
+func RetryDelay(d time.Duration) Option {
+	return func(c *config) { c.retryDelay = d }
+}
+
+// Backoff sets the policy spacing retries, replacing RetryDelay. The
+// default policy already waits as long as a 429 or 503 response asks for
+// in Retry-After or RateLimit-Reset.
+func Backoff(p *retry.Policy) Option {
+	return func(c *config) { c.backoff = p }
+}
+
+// Tolerance sets how far the timestamp of a delivery may be from the
+// receiver's clock for Verify to accept it.
+func Tolerance(d time.Duration) Option {
+	return func(c *config) { c.tolerance = d }
+}
+
+// Payload is the body of a delivery.
+type Payload struct {
+	ContentType string
+	Body        []byte
+	// IdempotencyKey identifies the delivery across retries. Send
+	// generates one when it is empty.
+	IdempotencyKey string
+}
+
+// JSON returns a payload with v encoded as JSON.
+func JSON(v any) (Payload, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return Payload{}, fmt.Errorf("failed to encode payload: %w", err)
+	}
+	return Payload{ContentType: "application/json", Body: body}, nil
+}
+
+// File is a file part of a Multipart payload.
+type File struct {
+	Field       string
+	Filename    string
+	ContentType string // application/octet-stream if empty
+	Data        []byte
+}
+
+// Multipart returns a multipart/form-data payload with fields, in sorted
+// order, followed by files. The body is built in memory, as it has to be
+// signed before it is sent and kept for retries.
+func Multipart(fields map[string]string, files ...File) (Payload, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if err := mw.WriteField(k, fields[k]); err != nil {
+			return Payload{}, fmt.Errorf("failed to write field [%q]: %w", k, err)
+		}
+	}
+	for _, f := range files {
+		ct := f.ContentType
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", multipart.FileContentDisposition(f.Field, f.Filename))
+		h.Set("Content-Type", ct)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return Payload{}, fmt.Errorf("failed to create file [%q]: %w", f.Field, err)
+		}
+		if _, err := part.Write(f.Data); err != nil {
+			return Payload{}, fmt.Errorf("failed to write file [%q]: %w", f.Field, err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return Payload{}, fmt.Errorf("failed to close multipart writer: %w", err)
+	}
+	return Payload{ContentType: mw.FormDataContentType(), Body: buf.Bytes()}, nil
+}
+
+// Sender signs and sends deliveries. It is safe for concurrent use.
+type Sender struct {
+	client *http.Client
+	secret []byte
+	cfg    config
+}
+
+// NewSender returns a sender signing with secret. A nil client means
+// http.DefaultClient.
+func NewSender(client *http.Client, secret []byte, opts ...Option) *Sender {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Sender{client: client, secret: secret, cfg: newConfig(opts)}
+}
+
+// Delivery is the outcome of Send.
+type Delivery struct {
+	IdempotencyKey string
+	Attempts       int
+	StatusCode     int // of the last response, 0 if there was none
+}
+
+// Send POSTs p to url, retrying transport errors and 5xx, 408 and 429
+// responses with backoff until MaxAttempts, waiting longer when the
+// receiver asks for it in Retry-After. Each attempt is signed with a
+// fresh timestamp and carries the same idempotency key. Any other status
+// that is not 2xx fails at once with ErrRejected.
+func (s *Sender) Send(ctx context.Context, url string, p Payload) (Delivery, error) {
+	d := Delivery{IdempotencyKey: p.IdempotencyKey}
+	if d.IdempotencyKey == "" {
+		key, err := newKey()
+		if err != nil {
+			return d, err
+		}
+		d.IdempotencyKey = key
+	}
+	var (
+		err    error
+		header http.Header
+	)
+	for d.Attempts < s.cfg.maxAttempts {
+		if d.Attempts > 0 {
+			if werr := s.cfg.backoff.Wait(ctx, d.Attempts, header, err); werr != nil {
+				return d, fmt.Errorf("failed to deliver webhook: %w", errors.Join(werr, err))
+			}
+		}
+		d.Attempts++
+		var again bool
+		d.StatusCode, header, again, err = s.attempt(ctx, url, p, d.IdempotencyKey)
+		if err == nil || !again {
+			break
+		}
+	}
+	if err != nil {
+		return d, fmt.Errorf("failed to deliver webhook after %d attempts: %w", d.Attempts, err)
+	}
+	return d, nil
+}
+
+// attempt sends one signed request and reports whether its failure may be
+// retried, along with the response header for retry hints.
+func (s *Sender) attempt(ctx context.Context, url string, p Payload, key string) (status int, header http.Header, again bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(p.Body))
+	if err != nil {
+		return 0, nil, false, fmt.Errorf("failed to create request: %w", err)
+	}
+	timestamp := strconv.FormatInt(s.cfg.now().Unix(), 10)
+	req.Header.Set("Content-Type", p.ContentType)
+	req.Header.Set(DefaultIdempotencyHeader, key)
+	req.Header.Set(s.cfg.timestampHeader, timestamp)
+	req.Header.Set(s.cfg.signatureHeader, s.cfg.algorithm+"="+hex.EncodeToString(sign(s.cfg.newHash, s.secret, timestamp, p.Body)))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, nil, ctx.Err() == nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return resp.StatusCode, resp.Header, false, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return resp.StatusCode, resp.Header, true, fmt.Errorf("delivery failed: %s", resp.Status)
+	default:
+		return resp.StatusCode, resp.Header, false, fmt.Errorf("%w: %s", ErrRejected, resp.Status)
+	}
+}
+
+// Verify checks the signature and timestamp of a delivery received by a
+// handler and returns its body, which it reads from r.
+func Verify(r *http.Request, secret []byte, opts ...Option) ([]byte, error) {
+	cfg := newConfig(opts)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook body: %w", err)
+	}
+	timestamp := r.Header.Get(cfg.timestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrTimestamp, timestamp)
+	}
+	if skew := cfg.now().Sub(time.Unix(unix, 0)).Abs(); skew > cfg.tolerance {
+		return nil, fmt.Errorf("%w: off by %v", ErrTimestamp, skew)
+	}
+	algorithm, sig, _ := strings.Cut(r.Header.Get(cfg.signatureHeader), "=")
+	got, err := hex.DecodeString(sig)
+	if algorithm != cfg.algorithm || err != nil || !hmac.Equal(got, sign(cfg.newHash, secret, timestamp, body)) {
+		return nil, ErrSignature
+	}
+	return body, nil
+}
+
+// sign returns the HMAC of "<timestamp>.<body>".
+func sign(newHash func() hash.Hash, secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(newHash, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func newKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate idempotency key: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha512"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/isauran/go-std-library/http/request/retry"
+)
+
+func TestSendVerify(t *testing.T) {
+	secret := []byte("s3cret")
+	opts := []Option{Algorithm("sha512", sha512.New), SignatureHeader("X-Sig"), RetryDelay(time.Millisecond)}
+
+	var mu sync.Mutex
+	var keys []string
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := Verify(r, secret, opts...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get(DefaultIdempotencyHeader))
+		bodies = append(bodies, string(body))
+		if len(keys) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable) // the first attempt fails
+		}
+	}))
+	defer srv.Close()
+
+	p, err := JSON(map[string]string{"event": "created"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewSender(srv.Client(), secret, opts...).Send(context.Background(), srv.URL, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Attempts != 2 || d.StatusCode != http.StatusOK {
+		t.Errorf("delivery = %+v", d)
+	}
+	if len(keys) != 2 || keys[0] != d.IdempotencyKey || keys[1] != d.IdempotencyKey {
+		t.Errorf("idempotency keys = %q, want %q twice", keys, d.IdempotencyKey)
+	}
+	if bodies[1] != `{"event":"created"}` {
+		t.Errorf("body = %q", bodies[1])
+	}
+
+	mp, err := Multipart(map[string]string{"event": "upload"}, File{Field: "file", Filename: "a.txt", Data: []byte("a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSender(srv.Client(), secret, opts...).Send(context.Background(), srv.URL, mp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(bodies[2], `name="file"; filename="a.txt"`) {
+		t.Errorf("multipart body = %q", bodies[2])
+	}
+
+	// A wrong secret is rejected without retries.
+	d, err = NewSender(srv.Client(), []byte("wrong"), opts...).Send(context.Background(), srv.URL, p)
+	if !errors.Is(err, ErrRejected) || d.Attempts != 1 {
+		t.Errorf("Send with wrong secret = %+v, %v", d, err)
+	}
+}
+
+func TestVerifySkew(t *testing.T) {
+	secret := []byte("s3cret")
+	past := func(c *config) { c.now = func() time.Time { return time.Now().Add(-time.Hour) } }
+
+	var got error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, got = Verify(r, secret, Tolerance(time.Minute))
+	}))
+	defer srv.Close()
+
+	p, _ := JSON("old")
+	if _, err := NewSender(srv.Client(), secret, past).Send(context.Background(), srv.URL, p); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(got, ErrTimestamp) {
+		t.Errorf("Verify of an old delivery = %v, want ErrTimestamp", got)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("x"))
+	req.Header.Set(DefaultTimestampHeader, "1")
+	if _, err := Verify(req, secret, past, Tolerance(100*365*24*time.Hour)); !errors.Is(err, ErrSignature) {
+		t.Errorf("Verify without signature = %v, want ErrSignature", err)
+	}
+}
+
+func TestSendRetryAfter(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits++; hits == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	var waits []time.Duration
+	policy := retry.New(retry.MaxDelay(10*time.Millisecond), retry.Jitter(0),
+		retry.OnRetry(func(attempt int, wait time.Duration, cause error) { waits = append(waits, wait) }))
+	d, err := NewSender(srv.Client(), []byte("k"), Backoff(policy)).Send(context.Background(), srv.URL, Payload{Body: []byte("{}")})
+	if err != nil || d.Attempts != 2 {
+		t.Fatalf("Send = %+v, %v", d, err)
+	}
+	if len(waits) != 1 || waits[0] != 10*time.Millisecond {
+		t.Errorf("waits = %v, want the Retry-After hint capped at 10ms", waits)
+	}
+}
@@ -0,0 +1,146 @@
+package form
+
+import (
+	"io"
+	"mime/multipart"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var (
+	fileHeaderType = reflect.TypeOf((*multipart.FileHeader)(nil))
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+// bind stores values and files into the struct pointed to by dst. Fields are
+// matched by their `form:"name"` tag, or by the Go field name when untagged;
+// `form:"-"` skips a field and nested structs are bound with the same
+// values. Conversion failures are added to verr. Bind has checked dst.
+func bind(dst any, values map[string][]string, files map[string][]*multipart.FileHeader, verr *ValidationError) {
+	bindStruct(reflect.ValueOf(dst).Elem(), values, files, verr)
+}
+
+func bindStruct(v reflect.Value, values map[string][]string, files map[string][]*multipart.FileHeader, verr *ValidationError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := sf.Tag.Get("form")
+		if name == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		if name == "" && sf.Type.Kind() == reflect.Struct && sf.Type != timeType {
+			bindStruct(fv, values, files, verr)
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		switch {
+		case sf.Type == fileHeaderType:
+			if fhs := files[name]; len(fhs) > 0 {
+				fv.Set(reflect.ValueOf(fhs[0]))
+			}
+		case sf.Type == reflect.SliceOf(fileHeaderType):
+			if fhs := files[name]; len(fhs) > 0 {
+				fv.Set(reflect.ValueOf(fhs))
+			}
+		case sf.Type.Kind() == reflect.Slice && sf.Type.Elem().Kind() == reflect.Uint8 && len(files[name]) > 0:
+			b, err := readFile(files[name][0])
+			if err != nil {
+				verr.add(name, CodeInvalid, "cannot read file: %v", err)
+				continue
+			}
+			fv.SetBytes(b)
+		case sf.Type.Kind() == reflect.Slice && sf.Type.Elem().Kind() != reflect.Uint8:
+			vs := values[name]
+			if len(vs) == 0 {
+				continue
+			}
+			s := reflect.MakeSlice(sf.Type, len(vs), len(vs))
+			for j, raw := range vs {
+				if err := setScalar(s.Index(j), raw); err != nil {
+					verr.add(name, CodeInvalid, "%q is not a valid %s", raw, sf.Type.Elem())
+				}
+			}
+			fv.Set(s)
+		default:
+			vs := values[name]
+			if len(vs) == 0 {
+				continue
+			}
+			if err := setScalar(fv, vs[0]); err != nil {
+				verr.add(name, CodeInvalid, "%q is not a valid %s", vs[0], sf.Type)
+			}
+		}
+	}
+}
+
+func readFile(fh *multipart.FileHeader) ([]byte, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func setScalar(v reflect.Value, raw string) error {
+	if v.Kind() == reflect.Pointer {
+		p := reflect.New(v.Type().Elem())
+		if err := setScalar(p.Elem(), raw); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	if v.Type() == timeType {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return strconv.ErrSyntax
+		}
+		v.SetBytes([]byte(raw))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return strconv.ErrSyntax
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+// Package form validates HTML form submissions against a declarative schema
+// and binds them into structs tagged with `form:"name"`.
+package form
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// DefaultMaxMemory is the memory budget for multipart parsing; larger files
+// are spooled to temporary files by mime/multipart.
+const DefaultMaxMemory = 32 << 20
+
+// Field is the rule for a non-file field.
+type Field struct {
+	Required bool
+	MaxSize  int64 // maximum length of each value in bytes; 0 means no limit
+	Multiple bool  // whether the field may be repeated
+}
+
+// File is the rule for a file field.
+type File struct {
+	Required bool
+	MaxSize  int64    // maximum size of each file in bytes; 0 means no limit
+	Types    []string // allowed media types, "image/*" style wildcards allowed; empty allows any
+	Sniff    bool     // also check the type detected from the content, not only the declared one
+	Multiple bool     // whether several files may be sent under this name
+}
+
+// Schema describes the accepted form.
+type Schema struct {
+	Fields map[string]Field
+	Files  map[string]File
+
+	// MaxBodySize limits the request body; 0 means no limit.
+	MaxBodySize int64
+	// MaxMemory is passed to ParseMultipartForm; defaults to DefaultMaxMemory.
+	MaxMemory int64
+	// DisallowUnknown rejects fields and files not declared in the schema.
+	DisallowUnknown bool
+}
+
+// Error codes used in FieldError.
+const (
+	CodeRequired = "required"
+	CodeTooLarge = "too_large"
+	CodeType     = "type"
+	CodeMultiple = "multiple"
+	CodeUnknown  = "unknown"
+	CodeInvalid  = "invalid"
+)
+
+// FieldError is a validation failure of a single field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError collects all field errors of a submission.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Error()
+	}
+	return "form: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) add(field, code, format string, args ...any) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// InvalidBindError describes an invalid destination passed to Bind: not a
+// non-nil pointer to a struct.
+type InvalidBindError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidBindError) Error() string {
+	switch {
+	case e.Type == nil:
+		return "form: Bind(nil)"
+	case e.Type.Kind() != reflect.Pointer:
+		return "form: Bind(non-pointer " + e.Type.String() + ")"
+	case e.Type.Elem().Kind() != reflect.Struct:
+		return "form: Bind(pointer to non-struct " + e.Type.String() + ")"
+	}
+	return "form: Bind(nil " + e.Type.String() + ")"
+}
+
+// Bind parses the form in r (multipart or urlencoded), validates it against
+// the schema and stores the values into dst, which must be a pointer to a
+// struct, or nil to only validate. Any other dst is reported as an
+// *InvalidBindError before r is read. Validation problems are returned
+// together as a *ValidationError; other errors come from reading the
+// request.
+func (s Schema) Bind(r *http.Request, dst any) error {
+	if dst != nil {
+		if v := reflect.ValueOf(dst); v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+			return &InvalidBindError{Type: v.Type()}
+		}
+	}
+	if s.MaxBodySize > 0 {
+		r.Body = http.MaxBytesReader(nil, r.Body, s.MaxBodySize)
+	}
+	values, files, err := s.parse(r)
+	if err != nil {
+		return err
+	}
+
+	verr := &ValidationError{}
+	s.validateFields(values, verr)
+	s.validateFiles(files, verr)
+	if dst != nil {
+		bind(dst, values, files, verr)
+	}
+	if len(verr.Errors) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// Bind binds r into dst without schema validation.
+func Bind(r *http.Request, dst any) error {
+	return Schema{}.Bind(r, dst)
+}
+
+func (s Schema) parse(r *http.Request) (map[string][]string, map[string][]*multipart.FileHeader, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		maxMemory := s.MaxMemory
+		if maxMemory == 0 {
+			maxMemory = DefaultMaxMemory
+		}
+		if err := r.ParseMultipartForm(maxMemory); err != nil {
+			return nil, nil, err
+		}
+		values := map[string][]string(r.MultipartForm.Value)
+		// Query parameters are allowed to fill fields too.
+		for k, v := range r.URL.Query() {
+			if _, ok := values[k]; !ok {
+				values[k] = v
+			}
+		}
+		return values, r.MultipartForm.File, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, nil, err
+	}
+	return r.Form, nil, nil
+}
+
+func (s Schema) validateFields(values map[string][]string, verr *ValidationError) {
+	for _, name := range sortedKeys(s.Fields) {
+		rule := s.Fields[name]
+		vs := values[name]
+		if len(vs) == 0 || (len(vs) == 1 && vs[0] == "") {
+			if rule.Required {
+				verr.add(name, CodeRequired, "is required")
+			}
+			continue
+		}
+		if len(vs) > 1 && !rule.Multiple {
+			verr.add(name, CodeMultiple, "must be sent once, got %d values", len(vs))
+		}
+		for _, v := range vs {
+			if rule.MaxSize > 0 && int64(len(v)) > rule.MaxSize {
+				verr.add(name, CodeTooLarge, "must be at most %d bytes", rule.MaxSize)
+				break
+			}
+		}
+	}
+	if s.DisallowUnknown {
+		for _, name := range sortedKeys(values) {
+			if _, ok := s.Fields[name]; !ok {
+				verr.add(name, CodeUnknown, "is not an accepted field")
+			}
+		}
+	}
+}
+
+func (s Schema) validateFiles(files map[string][]*multipart.FileHeader, verr *ValidationError) {
+	for _, name := range sortedKeys(s.Files) {
+		rule := s.Files[name]
+		fhs := files[name]
+		if len(fhs) == 0 {
+			if rule.Required {
+				verr.add(name, CodeRequired, "is required")
+			}
+			continue
+		}
+		if len(fhs) > 1 && !rule.Multiple {
+			verr.add(name, CodeMultiple, "must be a single file, got %d", len(fhs))
+		}
+		for _, fh := range fhs {
+			if rule.MaxSize > 0 && fh.Size > rule.MaxSize {
+				verr.add(name, CodeTooLarge, "%s is larger than %d bytes", fh.Filename, rule.MaxSize)
+			}
+			if len(rule.Types) == 0 {
+				continue
+			}
+			declared, _, _ := mime.ParseMediaType(fh.Header.Get("Content-Type"))
+			if !typeAllowed(rule.Types, declared) {
+				verr.add(name, CodeType, "%s has type %q, allowed: %s", fh.Filename, declared, strings.Join(rule.Types, ", "))
+				continue
+			}
+			if rule.Sniff {
+				detected, err := sniff(fh)
+				if err != nil {
+					verr.add(name, CodeInvalid, "%s cannot be read: %v", fh.Filename, err)
+				} else if !typeAllowed(rule.Types, detected) {
+					verr.add(name, CodeType, "%s content looks like %q, allowed: %s", fh.Filename, detected, strings.Join(rule.Types, ", "))
+				}
+			}
+		}
+	}
+	if s.DisallowUnknown {
+		for _, name := range sortedKeys(files) {
+			if _, ok := s.Files[name]; !ok {
+				verr.add(name, CodeUnknown, "is not an accepted file")
+			}
+		}
+	}
+}
+
+func typeAllowed(allowed []string, mediaType string) bool {
+	return slices.ContainsFunc(allowed, func(pattern string) bool {
+		ok, _ := path.Match(pattern, mediaType)
+		return ok
+	})
+}
+
+func sniff(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && err != io.EOF {
+		return "", err
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(buf[:n]))
+	return mediaType, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
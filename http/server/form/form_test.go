@@ -0,0 +1,142 @@
+package form
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type upload struct {
+	Title  string                `form:"title"`
+	Count  int                   `form:"count"`
+	Tags   []string              `form:"tag"`
+	Public *bool                 `form:"public"`
+	Avatar *multipart.FileHeader `form:"avatar"`
+	Notes  []byte                `form:"notes"`
+	Skip   string                `form:"-"`
+}
+
+func multipartRequest(t *testing.T, build func(mw *multipart.Writer)) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	build(mw)
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func imagePart(mw *multipart.Writer, name, contentType string, content []byte) {
+	w, _ := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="` + name + `"; filename="a.png"`},
+		"Content-Type":        {contentType},
+	})
+	w.Write(content)
+}
+
+var png = []byte("\x89PNG\r\n\x1a\n0000")
+
+func TestBindMultipart(t *testing.T) {
+	schema := Schema{
+		Fields: map[string]Field{"title": {Required: true, MaxSize: 10}, "tag": {Multiple: true}},
+		Files:  map[string]File{"avatar": {Required: true, Types: []string{"image/*"}, Sniff: true}},
+	}
+	req := multipartRequest(t, func(mw *multipart.Writer) {
+		mw.WriteField("title", "hello")
+		mw.WriteField("count", "3")
+		mw.WriteField("tag", "a")
+		mw.WriteField("tag", "b")
+		mw.WriteField("public", "true")
+		mw.WriteField("notes", "text")
+		imagePart(mw, "avatar", "image/png", png)
+	})
+
+	var dst upload
+	if err := schema.Bind(req, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Title != "hello" || dst.Count != 3 || strings.Join(dst.Tags, ",") != "a,b" || string(dst.Notes) != "text" {
+		t.Errorf("dst = %+v", dst)
+	}
+	if dst.Public == nil || !*dst.Public {
+		t.Error("Public not bound")
+	}
+	if dst.Avatar == nil || dst.Avatar.Filename != "a.png" {
+		t.Errorf("Avatar = %+v", dst.Avatar)
+	}
+}
+
+func TestBindValidationErrors(t *testing.T) {
+	schema := Schema{
+		Fields:          map[string]Field{"title": {Required: true, MaxSize: 3}},
+		Files:           map[string]File{"avatar": {Types: []string{"image/png"}, Sniff: true}},
+		DisallowUnknown: true,
+	}
+	req := multipartRequest(t, func(mw *multipart.Writer) {
+		mw.WriteField("title", "too long")
+		mw.WriteField("count", "x")
+		imagePart(mw, "avatar", "image/png", []byte("<html>not an image</html>"))
+	})
+
+	var dst upload
+	err := schema.Bind(req, &dst)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("err = %v, want *ValidationError", err)
+	}
+	codes := map[string]string{}
+	for _, fe := range verr.Errors {
+		codes[fe.Field+"/"+fe.Code] = fe.Message
+	}
+	for _, want := range []string{"title/too_large", "avatar/type", "count/unknown", "count/invalid"} {
+		if _, ok := codes[want]; !ok {
+			t.Errorf("missing %s in %v", want, verr.Errors)
+		}
+	}
+}
+
+func TestBindURLEncoded(t *testing.T) {
+	form := url.Values{"title": {"x"}, "count": {"7"}}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var dst upload
+	if err := Bind(req, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Title != "x" || dst.Count != 7 {
+		t.Errorf("dst = %+v", dst)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	err := Schema{Fields: map[string]Field{"title": {Required: true}}}.Bind(req, &dst)
+	if err == nil || !strings.Contains(err.Error(), "title: is required") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestBindInvalidDestination(t *testing.T) {
+	var u upload
+	var nilUpload *upload
+	n := 0
+	for _, dst := range []any{u, &n, nilUpload} {
+		req := httptest.NewRequest(http.MethodPost, "/?title=x", nil)
+		err := Bind(req, dst)
+		var invalid *InvalidBindError
+		if !errors.As(err, &invalid) {
+			t.Errorf("Bind(%T) = %v, want *InvalidBindError", dst, err)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, "/?title=x", nil)
+	if err := Bind(req, nil); err != nil {
+		t.Errorf("Bind(nil) = %v, want validation only", err)
+	}
+}
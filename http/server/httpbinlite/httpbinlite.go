@@ -0,0 +1,257 @@
+// Package httpbinlite is a local stand-in for the subset of httpbin.org the
+// examples use, so they run without network access.
+package httpbinlite
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isauran/go-std-library/http/server/serverx"
+)
+
+// MaxDelay caps /delay/{s}, as httpbin.org does.
+const MaxDelay = 10 * time.Second
+
+// maxMemory is how much of a multipart form is kept in memory.
+const maxMemory = 32 << 20
+
+// Response is the JSON document returned by /post and /put, in httpbin's
+// format. Fields and files with several values are reported as arrays.
+type Response struct {
+	Args    map[string]any    `json:"args"`
+	Data    string            `json:"data"`
+	Files   map[string]any    `json:"files"`
+	Form    map[string]any    `json:"form"`
+	Headers map[string]string `json:"headers"`
+	JSON    any               `json:"json"`
+	Origin  string            `json:"origin"`
+	URL     string            `json:"url"`
+}
+
+// Handler returns a handler implementing /post, /put, /status/{code},
+// /delay/{s} and /drip.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /post", echo)
+	mux.HandleFunc("PUT /put", echo)
+	mux.HandleFunc("/status/{code}", status)
+	mux.HandleFunc("/delay/{s}", delay)
+	mux.HandleFunc("GET /drip", drip)
+	return mux
+}
+
+// Start serves Handler on a random localhost port. Requests go to
+// s.URL()+"/post" and so on; call s.Shutdown when done.
+func Start(ctx context.Context) (*serverx.Server, error) {
+	return serverx.Start(ctx, &http.Server{Handler: Handler()}, serverx.Signals())
+}
+
+func echo(w http.ResponseWriter, r *http.Request) {
+	resp := Response{
+		Args:    flatten(r.URL.Query()),
+		Files:   map[string]any{},
+		Form:    map[string]any{},
+		Headers: headers(r),
+		Origin:  origin(r),
+		URL:     fullURL(r),
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(maxMemory); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		resp.Form = flatten(r.MultipartForm.Value)
+		for field, headers := range r.MultipartForm.File {
+			var contents []string
+			for _, fh := range headers {
+				f, err := fh.Open()
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				b, err := io.ReadAll(f)
+				f.Close()
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				contents = append(contents, string(b))
+			}
+			resp.Files[field] = single(contents)
+		}
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp.Form = flatten(r.PostForm)
+	default:
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp.Data = string(b)
+		if mediaType == "application/json" {
+			json.Unmarshal(b, &resp.JSON)
+		}
+	}
+	writeJSON(w, resp)
+}
+
+func status(w http.ResponseWriter, r *http.Request) {
+	code, err := strconv.Atoi(r.PathValue("code"))
+	if err != nil || code < 100 || code > 999 {
+		http.Error(w, "invalid status code", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(code)
+}
+
+func delay(w http.ResponseWriter, r *http.Request) {
+	seconds, err := strconv.ParseFloat(r.PathValue("s"), 64)
+	if err != nil || seconds < 0 {
+		http.Error(w, "invalid delay", http.StatusBadRequest)
+		return
+	}
+	d := min(time.Duration(seconds*float64(time.Second)), MaxDelay)
+	select {
+	case <-time.After(d):
+	case <-r.Context().Done():
+		return
+	}
+	writeJSON(w, map[string]any{
+		"args":    flatten(r.URL.Query()),
+		"headers": headers(r),
+		"origin":  origin(r),
+		"url":     fullURL(r),
+	})
+}
+
+// drip writes numbytes asterisks spread evenly over duration seconds, after
+// an initial delay, with the given status code.
+func drip(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	numBytes, err1 := queryInt(q.Get("numbytes"), 10)
+	duration, err2 := queryFloat(q.Get("duration"), 2)
+	initial, err3 := queryFloat(q.Get("delay"), 2)
+	code, err4 := queryInt(q.Get("code"), http.StatusOK)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || numBytes < 0 || numBytes > 10<<20 {
+		http.Error(w, "invalid drip parameters", http.StatusBadRequest)
+		return
+	}
+
+	if !sleep(r.Context(), seconds(initial)) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(numBytes))
+	w.WriteHeader(code)
+
+	flusher, _ := w.(http.Flusher)
+	var pause time.Duration
+	if numBytes > 0 {
+		pause = seconds(duration) / time.Duration(numBytes)
+	}
+	for i := 0; i < numBytes; i++ {
+		if _, err := w.Write([]byte{'*'}); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if !sleep(r.Context(), pause) {
+			return
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func queryInt(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func queryFloat(s string, def float64) (float64, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// flatten reports single values as strings and repeated values as arrays.
+func flatten(values map[string][]string) map[string]any {
+	out := make(map[string]any, len(values))
+	for k, vs := range values {
+		out[k] = single(vs)
+	}
+	return out
+}
+
+func single(vs []string) any {
+	if len(vs) == 1 {
+		return vs[0]
+	}
+	return vs
+}
+
+func headers(r *http.Request) map[string]string {
+	out := map[string]string{"Host": r.Host}
+	for k, vs := range r.Header {
+		out[k] = strings.Join(vs, ",")
+	}
+	return out
+}
+
+func origin(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func fullURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
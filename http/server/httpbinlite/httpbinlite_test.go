@@ -0,0 +1,106 @@
+package httpbinlite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPostMultipart(t *testing.T) {
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "report")
+	mw.WriteField("tag", "a")
+	mw.WriteField("tag", "b")
+	fw, _ := mw.CreateFormFile("doc", "doc.txt")
+	fw.Write([]byte("contents"))
+	mw.Close()
+
+	resp, err := srv.Client().Post(srv.URL+"/post?x=1", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var got Response
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Form["title"] != "report" || got.Files["doc"] != "contents" || got.Args["x"] != "1" {
+		t.Errorf("response = %+v", got)
+	}
+	if tags, ok := got.Form["tag"].([]any); !ok || len(tags) != 2 {
+		t.Errorf("tag = %#v, want two values", got.Form["tag"])
+	}
+}
+
+func TestPutJSONAndMethods(t *testing.T) {
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/put", bytes.NewReader([]byte(`{"a":1}`)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Response
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if got.Data != `{"a":1}` || got.JSON.(map[string]any)["a"] != 1.0 {
+		t.Errorf("response = %+v", got)
+	}
+
+	resp, err = srv.Client().Get(srv.URL + "/post")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /post = %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestStatusDelayDrip(t *testing.T) {
+	s, err := Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+
+	resp, err := http.Get(s.URL() + "/status/418")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("status = %d, want 418", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.URL()+"/delay/5", nil)
+	if _, err := http.DefaultClient.Do(req); err == nil {
+		t.Error("delay/5 returned before the client timeout")
+	}
+
+	start := time.Now()
+	resp, err = http.Get(s.URL() + "/drip?numbytes=5&duration=0.1&delay=0&code=201")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || string(b) != "*****" || time.Since(start) < 80*time.Millisecond {
+		t.Errorf("drip = %d %q after %v", resp.StatusCode, b, time.Since(start))
+	}
+}
@@ -0,0 +1,301 @@
+package serverx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidRange is returned by ParseRange for a malformed Range header or
+// one with no range inside the content.
+var ErrInvalidRange = errors.New("serverx: invalid range")
+
+// Range is a byte range of a representation, as requested with a Range
+// header.
+type Range struct {
+	Start, Length int64
+}
+
+// ContentRange formats r for the Content-Range header of a content of size
+// bytes.
+func (r Range) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange parses a "bytes=" Range header against a content of size
+// bytes. Ranges reaching past the end are truncated and unsatisfiable ones
+// dropped; ErrInvalidRange means none was left and the reply should be 416.
+func ParseRange(header string, size int64) ([]Range, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRange, header)
+	}
+	var ranges []Range
+	for _, ra := range strings.Split(spec, ",") {
+		ra = textproto.TrimString(ra)
+		if ra == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRange, ra)
+		}
+		first, last = textproto.TrimString(first), textproto.TrimString(last)
+		var r Range
+		if first == "" {
+			// Suffix range: the last n bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidRange, ra)
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			r = Range{Start: max(size-n, 0), Length: min(n, size)}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidRange, ra)
+			}
+			if start >= size {
+				continue
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, fmt.Errorf("%w: %q", ErrInvalidRange, ra)
+				}
+				end = min(end, size-1)
+			}
+			r = Range{Start: start, Length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("%w: none satisfiable in %d bytes", ErrInvalidRange, size)
+	}
+	return ranges, nil
+}
+
+// RangeWriter writes a 206 multipart/byteranges reply, one part per range.
+// The status and headers are sent with the first part.
+type RangeWriter struct {
+	w           http.ResponseWriter
+	mw          *multipart.Writer
+	contentType string
+	size        int64
+	started     bool
+	part        *rangePart
+}
+
+// NewRangeWriter returns a RangeWriter for ranges of a content of size
+// bytes and the given media type.
+func NewRangeWriter(w http.ResponseWriter, contentType string, size int64) *RangeWriter {
+	return &RangeWriter{
+		w:           w,
+		mw:          multipart.NewWriter(w),
+		contentType: contentType,
+		size:        size,
+	}
+}
+
+// WritePart starts the part for r and returns a writer for exactly
+// r.Length bytes of it. Writing more is an error, and so is starting the
+// next part or closing before the previous one is complete.
+func (rw *RangeWriter) WritePart(r Range) (io.Writer, error) {
+	if err := rw.finishPart(); err != nil {
+		return nil, err
+	}
+	if r.Start < 0 || r.Length <= 0 || r.Start+r.Length > rw.size {
+		return nil, fmt.Errorf("%w: %d bytes at %d of %d", ErrInvalidRange, r.Length, r.Start, rw.size)
+	}
+	if !rw.started {
+		rw.started = true
+		rw.w.Header().Set("Content-Type", "multipart/byteranges; boundary="+rw.mw.Boundary())
+		rw.w.WriteHeader(http.StatusPartialContent)
+	}
+	h := textproto.MIMEHeader{}
+	if rw.contentType != "" {
+		h.Set("Content-Type", rw.contentType)
+	}
+	h.Set("Content-Range", r.ContentRange(rw.size))
+	pw, err := rw.mw.CreatePart(h)
+	if err != nil {
+		return nil, err
+	}
+	rw.part = &rangePart{w: pw, left: r.Length}
+	return rw.part, nil
+}
+
+// Close checks that the last part is complete and writes the closing
+// boundary.
+func (rw *RangeWriter) Close() error {
+	if err := rw.finishPart(); err != nil {
+		return err
+	}
+	return rw.mw.Close()
+}
+
+func (rw *RangeWriter) finishPart() error {
+	if rw.part != nil && rw.part.left > 0 {
+		return fmt.Errorf("serverx: range part is %d bytes short", rw.part.left)
+	}
+	rw.part = nil
+	return nil
+}
+
+// rangePart holds a part to the length announced in its Content-Range.
+type rangePart struct {
+	w    io.Writer
+	left int64
+}
+
+func (p *rangePart) Write(b []byte) (int, error) {
+	if int64(len(b)) > p.left {
+		return 0, fmt.Errorf("serverx: write of %d bytes exceeds range part by %d", len(b), int64(len(b))-p.left)
+	}
+	n, err := p.w.Write(b)
+	p.left -= int64(n)
+	return n, err
+}
+
+// ServeRanges replies to r with content, honoring its Range header: a
+// single range gets a plain 206, several get multipart/byteranges, an
+// unsatisfiable one 416 and no Range header the whole content.
+func ServeRanges(w http.ResponseWriter, r *http.Request, contentType string, content io.ReaderAt, size int64) error {
+	w.Header().Set("Accept-Ranges", "bytes")
+	header := r.Header.Get("Range")
+	if header == "" {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		_, err := io.Copy(w, io.NewSectionReader(content, 0, size))
+		return err
+	}
+	ranges, err := ParseRange(header, size)
+	if err != nil {
+		w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return err
+	}
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Range", ra.ContentRange(size))
+		w.Header().Set("Content-Length", strconv.FormatInt(ra.Length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		_, err := io.Copy(w, io.NewSectionReader(content, ra.Start, ra.Length))
+		return err
+	}
+	rw := NewRangeWriter(w, contentType, size)
+	for _, ra := range ranges {
+		pw, err := rw.WritePart(ra)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(pw, io.NewSectionReader(content, ra.Start, ra.Length)); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
+
+// MixedReplaceStreamer writes a multipart/x-mixed-replace reply in which
+// every part replaces the previous one, as used for MJPEG cameras and
+// long-poll status feeds. Parts are flushed to the client as they complete.
+type MixedReplaceStreamer struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	mw      *multipart.Writer
+	started bool
+	pending bool
+}
+
+// NewMixedReplaceStreamer returns a streamer writing to w. The status and
+// headers are sent with the first part.
+func NewMixedReplaceStreamer(w http.ResponseWriter) *MixedReplaceStreamer {
+	return &MixedReplaceStreamer{
+		w:  w,
+		rc: http.NewResponseController(w),
+		mw: multipart.NewWriter(w),
+	}
+}
+
+// NextPart flushes the previous part and starts a new one of the given
+// media type. The part is not flushed until the next call to NextPart,
+// Flush or Close, so it can be written in pieces.
+func (s *MixedReplaceStreamer) NextPart(contentType string) (io.Writer, error) {
+	return s.nextPart(contentType, -1)
+}
+
+// WriteFrame writes data as a complete part with a Content-Length, which
+// lets clients display it without waiting for the next boundary, and
+// flushes it.
+func (s *MixedReplaceStreamer) WriteFrame(contentType string, data []byte) error {
+	pw, err := s.nextPart(contentType, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	if _, err := pw.Write(data); err != nil {
+		return err
+	}
+	return s.Flush()
+}
+
+func (s *MixedReplaceStreamer) nextPart(contentType string, length int64) (io.Writer, error) {
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+	s.start()
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", contentType)
+	if length >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	pw, err := s.mw.CreatePart(h)
+	if err != nil {
+		return nil, err
+	}
+	s.pending = true
+	return pw, nil
+}
+
+func (s *MixedReplaceStreamer) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	h := s.w.Header()
+	h.Set("Content-Type", "multipart/x-mixed-replace; boundary="+s.mw.Boundary())
+	h.Set("Cache-Control", "no-cache")
+	s.w.WriteHeader(http.StatusOK)
+}
+
+// Flush sends everything written so far to the client. It is a no-op when
+// nothing is pending.
+func (s *MixedReplaceStreamer) Flush() error {
+	if !s.pending {
+		return nil
+	}
+	s.pending = false
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// Close writes the closing boundary and flushes it, ending the stream.
+func (s *MixedReplaceStreamer) Close() error {
+	s.start()
+	if err := s.mw.Close(); err != nil {
+		return err
+	}
+	s.pending = true
+	return s.Flush()
+}
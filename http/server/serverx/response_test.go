@@ -0,0 +1,159 @@
+package serverx
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []Range
+	}{
+		{"bytes=0-4", []Range{{0, 5}}},
+		{"bytes=5-", []Range{{5, 5}}},
+		{"bytes=-3", []Range{{7, 3}}},
+		{"bytes=-30", []Range{{0, 10}}},
+		{"bytes=8-20", []Range{{8, 2}}},
+		{"bytes=0-0, 2-3,20-30", []Range{{0, 1}, {2, 2}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRange(tt.header, 10)
+		if err != nil {
+			t.Errorf("ParseRange(%q): %v", tt.header, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseRange(%q) = %v, want %v", tt.header, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("ParseRange(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		}
+	}
+	for _, header := range []string{"items=0-1", "bytes=4-2", "bytes=x-1", "bytes=10-", "bytes=-0"} {
+		if _, err := ParseRange(header, 10); !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("ParseRange(%q) err = %v", header, err)
+		}
+	}
+}
+
+func TestServeRanges(t *testing.T) {
+	const content = "0123456789"
+	serve := func(rangeHeader string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/file", nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		ServeRanges(rec, req, "text/plain", strings.NewReader(content), int64(len(content)))
+		return rec.Result()
+	}
+
+	if resp := serve(""); resp.StatusCode != http.StatusOK {
+		t.Errorf("no Range: status %d", resp.StatusCode)
+	}
+	if resp := serve("bytes=20-"); resp.StatusCode != http.StatusRequestedRangeNotSatisfiable ||
+		resp.Header.Get("Content-Range") != "bytes */10" {
+		t.Errorf("unsatisfiable: status %d, Content-Range %q", resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+
+	resp := serve("bytes=2-4")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "234" ||
+		resp.Header.Get("Content-Range") != "bytes 2-4/10" {
+		t.Errorf("single range: status %d, Content-Range %q, body %q",
+			resp.StatusCode, resp.Header.Get("Content-Range"), body)
+	}
+
+	resp = serve("bytes=0-1,-2")
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode != http.StatusPartialContent || mediaType != "multipart/byteranges" {
+		t.Fatalf("status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	var got []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(p)
+		got = append(got, p.Header.Get("Content-Range")+"="+string(b))
+	}
+	if s := strings.Join(got, ","); s != "bytes 0-1/10=01,bytes 8-9/10=89" {
+		t.Errorf("parts = %q", s)
+	}
+}
+
+func TestRangeWriterPartLength(t *testing.T) {
+	rw := NewRangeWriter(httptest.NewRecorder(), "text/plain", 10)
+	pw, err := rw.WritePart(Range{Start: 0, Length: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pw.Write([]byte("0123")); err == nil {
+		t.Error("overlong write accepted")
+	}
+	pw.Write([]byte("01"))
+	if err := rw.Close(); err == nil {
+		t.Error("Close accepted a short part")
+	}
+	rw = NewRangeWriter(httptest.NewRecorder(), "text/plain", 10)
+	if _, err := rw.WritePart(Range{Start: 8, Length: 5}); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("range past the end: err = %v", err)
+	}
+}
+
+func TestMixedReplaceStreamer(t *testing.T) {
+	rec := httptest.NewRecorder()
+	s := NewMixedReplaceStreamer(rec)
+	if err := s.WriteFrame("image/jpeg", []byte("frame1")); err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Flushed {
+		t.Error("frame not flushed")
+	}
+	pw, err := s.NextPart("text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(pw, "status ")
+	io.WriteString(pw, "ok")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := rec.Result()
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "multipart/x-mixed-replace" {
+		t.Fatalf("Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	var got []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(p)
+		got = append(got, p.Header.Get("Content-Type")+"["+p.Header.Get("Content-Length")+"]="+string(b))
+	}
+	if s := strings.Join(got, ","); s != "image/jpeg[6]=frame1,text/plain[]=status ok" {
+		t.Errorf("parts = %q", s)
+	}
+}
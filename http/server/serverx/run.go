@@ -0,0 +1,177 @@
+package serverx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long Run waits for in-flight requests.
+const DefaultShutdownTimeout = 10 * time.Second
+
+type runConfig struct {
+	shutdownTimeout time.Duration
+	signals         []os.Signal
+	ready           func(net.Addr)
+}
+
+// RunOption configures Run and Start.
+type RunOption func(*runConfig)
+
+// ShutdownTimeout sets how long in-flight requests may take to finish
+// before remaining connections are closed forcibly.
+func ShutdownTimeout(d time.Duration) RunOption {
+	return func(c *runConfig) { c.shutdownTimeout = d }
+}
+
+// Signals replaces the signals that trigger shutdown (SIGINT and SIGTERM by
+// default). Calling it without arguments disables signal handling.
+func Signals(sig ...os.Signal) RunOption {
+	return func(c *runConfig) { c.signals = sig }
+}
+
+// OnReady is called with the listener address once the server accepts
+// connections.
+func OnReady(fn func(addr net.Addr)) RunOption {
+	return func(c *runConfig) { c.ready = fn }
+}
+
+// Server is a running server returned by Start.
+type Server struct {
+	srv    *http.Server
+	ln     net.Listener
+	stop   context.CancelFunc
+	done   chan struct{}
+	err    error
+	config runConfig
+	tls    bool
+}
+
+// Run serves srv until ctx is canceled or a shutdown signal arrives, then
+// shuts it down gracefully. It returns the serve, shutdown and close errors
+// joined together; a clean shutdown returns nil.
+func Run(ctx context.Context, srv *http.Server, opts ...RunOption) error {
+	s, err := Start(ctx, srv, opts...)
+	if err != nil {
+		return err
+	}
+	return s.Wait()
+}
+
+// Start binds the listener of srv and serves it in the background. When
+// Start returns without error the server already accepts connections, so
+// no sleep is needed before sending requests. An empty srv.Addr listens on
+// a random localhost port.
+func Start(ctx context.Context, srv *http.Server, opts ...RunOption) (*Server, error) {
+	cfg := runConfig{
+		shutdownTimeout: DefaultShutdownTimeout,
+		signals:         []os.Signal{os.Interrupt, syscall.SIGTERM},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	addr := srv.Addr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := context.WithCancel(ctx)
+	if len(cfg.signals) > 0 {
+		var stopSignals context.CancelFunc
+		ctx, stopSignals = signal.NotifyContext(ctx, cfg.signals...)
+		stop = chainCancel(stop, stopSignals)
+	}
+	// Serve fills in srv.TLSConfig for HTTP/2, so decide on TLS up front.
+	s := &Server{srv: srv, ln: ln, stop: stop, done: make(chan struct{}), config: cfg, tls: srv.TLSConfig != nil}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.tls {
+			serveErr <- srv.ServeTLS(ln, "", "")
+		} else {
+			serveErr <- srv.Serve(ln)
+		}
+	}()
+	go s.supervise(ctx, serveErr)
+
+	if cfg.ready != nil {
+		cfg.ready(ln.Addr())
+	}
+	return s, nil
+}
+
+func (s *Server) supervise(ctx context.Context, serveErr chan error) {
+	defer close(s.done)
+	defer s.stop()
+
+	var errs []error
+	select {
+	case err := <-serveErr:
+		// Serve failed on its own; nothing left to drain.
+		s.err = filterClosed(err)
+		return
+	case <-ctx.Done():
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), s.config.shutdownTimeout)
+	defer cancel()
+	if err := s.srv.Shutdown(sctx); err != nil {
+		errs = append(errs, err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			errs = append(errs, s.srv.Close())
+		}
+	}
+	errs = append(errs, filterClosed(<-serveErr))
+	s.err = errors.Join(errs...)
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// URL returns the base URL of the server, e.g. "http://127.0.0.1:8080".
+func (s *Server) URL() string {
+	scheme := "http"
+	if s.tls {
+		scheme = "https"
+	}
+	return scheme + "://" + s.ln.Addr().String()
+}
+
+// Shutdown starts a graceful shutdown and waits for it to finish.
+func (s *Server) Shutdown() error {
+	s.stop()
+	return s.Wait()
+}
+
+// Wait blocks until the server has stopped and returns its errors.
+func (s *Server) Wait() error {
+	<-s.done
+	return s.err
+}
+
+func filterClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func chainCancel(fns ...context.CancelFunc) context.CancelFunc {
+	return func() {
+		for _, fn := range fns {
+			fn()
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package serverx
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStartServesImmediately(t *testing.T) {
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})}
+	var readyAddr net.Addr
+	s, err := Start(context.Background(), srv, OnReady(func(a net.Addr) { readyAddr = a }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readyAddr == nil || readyAddr.String() != s.Addr().String() {
+		t.Errorf("OnReady addr = %v, want %v", readyAddr, s.Addr())
+	}
+
+	resp, err := http.Get(s.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("body = %q", body)
+	}
+	if err := s.Shutdown(); err != nil {
+		t.Errorf("Shutdown() = %v", err)
+	}
+}
+
+func TestRunDrainTimeout(t *testing.T) {
+	inHandler := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(inHandler)
+		time.Sleep(time.Second)
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addr := make(chan net.Addr, 1)
+	result := make(chan error, 1)
+	go func() {
+		result <- Run(ctx, srv, ShutdownTimeout(50*time.Millisecond), Signals(), OnReady(func(a net.Addr) { addr <- a }))
+	}()
+	go http.Get("http://" + (<-addr).String())
+	<-inHandler
+	cancel()
+
+	if err := <-result; err == nil {
+		t.Error("expected shutdown timeout error")
+	}
+}
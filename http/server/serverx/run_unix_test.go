@@ -0,0 +1,28 @@
+//go:build unix
+
+package serverx
+
+import (
+	"context"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunSignal(t *testing.T) {
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	s, err := Start(context.Background(), srv, Signals(syscall.SIGUSR1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop on signal")
+	}
+	if err := s.Wait(); err != nil {
+		t.Errorf("Wait() = %v", err)
+	}
+}
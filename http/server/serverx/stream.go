@@ -0,0 +1,165 @@
+// Package serverx contains helpers for the server side of the streaming
+// examples: graceful running, streamed multipart uploads and multipart
+// responses.
+package serverx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+var (
+	// ErrPartTooLarge is returned when a single part exceeds MaxPartSize.
+	ErrPartTooLarge = errors.New("serverx: multipart part too large")
+	// ErrBodyTooLarge is returned when the whole body exceeds MaxTotalSize.
+	ErrBodyTooLarge = errors.New("serverx: multipart body too large")
+	// ErrTooManyParts is returned when the body has more than MaxParts parts.
+	ErrTooManyParts = errors.New("serverx: too many multipart parts")
+)
+
+// DefaultDrainLimit mirrors how much net/http itself is willing to read from
+// an unconsumed body to keep a connection alive.
+const DefaultDrainLimit = 256 << 10
+
+type streamConfig struct {
+	maxPartSize  int64
+	maxTotalSize int64
+	maxParts     int
+	drainLimit   int64
+}
+
+// StreamOption configures StreamParts.
+type StreamOption func(*streamConfig)
+
+// MaxPartSize limits the bytes of a single part, headers included. The limit
+// is enforced on the request body, so it is exact to within the multipart
+// reader's internal buffer (a few KB).
+func MaxPartSize(n int64) StreamOption {
+	return func(c *streamConfig) { c.maxPartSize = n }
+}
+
+// MaxTotalSize limits the bytes of the whole request body.
+func MaxTotalSize(n int64) StreamOption {
+	return func(c *streamConfig) { c.maxTotalSize = n }
+}
+
+// MaxParts limits the number of parts.
+func MaxParts(n int) StreamOption {
+	return func(c *streamConfig) { c.maxParts = n }
+}
+
+// DrainLimit sets how many unread bytes are discarded after an early abort
+// so the connection can be reused. Larger bodies are left unread and
+// net/http closes the connection instead.
+func DrainLimit(n int64) StreamOption {
+	return func(c *streamConfig) { c.drainLimit = n }
+}
+
+// StreamParts calls fn for every part of the multipart request body in
+// order, without buffering parts in memory or on disk as
+// ParseMultipartForm does. Iteration stops at the first error returned by
+// fn or by a limit; the rest of the body is then drained up to DrainLimit
+// bytes and closed.
+func StreamParts(r *http.Request, fn func(*multipart.Part) error, opts ...StreamOption) (err error) {
+	cfg := streamConfig{drainLimit: DefaultDrainLimit}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	body := &limitedBody{r: r.Body, cfg: &cfg}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, r.Body}
+	defer func() {
+		if err != nil {
+			drain(body, cfg.drainLimit)
+		}
+	}()
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	for n := 1; ; n++ {
+		body.startPart()
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return body.cause(err)
+		}
+		if cfg.maxParts > 0 && n > cfg.maxParts {
+			part.Close()
+			return fmt.Errorf("%w: more than %d", ErrTooManyParts, cfg.maxParts)
+		}
+		err = fn(part)
+		part.Close()
+		if err != nil {
+			return body.cause(err)
+		}
+	}
+}
+
+// drain discards up to limit remaining bytes. Reading stops on the first
+// error, including the limit errors of limitedBody.
+func drain(body *limitedBody, limit int64) {
+	body.cfg.maxPartSize, body.cfg.maxTotalSize = 0, 0
+	io.CopyN(io.Discard, body.r, limit)
+}
+
+// limitedBody enforces the size limits on the raw request body.
+type limitedBody struct {
+	r         io.Reader
+	cfg       *streamConfig
+	total     int64
+	partStart int64
+	exceeded  error
+}
+
+func (b *limitedBody) startPart() {
+	b.partStart = b.total
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded != nil {
+		return 0, b.exceeded
+	}
+	n, err := b.r.Read(p)
+	b.total += int64(n)
+	switch {
+	case b.cfg.maxTotalSize > 0 && b.total > b.cfg.maxTotalSize:
+		b.exceeded = fmt.Errorf("%w: limit %d bytes", ErrBodyTooLarge, b.cfg.maxTotalSize)
+	case b.cfg.maxPartSize > 0 && b.total-b.partStart > b.cfg.maxPartSize:
+		b.exceeded = fmt.Errorf("%w: limit %d bytes", ErrPartTooLarge, b.cfg.maxPartSize)
+	}
+	if b.exceeded != nil {
+		return 0, b.exceeded
+	}
+	return n, err
+}
+
+// cause prefers a limit violation over whatever error it surfaced as, since
+// the multipart reader and user callbacks may wrap or replace it.
+func (b *limitedBody) cause(err error) error {
+	if b.exceeded != nil && !errors.Is(err, b.exceeded) {
+		return b.exceeded
+	}
+	return err
+}
+
+// StatusCode maps errors from StreamParts to an HTTP status code.
+func StatusCode(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, ErrPartTooLarge), errors.Is(err, ErrBodyTooLarge), errors.Is(err, ErrTooManyParts):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusBadRequest
+	}
+}
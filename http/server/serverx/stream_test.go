@@ -0,0 +1,189 @@
+package serverx
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func multipartRequest(t *testing.T, parts map[string]string, order ...string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, name := range order {
+		fw, _ := mw.CreateFormFile(name, name+".txt")
+		fw.Write([]byte(parts[name]))
+	}
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestStreamParts(t *testing.T) {
+	req := multipartRequest(t, map[string]string{"a": "first", "b": "second"}, "a", "b")
+
+	var got []string
+	err := StreamParts(req, func(p *multipart.Part) error {
+		b, err := io.ReadAll(p)
+		got = append(got, p.FormName()+"="+string(b))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(got, ","); s != "a=first,b=second" {
+		t.Errorf("parts = %q", s)
+	}
+}
+
+func TestStreamPartsLimits(t *testing.T) {
+	big := strings.Repeat("x", 64<<10)
+	tests := []struct {
+		name string
+		opt  StreamOption
+		want error
+	}{
+		{"part", MaxPartSize(16 << 10), ErrPartTooLarge},
+		{"total", MaxTotalSize(32 << 10), ErrBodyTooLarge},
+		{"count", MaxParts(1), ErrTooManyParts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := multipartRequest(t, map[string]string{"small": "ok", "big": big}, "small", "big")
+			err := StreamParts(req, func(p *multipart.Part) error {
+				_, err := io.Copy(io.Discard, p)
+				return err
+			}, tt.opt)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if StatusCode(err) != http.StatusRequestEntityTooLarge {
+				t.Errorf("StatusCode = %d", StatusCode(err))
+			}
+		})
+	}
+}
+
+func TestStreamPartsEarlyAbortDrains(t *testing.T) {
+	req := multipartRequest(t, map[string]string{"a": "1", "b": "2"}, "a", "b")
+	body := req.Body
+
+	stop := errors.New("stop")
+	err := StreamParts(req, func(p *multipart.Part) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Fatalf("err = %v", err)
+	}
+	if n, _ := body.Read(make([]byte, 1)); n != 0 {
+		t.Error("body was not drained after abort")
+	}
+}
+
+// countingBody counts the bytes StreamParts pulls from the request body.
+type countingBody struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// FuzzStreamParts feeds arbitrary bodies and limits to StreamParts. It must
+// never panic, never hand out more than the limits allow, map every failure
+// to 400 or 413, and agree with a plain mime/multipart read when no limit
+// is hit.
+func FuzzStreamParts(f *testing.F) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.SetBoundary("xyz")
+	mw.WriteField("a", "first")
+	fw, _ := mw.CreateFormFile("b", "b.txt")
+	fw.Write([]byte("second"))
+	mw.Close()
+	body := buf.Bytes()
+	f.Add(body, "xyz", uint16(0), uint16(0), uint8(0))
+	f.Add(body, "xyz", uint16(8), uint16(0), uint8(0))
+	f.Add(body, "xyz", uint16(0), uint16(200), uint8(1))
+	f.Add([]byte("--xyz\r\nContent-Disposition: form-data; name=\"f1\"\r\n\r\nval--xyz\r\n"+
+		"Content-Disposition: form-data; name=\"f2\"\r\n\r\nvalue2ue1\r\n--xyz--\r\n"), "xyz", uint16(0), uint16(0), uint8(0))
+	f.Add([]byte("--xyz\r\nContent-Disposition: form-data; name=\"a\"\n\r\n1\r\n"), "xyz", uint16(0), uint16(0), uint8(0))
+
+	f.Fuzz(func(t *testing.T, data []byte, boundary string, maxPart, maxTotal uint16, maxParts uint8) {
+		contentType := mime.FormatMediaType("multipart/form-data", map[string]string{"boundary": boundary})
+		if boundary == "" || contentType == "" {
+			return
+		}
+		var opts []StreamOption
+		if maxPart > 0 {
+			opts = append(opts, MaxPartSize(int64(maxPart)))
+		}
+		if maxTotal > 0 {
+			opts = append(opts, MaxTotalSize(int64(maxTotal)))
+		}
+		if maxParts > 0 {
+			opts = append(opts, MaxParts(int(maxParts)))
+		}
+
+		counted := &countingBody{r: bytes.NewReader(data)}
+		req := httptest.NewRequest(http.MethodPost, "/upload", counted)
+		req.Header.Set("Content-Type", contentType)
+		parts := 0
+		err := StreamParts(req, func(p *multipart.Part) error {
+			parts++
+			n, err := io.Copy(io.Discard, p)
+			if err == nil && maxPart > 0 && n > int64(maxPart) {
+				t.Fatalf("part %d yielded %d bytes, limit %d", parts, n, maxPart)
+			}
+			return err
+		}, opts...)
+
+		if maxParts > 0 && parts > int(maxParts) {
+			t.Fatalf("%d parts handed out, limit %d", parts, maxParts)
+		}
+		if code := StatusCode(err); err != nil && code != http.StatusBadRequest && code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("StatusCode(%v) = %d", err, code)
+		}
+		if err == nil && maxTotal > 0 && counted.n > int(maxTotal) {
+			t.Fatalf("read %d body bytes without error, limit %d", counted.n, maxTotal)
+		}
+
+		limited := errors.Is(err, ErrPartTooLarge) || errors.Is(err, ErrBodyTooLarge) || errors.Is(err, ErrTooManyParts)
+		if limited {
+			return
+		}
+		plain := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(data))
+		plain.Header.Set("Content-Type", contentType)
+		plainParts, plainErr := readAllParts(plain)
+		if (err == nil) != (plainErr == nil) || (err == nil && parts != plainParts) {
+			t.Fatalf("StreamParts: %d parts, err %v; mime/multipart: %d parts, err %v", parts, err, plainParts, plainErr)
+		}
+	})
+}
+
+func readAllParts(r *http.Request) (int, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return 0, err
+	}
+	for n := 0; ; n++ {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if _, err := io.Copy(io.Discard, p); err != nil {
+			return n, err
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package tusserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a Store for unknown upload IDs.
+var ErrNotFound = errors.New("tusserver: upload not found")
+
+// Info describes an upload.
+type Info struct {
+	ID           string            `json:"id"`
+	Size         int64             `json:"size"` // -1 while the length is deferred
+	Offset       int64             `json:"offset"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	ExpiresAt    time.Time         `json:"expires_at,omitzero"`
+	SizeDeferred bool              `json:"size_deferred,omitempty"`
+}
+
+// Complete reports whether all bytes have been received.
+func (i Info) Complete() bool {
+	return !i.SizeDeferred && i.Offset == i.Size
+}
+
+// Store persists uploads. The handler serializes calls per upload ID and
+// validates offsets before calling WriteChunk.
+type Store interface {
+	Create(ctx context.Context, info Info) (Info, error)
+	Get(ctx context.Context, id string) (Info, error)
+	// WriteChunk appends r at info.Offset and returns the number of bytes
+	// written. Bytes written before an error must be counted so an
+	// interrupted PATCH can be resumed.
+	WriteChunk(ctx context.Context, info Info, r io.Reader) (int64, error)
+	// Update persists changed Offset, Size or ExpiresAt.
+	Update(ctx context.Context, info Info) error
+	Delete(ctx context.Context, id string) error
+	// List returns all uploads; used for expiration cleanup.
+	List(ctx context.Context) ([]Info, error)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// FileStore keeps each upload as "<id>.bin" with its Info in "<id>.info".
+type FileStore struct {
+	Dir string
+}
+
+func (s FileStore) path(id, ext string) (string, error) {
+	// IDs come from URLs; only accept what newID produces.
+	if id == "" || strings.Trim(id, "0123456789abcdef") != "" {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.Dir, id+ext), nil
+}
+
+func (s FileStore) Create(_ context.Context, info Info) (Info, error) {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return Info{}, err
+	}
+	info.ID = newID()
+	bin, _ := s.path(info.ID, ".bin")
+	f, err := os.OpenFile(bin, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return Info{}, err
+	}
+	f.Close()
+	return info, s.Update(context.Background(), info)
+}
+
+func (s FileStore) Get(_ context.Context, id string) (Info, error) {
+	p, err := s.path(id, ".info")
+	if err != nil {
+		return Info{}, err
+	}
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return Info{}, ErrNotFound
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	var info Info
+	err = json.Unmarshal(b, &info)
+	return info, err
+}
+
+func (s FileStore) WriteChunk(_ context.Context, info Info, r io.Reader) (int64, error) {
+	bin, err := s.path(info.ID, ".bin")
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(bin, os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(info.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(f, r)
+}
+
+func (s FileStore) Update(_ context.Context, info Info) error {
+	p, err := s.path(info.ID, ".info")
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	// Write and rename so a crash never leaves a torn info file.
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s FileStore) Delete(_ context.Context, id string) error {
+	bin, err := s.path(id, ".bin")
+	if err != nil {
+		return err
+	}
+	info, _ := s.path(id, ".info")
+	if err := os.Remove(info); errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return os.Remove(bin)
+}
+
+func (s FileStore) List(ctx context.Context) ([]Info, error) {
+	matches, err := filepath.Glob(filepath.Join(s.Dir, "*.info"))
+	if err != nil {
+		return nil, err
+	}
+	var infos []Info
+	for _, m := range matches {
+		info, err := s.Get(ctx, strings.TrimSuffix(filepath.Base(m), ".info"))
+		if err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// Open returns the data of a finished upload.
+func (s FileStore) Open(id string) (*os.File, error) {
+	bin, err := s.path(id, ".bin")
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(bin)
+}
+
+// MemoryStore keeps uploads in memory; useful for tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	infos   map[string]Info
+	content map[string][]byte
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{infos: map[string]Info{}, content: map[string][]byte{}}
+}
+
+func (s *MemoryStore) Create(_ context.Context, info Info) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info.ID = newID()
+	s.infos[info.ID] = info
+	return info, nil
+}
+
+func (s *MemoryStore) Get(_ context.Context, id string) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, ok := s.infos[id]
+	if !ok {
+		return Info{}, ErrNotFound
+	}
+	return info, nil
+}
+
+func (s *MemoryStore) WriteChunk(_ context.Context, info Info, r io.Reader) (int64, error) {
+	var buf strings.Builder
+	n, err := io.Copy(&buf, r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.content[info.ID] = append(s.content[info.ID][:info.Offset], buf.String()...)
+	return n, err
+}
+
+func (s *MemoryStore) Update(_ context.Context, info Info) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.infos[info.ID]; !ok {
+		return ErrNotFound
+	}
+	s.infos[info.ID] = info
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.infos[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.infos, id)
+	delete(s.content, id)
+	return nil
+}
+
+func (s *MemoryStore) List(context.Context) ([]Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]Info, 0, len(s.infos))
+	for _, info := range s.infos {
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Bytes returns the data received so far for id.
+func (s *MemoryStore) Bytes(id string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.content[id]...)
+}
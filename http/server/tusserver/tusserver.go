@@ -0,0 +1,379 @@
+// Package tusserver implements the server side of the tus resumable upload
+// protocol (https://tus.io/protocols/resumable-upload) version 1.0.0 with
+// the creation, creation-defer-length, expiration and termination
+// extensions.
+package tusserver
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is the protocol version implemented by the handler.
+const Version = "1.0.0"
+
+const extensions = "creation,creation-defer-length,expiration,termination"
+
+// Config configures a Handler.
+type Config struct {
+	Store Store
+	// BasePath is the URL path uploads are created at, e.g. "/files/".
+	BasePath string
+	// MaxSize limits the length of a single upload; 0 means no limit.
+	MaxSize int64
+	// Expiration is how long an unfinished upload is kept after its last
+	// PATCH; 0 disables expiration.
+	Expiration time.Duration
+	// OnComplete is called after the last byte of an upload was stored.
+	OnComplete func(Info)
+}
+
+// Handler serves the tus protocol. Create it with New.
+type Handler struct {
+	cfg   Config
+	mu    sync.Mutex
+	locks map[string]struct{} // IDs of uploads a request is working on
+	now   func() time.Time
+}
+
+// New returns a Handler for cfg.
+func New(cfg Config) *Handler {
+	if !strings.HasSuffix(cfg.BasePath, "/") {
+		cfg.BasePath += "/"
+	}
+	return &Handler{cfg: cfg, locks: map[string]struct{}{}, now: time.Now}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", Version)
+	if r.Method == http.MethodOptions {
+		h.options(w)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != Version {
+		w.Header().Set("Tus-Version", Version)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	// Browsers and proxies that only speak GET/POST use this override.
+	method := r.Method
+	if o := r.Header.Get("X-HTTP-Method-Override"); o != "" && r.Method == http.MethodPost {
+		method = o
+	}
+
+	id, ok := strings.CutPrefix(r.URL.Path, h.cfg.BasePath)
+	if !ok || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case id == "" && method == http.MethodPost:
+		h.create(w, r)
+	case id != "" && method == http.MethodHead:
+		h.head(w, r, id)
+	case id != "" && method == http.MethodPatch:
+		h.patch(w, r, id)
+	case id != "" && method == http.MethodDelete:
+		h.delete(w, r, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) options(w http.ResponseWriter) {
+	w.Header().Set("Tus-Version", Version)
+	w.Header().Set("Tus-Extension", extensions)
+	if h.cfg.MaxSize > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.cfg.MaxSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	info := Info{Size: -1, CreatedAt: h.now()}
+	if r.Header.Get("Upload-Defer-Length") == "1" {
+		info.SizeDeferred = true
+	} else {
+		size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || size < 0 {
+			http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+			return
+		}
+		info.Size = size
+	}
+	if h.cfg.MaxSize > 0 && info.Size > h.cfg.MaxSize {
+		http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	meta, err := ParseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	info.Metadata = meta
+	h.touch(&info)
+
+	info, err = h.cfg.Store.Create(r.Context(), info)
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	w.Header().Set("Location", h.cfg.BasePath+info.ID)
+	h.setExpires(w, info)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) head(w http.ResponseWriter, r *http.Request, id string) {
+	info, ok := h.lookup(w, r, id)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	if info.SizeDeferred {
+		w.Header().Set("Upload-Defer-Length", "1")
+	} else {
+		w.Header().Set("Upload-Length", strconv.FormatInt(info.Size, 10))
+	}
+	if len(info.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", FormatMetadata(info.Metadata))
+	}
+	h.setExpires(w, info)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	// One PATCH per upload at a time; a concurrent one would race on the offset.
+	unlock, ok := h.tryLock(id)
+	if !ok {
+		http.Error(w, "upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer unlock()
+
+	info, ok := h.lookup(w, r, id)
+	if !ok {
+		return
+	}
+	if offset != info.Offset {
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+	if info.SizeDeferred {
+		if l := r.Header.Get("Upload-Length"); l != "" {
+			size, err := strconv.ParseInt(l, 10, 64)
+			if err != nil || size < info.Offset || (h.cfg.MaxSize > 0 && size > h.cfg.MaxSize) {
+				http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+				return
+			}
+			info.Size, info.SizeDeferred = size, false
+		}
+	}
+
+	limit := info.Size - info.Offset
+	if info.SizeDeferred {
+		limit = h.cfg.MaxSize - info.Offset
+		if h.cfg.MaxSize == 0 {
+			limit = 1<<63 - 1
+		}
+	}
+	body := &limitReader{r: r.Body, n: limit}
+	n, err := h.cfg.Store.WriteChunk(r.Context(), info, body)
+	info.Offset += n
+	h.touch(&info)
+	if uerr := h.cfg.Store.Update(context.WithoutCancel(r.Context()), info); uerr != nil && err == nil {
+		err = uerr
+	}
+	// Bytes received before a failure are kept; the client resumes from HEAD.
+	if n > 0 && info.Complete() && h.cfg.OnComplete != nil {
+		h.cfg.OnComplete(info)
+	}
+	if body.exceeded {
+		http.Error(w, "chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	h.setExpires(w, info)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request, id string) {
+	unlock, ok := h.tryLock(id)
+	if !ok {
+		http.Error(w, "upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer unlock()
+	if err := h.cfg.Store.Delete(r.Context(), id); err != nil {
+		h.fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookup loads an upload and answers 404 or 410 when it is gone.
+func (h *Handler) lookup(w http.ResponseWriter, r *http.Request, id string) (Info, bool) {
+	info, err := h.cfg.Store.Get(r.Context(), id)
+	if err != nil {
+		h.fail(w, err)
+		return Info{}, false
+	}
+	if h.expired(info) {
+		http.Error(w, "upload expired", http.StatusGone)
+		return Info{}, false
+	}
+	return info, true
+}
+
+func (h *Handler) fail(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("tusserver: %v", err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
+
+func (h *Handler) touch(info *Info) {
+	if h.cfg.Expiration > 0 && !info.Complete() {
+		info.ExpiresAt = h.now().Add(h.cfg.Expiration)
+	}
+}
+
+func (h *Handler) expired(info Info) bool {
+	return !info.ExpiresAt.IsZero() && !info.Complete() && h.now().After(info.ExpiresAt)
+}
+
+func (h *Handler) setExpires(w http.ResponseWriter, info Info) {
+	if !info.ExpiresAt.IsZero() && !info.Complete() {
+		w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// tryLock claims the upload id for the calling request, or reports false
+// if another request holds it. Claims are checked and taken under one lock,
+// so two requests can never hold the same upload.
+func (h *Handler) tryLock(id string) (func(), bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, held := h.locks[id]; held {
+		return nil, false
+	}
+	h.locks[id] = struct{}{}
+	return func() {
+		h.mu.Lock()
+		delete(h.locks, id)
+		h.mu.Unlock()
+	}, true
+}
+
+// Cleanup deletes unfinished uploads whose expiration has passed and
+// returns how many were removed.
+func (h *Handler) Cleanup(ctx context.Context) (int, error) {
+	infos, err := h.cfg.Store.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, info := range infos {
+		if !h.expired(info) {
+			continue
+		}
+		unlock, ok := h.tryLock(info.ID)
+		if !ok {
+			continue
+		}
+		if err := h.cfg.Store.Delete(ctx, info.ID); err == nil {
+			removed++
+		}
+		unlock()
+	}
+	return removed, nil
+}
+
+// ParseMetadata decodes an Upload-Metadata header: comma-separated
+// "key base64value" pairs, the value being optional.
+func ParseMetadata(header string) (map[string]string, error) {
+	meta := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("tusserver: empty Upload-Metadata key")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, errors.New("tusserver: invalid Upload-Metadata value for " + key)
+		}
+		meta[key] = string(decoded)
+	}
+	return meta, nil
+}
+
+// FormatMetadata encodes meta as an Upload-Metadata header value.
+func FormatMetadata(meta map[string]string) string {
+	pairs := make([]string, 0, len(meta))
+	for _, k := range sortedKeys(meta) {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(meta[k])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// limitReader is io.LimitReader that remembers whether more data was offered.
+type limitReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		var one [1]byte
+		if n, _ := l.r.Read(one[:]); n > 0 {
+			l.exceeded = true
+			return 0, errors.New("chunk exceeds Upload-Length")
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
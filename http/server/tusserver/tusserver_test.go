@@ -0,0 +1,185 @@
+package tusserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func do(t *testing.T, h http.Handler, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", Version)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func patch(t *testing.T, h http.Handler, loc string, offset, body string) *httptest.ResponseRecorder {
+	return do(t, h, http.MethodPatch, loc, body,
+		"Content-Type", "application/offset+octet-stream", "Upload-Offset", offset)
+}
+
+func TestUploadLifecycle(t *testing.T) {
+	store := NewMemoryStore()
+	var completed Info
+	h := New(Config{Store: store, BasePath: "/files", OnComplete: func(i Info) { completed = i }})
+
+	rec := do(t, h, http.MethodPost, "/files/", "", "Upload-Length", "11", "Upload-Metadata", FormatMetadata(map[string]string{"filename": "a.txt"}))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+	loc := rec.Header().Get("Location")
+
+	if rec := patch(t, h, loc, "0", "hello "); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "6" {
+		t.Fatalf("patch 1: %d offset=%s", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	if rec := patch(t, h, loc, "0", "again"); rec.Code != http.StatusConflict {
+		t.Errorf("stale offset: %d, want 409", rec.Code)
+	}
+
+	rec = do(t, h, http.MethodHead, loc, "")
+	if rec.Header().Get("Upload-Offset") != "6" || rec.Header().Get("Upload-Length") != "11" {
+		t.Errorf("head: %v", rec.Header())
+	}
+	if meta, _ := ParseMetadata(rec.Header().Get("Upload-Metadata")); meta["filename"] != "a.txt" {
+		t.Errorf("metadata = %v", meta)
+	}
+
+	if rec := patch(t, h, loc, "6", "world!!"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("overlong chunk: %d, want 413", rec.Code)
+	}
+	// The bytes within Upload-Length were kept and completed the upload.
+	if rec := do(t, h, http.MethodHead, loc, ""); rec.Header().Get("Upload-Offset") != "11" {
+		t.Errorf("offset after overlong chunk = %s", rec.Header().Get("Upload-Offset"))
+	}
+	if got := string(store.Bytes(completed.ID)); got != "hello world" {
+		t.Errorf("content = %q", got)
+	}
+
+	if rec := do(t, h, http.MethodDelete, loc, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: %d", rec.Code)
+	}
+	if rec := do(t, h, http.MethodHead, loc, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("head after delete: %d", rec.Code)
+	}
+}
+
+func TestDeferredLengthAndExpiration(t *testing.T) {
+	store := NewMemoryStore()
+	h := New(Config{Store: store, BasePath: "/files/", Expiration: time.Hour})
+	now := time.Now()
+	h.now = func() time.Time { return now }
+
+	rec := do(t, h, http.MethodPost, "/files/", "", "Upload-Defer-Length", "1")
+	loc := rec.Header().Get("Location")
+	if rec.Header().Get("Upload-Expires") == "" {
+		t.Error("missing Upload-Expires")
+	}
+	if rec := patch(t, h, loc, "0", "abc"); rec.Code != http.StatusNoContent {
+		t.Fatalf("patch: %d", rec.Code)
+	}
+	if rec := do(t, h, http.MethodHead, loc, ""); rec.Header().Get("Upload-Defer-Length") != "1" {
+		t.Errorf("head: %v", rec.Header())
+	}
+
+	now = now.Add(2 * time.Hour)
+	if rec := patch(t, h, loc, "3", "d"); rec.Code != http.StatusGone {
+		t.Errorf("expired patch: %d, want 410", rec.Code)
+	}
+	if n, err := h.Cleanup(context.Background()); n != 1 || err != nil {
+		t.Errorf("Cleanup = %d, %v", n, err)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	h := New(Config{Store: NewMemoryStore(), BasePath: "/files/", MaxSize: 10})
+
+	req := httptest.NewRequest(http.MethodPost, "/files/", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("missing Tus-Resumable: %d", rec.Code)
+	}
+
+	rec = do(t, h, http.MethodOptions, "/files/", "")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Tus-Max-Size") != "10" {
+		t.Errorf("options: %d %v", rec.Code, rec.Header())
+	}
+	if rec := do(t, h, http.MethodPost, "/files/", "", "Upload-Length", "11"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("too large: %d", rec.Code)
+	}
+	loc := do(t, h, http.MethodPost, "/files/", "", "Upload-Length", "5").Header().Get("Location")
+	if rec := do(t, h, http.MethodPatch, loc, "x", "Upload-Offset", "0"); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("wrong content type: %d", rec.Code)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	store := FileStore{Dir: t.TempDir()}
+	h := New(Config{Store: store, BasePath: "/files/"})
+
+	loc := do(t, h, http.MethodPost, "/files/", "", "Upload-Length", "6").Header().Get("Location")
+	patch(t, h, loc, "0", "abc")
+	patch(t, h, loc, "3", "def")
+
+	f, err := store.Open(strings.TrimPrefix(loc, "/files/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 16)
+	n, _ := f.Read(buf)
+	if string(buf[:n]) != "abcdef" {
+		t.Errorf("content = %q", buf[:n])
+	}
+	if _, err := store.Get(context.Background(), "../etc"); err != ErrNotFound {
+		t.Errorf("path traversal id: %v", err)
+	}
+}
+
+func TestConcurrentPatch(t *testing.T) {
+	store := NewMemoryStore()
+	h := New(Config{Store: store, BasePath: "/files"})
+	rec := do(t, h, http.MethodPost, "/files/", "", "Upload-Length", "100000")
+	loc := rec.Header().Get("Location")
+
+	var wg sync.WaitGroup
+	var accepted atomic.Int64
+	for g := range 8 {
+		wg.Go(func() {
+			chunk := strings.Repeat(strconv.Itoa(g), 4)
+			for range 200 {
+				offset := do(t, h, http.MethodHead, loc, "").Header().Get("Upload-Offset")
+				if rec := patch(t, h, loc, offset, chunk); rec.Code == http.StatusNoContent {
+					accepted.Add(1)
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	offset := do(t, h, http.MethodHead, loc, "").Header().Get("Upload-Offset")
+	if want := strconv.FormatInt(4*accepted.Load(), 10); offset != want {
+		t.Fatalf("offset = %s after %d accepted chunks, want %s", offset, accepted.Load(), want)
+	}
+	id := strings.TrimPrefix(loc, "/files/")
+	content := store.Bytes(id)
+	if int64(len(content)) != 4*accepted.Load() {
+		t.Fatalf("stored %d bytes for %d accepted chunks", len(content), accepted.Load())
+	}
+	for i := 0; i+4 <= len(content); i += 4 {
+		if c := content[i : i+4]; strings.Count(string(c), string(c[0])) != 4 {
+			t.Fatalf("interleaved chunk %q at %d", c, i)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package uploadserver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Inspector examines the content of a file part while it is being stored.
+// r yields the same bytes the Storage receives; returning an error rejects
+// the part, aborting the upload mid-stream. An Inspector may return before
+// reading r to the end.
+//
+// Inspectors run concurrently with the Storage but are fed through a
+// synchronous pipe, so a slow Inspector slows down the upload.
+type Inspector interface {
+	Inspect(ctx context.Context, obj Object, r io.Reader) error
+}
+
+// InspectorFunc adapts a function to the Inspector interface.
+type InspectorFunc func(ctx context.Context, obj Object, r io.Reader) error
+
+func (f InspectorFunc) Inspect(ctx context.Context, obj Object, r io.Reader) error {
+	return f(ctx, obj, r)
+}
+
+// RejectedError is returned when an Inspector rejects a file part.
+type RejectedError struct {
+	Field    string
+	FileName string
+	Err      error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("uploadserver: file %q in field %q rejected: %v", e.FileName, e.Field, e.Err)
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// Rejection is the structured description of a RejectedError in the JSON
+// error response.
+type Rejection struct {
+	Field    string `json:"field"`
+	FileName string `json:"filename"`
+	Reason   string `json:"reason"`
+}
+
+// inspection fans the content of one part out to all inspectors.
+type inspection struct {
+	writers []*io.PipeWriter
+	results chan error
+	obj     Object
+}
+
+func startInspection(ctx context.Context, inspectors []Inspector, obj Object) *inspection {
+	in := &inspection{results: make(chan error, len(inspectors)), obj: obj}
+	for _, insp := range inspectors {
+		pr, pw := io.Pipe()
+		in.writers = append(in.writers, pw)
+		go func() {
+			err := insp.Inspect(ctx, obj, pr)
+			if err != nil {
+				err = &RejectedError{Field: obj.Field, FileName: obj.FileName, Err: err}
+				// Fails the pending write, which aborts the storage read.
+				pr.CloseWithError(err)
+			} else {
+				io.Copy(io.Discard, pr)
+			}
+			in.results <- err
+		}()
+	}
+	return in
+}
+
+// Write feeds p to every inspector; it fails once any of them rejected.
+func (in *inspection) Write(p []byte) (int, error) {
+	for _, w := range in.writers {
+		if _, err := w.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// wait ends the content stream and returns the first rejection, if any.
+// cause is the storage error, passed on to inspectors still reading.
+func (in *inspection) wait(cause error) error {
+	for _, w := range in.writers {
+		w.CloseWithError(cause)
+	}
+	var rejected error
+	for range in.writers {
+		if err := <-in.results; err != nil && rejected == nil {
+			rejected = err
+		}
+	}
+	return rejected
+}
+
+// MagicBytes rejects files whose content, as detected by
+// http.DetectContentType from the first 512 bytes, is not one of Allowed.
+// Patterns such as "image/*" are allowed. The declared Content-Type is
+// ignored, as clients control it.
+type MagicBytes struct {
+	Allowed []string
+}
+
+func (m MagicBytes) Inspect(_ context.Context, _ Object, r io.Reader) error {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	detected, _, _ := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	allowed := slices.ContainsFunc(m.Allowed, func(pattern string) bool {
+		ok, _ := path.Match(pattern, detected)
+		return ok
+	})
+	if !allowed {
+		return fmt.Errorf("content type %s is not allowed (allowed: %s)", detected, strings.Join(m.Allowed, ", "))
+	}
+	return nil
+}
+
+// MaxDecompressedSize rejects gzip-compressed files that expand to more
+// than Limit bytes, guarding later processing against decompression bombs.
+// Content that is not gzip passes unchanged.
+type MaxDecompressedSize struct {
+	Limit int64
+}
+
+func (m MaxDecompressedSize) Inspect(_ context.Context, _ Object, r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return fmt.Errorf("invalid gzip stream: %w", err)
+	}
+	n, err := io.Copy(io.Discard, io.LimitReader(zr, m.Limit+1))
+	if n > m.Limit {
+		return fmt.Errorf("decompressed size exceeds %d bytes", m.Limit)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid gzip stream: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package uploadserver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestInspectors(t *testing.T) {
+	var bomb bytes.Buffer
+	zw := gzip.NewWriter(&bomb)
+	zw.Write(make([]byte, 1<<20))
+	zw.Close()
+
+	signature := InspectorFunc(func(_ context.Context, _ Object, r io.Reader) error {
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			if strings.Contains(sc.Text(), "EICAR") {
+				return errors.New("signature EICAR found")
+			}
+		}
+		return nil
+	})
+
+	tests := []struct {
+		name      string
+		inspector Inspector
+		content   []byte
+		reason    string
+	}{
+		{"magic bytes", MagicBytes{Allowed: []string{"image/*"}}, []byte("<html>fake png</html>"), "text/html"},
+		{"decompression bomb", MaxDecompressedSize{Limit: 1 << 10}, bomb.Bytes(), "exceeds"},
+		{"mid-stream scanner", signature, []byte(strings.Repeat("clean line\n", 10000) + "EICAR\n" + strings.Repeat("x\n", 10000)), "EICAR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			h := New(Config{Storage: DirStorage{Dir: dir}, Inspectors: []Inspector{tt.inspector}})
+			rec := upload(t, h, func(mw *multipart.Writer) {
+				fw, _ := mw.CreateFormFile("doc", "a.png")
+				fw.Write(tt.content)
+			})
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+			var resp ErrorResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Rejected == nil || resp.Rejected.Field != "doc" || !strings.Contains(resp.Rejected.Reason, tt.reason) {
+				t.Errorf("rejected = %+v", resp.Rejected)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("%d files left behind", len(entries))
+			}
+		})
+	}
+}
+
+func TestInspectorsAccept(t *testing.T) {
+	h := New(Config{
+		Storage:    DirStorage{Dir: t.TempDir()},
+		Inspectors: []Inspector{MagicBytes{Allowed: []string{"image/png"}}, MaxDecompressedSize{Limit: 10}},
+	})
+	rec := upload(t, h, func(mw *multipart.Writer) {
+		fw, _ := mw.CreateFormFile("doc", "a.png")
+		fw.Write(append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 4096)...))
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,214 @@
+package uploadserver
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProgressHeader is the request header carrying the client-chosen upload ID.
+// The "upload_id" query parameter is accepted as well, for HTML forms.
+const ProgressHeader = "X-Upload-ID"
+
+// Upload states reported by Progress.
+const (
+	StateUploading = "uploading"
+	StateDone      = "done"
+	StateFailed    = "failed"
+)
+
+// Progress is a snapshot of one upload.
+type Progress struct {
+	ID             string    `json:"id"`
+	State          string    `json:"state"`
+	BytesReceived  int64     `json:"bytes_received"`
+	BytesTotal     int64     `json:"bytes_total"` // -1 when the client sent no Content-Length
+	PartsCompleted int64     `json:"parts_completed"`
+	CurrentPart    string    `json:"current_part,omitempty"`
+	StartedAt      time.Time `json:"started_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// Metrics aggregates all uploads seen by a ProgressRegistry.
+type Metrics struct {
+	Active        int64 `json:"active"`
+	Completed     int64 `json:"completed"`
+	Failed        int64 `json:"failed"`
+	BytesReceived int64 `json:"bytes_received"`
+}
+
+// ProgressRegistry tracks uploads in memory so clients can poll their
+// progress while the upload request is still running. Create it with
+// NewProgressRegistry and set it as Config.Progress.
+type ProgressRegistry struct {
+	retain time.Duration
+
+	mu      sync.Mutex
+	uploads map[string]*tracker
+
+	active, completed, failed, bytes atomic.Int64
+}
+
+// NewProgressRegistry returns a registry that keeps finished uploads for
+// retain, so a last poll can see the final state.
+func NewProgressRegistry(retain time.Duration) *ProgressRegistry {
+	return &ProgressRegistry{retain: retain, uploads: map[string]*tracker{}}
+}
+
+type tracker struct {
+	reg *ProgressRegistry
+
+	bytes atomic.Int64
+	parts atomic.Int64
+
+	mu         sync.Mutex
+	p          Progress
+	finishedAt time.Time
+}
+
+func (reg *ProgressRegistry) start(id string, total int64) *tracker {
+	now := time.Now()
+	t := &tracker{reg: reg, p: Progress{ID: id, State: StateUploading, BytesTotal: total, StartedAt: now, UpdatedAt: now}}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.purgeLocked(now)
+	reg.uploads[id] = t
+	reg.active.Add(1)
+	return t
+}
+
+// purgeLocked drops finished uploads older than the retention period.
+func (reg *ProgressRegistry) purgeLocked(now time.Time) {
+	for id, t := range reg.uploads {
+		t.mu.Lock()
+		expired := !t.finishedAt.IsZero() && now.Sub(t.finishedAt) > reg.retain
+		t.mu.Unlock()
+		if expired {
+			delete(reg.uploads, id)
+		}
+	}
+}
+
+// Get returns the progress of the upload with the given ID.
+func (reg *ProgressRegistry) Get(id string) (Progress, bool) {
+	reg.mu.Lock()
+	reg.purgeLocked(time.Now())
+	t, ok := reg.uploads[id]
+	reg.mu.Unlock()
+	if !ok {
+		return Progress{}, false
+	}
+	return t.snapshot(), true
+}
+
+// Metrics returns aggregate counters.
+func (reg *ProgressRegistry) Metrics() Metrics {
+	return Metrics{
+		Active:        reg.active.Load(),
+		Completed:     reg.completed.Load(),
+		Failed:        reg.failed.Load(),
+		BytesReceived: reg.bytes.Load(),
+	}
+}
+
+// Handler serves the progress of one upload as JSON. It is meant to be
+// registered as "GET /uploads/{id}/progress"; without a {id} wildcard the ID
+// is taken from the path segment before "/progress".
+func (reg *ProgressRegistry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if id == "" {
+			rest := strings.TrimSuffix(r.URL.Path, "/progress")
+			id = rest[strings.LastIndex(rest, "/")+1:]
+		}
+		p, ok := reg.Get(id)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "unknown upload"})
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, p)
+	})
+}
+
+// MetricsHandler serves Metrics as JSON.
+func (reg *ProgressRegistry) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, reg.Metrics())
+	})
+}
+
+func (t *tracker) snapshot() Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.p
+	p.BytesReceived = t.bytes.Load()
+	p.PartsCompleted = t.parts.Load()
+	return p
+}
+
+func (t *tracker) beginPart(name string) {
+	t.mu.Lock()
+	t.p.CurrentPart = name
+	t.p.UpdatedAt = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *tracker) endPart() {
+	t.parts.Add(1)
+	t.mu.Lock()
+	t.p.CurrentPart = ""
+	t.p.UpdatedAt = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *tracker) finish(err error) {
+	t.mu.Lock()
+	t.p.UpdatedAt = time.Now()
+	t.finishedAt = t.p.UpdatedAt
+	t.p.CurrentPart = ""
+	if err != nil {
+		t.p.State = StateFailed
+		t.p.Error = err.Error()
+		t.reg.failed.Add(1)
+	} else {
+		t.p.State = StateDone
+		t.reg.completed.Add(1)
+	}
+	t.mu.Unlock()
+	t.reg.active.Add(-1)
+}
+
+// progressBody counts request body bytes as the multipart reader consumes them.
+type progressBody struct {
+	r io.ReadCloser
+	t *tracker
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.t.bytes.Add(int64(n))
+	b.t.reg.bytes.Add(int64(n))
+	if n > 0 {
+		b.t.mu.Lock()
+		b.t.p.UpdatedAt = time.Now()
+		b.t.mu.Unlock()
+	}
+	return n, err
+}
+
+func (b *progressBody) Close() error {
+	return b.r.Close()
+}
+
+func uploadID(r *http.Request) string {
+	if id := r.Header.Get(ProgressHeader); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("upload_id")
+}
@@ -0,0 +1,91 @@
+package uploadserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProgressEndpoint(t *testing.T) {
+	reg := NewProgressRegistry(time.Minute)
+
+	// The storage blocks mid-upload so the progress can be observed.
+	inStorage := make(chan struct{})
+	release := make(chan struct{})
+	storage := WriterStorage{New: func(context.Context, Object) (io.WriteCloser, string, error) {
+		close(inStorage)
+		<-release
+		return nopCloser{io.Discard}, "discard", nil
+	}}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /upload", New(Config{Storage: storage, Progress: reg}))
+	mux.Handle("GET /uploads/{id}/progress", reg.Handler())
+	mux.Handle("GET /uploads/metrics", reg.MetricsHandler())
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		mw.WriteField("title", "x")
+		fw, _ := mw.CreateFormFile("doc", "a.bin")
+		fw.Write(make([]byte, 1000))
+		mw.Close()
+		pw.Close()
+	}()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/upload?upload_id=abc", pr)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	done := make(chan *http.Response)
+	go func() {
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- resp
+	}()
+
+	<-inStorage
+	p := getProgress(t, srv.URL+"/uploads/abc/progress")
+	if p.State != StateUploading || p.PartsCompleted != 1 || p.CurrentPart != "doc" || p.BytesReceived == 0 {
+		t.Errorf("in-flight progress = %+v", p)
+	}
+
+	close(release)
+	resp := <-done
+	resp.Body.Close()
+	p = getProgress(t, srv.URL+"/uploads/abc/progress")
+	if p.State != StateDone || p.PartsCompleted != 2 {
+		t.Errorf("final progress = %+v", p)
+	}
+	if m := reg.Metrics(); m.Active != 0 || m.Completed != 1 || m.BytesReceived != p.BytesReceived {
+		t.Errorf("metrics = %+v", m)
+	}
+
+	resp, _ = srv.Client().Get(srv.URL + "/uploads/missing/progress")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown upload status = %d", resp.StatusCode)
+	}
+}
+
+func getProgress(t *testing.T, url string) Progress {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var p Progress
+	json.NewDecoder(resp.Body).Decode(&p)
+	return p
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,173 @@
+package uploadserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Object describes a file part about to be stored.
+type Object struct {
+	Field       string
+	FileName    string
+	ContentType string
+	Header      textproto.MIMEHeader
+}
+
+// Stored is what a Storage reports back for a stored object.
+type Stored struct {
+	Location string // backend-specific: path, key or URL
+	Size     int64
+}
+
+// Storage persists file parts. Put must consume r until EOF or return an error.
+type Storage interface {
+	Put(ctx context.Context, obj Object, r io.Reader) (Stored, error)
+}
+
+// Deleter is implemented by storages that can remove stored objects. The
+// handler uses it to clean up files of an upload that failed later on.
+type Deleter interface {
+	Delete(ctx context.Context, s Stored) error
+}
+
+// DirStorage writes each file into Dir under a random prefix, so clients
+// can neither overwrite each other nor escape the directory.
+type DirStorage struct {
+	Dir string
+}
+
+func (d DirStorage) Put(_ context.Context, obj Object, r io.Reader) (Stored, error) {
+	if err := os.MkdirAll(d.Dir, 0o755); err != nil {
+		return Stored{}, err
+	}
+	path := filepath.Join(d.Dir, randomName()+"_"+SafeName(obj.FileName))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return Stored{}, err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return Stored{}, err
+	}
+	return Stored{Location: path, Size: n}, nil
+}
+
+func (d DirStorage) Delete(_ context.Context, s Stored) error {
+	return os.Remove(s.Location)
+}
+
+// TempStorage spools files into temporary files, e.g. before handing them
+// to a slower backend. Dir defaults to os.TempDir.
+type TempStorage struct {
+	Dir string
+}
+
+func (t TempStorage) Put(_ context.Context, obj Object, r io.Reader) (Stored, error) {
+	f, err := os.CreateTemp(t.Dir, "upload-*-"+SafeName(obj.FileName))
+	if err != nil {
+		return Stored{}, err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return Stored{}, err
+	}
+	return Stored{Location: f.Name(), Size: n}, nil
+}
+
+func (t TempStorage) Delete(_ context.Context, s Stored) error {
+	return os.Remove(s.Location)
+}
+
+// WriterStorage streams each file into the writer returned by New, which
+// also names the location reported in the manifest.
+type WriterStorage struct {
+	New func(ctx context.Context, obj Object) (w io.WriteCloser, location string, err error)
+}
+
+func (s WriterStorage) Put(ctx context.Context, obj Object, r io.Reader) (Stored, error) {
+	w, location, err := s.New(ctx, obj)
+	if err != nil {
+		return Stored{}, err
+	}
+	n, err := io.Copy(w, r)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return Stored{}, err
+	}
+	return Stored{Location: location, Size: n}, nil
+}
+
+// PutObjectAPI is the subset of an S3-style client used by PutObjectStorage.
+// size is -1 because streamed parts have no known length.
+type PutObjectAPI interface {
+	PutObject(ctx context.Context, bucket, key string, body io.Reader, size int64, contentType string) error
+}
+
+// PutObjectStorage stores files with an S3-style PutObject call.
+type PutObjectStorage struct {
+	Client PutObjectAPI
+	Bucket string
+	// Key builds the object key; defaults to "<random>/<filename>".
+	Key func(obj Object) string
+}
+
+func (s PutObjectStorage) Put(ctx context.Context, obj Object, r io.Reader) (Stored, error) {
+	key := randomName() + "/" + SafeName(obj.FileName)
+	if s.Key != nil {
+		key = s.Key(obj)
+	}
+	counter := &countingReader{r: r}
+	if err := s.Client.PutObject(ctx, s.Bucket, key, counter, -1, obj.ContentType); err != nil {
+		return Stored{}, err
+	}
+	return Stored{Location: s.Bucket + "/" + key, Size: counter.n}, nil
+}
+
+// SafeName reduces a client-supplied file name to a base name without path
+// separators or control characters.
+func SafeName(name string) string {
+	name = filepath.Base(filepath.Clean("/" + strings.ReplaceAll(name, `\`, "/")))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "/" || name == "." {
+		return "file"
+	}
+	return name
+}
+
+func randomName() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
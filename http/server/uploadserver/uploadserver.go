@@ -0,0 +1,294 @@
+// Package uploadserver provides an http.Handler that accepts multipart
+// uploads and streams file parts into a pluggable Storage.
+package uploadserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/isauran/go-std-library/http/server/serverx"
+)
+
+// Config configures a Handler.
+type Config struct {
+	Storage Storage
+
+	// Required lists field or file names that must be present.
+	Required []string
+	// Files lists the form names accepted as file parts; empty accepts any.
+	Files []string
+	// MaxFieldSize limits a single non-file field; defaults to 1 MB.
+	MaxFieldSize int64
+	// MaxFileSize limits a single file part; 0 means no limit.
+	MaxFileSize int64
+	// MaxBodySize limits the whole request body; 0 means no limit.
+	MaxBodySize int64
+
+	// Inspectors examine every file part while it is stored and may reject it.
+	Inspectors []Inspector
+
+	// Progress, when set, tracks uploads that carry an ID in the
+	// X-Upload-ID header or the upload_id query parameter.
+	Progress *ProgressRegistry
+}
+
+// Manifest is the JSON document returned for a successful upload.
+type Manifest struct {
+	Fields map[string][]string `json:"fields"`
+	Files  []FileEntry         `json:"files"`
+}
+
+// FileEntry describes one stored file part.
+type FileEntry struct {
+	Field       string `json:"field"`
+	FileName    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	Location    string `json:"location"`
+}
+
+// ErrorResponse is the JSON document returned for a failed upload.
+type ErrorResponse struct {
+	Error    string            `json:"error"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Rejected *Rejection        `json:"rejected,omitempty"`
+}
+
+// ValidationError reports schema violations per field name.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ": " + e.Fields[name]
+	}
+	return "uploadserver: invalid form: " + strings.Join(parts, "; ")
+}
+
+// Handler accepts multipart uploads. Create it with New.
+type Handler struct {
+	cfg Config
+}
+
+// New returns a Handler for cfg.
+func New(cfg Config) *Handler {
+	if cfg.MaxFieldSize == 0 {
+		cfg.MaxFieldSize = 1 << 20
+	}
+	return &Handler{cfg: cfg}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"})
+		return
+	}
+
+	manifest, err := h.Receive(r)
+	if err != nil {
+		status := serverx.StatusCode(err)
+		resp := ErrorResponse{Error: err.Error()}
+		var verr *ValidationError
+		var rerr *RejectedError
+		if errors.As(err, &verr) {
+			status = http.StatusUnprocessableEntity
+			resp.Fields = verr.Fields
+		} else if errors.As(err, &rerr) {
+			status = http.StatusUnprocessableEntity
+			resp.Fields = map[string]string{rerr.Field: rerr.Err.Error()}
+			resp.Rejected = &Rejection{Field: rerr.Field, FileName: rerr.FileName, Reason: rerr.Err.Error()}
+		} else if errors.Is(err, errStorage) {
+			status = http.StatusInternalServerError
+		}
+		writeJSON(w, status, resp)
+		return
+	}
+	writeJSON(w, http.StatusCreated, manifest)
+}
+
+var (
+	errNoStorage = errors.New("uploadserver: no storage configured")
+	errStorage   = errors.New("storage failed")
+)
+
+// Receive streams the parts of r into the configured Storage and returns the
+// manifest. On error, files already stored are deleted when the Storage
+// implements Deleter.
+func (h *Handler) Receive(r *http.Request) (*Manifest, error) {
+	if h.cfg.Storage == nil {
+		return nil, errNoStorage
+	}
+	m := &Manifest{Fields: map[string][]string{}, Files: []FileEntry{}}
+	opts := []serverx.StreamOption{}
+	if h.cfg.MaxBodySize > 0 {
+		opts = append(opts, serverx.MaxTotalSize(h.cfg.MaxBodySize))
+	}
+
+	var progress *tracker
+	if id := uploadID(r); id != "" && h.cfg.Progress != nil {
+		progress = h.cfg.Progress.start(id, r.ContentLength)
+		r.Body = &progressBody{r: r.Body, t: progress}
+	}
+
+	err := serverx.StreamParts(r, func(part *multipart.Part) error {
+		if progress != nil {
+			progress.beginPart(part.FormName())
+		}
+		var err error
+		if part.FileName() == "" {
+			err = h.readField(m, part)
+		} else {
+			err = h.storeFile(r.Context(), m, part)
+		}
+		if progress != nil && err == nil {
+			progress.endPart()
+		}
+		return err
+	}, opts...)
+	if err == nil {
+		err = h.validate(m)
+	}
+	if progress != nil {
+		progress.finish(err)
+	}
+	if err != nil {
+		h.cleanup(r.Context(), m)
+		return nil, err
+	}
+	return m, nil
+}
+
+func (h *Handler) readField(m *Manifest, part *multipart.Part) error {
+	value, err := io.ReadAll(io.LimitReader(part, h.cfg.MaxFieldSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(value)) > h.cfg.MaxFieldSize {
+		return &ValidationError{Fields: map[string]string{
+			part.FormName(): fmt.Sprintf("longer than %d bytes", h.cfg.MaxFieldSize),
+		}}
+	}
+	m.Fields[part.FormName()] = append(m.Fields[part.FormName()], string(value))
+	return nil
+}
+
+func (h *Handler) storeFile(ctx context.Context, m *Manifest, part *multipart.Part) error {
+	if len(h.cfg.Files) > 0 && !slices.Contains(h.cfg.Files, part.FormName()) {
+		return &ValidationError{Fields: map[string]string{part.FormName(): "file not accepted"}}
+	}
+
+	var src io.Reader = part
+	limited := &sizeLimit{r: part, max: h.cfg.MaxFileSize}
+	if h.cfg.MaxFileSize > 0 {
+		src = limited
+	}
+	hash := sha256.New()
+	obj := Object{
+		Field:       part.FormName(),
+		FileName:    part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+		Header:      part.Header,
+	}
+	var in *inspection
+	if len(h.cfg.Inspectors) > 0 {
+		in = startInspection(ctx, h.cfg.Inspectors, obj)
+		src = io.TeeReader(src, in)
+	}
+	stored, err := h.cfg.Storage.Put(ctx, obj, io.TeeReader(src, hash))
+	if in != nil {
+		if rejected := in.wait(err); rejected != nil {
+			if err == nil {
+				// Rejected after the storage already had everything.
+				if d, ok := h.cfg.Storage.(Deleter); ok {
+					d.Delete(ctx, stored)
+				}
+			}
+			return rejected
+		}
+	}
+	if limited.exceeded {
+		return &ValidationError{Fields: map[string]string{
+			part.FormName(): fmt.Sprintf("file larger than %d bytes", h.cfg.MaxFileSize),
+		}}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", errStorage, part.FormName(), err)
+	}
+	m.Files = append(m.Files, FileEntry{
+		Field:       obj.Field,
+		FileName:    obj.FileName,
+		ContentType: obj.ContentType,
+		Size:        stored.Size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		Location:    stored.Location,
+	})
+	return nil
+}
+
+func (h *Handler) validate(m *Manifest) error {
+	missing := map[string]string{}
+	for _, name := range h.cfg.Required {
+		if _, ok := m.Fields[name]; ok {
+			continue
+		}
+		if slices.ContainsFunc(m.Files, func(f FileEntry) bool { return f.Field == name }) {
+			continue
+		}
+		missing[name] = "required"
+	}
+	if len(missing) > 0 {
+		return &ValidationError{Fields: missing}
+	}
+	return nil
+}
+
+func (h *Handler) cleanup(ctx context.Context, m *Manifest) {
+	d, ok := h.cfg.Storage.(Deleter)
+	if !ok {
+		return
+	}
+	for _, f := range m.Files {
+		d.Delete(ctx, Stored{Location: f.Location, Size: f.Size})
+	}
+}
+
+// sizeLimit fails reads once more than max bytes were read.
+type sizeLimit struct {
+	r        io.Reader
+	max, n   int64
+	exceeded bool
+}
+
+func (s *sizeLimit) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.n += int64(n)
+	if s.n > s.max {
+		s.exceeded = true
+		return 0, errors.New("file too large")
+	}
+	return n, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
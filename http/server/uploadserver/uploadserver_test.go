@@ -0,0 +1,139 @@
+package uploadserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func upload(t *testing.T, h http.Handler, build func(mw *multipart.Writer)) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	build(mw)
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlerStoresFiles(t *testing.T) {
+	dir := t.TempDir()
+	h := New(Config{Storage: DirStorage{Dir: dir}, Required: []string{"title", "doc"}})
+
+	rec := upload(t, h, func(mw *multipart.Writer) {
+		mw.WriteField("title", "report")
+		fw, _ := mw.CreateFormFile("doc", "../../evil.txt")
+		fw.Write([]byte("hello"))
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Fields["title"][0] != "report" || len(m.Files) != 1 {
+		t.Fatalf("manifest = %+v", m)
+	}
+	f := m.Files[0]
+	if filepath.Dir(f.Location) != dir || !strings.HasSuffix(f.Location, "_evil.txt") {
+		t.Errorf("location = %s", f.Location)
+	}
+	if f.Size != 5 || f.SHA256 != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("entry = %+v", f)
+	}
+	if b, _ := os.ReadFile(f.Location); string(b) != "hello" {
+		t.Errorf("stored content = %q", b)
+	}
+}
+
+func TestHandlerValidation(t *testing.T) {
+	dir := t.TempDir()
+	h := New(Config{
+		Storage:     DirStorage{Dir: dir},
+		Required:    []string{"title"},
+		Files:       []string{"doc"},
+		MaxFileSize: 4,
+	})
+
+	tests := []struct {
+		name  string
+		build func(mw *multipart.Writer)
+		field string
+	}{
+		{"missing required", func(mw *multipart.Writer) {
+			fw, _ := mw.CreateFormFile("doc", "a.txt")
+			fw.Write([]byte("ok"))
+		}, "title"},
+		{"file too large", func(mw *multipart.Writer) {
+			mw.WriteField("title", "x")
+			fw, _ := mw.CreateFormFile("doc", "a.txt")
+			fw.Write([]byte("too large"))
+		}, "doc"},
+		{"unexpected file", func(mw *multipart.Writer) {
+			mw.WriteField("title", "x")
+			fw, _ := mw.CreateFormFile("other", "a.txt")
+			fw.Write([]byte("ok"))
+		}, "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := upload(t, h, tt.build)
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+			var resp ErrorResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Fields[tt.field] == "" {
+				t.Errorf("fields = %v, want entry for %q", resp.Fields, tt.field)
+			}
+		})
+	}
+
+	// Files of failed uploads are cleaned up.
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d files left behind", len(entries))
+	}
+}
+
+type fakeS3 struct {
+	objects map[string][]byte
+}
+
+func (f *fakeS3) PutObject(_ context.Context, bucket, key string, body io.Reader, size int64, contentType string) error {
+	b, err := io.ReadAll(body)
+	f.objects[bucket+"/"+key] = b
+	return err
+}
+
+func TestPutObjectStorage(t *testing.T) {
+	s3 := &fakeS3{objects: map[string][]byte{}}
+	h := New(Config{Storage: PutObjectStorage{
+		Client: s3,
+		Bucket: "uploads",
+		Key:    func(obj Object) string { return obj.Field + "/" + obj.FileName },
+	}})
+
+	rec := upload(t, h, func(mw *multipart.Writer) {
+		fw, _ := mw.CreateFormFile("doc", "a.txt")
+		fw.Write([]byte("data"))
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if string(s3.objects["uploads/doc/a.txt"]) != "data" {
+		t.Errorf("objects = %v", s3.objects)
+	}
+}
@@ -0,0 +1,171 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// ErrStreamEnded is returned by Subscribe when the server replies 204 No
+// Content, which tells clients to stop reconnecting.
+var ErrStreamEnded = errors.New("sse: server ended the stream")
+
+// DefaultRetry is the reconnection delay until the server sets one with a
+// retry field.
+const DefaultRetry = 3 * time.Second
+
+type subscribeConfig struct {
+	retry       time.Duration
+	maxRetries  int
+	lastEventID string
+	header      http.Header
+}
+
+// SubscribeOption configures Subscribe.
+type SubscribeOption func(*subscribeConfig)
+
+// RetryDelay sets the initial reconnection delay. A retry field from the
+// server replaces it.
+func RetryDelay(d time.Duration) SubscribeOption {
+	return func(c *subscribeConfig) { c.retry = d }
+}
+
+// MaxRetries limits consecutive failed reconnection attempts. Any event
+// received resets the count. The default, 0, retries forever.
+func MaxRetries(n int) SubscribeOption {
+	return func(c *subscribeConfig) { c.maxRetries = n }
+}
+
+// LastEventID resumes a stream from an ID saved by an earlier subscription.
+func LastEventID(id string) SubscribeOption {
+	return func(c *subscribeConfig) { c.lastEventID = id }
+}
+
+// RequestHeader adds a header to every request, e.g. for authorization.
+func RequestHeader(key, value string) SubscribeOption {
+	return func(c *subscribeConfig) { c.header.Add(key, value) }
+}
+
+// Subscribe connects to the event stream at url and calls fn for every
+// event, in order. When the connection drops it reconnects after the retry
+// delay, sending the last event ID it saw so the server can resume.
+//
+// Subscribe returns when ctx is canceled (with ctx.Err()), when fn returns
+// an error (with that error), when the server replies 204 (ErrStreamEnded)
+// and, per the standard, on any other reply that is not a 200 event stream.
+// Network errors only end it after MaxRetries failed attempts.
+func Subscribe(ctx context.Context, client *http.Client, url string, fn func(Event) error, opts ...SubscribeOption) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	cfg := subscribeConfig{retry: DefaultRetry, header: http.Header{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	lastEventID := cfg.lastEventID
+	retry := cfg.retry
+	failures := 0
+	for {
+		dec, err := connect(ctx, client, url, lastEventID, cfg.header)
+		var fatal *fatalError
+		switch {
+		case errors.As(err, &fatal):
+			return fatal.err
+		case err == nil:
+			err = dispatch(dec, fn, &failures)
+			lastEventID = dec.lastEventID
+			if dec.retry > 0 {
+				retry = dec.retry
+			}
+			var cb *callbackError
+			if errors.As(err, &cb) {
+				return cb.err
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		failures++
+		if cfg.maxRetries > 0 && failures > cfg.maxRetries {
+			return fmt.Errorf("sse: giving up after %d attempts: %w", failures, err)
+		}
+
+		t := time.NewTimer(retry)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// fatalError is a reply that must not be retried.
+type fatalError struct{ err error }
+
+func (e *fatalError) Error() string { return e.err.Error() }
+
+// callbackError is an error returned by the event callback.
+type callbackError struct{ err error }
+
+func (e *callbackError) Error() string { return e.err.Error() }
+
+// connect opens the stream. Its decoder owns the response body, which is
+// closed when the stream is read to the end or fails.
+func connect(ctx context.Context, client *http.Client, url, lastEventID string, header http.Header) (*streamDecoder, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, &fatalError{err}
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", ContentType)
+	req.Header.Set("Cache-Control", "no-cache")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		resp.Body.Close()
+		return nil, &fatalError{ErrStreamEnded}
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode != http.StatusOK || mediaType != ContentType {
+		resp.Body.Close()
+		return nil, &fatalError{fmt.Errorf("sse: %s: %s, Content-Type %q", url, resp.Status, mediaType)}
+	}
+	return &streamDecoder{decoder: newDecoder(resp.Body, lastEventID), body: resp.Body}, nil
+}
+
+type streamDecoder struct {
+	*decoder
+	body io.Closer
+}
+
+// dispatch hands the events of one connection to fn until the stream
+// ends. Every event resets the failure count.
+func dispatch(dec *streamDecoder, fn func(Event) error, failures *int) error {
+	defer dec.body.Close()
+	for {
+		ev, err := dec.next()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		*failures = 0
+		if err := fn(ev); err != nil {
+			return &callbackError{err}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSlowClient is returned by Send, and then by Serve, when the
+	// client's buffer is full because it does not keep up with the events.
+	ErrSlowClient = errors.New("sse: client send buffer full")
+	// ErrClosed is returned by Send after Close.
+	ErrClosed = errors.New("sse: event writer closed")
+)
+
+// DefaultHeartbeat is how long a stream may stay idle before a comment is
+// sent to keep proxies from timing it out.
+const DefaultHeartbeat = 15 * time.Second
+
+// DefaultBuffer is the number of events queued for a client by default.
+const DefaultBuffer = 64
+
+type writerConfig struct {
+	heartbeat time.Duration
+	buffer    int
+}
+
+// WriterOption configures NewEventWriter.
+type WriterOption func(*writerConfig)
+
+// Heartbeat sets the idle interval after which a comment line is sent. Zero
+// or less disables heartbeats.
+func Heartbeat(d time.Duration) WriterOption {
+	return func(c *writerConfig) { c.heartbeat = d }
+}
+
+// Buffer sets how many events may wait for a slow client before it is
+// dropped with ErrSlowClient.
+func Buffer(n int) WriterOption {
+	return func(c *writerConfig) { c.buffer = max(n, 1) }
+}
+
+// EventWriter streams events to one client. Send may be called from any
+// goroutine, for example a broadcaster; the handler goroutine runs Serve,
+// which writes the queued events to the response until the client goes
+// away or the writer is closed.
+type EventWriter struct {
+	w         http.ResponseWriter
+	rc        *http.ResponseController
+	heartbeat time.Duration
+
+	mu     sync.Mutex
+	events chan Event
+	done   chan struct{}
+	err    error // why done was closed
+}
+
+// NewEventWriter returns an EventWriter for w. The headers are sent when
+// Serve starts.
+func NewEventWriter(w http.ResponseWriter, opts ...WriterOption) *EventWriter {
+	cfg := writerConfig{heartbeat: DefaultHeartbeat, buffer: DefaultBuffer}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &EventWriter{
+		w:         w,
+		rc:        http.NewResponseController(w),
+		heartbeat: cfg.heartbeat,
+		events:    make(chan Event, cfg.buffer),
+		done:      make(chan struct{}),
+	}
+}
+
+// Send queues ev for the client without blocking. A full buffer drops the
+// client: Send and Serve return ErrSlowClient. Once the writer has stopped,
+// Send returns the reason, such as ErrClosed or the request context's error.
+func (ew *EventWriter) Send(ev Event) error {
+	if err := ev.validate(); err != nil {
+		return err
+	}
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	if ew.err != nil {
+		return ew.err
+	}
+	select {
+	case ew.events <- ev:
+		return nil
+	default:
+		ew.stop(ErrSlowClient)
+		return ErrSlowClient
+	}
+}
+
+// Close ends the stream once the events already queued are written. Serve
+// then returns nil.
+func (ew *EventWriter) Close() {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	ew.stop(ErrClosed)
+}
+
+// stop records why the writer stopped and wakes Serve. ew.mu must be held.
+func (ew *EventWriter) stop(err error) {
+	if ew.err == nil {
+		ew.err = err
+		close(ew.done)
+	}
+}
+
+// Serve writes queued events and heartbeats until ctx is done (usually the
+// request context), Close is called, the client falls behind or a write
+// fails. It returns nil after Close and the cause otherwise.
+func (ew *EventWriter) Serve(ctx context.Context) error {
+	h := ew.w.Header()
+	h.Set("Content-Type", ContentType)
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // keep nginx from buffering the stream
+	ew.w.WriteHeader(http.StatusOK)
+	if err := ew.flush(); err != nil {
+		return ew.fail(err)
+	}
+
+	var tick <-chan time.Time
+	if ew.heartbeat > 0 {
+		t := time.NewTicker(ew.heartbeat)
+		defer t.Stop()
+		tick = t.C
+	}
+	idle := true
+	for {
+		select {
+		case ev := <-ew.events:
+			if err := ev.writeTo(ew.w); err != nil {
+				return ew.fail(err)
+			}
+			if err := ew.flush(); err != nil {
+				return ew.fail(err)
+			}
+			idle = false
+		case <-tick:
+			// Only comment after an interval without events.
+			if !idle {
+				idle = true
+				continue
+			}
+			if _, err := io.WriteString(ew.w, ":\n\n"); err != nil {
+				return ew.fail(err)
+			}
+			if err := ew.flush(); err != nil {
+				return ew.fail(err)
+			}
+		case <-ctx.Done():
+			return ew.fail(ctx.Err())
+		case <-ew.done:
+			return ew.drain()
+		}
+	}
+}
+
+// drain writes what is left in the buffer after Close. A slow client is
+// dropped without it.
+func (ew *EventWriter) drain() error {
+	ew.mu.Lock()
+	err := ew.err
+	ew.mu.Unlock()
+	if err != ErrClosed {
+		return err
+	}
+	for {
+		select {
+		case ev := <-ew.events:
+			if err := ev.writeTo(ew.w); err != nil {
+				return err
+			}
+		default:
+			return ew.flush()
+		}
+	}
+}
+
+// fail stops the writer with err so later Sends report it.
+func (ew *EventWriter) fail(err error) error {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	ew.stop(err)
+	return err
+}
+
+func (ew *EventWriter) flush() error {
+	if err := ew.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
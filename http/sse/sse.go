@@ -0,0 +1,127 @@
+// Package sse implements both ends of Server-Sent Events (text/event-stream):
+// EventWriter streams events to a client with heartbeats and a per-client
+// send buffer, and Subscribe consumes a stream, reconnecting with
+// Last-Event-ID after the connection drops.
+package sse
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentType is the media type of an event stream.
+const ContentType = "text/event-stream"
+
+// ErrInvalidEvent is returned when an event's ID or type contains a line
+// break, which would corrupt the stream.
+var ErrInvalidEvent = errors.New("sse: line break in event id or type")
+
+// Event is a single event of a stream.
+type Event struct {
+	ID    string        // sets the client's Last-Event-ID; empty keeps it
+	Event string        // event type; empty means "message"
+	Data  string        // may span several lines
+	Retry time.Duration // reconnection delay for the client; 0 leaves it unchanged
+}
+
+func (e Event) validate() error {
+	if strings.ContainsAny(e.ID, "\r\n") || strings.ContainsAny(e.Event, "\r\n") {
+		return ErrInvalidEvent
+	}
+	return nil
+}
+
+// writeTo writes e in wire format, followed by the blank line that
+// dispatches it.
+func (e Event) writeTo(w io.Writer) error {
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// decoder parses an event stream as described in the HTML Living Standard,
+// section 9.2.6. Lines may end in CRLF or LF.
+type decoder struct {
+	r           *bufio.Reader
+	lastEventID string
+	retry       time.Duration // last valid retry field, 0 if none
+	started     bool
+}
+
+func newDecoder(r io.Reader, lastEventID string) *decoder {
+	return &decoder{r: bufio.NewReader(r), lastEventID: lastEventID}
+}
+
+// next returns the next dispatched event. Its ID is the last event ID seen
+// so far in the stream, as the standard prescribes; retry fields are kept
+// on the decoder rather than the event.
+func (d *decoder) next() (Event, error) {
+	var (
+		ev      Event
+		data    strings.Builder
+		hasData bool
+	)
+	for {
+		line, err := d.r.ReadString('\n')
+		if err != nil {
+			// An event not terminated by a blank line is discarded.
+			return Event{}, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if !d.started {
+			d.started = true
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		if line == "" {
+			if !hasData {
+				ev.Event = ""
+				continue
+			}
+			ev.Data = data.String()
+			ev.ID = d.lastEventID
+			return ev, nil
+		}
+		if line[0] == ':' {
+			continue // comment, e.g. a heartbeat
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			ev.Event = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				d.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				d.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDecoder(t *testing.T) {
+	stream := "\ufeff: comment\r\n" +
+		"data: first\n\n" +
+		"event: update\nid: 7\ndata:two\ndata:  lines\n\n" +
+		"id: 8\n\n" + // no data: not dispatched, but the ID sticks
+		"retry: 1500\nevent: ignored\n\n" +
+		"data\n\n" + // empty data is still an event
+
+		"data: unterminated"
+	dec := newDecoder(strings.NewReader(stream), "")
+	want := []Event{
+		{Data: "first"},
+		{ID: "7", Event: "update", Data: "two\n lines"},
+		{ID: "8"},
+	}
+	for i, w := range want {
+		ev, err := dec.next()
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if ev != w {
+			t.Errorf("event %d = %+v, want %+v", i, ev, w)
+		}
+	}
+	if _, err := dec.next(); err != io.EOF {
+		t.Errorf("unterminated event: err = %v", err)
+	}
+	if dec.retry != 1500*time.Millisecond {
+		t.Errorf("retry = %v", dec.retry)
+	}
+}
+
+func TestEventWireFormat(t *testing.T) {
+	var b strings.Builder
+	ev := Event{ID: "1", Event: "note", Data: "a\r\nb", Retry: 2 * time.Second}
+	ev.writeTo(&b)
+	if want := "id: 1\nevent: note\nretry: 2000\ndata: a\ndata: b\n\n"; b.String() != want {
+		t.Errorf("wire = %q, want %q", b.String(), want)
+	}
+	got, err := newDecoder(strings.NewReader(b.String()), "").next()
+	if err != nil || got.Data != "a\nb" || got.Event != "note" {
+		t.Errorf("round trip = %+v, %v", got, err)
+	}
+	if err := (Event{ID: "a\nb"}).validate(); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("validate = %v", err)
+	}
+}
+
+// TestSubscribeReconnect serves two events per connection and checks the
+// client resumes from the last ID after each drop.
+func TestSubscribeReconnect(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		lastIDs []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lastIDs = append(lastIDs, r.Header.Get("Last-Event-ID"))
+		n := len(lastIDs)
+		mu.Unlock()
+		if n > 2 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		ew := NewEventWriter(w)
+		for i := range 2 {
+			id := string(rune('a' + 2*(n-1) + i))
+			ew.Send(Event{ID: id, Data: "event " + id, Retry: time.Millisecond})
+		}
+		ew.Close()
+		ew.Serve(r.Context())
+	}))
+	defer srv.Close()
+
+	var got []string
+	err := Subscribe(context.Background(), srv.Client(), srv.URL, func(ev Event) error {
+		got = append(got, ev.Data)
+		return nil
+	}, RetryDelay(time.Hour))
+	if !errors.Is(err, ErrStreamEnded) {
+		t.Fatalf("err = %v", err)
+	}
+	if s := strings.Join(got, ","); s != "event a,event b,event c,event d" {
+		t.Errorf("events = %q", s)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if s := strings.Join(lastIDs, ","); s != ",b,d" {
+		t.Errorf("Last-Event-ID headers = %q", s)
+	}
+}
+
+func TestSubscribeStops(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := NewEventWriter(w)
+		ew.Send(Event{Data: "one"})
+		ew.Send(Event{Data: "two"})
+		ew.Serve(r.Context())
+	}))
+	defer srv.Close()
+
+	stop := errors.New("stop")
+	err := Subscribe(context.Background(), srv.Client(), srv.URL, func(ev Event) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("callback error: err = %v", err)
+	}
+
+	notStream := httptest.NewServer(http.NotFoundHandler())
+	defer notStream.Close()
+	err = Subscribe(context.Background(), notStream.Client(), notStream.URL, func(Event) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("404 reply: err = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = Subscribe(ctx, srv.Client(), srv.URL, func(Event) error { return nil })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("canceled: err = %v", err)
+	}
+}
+
+func TestSubscribeMaxRetries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler) // drop the connection
+	}))
+	defer srv.Close()
+	err := Subscribe(context.Background(), srv.Client(), srv.URL, func(Event) error { return nil },
+		RetryDelay(time.Millisecond), MaxRetries(2))
+	if err == nil || !strings.Contains(err.Error(), "3 attempts") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestEventWriterHeartbeat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		NewEventWriter(w, Heartbeat(10*time.Millisecond)).Serve(r.Context())
+	}))
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != ":\n" {
+		t.Errorf("first line = %q, %v", line, err)
+	}
+}
+
+func TestEventWriterSlowClient(t *testing.T) {
+	ew := NewEventWriter(httptest.NewRecorder(), Buffer(2))
+	for range 2 {
+		if err := ew.Send(Event{Data: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ew.Send(Event{Data: "x"}); !errors.Is(err, ErrSlowClient) {
+		t.Fatalf("Send on full buffer = %v", err)
+	}
+	if err := ew.Serve(context.Background()); !errors.Is(err, ErrSlowClient) {
+		t.Errorf("Serve = %v", err)
+	}
+}
+
+func TestEventWriterClose(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ew := NewEventWriter(rec)
+	ew.Send(Event{Data: "last"})
+	ew.Close()
+	if err := ew.Serve(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if body := rec.Body.String(); body != "data: last\n\n" {
+		t.Errorf("body = %q", body)
+	}
+	if err := ew.Send(Event{Data: "late"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Send after Close = %v", err)
+	}
+}
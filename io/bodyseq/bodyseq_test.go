@@ -0,0 +1,93 @@
+package bodyseq
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "middle.txt")
+	if err := os.WriteFile(path, []byte("[file]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	body := Compose(
+		String("prefix:"),
+		File(path),
+		Generate(func(w io.Writer) error {
+			for i := range 3 {
+				fmt.Fprint(w, i)
+			}
+			return nil
+		}),
+		Reader(strings.NewReader(":")),
+		Bytes([]byte("suffix")),
+	)
+	defer body.Close()
+
+	got, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "prefix:[file]012:suffix" {
+		t.Errorf("body = %q", got)
+	}
+}
+
+func TestComposeLazyOpen(t *testing.T) {
+	opened := 0
+	counting := SourceFunc(func() (io.ReadCloser, error) {
+		opened++
+		return io.NopCloser(strings.NewReader("x")), nil
+	})
+
+	body := Compose(String("ab"), counting, counting)
+	buf := make([]byte, 2)
+	io.ReadFull(body, buf)
+	if opened != 0 {
+		t.Errorf("%d sources opened before their turn", opened)
+	}
+	body.Close()
+	if _, err := body.Read(buf); err != io.ErrClosedPipe {
+		t.Errorf("Read after Close = %v", err)
+	}
+	if opened != 0 {
+		t.Errorf("%d sources opened after Close", opened)
+	}
+}
+
+func TestComposeErrors(t *testing.T) {
+	_, err := io.ReadAll(Compose(String("a"), File(filepath.Join(t.TempDir(), "missing"))))
+	if !errors.Is(err, os.ErrNotExist) || !strings.Contains(err.Error(), "source 2") {
+		t.Errorf("missing file err = %v", err)
+	}
+
+	boom := errors.New("boom")
+	got, err := io.ReadAll(Compose(Generate(func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return boom
+	})))
+	if string(got) != "partial" || !errors.Is(err, boom) {
+		t.Errorf("generator = %q, %v", got, err)
+	}
+
+	stopped := make(chan error, 1)
+	body := Compose(Generate(func(w io.Writer) error {
+		for {
+			if _, err := io.WriteString(w, "forever"); err != nil {
+				stopped <- err
+				return err
+			}
+		}
+	}))
+	body.Read(make([]byte, 3))
+	body.Close()
+	if err := <-stopped; !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("generator write after Close = %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+// Package bufpipe provides an in-memory pipe with a ring buffer between the
+// writer and the reader.
+//
+// io.Pipe is fully synchronous: every Write blocks until a Read consumes it,
+// so a slow network stalls the producer on each write. A bufpipe absorbs up
+// to its capacity before the writer blocks, and watermark callbacks report
+// when the buffer fills up or drains, so producers can apply backpressure
+// deliberately instead of by accident.
+package bufpipe
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultCapacity is used by New for capacities below 1.
+const DefaultCapacity = 64 << 10
+
+// Option configures a pipe.
+type Option func(*pipe)
+
+// HighWatermark calls fn, with the number of buffered bytes, when a write
+// brings the buffer to level bytes or more. It fires again only after the
+// buffer has drained to the low watermark (0 by default).
+func HighWatermark(level int, fn func(buffered int)) Option {
+	return func(p *pipe) { p.high, p.onHigh = level, fn }
+}
+
+// LowWatermark calls fn, with the number of buffered bytes, when reads
+// drain the buffer to level bytes or less after the high watermark fired.
+func LowWatermark(level int, fn func(buffered int)) Option {
+	return func(p *pipe) { p.low, p.onLow = level, fn }
+}
+
+type pipe struct {
+	mu       sync.Mutex
+	canRead  sync.Cond
+	canWrite sync.Cond
+
+	buf        []byte
+	start, len int // ring buffer window
+
+	rerr error // set when the reader is closed
+	werr error // set when the writer is closed
+
+	high, low     int
+	onHigh, onLow func(int)
+	aboveHigh     bool
+}
+
+// New returns a connected pipe buffering up to capacity bytes.
+func New(capacity int, opts ...Option) (*PipeReader, *PipeWriter) {
+	if capacity < 1 {
+		capacity = DefaultCapacity
+	}
+	p := &pipe{buf: make([]byte, capacity)}
+	p.canRead.L = &p.mu
+	p.canWrite.L = &p.mu
+	for _, opt := range opts {
+		opt(p)
+	}
+	return &PipeReader{p}, &PipeWriter{p}
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	p.mu.Lock()
+	for p.len == 0 && p.werr == nil && p.rerr == nil {
+		p.canRead.Wait()
+	}
+	if p.rerr != nil {
+		p.mu.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	if p.len == 0 {
+		err := p.werr
+		p.mu.Unlock()
+		return 0, err
+	}
+
+	n := 0
+	for n < len(b) && p.len > 0 {
+		end := min(p.start+p.len, len(p.buf))
+		c := copy(b[n:], p.buf[p.start:end])
+		n += c
+		p.start = (p.start + c) % len(p.buf)
+		p.len -= c
+	}
+	var notify func(int)
+	if p.aboveHigh && p.len <= p.low {
+		p.aboveHigh = false
+		notify = p.onLow
+	}
+	buffered := p.len
+	p.canWrite.Broadcast()
+	p.mu.Unlock()
+
+	if notify != nil {
+		notify(buffered)
+	}
+	return n, nil
+}
+
+func (p *pipe) write(b []byte) (int, error) {
+	n := 0
+	for {
+		p.mu.Lock()
+		for p.len == len(p.buf) && p.rerr == nil && p.werr == nil {
+			p.canWrite.Wait()
+		}
+		switch {
+		case p.werr != nil:
+			p.mu.Unlock()
+			return n, io.ErrClosedPipe
+		case p.rerr != nil:
+			err := p.rerr
+			p.mu.Unlock()
+			return n, err
+		}
+
+		for n < len(b) && p.len < len(p.buf) {
+			at := (p.start + p.len) % len(p.buf)
+			end := len(p.buf)
+			if at < p.start {
+				end = p.start
+			}
+			c := copy(p.buf[at:end], b[n:])
+			n += c
+			p.len += c
+		}
+		var notify func(int)
+		if p.onHigh != nil && !p.aboveHigh && p.high > 0 && p.len >= p.high {
+			p.aboveHigh = true
+			notify = p.onHigh
+		}
+		buffered := p.len
+		p.canRead.Broadcast()
+		p.mu.Unlock()
+
+		if notify != nil {
+			notify(buffered)
+		}
+		if n == len(b) {
+			return n, nil
+		}
+	}
+}
+
+func (p *pipe) closeRead(err error) {
+	if err == nil {
+		err = io.ErrClosedPipe
+	}
+	p.mu.Lock()
+	if p.rerr == nil {
+		p.rerr = err
+		p.len = 0
+	}
+	p.canRead.Broadcast()
+	p.canWrite.Broadcast()
+	p.mu.Unlock()
+}
+
+func (p *pipe) closeWrite(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	p.mu.Lock()
+	if p.werr == nil {
+		p.werr = err
+	}
+	p.canRead.Broadcast()
+	p.canWrite.Broadcast()
+	p.mu.Unlock()
+}
+
+// buffered returns the number of bytes waiting to be read.
+func (p *pipe) buffered() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.len
+}
+
+// PipeReader is the read half of a pipe.
+type PipeReader struct {
+	p *pipe
+}
+
+// Read reads buffered data, blocking while the buffer is empty. Once the
+// writer is closed and the buffer drained it returns the writer's error
+// (io.EOF for Close).
+func (r *PipeReader) Read(b []byte) (int, error) {
+	return r.p.read(b)
+}
+
+// Close closes the reader; subsequent writes fail with io.ErrClosedPipe.
+func (r *PipeReader) Close() error {
+	return r.CloseWithError(nil)
+}
+
+// CloseWithError closes the reader and discards buffered data; subsequent
+// writes return err, or io.ErrClosedPipe if err is nil.
+func (r *PipeReader) CloseWithError(err error) error {
+	r.p.closeRead(err)
+	return nil
+}
+
+// Buffered returns the number of bytes waiting to be read.
+func (r *PipeReader) Buffered() int {
+	return r.p.buffered()
+}
+
+// PipeWriter is the write half of a pipe.
+type PipeWriter struct {
+	p *pipe
+}
+
+// Write copies b into the buffer, blocking only while the buffer is full.
+// It fails if the reader was closed.
+func (w *PipeWriter) Write(b []byte) (int, error) {
+	return w.p.write(b)
+}
+
+// Close closes the writer; the reader gets io.EOF after the buffered data.
+func (w *PipeWriter) Close() error {
+	return w.CloseWithError(nil)
+}
+
+// CloseWithError closes the writer; the reader gets err, or io.EOF if err
+// is nil, after the buffered data. Unlike io.Pipe, data already written is
+// never lost to a writer-side close.
+func (w *PipeWriter) CloseWithError(err error) error {
+	w.p.closeWrite(err)
+	return nil
+}
+
+// Buffered returns the number of bytes waiting to be read.
+func (w *PipeWriter) Buffered() int {
+	return w.p.buffered()
+}
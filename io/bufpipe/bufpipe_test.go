@@ -0,0 +1,100 @@
+package bufpipe
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	pr, pw := New(7) // small and odd, so writes wrap around the ring
+	want := make([]byte, 10000)
+	rand.Read(want)
+
+	go func() {
+		for b := want; len(b) > 0; {
+			n := min(len(b), 13)
+			if _, err := pw.Write(b[:n]); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			b = b[n:]
+		}
+		pw.Close()
+	}()
+	got, err := io.ReadAll(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("data corrupted in transit")
+	}
+}
+
+func TestWriteDoesNotBlockBelowCapacity(t *testing.T) {
+	pr, pw := New(1024)
+	done := make(chan struct{})
+	go func() {
+		pw.Write(make([]byte, 1000))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked with room in the buffer")
+	}
+	if pr.Buffered() != 1000 {
+		t.Errorf("Buffered = %d, want 1000", pr.Buffered())
+	}
+}
+
+func TestCloseSemantics(t *testing.T) {
+	boom := errors.New("boom")
+
+	pr, pw := New(16)
+	pw.Write([]byte("abc"))
+	pw.CloseWithError(boom)
+	got, err := io.ReadAll(pr)
+	if string(got) != "abc" || !errors.Is(err, boom) {
+		t.Errorf("read after writer error = %q, %v; want data then boom", got, err)
+	}
+	if _, err := pw.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Errorf("write after close = %v, want ErrClosedPipe", err)
+	}
+
+	pr, pw = New(4)
+	blocked := make(chan error)
+	go func() {
+		_, err := pw.Write([]byte("more than four"))
+		blocked <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	pr.CloseWithError(boom)
+	if err := <-blocked; !errors.Is(err, boom) {
+		t.Errorf("blocked write after reader close = %v, want boom", err)
+	}
+}
+
+func TestWatermarks(t *testing.T) {
+	var highs, lows atomic.Int32
+	pr, pw := New(100,
+		HighWatermark(80, func(n int) { highs.Add(1) }),
+		LowWatermark(20, func(n int) { lows.Add(1) }),
+	)
+
+	pw.Write(make([]byte, 50))
+	pw.Write(make([]byte, 40)) // 90 >= 80
+	pw.Write(make([]byte, 5))  // still high, no second callback
+	buf := make([]byte, 60)
+	pr.Read(buf) // 35 left
+	pr.Read(buf) // 0 left <= 20
+	pw.Write(make([]byte, 85))
+
+	if highs.Load() != 2 || lows.Load() != 1 {
+		t.Errorf("high = %d, low = %d; want 2, 1", highs.Load(), lows.Load())
+	}
+}
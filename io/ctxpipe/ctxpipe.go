@@ -0,0 +1,273 @@
+// Package ctxpipe provides a synchronous in-memory pipe, like io.Pipe, whose
+// reads and writes also give up when a deadline passes or a bound context
+// is done. A consumer that stops reading can no longer hang the producer
+// forever.
+package ctxpipe
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// onceError stores the first error only.
+type onceError struct {
+	sync.Mutex
+	err error
+}
+
+func (a *onceError) Store(err error) {
+	a.Lock()
+	defer a.Unlock()
+	if a.err != nil {
+		return
+	}
+	a.err = err
+}
+
+func (a *onceError) Load() error {
+	a.Lock()
+	defer a.Unlock()
+	return a.err
+}
+
+// deadline is a settable point in time; wait returns a channel that is
+// closed once it has passed. Modeled on the deadlines of net.Pipe.
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makeDeadline() deadline {
+	return deadline{cancel: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // wait for the timer callback to finish closing
+	}
+	d.timer = nil
+
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+type pipe struct {
+	wrMu sync.Mutex // serializes writes
+	wrCh chan []byte
+	rdCh chan int
+
+	once sync.Once
+	done chan struct{}
+	rerr onceError
+	werr onceError
+
+	ctx           context.Context
+	readDeadline  deadline
+	writeDeadline deadline
+}
+
+// New returns a connected pipe bound to ctx: once ctx is done, pending and
+// future reads and writes fail with ctx.Err().
+func New(ctx context.Context) (*PipeReader, *PipeWriter) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	p := &pipe{
+		wrCh:          make(chan []byte),
+		rdCh:          make(chan int),
+		done:          make(chan struct{}),
+		ctx:           ctx,
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+	}
+	return &PipeReader{p}, &PipeWriter{p}
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	select {
+	case <-p.done:
+		return 0, p.readCloseError()
+	case <-p.ctx.Done():
+		return 0, p.ctx.Err()
+	case <-p.readDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+
+	select {
+	case bw := <-p.wrCh:
+		nr := copy(b, bw)
+		p.rdCh <- nr
+		return nr, nil
+	case <-p.done:
+		return 0, p.readCloseError()
+	case <-p.ctx.Done():
+		return 0, p.ctx.Err()
+	case <-p.readDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (p *pipe) write(b []byte) (n int, err error) {
+	select {
+	case <-p.done:
+		return 0, p.writeCloseError()
+	case <-p.ctx.Done():
+		return 0, p.ctx.Err()
+	case <-p.writeDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+		p.wrMu.Lock()
+		defer p.wrMu.Unlock()
+	}
+
+	for once := true; once || len(b) > 0; once = false {
+		select {
+		case p.wrCh <- b:
+			nw := <-p.rdCh
+			b = b[nw:]
+			n += nw
+		case <-p.done:
+			return n, p.writeCloseError()
+		case <-p.ctx.Done():
+			return n, p.ctx.Err()
+		case <-p.writeDeadline.wait():
+			return n, os.ErrDeadlineExceeded
+		}
+	}
+	return n, nil
+}
+
+func (p *pipe) closeRead(err error) error {
+	if err == nil {
+		err = io.ErrClosedPipe
+	}
+	p.rerr.Store(err)
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
+
+func (p *pipe) closeWrite(err error) error {
+	if err == nil {
+		err = io.EOF
+	}
+	p.werr.Store(err)
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
+
+// readCloseError is the error a read returns after the pipe was closed.
+func (p *pipe) readCloseError() error {
+	rerr := p.rerr.Load()
+	if werr := p.werr.Load(); rerr == nil && werr != nil {
+		return werr
+	}
+	return io.ErrClosedPipe
+}
+
+// writeCloseError is the error a write returns after the pipe was closed.
+func (p *pipe) writeCloseError() error {
+	werr := p.werr.Load()
+	if rerr := p.rerr.Load(); werr == nil && rerr != nil {
+		return rerr
+	}
+	return io.ErrClosedPipe
+}
+
+// PipeReader is the read half of a pipe.
+type PipeReader struct {
+	p *pipe
+}
+
+// Read blocks until data is written, the writer is closed, the read
+// deadline passes (os.ErrDeadlineExceeded) or the context is done.
+func (r *PipeReader) Read(b []byte) (int, error) {
+	return r.p.read(b)
+}
+
+// Close closes the reader; subsequent writes fail with io.ErrClosedPipe.
+func (r *PipeReader) Close() error {
+	return r.CloseWithError(nil)
+}
+
+// CloseWithError closes the reader; subsequent writes return err, or
+// io.ErrClosedPipe if err is nil.
+func (r *PipeReader) CloseWithError(err error) error {
+	return r.p.closeRead(err)
+}
+
+// SetReadDeadline sets the deadline for pending and future reads. A zero
+// value disables it. Unlike closing, an expired deadline does not break the
+// pipe: it can be extended and reads resumed.
+func (r *PipeReader) SetReadDeadline(t time.Time) error {
+	r.p.readDeadline.set(t)
+	return nil
+}
+
+// PipeWriter is the write half of a pipe.
+type PipeWriter struct {
+	p *pipe
+}
+
+// Write blocks until readers have consumed all of b, the reader is closed,
+// the write deadline passes (os.ErrDeadlineExceeded) or the context is
+// done. It returns the number of bytes consumed before that.
+func (w *PipeWriter) Write(b []byte) (int, error) {
+	return w.p.write(b)
+}
+
+// Close closes the writer; subsequent reads return io.EOF.
+func (w *PipeWriter) Close() error {
+	return w.CloseWithError(nil)
+}
+
+// CloseWithError closes the writer; subsequent reads return err, or io.EOF
+// if err is nil.
+func (w *PipeWriter) CloseWithError(err error) error {
+	return w.p.closeWrite(err)
+}
+
+// SetWriteDeadline sets the deadline for pending and future writes. A zero
+// value disables it.
+func (w *PipeWriter) SetWriteDeadline(t time.Time) error {
+	w.p.writeDeadline.set(t)
+	return nil
+}
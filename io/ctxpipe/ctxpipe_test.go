@@ -0,0 +1,88 @@
+package ctxpipe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	pr, pw := New(context.Background())
+	go func() {
+		pw.Write([]byte("hello, "))
+		pw.Write([]byte("world"))
+		pw.Close()
+	}()
+	got, err := io.ReadAll(pr)
+	if err != nil || string(got) != "hello, world" {
+		t.Fatalf("ReadAll = %q, %v", got, err)
+	}
+}
+
+func TestWriteDeadline(t *testing.T) {
+	pr, pw := New(context.Background())
+	pw.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+
+	start := time.Now()
+	n, err := pw.Write([]byte("nobody reads this"))
+	if !errors.Is(err, os.ErrDeadlineExceeded) || n != 0 {
+		t.Fatalf("Write = %d, %v; want 0, ErrDeadlineExceeded", n, err)
+	}
+	if d := time.Since(start); d < 15*time.Millisecond {
+		t.Errorf("Write returned after %v, before the deadline", d)
+	}
+
+	// The pipe is still usable once the deadline is lifted.
+	pw.SetWriteDeadline(time.Time{})
+	go pw.Write([]byte("x"))
+	b := make([]byte, 1)
+	if _, err := pr.Read(b); err != nil || b[0] != 'x' {
+		t.Errorf("Read after deadline reset = %q, %v", b, err)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	pr, _ := New(context.Background())
+	pr.SetReadDeadline(time.Now().Add(-time.Second))
+	if _, err := pr.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read = %v, want ErrDeadlineExceeded", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	_, pw := New(ctx)
+	errc := make(chan error)
+	go func() {
+		_, err := pw.Write([]byte("stuck"))
+		errc <- err
+	}()
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Write = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write not released by context cancellation")
+	}
+}
+
+func TestCloseWithError(t *testing.T) {
+	boom := errors.New("boom")
+	pr, pw := New(context.Background())
+	pr.CloseWithError(boom)
+	if _, err := pw.Write([]byte("x")); !errors.Is(err, boom) {
+		t.Errorf("Write after reader error = %v, want boom", err)
+	}
+
+	pr, pw = New(context.Background())
+	pw.CloseWithError(boom)
+	pw.Close() // the first error sticks
+	if _, err := pr.Read(make([]byte, 1)); !errors.Is(err, boom) {
+		t.Errorf("Read after writer error = %v, want boom", err)
+	}
+}
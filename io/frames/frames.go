@@ -0,0 +1,180 @@
+// Package frames delimits messages in a byte stream with a length prefix,
+// so many small records can travel through the single pipe feeding an
+// upload and be split apart again on the other side.
+//
+// Every frame is a length, the payload and, with the CRC option, a CRC-32C
+// of the payload:
+//
+//	uvarint: length (uvarint) | payload | crc (uint32 BE, optional)
+//	fixed:   length (uint32 BE) | payload | crc (uint32 BE, optional)
+//
+// The fixed layout is the length half of gRPC's message prefix. Both ends
+// must use the same options; the stream itself carries no header.
+package frames
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sync"
+)
+
+// DefaultMaxSize is the largest frame a Reader accepts by default, the
+// same limit gRPC applies to received messages.
+const DefaultMaxSize = 4 << 20
+
+var (
+	// ErrTooLarge is returned for frames above the configured maximum size.
+	ErrTooLarge = errors.New("frames: frame too large")
+	// ErrChecksum is returned by a Reader when a frame's CRC does not match
+	// its payload.
+	ErrChecksum = errors.New("frames: checksum mismatch")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type config struct {
+	fixed   bool
+	crc     bool
+	maxSize int
+}
+
+// Option configures a Reader or Writer.
+type Option func(*config)
+
+// Fixed32 uses a 4-byte big-endian length instead of a uvarint.
+func Fixed32() Option {
+	return func(c *config) { c.fixed = true }
+}
+
+// CRC appends a CRC-32C of the payload to every frame, and checks it when
+// reading.
+func CRC() Option {
+	return func(c *config) { c.crc = true }
+}
+
+// MaxSize limits the payload size of a frame. Writers reject larger frames
+// and Readers stop at them. It defaults to DefaultMaxSize.
+func MaxSize(n int) Option {
+	return func(c *config) { c.maxSize = n }
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{maxSize: DefaultMaxSize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.fixed {
+		cfg.maxSize = min(cfg.maxSize, math.MaxUint32)
+	}
+	return cfg
+}
+
+// Writer writes frames to an underlying writer. It is safe for concurrent
+// use: each frame is written whole before the next one starts, so several
+// producers can share one stream.
+type Writer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	cfg config
+	hdr []byte
+}
+
+// NewWriter returns a Writer framing messages onto w.
+func NewWriter(w io.Writer, opts ...Option) *Writer {
+	return &Writer{w: w, cfg: newConfig(opts), hdr: make([]byte, 0, binary.MaxVarintLen64)}
+}
+
+// WriteFrame writes p as one frame.
+func (w *Writer) WriteFrame(p []byte) error {
+	if len(p) > w.cfg.maxSize {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrTooLarge, len(p), w.cfg.maxSize)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cfg.fixed {
+		w.hdr = binary.BigEndian.AppendUint32(w.hdr[:0], uint32(len(p)))
+	} else {
+		w.hdr = binary.AppendUvarint(w.hdr[:0], uint64(len(p)))
+	}
+	if _, err := w.w.Write(w.hdr); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(p); err != nil {
+		return err
+	}
+	if w.cfg.crc {
+		w.hdr = binary.BigEndian.AppendUint32(w.hdr[:0], crc32.Checksum(p, castagnoli))
+		if _, err := w.w.Write(w.hdr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write writes p as one frame, so a Writer can stand in for an io.Writer
+// whose callers write whole records, such as json.Encoder.
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.WriteFrame(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Reader reads frames from an underlying reader.
+type Reader struct {
+	r   *bufio.Reader
+	cfg config
+	buf []byte
+}
+
+// NewReader returns a Reader splitting r into frames.
+func NewReader(r io.Reader, opts ...Option) *Reader {
+	return &Reader{r: bufio.NewReader(r), cfg: newConfig(opts)}
+}
+
+// ReadFrame returns the payload of the next frame. The slice is only valid
+// until the next call. At the end of the stream it returns io.EOF; a stream
+// ending inside a frame returns io.ErrUnexpectedEOF.
+func (r *Reader) ReadFrame() ([]byte, error) {
+	var n uint64
+	if r.cfg.fixed {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+			return nil, err
+		}
+		n = uint64(binary.BigEndian.Uint32(hdr[:]))
+	} else {
+		var err error
+		if n, err = binary.ReadUvarint(r.r); err != nil {
+			return nil, err
+		}
+	}
+	if n > uint64(r.cfg.maxSize) {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrTooLarge, n, r.cfg.maxSize)
+	}
+
+	size := int(n)
+	if r.cfg.crc {
+		size += 4
+	}
+	if cap(r.buf) < size {
+		r.buf = make([]byte, size)
+	}
+	buf := r.buf[:size]
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	payload := buf[:n]
+	if r.cfg.crc && binary.BigEndian.Uint32(buf[n:]) != crc32.Checksum(payload, castagnoli) {
+		return nil, ErrChecksum
+	}
+	return payload, nil
+}
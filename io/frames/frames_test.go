@@ -0,0 +1,134 @@
+package frames
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	records := [][]byte{[]byte("first"), {}, bytes.Repeat([]byte("x"), 300), []byte("last")}
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"uvarint", nil},
+		{"fixed", []Option{Fixed32()}},
+		{"uvarint+crc", []Option{CRC()}},
+		{"fixed+crc", []Option{Fixed32(), CRC()}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, tt.opts...)
+			for _, rec := range records {
+				if err := w.WriteFrame(rec); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := NewReader(&buf, tt.opts...)
+			for i, want := range records {
+				got, err := r.ReadFrame()
+				if err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("frame %d = %q, want %q", i, got, want)
+				}
+			}
+			if _, err := r.ReadFrame(); err != io.EOF {
+				t.Errorf("after last frame: err = %v", err)
+			}
+		})
+	}
+}
+
+func TestWireFormat(t *testing.T) {
+	var buf bytes.Buffer
+	NewWriter(&buf, Fixed32()).WriteFrame([]byte("hi"))
+	if want := "\x00\x00\x00\x02hi"; buf.String() != want {
+		t.Errorf("fixed frame = %q, want %q", buf.String(), want)
+	}
+	buf.Reset()
+	NewWriter(&buf).WriteFrame(bytes.Repeat([]byte("a"), 200))
+	if !bytes.HasPrefix(buf.Bytes(), []byte{0xc8, 0x01}) || buf.Len() != 202 {
+		t.Errorf("uvarint frame starts with % x, %d bytes", buf.Bytes()[:2], buf.Len())
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	var buf bytes.Buffer
+	NewWriter(&buf, CRC()).WriteFrame([]byte("payload"))
+	corrupt := buf.Bytes()
+	corrupt[3] ^= 1
+	if _, err := NewReader(bytes.NewReader(corrupt), CRC()).ReadFrame(); !errors.Is(err, ErrChecksum) {
+		t.Errorf("err = %v, want ErrChecksum", err)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	w := NewWriter(io.Discard, MaxSize(4))
+	if err := w.WriteFrame([]byte("12345")); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("WriteFrame = %v", err)
+	}
+	var buf bytes.Buffer
+	NewWriter(&buf).WriteFrame([]byte("12345"))
+	if _, err := NewReader(&buf, MaxSize(4)).ReadFrame(); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("ReadFrame = %v", err)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	NewWriter(&buf, Fixed32(), CRC()).WriteFrame([]byte("payload"))
+	full := buf.Bytes()
+	for n := 1; n < len(full); n++ {
+		if _, err := NewReader(bytes.NewReader(full[:n]), Fixed32(), CRC()).ReadFrame(); err != io.ErrUnexpectedEOF {
+			t.Errorf("%d of %d bytes: err = %v", n, len(full), err)
+		}
+	}
+}
+
+// TestConcurrentWriters multiplexes records from several goroutines through
+// one pipe and checks none is torn.
+func TestConcurrentWriters(t *testing.T) {
+	pr, pw := io.Pipe()
+	w := NewWriter(pw, CRC())
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				w.WriteFrame([]byte(fmt.Sprintf("g%d-%d-%s", g, i, strings.Repeat("x", i))))
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		pw.Close()
+	}()
+
+	r := NewReader(pr, CRC())
+	seen := 0
+	for {
+		rec, err := r.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := strings.Split(string(rec), "-")
+		if len(fields) != 3 || fmt.Sprint(len(fields[2])) != fields[1] {
+			t.Fatalf("torn record %q", rec)
+		}
+		seen++
+	}
+	if seen != 8*50 {
+		t.Errorf("read %d records, want %d", seen, 8*50)
+	}
+}
@@ -0,0 +1,235 @@
+// Package gcmstream encrypts and decrypts streams with AES-GCM in
+// independently authenticated chunks, so neither side has to hold the whole
+// payload in memory or on disk.
+//
+// The format follows the STREAM construction used by age: a header with a
+// random nonce prefix, then chunks of at most ChunkSize plaintext bytes,
+// each sealed with a nonce made of the prefix, a chunk counter and a
+// last-chunk flag. Reordered, dropped, duplicated or truncated chunks fail
+// authentication.
+//
+//	header: "GCM1" | chunk size (uint32 BE) | nonce prefix (7 bytes)
+//	chunk:  AES-GCM(plaintext[i]) with nonce = prefix | i (uint32 BE) | last (1 byte)
+package gcmstream
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ChunkSize is the plaintext size of every chunk but the last.
+const ChunkSize = 64 << 10
+
+const (
+	magic      = "GCM1"
+	prefixSize = 7
+	headerSize = len(magic) + 4 + prefixSize
+)
+
+var (
+	// ErrInvalidHeader is returned when the stream does not start with a
+	// gcmstream header.
+	ErrInvalidHeader = errors.New("gcmstream: invalid header")
+	// ErrAuthentication is returned when a chunk fails to decrypt, i.e. the
+	// key is wrong or the stream was modified or truncated.
+	ErrAuthentication = errors.New("gcmstream: message authentication failed")
+)
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("gcmstream: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(prefix []byte, counter uint32, last bool) []byte {
+	n := make([]byte, 0, 12)
+	n = append(n, prefix...)
+	n = binary.BigEndian.AppendUint32(n, counter)
+	if last {
+		return append(n, 1)
+	}
+	return append(n, 0)
+}
+
+// Writer encrypts what is written to it. Close must be called to write the
+// final chunk; without it the stream fails to decrypt.
+type Writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	buf     []byte
+	out     []byte
+	counter uint32
+	header  bool
+	closed  bool
+}
+
+// NewWriter returns a Writer encrypting to w with key, which must be 16,
+// 24 or 32 bytes long (AES-128, AES-192 or AES-256).
+func NewWriter(w io.Writer, key []byte) (*Writer, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, ChunkSize)}, nil
+}
+
+// Write buffers p and writes every completed chunk. A full chunk is only
+// sealed once more data arrives, so the final chunk is never empty unless
+// the whole stream is.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("gcmstream: write after close")
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(w.buf) == ChunkSize {
+			if err := w.seal(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(w.buf[len(w.buf):ChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// Close writes the final chunk. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+func (w *Writer) seal(last bool) error {
+	if !w.header {
+		w.header = true
+		h := make([]byte, 0, headerSize)
+		h = append(h, magic...)
+		h = binary.BigEndian.AppendUint32(h, ChunkSize)
+		h = append(h, w.prefix...)
+		if _, err := w.w.Write(h); err != nil {
+			return err
+		}
+	}
+	if w.counter == math.MaxUint32 {
+		return errors.New("gcmstream: stream too long")
+	}
+	w.out = w.aead.Seal(w.out[:0], nonce(w.prefix, w.counter, last), w.buf, nil)
+	w.counter++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(w.out)
+	return err
+}
+
+// Reader decrypts a stream written by Writer.
+type Reader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	chunk   int
+	in      []byte
+	plain   []byte
+	counter uint32
+	started bool
+	done    bool
+	err     error
+}
+
+// NewReader returns a Reader decrypting r with key. The header is read on
+// the first call to Read.
+func NewReader(r io.Reader, key []byte) (*Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: bufio.NewReader(r), aead: aead}, nil
+}
+
+// Read returns decrypted data. Data is only returned after its chunk has
+// been authenticated; a stream that ends before its final chunk fails with
+// ErrAuthentication rather than io.EOF.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *Reader) readHeader() error {
+	h := make([]byte, headerSize)
+	if _, err := io.ReadFull(r.r, h); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	if string(h[:len(magic)]) != magic {
+		return ErrInvalidHeader
+	}
+	r.chunk = int(binary.BigEndian.Uint32(h[len(magic):]))
+	if r.chunk <= 0 || r.chunk > 16<<20 {
+		return fmt.Errorf("%w: chunk size %d", ErrInvalidHeader, r.chunk)
+	}
+	r.prefix = h[len(magic)+4:]
+	r.in = make([]byte, r.chunk+r.aead.Overhead())
+	return nil
+}
+
+// next reads and opens one chunk.
+func (r *Reader) next() error {
+	if !r.started {
+		r.started = true
+		if err := r.readHeader(); err != nil {
+			return err
+		}
+	}
+	n, err := io.ReadFull(r.r, r.in)
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		// A full chunk is the last one if nothing follows it.
+		if _, perr := r.r.Peek(1); perr == io.EOF {
+			last = true
+		} else if perr != nil {
+			return perr
+		}
+	}
+	if n < r.aead.Overhead() {
+		return ErrAuthentication
+	}
+	plain, oerr := r.aead.Open(r.in[:0], nonce(r.prefix, r.counter, last), r.in[:n], nil)
+	if oerr != nil {
+		return ErrAuthentication
+	}
+	r.counter++
+	r.plain = plain
+	r.done = last
+	return nil
+}
@@ -0,0 +1,79 @@
+package gcmstream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+var key = bytes.Repeat([]byte{7}, 32)
+
+func encrypt(t *testing.T, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Odd write sizes exercise chunk boundaries.
+	for p := plain; len(p) > 0; {
+		n := min(len(p), 10007)
+		w.Write(p[:n])
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(ciphertext, key []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(ciphertext), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 17} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		got, err := decrypt(encrypt(t, plain), key)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("size %d: plaintext mismatch", size)
+		}
+	}
+}
+
+func TestTampering(t *testing.T) {
+	plain := make([]byte, 2*ChunkSize+100)
+	ct := encrypt(t, plain)
+	chunk := ChunkSize + 16
+
+	tests := map[string][]byte{
+		"flipped bit":          func() []byte { c := bytes.Clone(ct); c[headerSize+5] ^= 1; return c }(),
+		"truncated at chunk":   ct[:headerSize+chunk],
+		"truncated mid chunk":  ct[:headerSize+chunk+50],
+		"dropped middle chunk": append(bytes.Clone(ct[:headerSize+chunk]), ct[headerSize+2*chunk:]...),
+	}
+	for name, c := range tests {
+		if _, err := decrypt(c, key); !errors.Is(err, ErrAuthentication) {
+			t.Errorf("%s: err = %v, want ErrAuthentication", name, err)
+		}
+	}
+	if _, err := decrypt(ct, bytes.Repeat([]byte{8}, 32)); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("wrong key: err = %v", err)
+	}
+	if _, err := decrypt([]byte("not encrypted at all"), key); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("garbage: err = %v", err)
+	}
+	if _, err := NewWriter(io.Discard, []byte("short")); err == nil {
+		t.Error("NewWriter accepted a 5-byte key")
+	}
+}
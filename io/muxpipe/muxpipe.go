@@ -0,0 +1,334 @@
+// Package muxpipe carries several independent ordered streams over one byte
+// stream, such as the io.Pipe feeding an HTTP request body.
+//
+// Writing to one pipe from several goroutines interleaves their bytes at
+// arbitrary points (see the concurrent_error demo). muxpipe instead cuts
+// every write into frames tagged with a stream ID, so producers can run
+// concurrently and the consumer gets each stream back intact through its
+// own reader:
+//
+//	frame: type (1 byte) | stream ID (uvarint) | length (uvarint) | payload
+//
+// Frame types open a stream, carry data, close it, or reset it with an
+// error message. Streams are accepted in the order they were opened.
+package muxpipe
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultMaxFrame is the default payload limit of a frame. Writes larger
+// than this are split, so one busy stream cannot hold up the others for
+// long.
+const DefaultMaxFrame = 32 << 10
+
+const (
+	frameOpen byte = iota
+	frameData
+	frameClose
+	frameReset
+)
+
+var (
+	// ErrFrameTooLarge is returned by a Reader for frames over its limit.
+	ErrFrameTooLarge = errors.New("muxpipe: frame too large")
+	// ErrProtocol is returned by a Reader for malformed input, such as data
+	// for a stream that was never opened.
+	ErrProtocol = errors.New("muxpipe: protocol error")
+)
+
+type config struct {
+	maxFrame int
+}
+
+// Option configures a Writer or Reader.
+type Option func(*config)
+
+// MaxFrame sets the largest frame payload. Writers split writes into frames
+// of at most n bytes; Readers reject larger frames.
+func MaxFrame(n int) Option {
+	return func(c *config) { c.maxFrame = max(n, 1) }
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{maxFrame: DefaultMaxFrame}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// Writer multiplexes streams onto an underlying writer. It is safe for
+// concurrent use; frames are written whole, one at a time.
+type Writer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	cfg    config
+	nextID uint64
+	hdr    []byte
+	err    error // sticky write error
+}
+
+// NewWriter returns a Writer multiplexing onto w.
+func NewWriter(w io.Writer, opts ...Option) *Writer {
+	return &Writer{w: w, cfg: newConfig(opts)}
+}
+
+// Open starts a new stream. It fails only if the underlying writer already
+// failed.
+func (m *Writer) Open() (*StreamWriter, error) {
+	m.mu.Lock()
+	id := m.nextID
+	m.nextID++
+	m.mu.Unlock()
+	if err := m.writeFrame(frameOpen, id, nil); err != nil {
+		return nil, err
+	}
+	return &StreamWriter{m: m, id: id}, nil
+}
+
+// Close closes the underlying writer if it is an io.Closer, ending the
+// multiplexed stream. Streams that are still open are seen as truncated by
+// the Reader.
+func (m *Writer) Close() error {
+	if c, ok := m.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (m *Writer) writeFrame(typ byte, id uint64, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.hdr = append(m.hdr[:0], typ)
+	m.hdr = binary.AppendUvarint(m.hdr, id)
+	m.hdr = binary.AppendUvarint(m.hdr, uint64(len(payload)))
+	if _, err := m.w.Write(m.hdr); err != nil {
+		m.err = err
+		return err
+	}
+	if _, err := m.w.Write(payload); err != nil {
+		m.err = err
+		return err
+	}
+	return nil
+}
+
+// StreamWriter is the producing end of one stream. A single stream must
+// not be written from several goroutines at once, but different streams
+// may.
+type StreamWriter struct {
+	m      *Writer
+	id     uint64
+	closed bool
+}
+
+// ID returns the stream's ID, which the Reader reports for it as well.
+func (s *StreamWriter) ID() uint64 {
+	return s.id
+}
+
+// Write sends p on the stream, split into frames of at most MaxFrame bytes.
+func (s *StreamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), s.m.cfg.maxFrame)]
+		if err := s.m.writeFrame(frameData, s.id, chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Close ends the stream; its reader gets io.EOF after the data sent so far.
+func (s *StreamWriter) Close() error {
+	return s.CloseWithError(nil)
+}
+
+// CloseWithError ends the stream so that its reader gets an error with
+// err's message after the data sent so far. A nil err is the same as
+// Close.
+func (s *StreamWriter) CloseWithError(err error) error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if err == nil {
+		return s.m.writeFrame(frameClose, s.id, nil)
+	}
+	msg := err.Error()
+	return s.m.writeFrame(frameReset, s.id, []byte(msg[:min(len(msg), s.m.cfg.maxFrame)]))
+}
+
+// Reader demultiplexes the streams of an underlying reader. Frames are read
+// on demand by whichever Accept or stream Read needs them; data for other
+// streams is buffered until their readers ask for it, so streams may be
+// consumed in any order, at the cost of memory for the ones left unread.
+type Reader struct {
+	mu      sync.Mutex
+	cond    sync.Cond
+	r       *bufio.Reader
+	cfg     config
+	reading bool  // a goroutine is reading a frame from r
+	err     error // sticky error of r; io.EOF at a clean end
+
+	streams  map[uint64]*StreamReader
+	accepted []*StreamReader // opened but not yet returned by Accept
+}
+
+// NewReader returns a Reader demultiplexing r.
+func NewReader(r io.Reader, opts ...Option) *Reader {
+	m := &Reader{
+		r:       bufio.NewReader(r),
+		cfg:     newConfig(opts),
+		streams: map[uint64]*StreamReader{},
+	}
+	m.cond.L = &m.mu
+	return m
+}
+
+// Accept returns the next stream in the order they were opened. It returns
+// io.EOF once the underlying reader ends and every stream was accepted.
+func (m *Reader) Accept() (*StreamReader, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		if len(m.accepted) > 0 {
+			s := m.accepted[0]
+			m.accepted = m.accepted[1:]
+			return s, nil
+		}
+		if m.err != nil {
+			return nil, m.err
+		}
+		m.pump()
+	}
+}
+
+// pump reads one frame, or waits for the goroutine already reading one.
+// m.mu must be held; it is released while blocked.
+func (m *Reader) pump() {
+	if m.reading {
+		m.cond.Wait()
+		return
+	}
+	m.reading = true
+	m.mu.Unlock()
+	typ, id, payload, err := m.readFrame()
+	m.mu.Lock()
+	m.reading = false
+	defer m.cond.Broadcast()
+	if err != nil {
+		m.err = err
+		return
+	}
+
+	s := m.streams[id]
+	if typ == frameOpen {
+		if s != nil {
+			m.err = fmt.Errorf("%w: stream %d opened twice", ErrProtocol, id)
+			return
+		}
+		s = &StreamReader{m: m, id: id}
+		m.streams[id] = s
+		m.accepted = append(m.accepted, s)
+		return
+	}
+	if s == nil || s.done {
+		m.err = fmt.Errorf("%w: frame for unknown or closed stream %d", ErrProtocol, id)
+		return
+	}
+	switch typ {
+	case frameData:
+		s.buf = append(s.buf, payload)
+	case frameClose:
+		s.done, s.err = true, io.EOF
+	case frameReset:
+		s.done, s.err = true, errors.New(string(payload))
+	default:
+		m.err = fmt.Errorf("%w: unknown frame type %d", ErrProtocol, typ)
+	}
+}
+
+func (m *Reader) readFrame() (typ byte, id uint64, payload []byte, err error) {
+	typ, err = m.r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if id, err = binary.ReadUvarint(m.r); err != nil {
+		return 0, 0, nil, noEOF(err)
+	}
+	n, err := binary.ReadUvarint(m.r)
+	if err != nil {
+		return 0, 0, nil, noEOF(err)
+	}
+	if n > uint64(m.cfg.maxFrame) {
+		return 0, 0, nil, fmt.Errorf("%w: %d bytes, limit %d", ErrFrameTooLarge, n, m.cfg.maxFrame)
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(m.r, payload); err != nil {
+		return 0, 0, nil, noEOF(err)
+	}
+	return typ, id, payload, nil
+}
+
+// noEOF reports a stream ending inside a frame as truncated.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// StreamReader is the consuming end of one stream.
+type StreamReader struct {
+	m    *Reader
+	id   uint64
+	buf  [][]byte // received, unread payloads
+	done bool     // a close or reset frame arrived
+	err  error    // returned once buf is drained
+}
+
+// ID returns the stream's ID, as assigned by the Writer.
+func (s *StreamReader) ID() uint64 {
+	return s.id
+}
+
+// Read reads the stream's data in the order it was written. It returns
+// io.EOF after the producer's Close, the producer's error after
+// CloseWithError, and io.ErrUnexpectedEOF if the underlying reader ends
+// first.
+func (s *StreamReader) Read(p []byte) (int, error) {
+	m := s.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		if len(s.buf) > 0 {
+			n := copy(p, s.buf[0])
+			if s.buf[0] = s.buf[0][n:]; len(s.buf[0]) == 0 {
+				s.buf = s.buf[1:]
+			}
+			return n, nil
+		}
+		if s.done {
+			return 0, s.err
+		}
+		if m.err != nil {
+			return 0, noEOF(m.err)
+		}
+		m.pump()
+	}
+}
@@ -0,0 +1,172 @@
+package muxpipe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentProducers writes several streams concurrently through one
+// io.Pipe and reads them back one after another, which only works because
+// frames of the streams not being read are buffered.
+func TestConcurrentProducers(t *testing.T) {
+	pr, pw := io.Pipe()
+	w := NewWriter(pw, MaxFrame(7))
+	const producers = 5
+	want := make([]string, producers)
+	for i := range want {
+		want[i] = strings.Repeat(fmt.Sprintf("stream %d;", i), 100*(i+1))
+	}
+
+	// Opening writes to the pipe too, so it runs alongside the consumer.
+	go func() {
+		var wg sync.WaitGroup
+		for i := range producers {
+			s, err := w.Open()
+			if err != nil {
+				t.Error(err)
+				break
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for line := range strings.SplitAfterSeq(want[i], ";") {
+					io.WriteString(s, line)
+				}
+				s.Close()
+			}()
+		}
+		wg.Wait()
+		w.Close()
+	}()
+
+	r := NewReader(pr, MaxFrame(7))
+	for i := range producers {
+		s, err := r.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.ID() != uint64(i) {
+			t.Errorf("accepted stream %d, want %d", s.ID(), i)
+		}
+		got, err := io.ReadAll(s)
+		if err != nil {
+			t.Fatalf("stream %d: %v", i, err)
+		}
+		if string(got) != want[i] {
+			t.Errorf("stream %d: got %d bytes, want %d", i, len(got), len(want[i]))
+		}
+	}
+	if _, err := r.Accept(); err != io.EOF {
+		t.Errorf("Accept after the end = %v", err)
+	}
+}
+
+// TestConcurrentConsumers reads every stream in its own goroutine.
+func TestConcurrentConsumers(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	a, _ := w.Open()
+	b, _ := w.Open()
+	for i := range 100 {
+		fmt.Fprintf(a, "a%d ", i)
+		fmt.Fprintf(b, "b%d ", i)
+	}
+	a.Close()
+	b.Close()
+
+	r := NewReader(&buf)
+	results := make(chan string, 2)
+	for range 2 {
+		s, err := r.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			data, err := io.ReadAll(s)
+			if err != nil {
+				data = []byte(err.Error())
+			}
+			results <- string(data)
+		}()
+	}
+	for range 2 {
+		got := <-results
+		prefix := got[:1]
+		if !strings.HasPrefix(got, prefix+"0 ") || !strings.HasSuffix(got, prefix+"99 ") || strings.Count(got, " ") != 100 {
+			t.Errorf("stream content = %.40q...", got)
+		}
+	}
+}
+
+func TestCloseWithError(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	s, _ := w.Open()
+	io.WriteString(s, "partial")
+	s.CloseWithError(errors.New("producer failed"))
+	if _, err := s.Write([]byte("more")); err != io.ErrClosedPipe {
+		t.Errorf("Write after close = %v", err)
+	}
+
+	rs, err := NewReader(&buf).Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rs)
+	if string(got) != "partial" || err == nil || err.Error() != "producer failed" {
+		t.Errorf("ReadAll = %q, %v", got, err)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	s, _ := w.Open()
+	io.WriteString(s, "never closed")
+
+	rs, err := NewReader(&buf).Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(rs); err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	for name, input := range map[string]string{
+		"unknown stream": "\x01\x05\x01x",
+		"double open":    "\x00\x00\x00\x00\x00\x00",
+		"bad type":       "\x00\x00\x00\x09\x00\x00",
+	} {
+		if _, err := io.ReadAll(readerFor(t, input)); !errors.Is(err, ErrProtocol) {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+	big := "\x00\x00\x00\x01\x00\xff\x01" + strings.Repeat("x", 255)
+	if _, err := io.ReadAll(readerFor(t, big, MaxFrame(16))); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("large frame: err = %v", err)
+	}
+}
+
+// readerFor returns a reader draining all streams of input.
+func readerFor(t *testing.T, input string, opts ...Option) io.Reader {
+	t.Helper()
+	r := NewReader(strings.NewReader(input), opts...)
+	return readerFunc(func(p []byte) (int, error) {
+		s, err := r.Accept()
+		if err != nil {
+			return 0, err
+		}
+		return s.Read(p)
+	})
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNoClosingBoundary is returned by OpenBuilder for a file that does not
+// end with the closing boundary of a multipart body, e.g. because the
+// process writing it was killed.
+var ErrNoClosingBoundary = errors.New("multipart file has no closing boundary")
+
+// ArchiveOption configures OpenBuilder.
+type ArchiveOption func(*Builder)
+
+// MaxSize rolls the archive over to a new file once the current one holds
+// n bytes or more, like SplitAt. With Gzip, n counts uncompressed bytes.
+func MaxSize(n int64) ArchiveOption {
+	return func(b *Builder) { b.splitAt = n }
+}
+
+// RotateEvery rolls the archive over to a new file when a part is added d
+// or more after the current file was started, so a collector hands off
+// its batches periodically.
+func RotateEvery(d time.Duration) ArchiveOption {
+	return func(b *Builder) { b.rotateEvery = d }
+}
+
+// Gzip compresses the archive files. OpenBuilder starts a new file rather
+// than append to a compressed one that has content. ReadMultipartFile decompresses such files transparently.
+func Gzip() ArchiveOption {
+	return func(b *Builder) { b.gzip = true }
+}
+
+// OpenBuilder returns a Builder appending parts to the multipart file at
+// path, for long-running collectors that batch data into multipart
+// archives. The closing boundary of the file is replaced by the new parts
+// and written again by Build. A missing or empty file is created. If the
+// archive was rolled over before, the last file of the series is opened
+// instead.
+func OpenBuilder(path string, opts ...ArchiveOption) (*Builder, error) {
+	cfg := &Builder{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	segment := 0
+	for n := 1; ; n++ {
+		if _, err := os.Stat(segmentPath(path, n)); err != nil {
+			break
+		}
+		segment = n
+	}
+	var (
+		b   *Builder
+		err error
+	)
+	if cfg.gzip {
+		// A compressed file cannot be appended to.
+		if info, err := os.Stat(segmentPath(path, segment)); err == nil && info.Size() > 0 {
+			segment++
+		}
+		b, err = openGzipSegment(segmentPath(path, segment))
+	} else {
+		b, err = openSegment(segmentPath(path, segment))
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.path, b.segment = path, segment
+	b.splitAt, b.rotateEvery, b.gzip = cfg.splitAt, cfg.rotateEvery, cfg.gzip
+	return b, nil
+}
+
+// openSegment starts a Builder appending to the file at path.
+func openSegment(path string) (*Builder, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file: %w", err)
+	}
+	boundary, end, err := closingBoundary(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open %s: %w", f.Name(), err)
+	}
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to truncate output file: %w", err)
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to seek output file: %w", err)
+	}
+	var w io.WriteCloser = f
+	if end > 0 {
+		w = &appendWriter{f: f}
+	}
+	b, err := newBuilder(SinkFunc(func(string) (io.WriteCloser, error) { return w, nil }), boundary, end)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// openGzipSegment starts a Builder writing a compressed file at path.
+func openGzipSegment(path string) (*Builder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	b, err := newBuilder(gzipSink(f), "", 0)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// gzipSink compresses the body into f.
+func gzipSink(f *os.File) Sink {
+	return SinkFunc(func(string) (io.WriteCloser, error) {
+		return &gzipFile{Writer: gzip.NewWriter(f), f: f}, nil
+	})
+}
+
+type gzipFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	return errors.Join(g.Writer.Close(), g.f.Close())
+}
+
+// SplitAt makes a Builder returned by OpenBuilder roll over to a new file
+// once the current one holds size bytes or more. Parts are never split, so
+// files end up somewhat larger than size. The files after path are named
+// like "archive.1.multipart", "archive.2.multipart" and so on. SplitAt has
+// no effect on other builders.
+func (b *Builder) SplitAt(size int64) *Builder {
+	if b.path != "" {
+		b.splitAt = size
+	}
+	return b
+}
+
+// segmentPath returns the name of file n of the archive at path.
+func segmentPath(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// dueForRollOver reports whether the next part goes to a new file.
+func (b *Builder) dueForRollOver() bool {
+	switch {
+	case b.path == "":
+		return false
+	case b.splitAt > 0 && b.size.n >= b.splitAt:
+		return true
+	case b.rotateEvery > 0 && b.size.n > 0 && time.Since(b.started) >= b.rotateEvery:
+		return true
+	}
+	return false
+}
+
+// rollOver ends the current file and continues in the next one. If the
+// next file cannot be created, the parts keep going to the current one.
+func (b *Builder) rollOver() {
+	b.mu.Lock()
+	aborted := b.aborted
+	b.mu.Unlock()
+	if aborted {
+		return
+	}
+	f, err := os.OpenFile(segmentPath(b.path, b.segment+1), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		b.addError(fmt.Errorf("failed to roll over: %w", err))
+		return
+	}
+	boundary := b.mw.Boundary()
+	b.endOutput()
+	b.segment++
+	var sink Sink = SinkFunc(func(string) (io.WriteCloser, error) { return f, nil })
+	if b.gzip {
+		sink = gzipSink(f)
+	}
+	if err := b.startOutput(sink, boundary, 0); err != nil {
+		f.Close()
+		b.addError(fmt.Errorf("failed to roll over: %w", err))
+	}
+}
+
+// closingBoundary returns the boundary of the multipart body in f and the
+// offset of the CRLF before its closing delimiter. Both are zero for an
+// empty file.
+func closingBoundary(f *os.File) (string, int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	if info.Size() == 0 {
+		return "", 0, nil
+	}
+	head := make([]byte, 80) // boundaries have at most 70 bytes
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	line, _, ok := bytes.Cut(head[:n], []byte("\r\n"))
+	if !ok || !bytes.HasPrefix(line, []byte("--")) {
+		return "", 0, ErrNoClosingBoundary
+	}
+	boundary := string(line[2:])
+
+	closing := "\r\n--" + boundary + "--"
+	tail := make([]byte, min(info.Size(), int64(len(closing)+2)))
+	if _, err := f.ReadAt(tail, info.Size()-int64(len(tail))); err != nil {
+		return "", 0, err
+	}
+	trimmed := bytes.TrimSuffix(tail, []byte("\r\n"))
+	if !bytes.HasSuffix(trimmed, []byte(closing)) {
+		return "", 0, ErrNoClosingBoundary
+	}
+	end := info.Size() - int64(len(tail)-len(trimmed)) - int64(len(closing))
+	return boundary, end, nil
+}
+
+// appendWriter continues a body whose closing delimiter was cut off with
+// its CRLF: a new part needs that CRLF back before its boundary, while the
+// closing delimiter written by the multipart writer brings its own.
+// It must not embed the file, whose ReadFrom would bypass Write.
+type appendWriter struct {
+	f       *os.File
+	started bool
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		if bytes.HasPrefix(p, []byte("--")) {
+			if _, err := w.f.Write([]byte("\r\n")); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return w.f.Write(p)
+}
+
+func (w *appendWriter) Close() error {
+	return w.f.Close()
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
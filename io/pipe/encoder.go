@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+)
+
+// Encoder writes values of type T as parts.
+type Encoder[T any] interface {
+	// Type names the kind of part in Stats, e.g. "json".
+	Type() string
+	// Header returns the header of the part called name.
+	Header(name string) textproto.MIMEHeader
+	// Encode writes v as the part content.
+	Encode(w io.Writer, v T) error
+}
+
+// part is what the worker receives: a header and a deferred encoding.
+type part struct {
+	typ    string
+	header textproto.MIMEHeader
+	encode func(w io.Writer) error
+}
+
+// Add adds a part called name holding v encoded by enc. It is a function
+// rather than a method because methods cannot have type parameters.
+func Add[T any](b *Builder, name string, v T, enc Encoder[T]) *Builder {
+	b.ch <- part{
+		typ:    enc.Type(),
+		header: enc.Header(name),
+		encode: func(w io.Writer) error { return enc.Encode(w, v) },
+	}
+	return b
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func formHeader(name, filename, contentType string) textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(name))
+	if filename != "" {
+		disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(filename))
+	}
+	h.Set("Content-Disposition", disposition)
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	return h
+}
+
+// FieldEncoder writes strings as plain form fields.
+type FieldEncoder struct{}
+
+func (FieldEncoder) Type() string { return "string" }
+
+func (FieldEncoder) Header(name string) textproto.MIMEHeader { return formHeader(name, "", "") }
+
+func (FieldEncoder) Encode(w io.Writer, v string) error {
+	_, err := io.WriteString(w, v)
+	return err
+}
+
+// JSONEncoder writes values as JSON files.
+type JSONEncoder[T any] struct {
+	Filename string // "<name>.json" if empty
+}
+
+func (JSONEncoder[T]) Type() string { return "json" }
+
+func (e JSONEncoder[T]) Header(name string) textproto.MIMEHeader {
+	filename := e.Filename
+	if filename == "" {
+		filename = name + ".json"
+	}
+	return formHeader(name, filename, "application/json")
+}
+
+func (JSONEncoder[T]) Encode(w io.Writer, v T) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// BytesEncoder writes byte slices as files.
+type BytesEncoder struct {
+	Filename    string // name if empty
+	ContentType string // application/octet-stream if empty
+}
+
+func (BytesEncoder) Type() string { return "bytes" }
+
+func (e BytesEncoder) Header(name string) textproto.MIMEHeader {
+	filename, contentType := e.Filename, e.ContentType
+	if filename == "" {
+		filename = name
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return formHeader(name, filename, contentType)
+}
+
+func (BytesEncoder) Encode(w io.Writer, v []byte) error {
+	_, err := w.Write(v)
+	return err
+}
@@ -1,105 +1,193 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"mime/multipart"
-	"os"
+	"slices"
 	"sync"
+	"time"
 )
 
-type Data struct {
-	FileType string
-	Value    any
+type Builder struct {
+	ch     chan part
+	wg     sync.WaitGroup
+	mw     *multipart.Writer
+	pw     *io.PipeWriter
+	size   *countingWriter // bytes of the current output, earlier content included
+	copied chan struct{}   // closed once the current output has the whole body
+	mu     sync.Mutex      // guards stats.Errors, pw and aborted
+	stats  Stats
+
+	aborted     bool
+	path        string    // set by OpenBuilder
+	segment     int       // index of the current file, see segmentPath
+	started     time.Time // when the current file was started
+	splitAt     int64
+	rotateEvery time.Duration
+	gzip        bool
 }
 
-type Builder struct {
-	ch    chan Data
-	wg    sync.WaitGroup
-	mw    *multipart.Writer
-	pr    *io.PipeReader
-	pw    *io.PipeWriter
-	stats map[string]int
+// Stats describes a built body.
+type Stats struct {
+	Counts map[string]int // parts written, by Encoder.Type
+	Bytes  int64          // body bytes written to the sink
+	Parts  []PartStats    // one entry per part added, in order
+	Errors []error        // failures, e.g. of parts or of the sink
 }
 
-func NewBuilder() (*Builder, error) {
-	file, err := os.Create("output.multipart")
-	if err != nil {
+// PartStats describes a part.
+type PartStats struct {
+	Type     string
+	Duration time.Duration // time taken to write the part
+	Err      error
+}
+
+// NewBuilder returns a Builder streaming its body to sink.
+func NewBuilder(sink Sink) (*Builder, error) {
+	return newBuilder(sink, "", 0)
+}
+
+// newBuilder starts a Builder whose output already holds size bytes. An
+// empty boundary is chosen at random.
+func newBuilder(sink Sink, boundary string, size int64) (*Builder, error) {
+	b := &Builder{
+		ch:    make(chan part), // Unbuffered channel to preserve the order of operations.
+		stats: Stats{Counts: make(map[string]int)},
+	}
+	if err := b.startOutput(sink, boundary, size); err != nil {
 		return nil, err
 	}
-	pipeReader, pipeWriter := io.Pipe()
-	ch := make(chan Data) // Unbuffered channel to preserve the order of operations.
-	b := &Builder{
-		ch:    ch,
-		pr:    pipeReader,
-		pw:    pipeWriter,
-		stats: make(map[string]int),
-		mw:    multipart.NewWriter(pipeWriter),
+	b.wg.Add(1)
+	go b.worker()
+	return b, nil
+}
+
+// startOutput opens sink and starts copying the body into it.
+func (b *Builder) startOutput(sink Sink, boundary string, size int64) error {
+	pr, pw := io.Pipe()
+	counter := &countingWriter{w: pw, n: size}
+	mw := multipart.NewWriter(counter)
+	if boundary != "" {
+		if err := mw.SetBoundary(boundary); err != nil {
+			return fmt.Errorf("failed to set boundary: %w", err)
+		}
 	}
+	out, err := sink.Open(mw.FormDataContentType())
+	if err != nil {
+		return err
+	}
+	copied := make(chan struct{})
 	// Start copying in a goroutine.
-	b.wg.Add(1)
 	go func() {
-		defer b.wg.Done()
-		io.Copy(file, b.pr)
+		defer close(copied)
+		n, err := io.Copy(out, pr)
+		b.stats.Bytes += n
+		if err != nil {
+			// Unblock the worker; the rest of the body is lost.
+			pr.CloseWithError(err)
+			b.addError(fmt.Errorf("failed to write output: %w", err))
+		}
+		if err := closeWithError(out, err); err != nil {
+			b.addError(fmt.Errorf("failed to close output: %w", err))
+		}
 	}()
-	b.wg.Add(1)
-	go b.worker()
-	return b, nil
+	b.mu.Lock()
+	b.mw, b.pw, b.size, b.copied = mw, pw, counter, copied
+	b.mu.Unlock()
+	b.started = time.Now()
+	return nil
+}
+
+// endOutput writes the closing boundary and waits for the sink.
+func (b *Builder) endOutput() {
+	if err := b.mw.Close(); err != nil {
+		b.addError(fmt.Errorf("failed to close multipart writer: %w", err))
+	}
+	b.pw.Close()
+	<-b.copied
+}
+
+func (b *Builder) addError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.Errors = append(b.stats.Errors, err)
 }
 
 func (b *Builder) worker() {
 	defer b.wg.Done()
-	defer b.mw.Close()
-	defer b.pw.Close()
-	for data := range b.ch {
-		if data.FileType == "string" {
-			if str, ok := data.Value.(string); ok {
-				err := b.mw.WriteField("string", str)
-				if err != nil {
-					fmt.Println("Error writing field:", err)
-					continue
-				}
-			}
-		} else if data.FileType == "json" {
-			part, err := b.mw.CreateFormFile("json", "data.json")
-			if err != nil {
-				fmt.Println("Error creating form file:", err)
-				continue
-			}
-			jsonData, err := json.Marshal(data.Value)
-			if err != nil {
-				fmt.Println("Error marshaling JSON:", err)
-				continue
-			}
-			_, err = part.Write(jsonData)
-			if err != nil {
-				fmt.Println("Error writing to part:", err)
-				continue
-			}
+	for p := range b.ch {
+		if b.dueForRollOver() {
+			b.rollOver()
+		}
+		start := time.Now()
+		err := b.writePart(p)
+		b.stats.Parts = append(b.stats.Parts, PartStats{Type: p.typ, Duration: time.Since(start), Err: err})
+		if err != nil {
+			b.addError(err)
+			continue
 		}
-		b.stats[data.FileType]++
+		b.stats.Counts[p.typ]++
 	}
+	b.endOutput()
+}
+
+func (b *Builder) writePart(p part) error {
+	w, err := b.mw.CreatePart(p.header)
+	if err != nil {
+		return fmt.Errorf("failed to create part: %w", err)
+	}
+	if err := p.encode(w); err != nil {
+		return fmt.Errorf("failed to write %s part: %w", p.typ, err)
+	}
+	return nil
 }
 
 func (b *Builder) String(line string) *Builder {
-	b.ch <- Data{FileType: "string", Value: line}
-	return b
+	return Add(b, "string", line, FieldEncoder{})
 }
 
 func (b *Builder) JSON(j any) *Builder {
-	b.ch <- Data{FileType: "json", Value: j}
-	return b
+	return Add(b, "json", j, JSONEncoder[any]{Filename: "data.json"})
+}
+
+// Build ends the body and returns its stats once the sink has it all.
+func (b *Builder) Build() Stats {
+	return b.BuildContext(context.Background())
 }
 
-func (b *Builder) Build() map[string]int {
+// BuildContext is Build, but gives up when ctx is done: the body is cut
+// short, the sink is closed and ctx's error is added to the stats. A sink
+// blocked in Write is still waited for.
+func (b *Builder) BuildContext(ctx context.Context) Stats {
 	close(b.ch)
-	b.wg.Wait()
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.aborted = true
+		b.pw.CloseWithError(ctx.Err())
+		b.mu.Unlock()
+		<-done
+		if !slices.ContainsFunc(b.stats.Errors, func(err error) bool { return errors.Is(err, ctx.Err()) }) {
+			b.addError(ctx.Err())
+		}
+	}
 	return b.stats
 }
 
 func main() {
-	builder, err := NewBuilder()
+	output := flag.String("o", "output.multipart", "path of the multipart file to write")
+	flag.Parse()
+	builder, err := NewBuilder(FileSink(*output))
 	if err != nil {
 		fmt.Println("Error creating builder:", err)
 		return
@@ -110,5 +198,8 @@ func main() {
 		String("3").
 		JSON(map[string]string{"key": "value"}).
 		Build()
-	fmt.Printf("stats: %v\n", stats)
+	fmt.Printf("parts: %v, bytes: %d\n", stats.Counts, stats.Bytes)
+	for _, err := range stats.Errors {
+		fmt.Println("Error:", err)
+	}
 }
@@ -2,13 +2,28 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestBuilder(t *testing.T) {
-	builder, err := NewBuilder()
+	path := filepath.Join(t.TempDir(), "output.multipart")
+	builder, err := NewBuilder(FileSink(path))
 	if err != nil {
 		t.Fatal("Error creating builder:", err)
 	}
@@ -18,20 +33,26 @@ func TestBuilder(t *testing.T) {
 		JSON(map[string]string{"key": "value"}).
 		Build()
 
-	if stats["string"] != 2 {
-		t.Errorf("Expected 2 strings, got %d", stats["string"])
+	if stats.Counts["string"] != 2 {
+		t.Errorf("Expected 2 strings, got %d", stats.Counts["string"])
 	}
-	if stats["json"] != 1 {
-		t.Errorf("Expected 1 json, got %d", stats["json"])
+	if stats.Counts["json"] != 1 {
+		t.Errorf("Expected 1 json, got %d", stats.Counts["json"])
+	}
+	if len(stats.Parts) != 3 || len(stats.Errors) != 0 {
+		t.Errorf("Expected 3 parts and no errors, got %+v", stats)
 	}
 
 	// Check file exists
-	if _, err := os.Stat("output.multipart"); os.IsNotExist(err) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
 		t.Error("output.multipart not created")
+	} else if info.Size() != stats.Bytes {
+		t.Errorf("Expected %d bytes, file has %d", stats.Bytes, info.Size())
 	}
 
 	// Check file has content
-	file, err := os.Open("output.multipart")
+	file, err := os.Open(path)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -50,13 +71,315 @@ func TestBuilder(t *testing.T) {
 	}
 }
 
+func TestSinks(t *testing.T) {
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			data, _ := io.ReadAll(part)
+			received = append(received, string(data))
+		}
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	builder, err := NewBuilder(MultiSink(WriterSink(&buf), HTTPSink(srv.Client(), srv.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder.String("a").JSON(1).Build()
+
+	if got := strings.Join(received, ","); got != "a,1" {
+		t.Errorf("server received %q", got)
+	}
+	if !strings.Contains(buf.String(), "\r\n\r\na\r\n") {
+		t.Errorf("writer received %q", buf.String())
+	}
+
+	if _, err := NewBuilder(FileSink(filepath.Join(t.TempDir(), "missing", "out"))); err == nil {
+		t.Error("FileSink in a missing directory: no error")
+	}
+}
+
+type failingEncoder struct{ err error }
+
+func (failingEncoder) Type() string { return "failing" }
+
+func (failingEncoder) Header(name string) textproto.MIMEHeader { return formHeader(name, "", "") }
+
+func (e failingEncoder) Encode(io.Writer, int) error { return e.err }
+
+func TestBuildErrors(t *testing.T) {
+	builder, err := NewBuilder(WriterSink(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errEncode := errors.New("encode failed")
+	Add(builder, "n", 1, failingEncoder{errEncode})
+	stats := builder.JSON(func() {}).String("ok").Build()
+	if stats.Counts["string"] != 1 || len(stats.Errors) != 2 || len(stats.Parts) != 3 || stats.Parts[1].Err == nil {
+		t.Errorf("stats = %+v", stats)
+	}
+	if !errors.Is(stats.Errors[0], errEncode) {
+		t.Errorf("first error = %v", stats.Errors[0])
+	}
+}
+
+func TestAdd(t *testing.T) {
+	var buf bytes.Buffer
+	builder, err := NewBuilder(WriterSink(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type point struct{ X, Y int }
+	Add(builder, "point", point{1, 2}, JSONEncoder[point]{})
+	Add(builder, "blob", []byte{0, 1}, BytesEncoder{Filename: "blob.bin"})
+	stats := Add(builder, "note", "hi", FieldEncoder{}).Build()
+	if len(stats.Errors) != 0 || stats.Counts["json"] != 1 || stats.Counts["bytes"] != 1 || stats.Counts["string"] != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	_, params, _ := mime.ParseMediaType(builder.mw.FormDataContentType())
+	mr := multipart.NewReader(&buf, params["boundary"])
+	var got []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(p)
+		got = append(got, fmt.Sprintf("%s|%s|%s|%q", p.FormName(), p.FileName(), p.Header.Get("Content-Type"), data))
+	}
+	want := []string{
+		`point|point.json|application/json|"{\"X\":1,\"Y\":2}"`,
+		`blob|blob.bin|application/octet-stream|"\x00\x01"`,
+		`note|||"hi"`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("parts = %q, want %q", got, want)
+	}
+}
+
+type blockingWriter struct{ release chan struct{} }
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestBuildContext(t *testing.T) {
+	release := make(chan struct{})
+	builder, err := NewBuilder(SinkFunc(func(string) (io.WriteCloser, error) {
+		return nopCloser{blockingWriter{release}}, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+		close(release)
+	}()
+	stats := builder.String("a").BuildContext(ctx)
+	if !slices.ContainsFunc(stats.Errors, func(err error) bool { return errors.Is(err, context.Canceled) }) {
+		t.Errorf("errors = %v", stats.Errors)
+	}
+}
+
+// readArchive returns the parts of the multipart file at path as
+// "name=content".
+func readArchive(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	boundary, _, err := closingBoundary(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(f, boundary)
+	var got []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		data, _ := io.ReadAll(p)
+		got = append(got, p.FormName()+"="+string(data))
+	}
+}
+
+func TestOpenBuilder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.multipart")
+	for _, batch := range [][]string{{"a", "b"}, {}, {"c"}} {
+		builder, err := OpenBuilder(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range batch {
+			builder.String(v)
+		}
+		if stats := builder.Build(); len(stats.Errors) != 0 {
+			t.Fatal(stats.Errors)
+		}
+	}
+	if got, want := readArchive(t, path), []string{"string=a", "string=b", "string=c"}; !slices.Equal(got, want) {
+		t.Errorf("parts = %q, want %q", got, want)
+	}
+
+	broken := filepath.Join(t.TempDir(), "broken.multipart")
+	os.WriteFile(broken, []byte("--x\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\ncut"), 0o644)
+	if _, err := OpenBuilder(broken); !errors.Is(err, ErrNoClosingBoundary) {
+		t.Errorf("truncated file: err = %v", err)
+	}
+}
+
+func TestSplitAt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.multipart")
+	value := strings.Repeat("v", 100)
+	for range 2 {
+		builder, err := OpenBuilder(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder.SplitAt(250)
+		for range 3 {
+			builder.String(value)
+		}
+		if stats := builder.Build(); len(stats.Errors) != 0 {
+			t.Fatal(stats.Errors)
+		}
+	}
+	// Each file takes the part that crosses the limit: 2 + 2 + 2 parts,
+	// with the second run resuming the last file.
+	total := 0
+	for n := range 3 {
+		parts := readArchive(t, segmentPath(path, n))
+		if len(parts) != 2 {
+			t.Errorf("%s has %d parts", segmentPath(path, n), len(parts))
+		}
+		total += len(parts)
+	}
+	if _, err := os.Stat(segmentPath(path, 3)); err == nil || total != 6 {
+		t.Errorf("expected 3 files with 6 parts, got %d parts", total)
+	}
+}
+
+func TestReadMultipartFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.multipart")
+	builder, err := NewBuilder(FileSink(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder.String("line").JSON(map[string]any{"key": "value"})
+	Add(builder, "blob", []byte{1, 2}, BytesEncoder{})
+	builder.Build()
+
+	var got []Data
+	for d, err := range ReadMultipartFile(path) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, d)
+	}
+	want := []Data{
+		{FileType: "string", Name: "string", Value: "line"},
+		{FileType: "json", Name: "json", Value: map[string]any{"key": "value"}},
+		{FileType: "bytes", Name: "blob", Value: []byte{1, 2}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, err := range ReadMultipartFile(filepath.Join(t.TempDir(), "missing")) {
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("missing file: err = %v", err)
+		}
+	}
+}
+
+func TestArchiveOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.multipart.gz")
+	value := strings.Repeat("v", 100)
+	for range 2 {
+		builder, err := OpenBuilder(path, Gzip(), MaxSize(250))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range 3 {
+			builder.String(value)
+		}
+		if stats := builder.Build(); len(stats.Errors) != 0 {
+			t.Fatal(stats.Errors)
+		}
+	}
+	// Compressed files are never appended to: 2 + 1 parts per run.
+	var counts []int
+	for n := 0; ; n++ {
+		f, err := os.Open(segmentPath(path, n))
+		if err != nil {
+			break
+		}
+		magic := make([]byte, 2)
+		io.ReadFull(f, magic)
+		f.Close()
+		if !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+			t.Errorf("%s is not compressed", segmentPath(path, n))
+		}
+		count := 0
+		for d, err := range ReadMultipartFile(segmentPath(path, n)) {
+			if err != nil || d.Value != value {
+				t.Fatalf("%s: %v, %+v", segmentPath(path, n), err, d)
+			}
+			count++
+		}
+		counts = append(counts, count)
+	}
+	if !slices.Equal(counts, []int{2, 1, 2, 1}) {
+		t.Errorf("parts per file = %v", counts)
+	}
+
+	rotated := filepath.Join(t.TempDir(), "events.multipart")
+	builder, err := OpenBuilder(rotated, RotateEvery(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder.String("a").String("b")
+	time.Sleep(150 * time.Millisecond)
+	builder.String("c").Build()
+	if got := readArchive(t, segmentPath(rotated, 1)); !slices.Equal(got, []string{"string=c"}) {
+		t.Errorf("rotated file parts = %q", got)
+	}
+}
+
 func BenchmarkBuilder(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		builder, _ := NewBuilder()
+		builder, _ := NewBuilder(WriterSink(io.Discard))
 		builder.
 			String("line").
 			JSON(map[string]int{"num": i}).
 			Build()
-		os.Remove("output.multipart") // Clean up
 	}
 }
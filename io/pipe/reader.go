@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+)
+
+// Data is a part read back by ReadMultipartFile.
+type Data struct {
+	FileType string // "string", "json" or "bytes", as the built-in Encoders name them
+	Name     string
+	Value    any // string, the decoded JSON value, or []byte
+}
+
+// ReadMultipartFile reads the parts of a multipart file written by a
+// Builder, for round-trip tests and offline reprocessing of archives. Form
+// fields come back as strings and JSON files, recognized by their
+// Content-Type or extension, are decoded; other files are returned as
+// bytes. The sequence stops after the first error.
+func ReadMultipartFile(path string) iter.Seq2[Data, error] {
+	return func(yield func(Data, error) bool) {
+		f, err := os.Open(path)
+		if err != nil {
+			yield(Data{}, fmt.Errorf("failed to open multipart file: %w", err))
+			return
+		}
+		defer f.Close()
+		r, boundary, err := openBody(f)
+		if err != nil {
+			yield(Data{}, fmt.Errorf("failed to read %s: %w", path, err))
+			return
+		}
+		mr := multipart.NewReader(r, boundary)
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(Data{}, fmt.Errorf("failed to read part: %w", err))
+				return
+			}
+			d, err := readData(p)
+			if !yield(d, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// openBody returns the body in f, decompressed if it was written with
+// Gzip, and its boundary, taken from the first line.
+func openBody(f *os.File) (io.Reader, string, error) {
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, "", err
+		}
+		br = bufio.NewReader(zr)
+	}
+	head, _ := br.Peek(80) // boundaries have at most 70 bytes
+	line, _, ok := bytes.Cut(head, []byte("\r\n"))
+	if !ok || !bytes.HasPrefix(line, []byte("--")) {
+		return nil, "", errors.New("not a multipart body")
+	}
+	return br, string(line[2:]), nil
+}
+
+func readData(p *multipart.Part) (Data, error) {
+	d := Data{Name: p.FormName()}
+	content, err := io.ReadAll(p)
+	if err != nil {
+		return d, fmt.Errorf("failed to read part [%q]: %w", d.Name, err)
+	}
+	mediaType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+	switch {
+	case p.FileName() == "":
+		d.FileType, d.Value = "string", string(content)
+	case mediaType == "application/json" || filepath.Ext(p.FileName()) == ".json":
+		d.FileType = "json"
+		if err := json.Unmarshal(content, &d.Value); err != nil {
+			return d, fmt.Errorf("failed to decode JSON part [%q]: %w", d.Name, err)
+		}
+	default:
+		d.FileType, d.Value = "bytes", content
+	}
+	return d, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// Sink is the destination of the body built by a Builder.
+type Sink interface {
+	// Open returns the writer the body is copied to. contentType is the
+	// multipart Content-Type, boundary included. The Builder closes the
+	// writer once the body is complete.
+	Open(contentType string) (io.WriteCloser, error)
+}
+
+// SinkFunc adapts a function to Sink.
+type SinkFunc func(contentType string) (io.WriteCloser, error)
+
+func (f SinkFunc) Open(contentType string) (io.WriteCloser, error) { return f(contentType) }
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// WriterSink writes the body to w, which is left open.
+func WriterSink(w io.Writer) Sink {
+	return SinkFunc(func(string) (io.WriteCloser, error) { return nopCloser{w}, nil })
+}
+
+// FileSink writes the body to a file created, or truncated, at path.
+func FileSink(path string) Sink {
+	return SinkFunc(func(string) (io.WriteCloser, error) {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create output file: %w", err)
+		}
+		return f, nil
+	})
+}
+
+// HTTPSink posts the body to url as it is built. Closing the writer waits
+// for the response and fails unless its status is 2xx.
+func HTTPSink(client *http.Client, url string) Sink {
+	return SinkFunc(func(contentType string) (io.WriteCloser, error) {
+		pr, pw := io.Pipe()
+		req, err := http.NewRequest(http.MethodPost, url, pr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		w := &httpWriter{PipeWriter: pw, done: make(chan error, 1)}
+		go func() {
+			resp, err := client.Do(req)
+			if err != nil {
+				pr.CloseWithError(err)
+				w.done <- fmt.Errorf("failed to send request: %w", err)
+				return
+			}
+			defer resp.Body.Close()
+			pr.CloseWithError(io.ErrClosedPipe) // the server may answer before reading everything
+			if resp.StatusCode/100 != 2 {
+				w.done <- fmt.Errorf("unexpected response status %s", resp.Status)
+				return
+			}
+			w.done <- nil
+		}()
+		return w, nil
+	})
+}
+
+type httpWriter struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (w *httpWriter) Close() error {
+	return w.CloseWithError(nil)
+}
+
+// CloseWithError aborts the request with err, or ends its body if err is
+// nil, and waits for the response.
+func (w *httpWriter) CloseWithError(err error) error {
+	w.PipeWriter.CloseWithError(err)
+	return <-w.done
+}
+
+// MultiSink writes the body to every sink, like io.MultiWriter.
+func MultiSink(sinks ...Sink) Sink {
+	return SinkFunc(func(contentType string) (io.WriteCloser, error) {
+		m := &multiWriteCloser{}
+		var writers []io.Writer
+		for _, s := range sinks {
+			w, err := s.Open(contentType)
+			if err != nil {
+				m.Close()
+				return nil, err
+			}
+			m.closers = append(m.closers, w)
+			writers = append(writers, w)
+		}
+		m.Writer = io.MultiWriter(writers...)
+		return m, nil
+	})
+}
+
+type multiWriteCloser struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (m *multiWriteCloser) Close() error {
+	return m.CloseWithError(nil)
+}
+
+func (m *multiWriteCloser) CloseWithError(err error) error {
+	var errs []error
+	for _, c := range m.closers {
+		errs = append(errs, closeWithError(c, err))
+	}
+	return errors.Join(errs...)
+}
+
+// closeWithError closes c, passing err on if c can report it to its
+// reader, as the writer of a pipe can.
+func closeWithError(c io.Closer, err error) error {
+	if ec, ok := c.(interface{ CloseWithError(error) error }); ok && err != nil {
+		return ec.CloseWithError(err)
+	}
+	return c.Close()
+}
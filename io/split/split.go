@@ -0,0 +1,133 @@
+// Package split cuts a stream into consecutive chunks, as chunked and
+// multipart upload protocols send large files in fixed-size pieces:
+//
+//	for part := range split.Reader(f, size, 8<<20) {
+//		if err := uploadPart(ctx, part); err != nil { ... }
+//	}
+//
+// Chunks are not buffered: each chunk reader reads straight from the
+// source, so memory use does not grow with the chunk size.
+package split
+
+import (
+	"bytes"
+	"io"
+	"iter"
+	"math"
+)
+
+// Reader returns the chunks of r in order, each chunk bytes long except
+// possibly the last. size is the length of r, or -1 if it is unknown, in
+// which case chunks are yielded until r is exhausted. A chunk of zero or
+// less yields r as a single chunk.
+//
+// If r is an io.ReaderAt and size is known, every chunk wraps an
+// *io.SectionReader and has its ReadAt, Seek and Size methods: chunks can be
+// read in any order, concurrently and more than once, e.g. to retry a failed
+// part. Otherwise chunks share r and must be read in order; a chunk reader
+// is valid only until the loop body returns, and the part of it left unread
+// is skipped. A source shorter than size fails the last chunk with
+// io.ErrUnexpectedEOF. An error reading r ends the sequence; if it happens
+// between chunks, while peeking or skipping, one more chunk is yielded whose
+// Read returns it, so the caller never mistakes it for the end of r.
+func Reader(r io.Reader, size int64, chunk int64) iter.Seq[io.Reader] {
+	if chunk <= 0 {
+		chunk = math.MaxInt64
+	}
+	return func(yield func(io.Reader) bool) {
+		if ra, ok := r.(io.ReaderAt); ok && size >= 0 {
+			for off := int64(0); off < size; off += min(chunk, size-off) {
+				if !yield(section{io.NewSectionReader(ra, off, min(chunk, size-off))}) {
+					return
+				}
+			}
+			return
+		}
+		for off := int64(0); size < 0 || off < size; off += chunk {
+			c := &chunkReader{r: r, n: chunk, exact: size >= 0}
+			if size >= 0 {
+				c.n = min(chunk, size-off)
+			} else {
+				// Peek a byte to learn whether another chunk follows.
+				var b [1]byte
+				if n, err := io.ReadFull(r, b[:]); n == 0 {
+					if err != io.EOF {
+						yield(errReader{err})
+					}
+					return
+				}
+				c.r = io.MultiReader(bytes.NewReader(b[:]), r)
+			}
+			if !yield(c) {
+				return
+			}
+			reported := c.err != nil
+			if _, err := io.Copy(io.Discard, c); err != nil {
+				if !reported {
+					yield(errReader{err})
+				}
+				return
+			}
+			if c.n > 0 {
+				return
+			}
+		}
+	}
+}
+
+// section is a chunk of an io.ReaderAt. Unlike io.SectionReader, it fails
+// with io.ErrUnexpectedEOF when the source ends inside the section.
+type section struct{ *io.SectionReader }
+
+func (s section) Read(p []byte) (int, error) {
+	n, err := s.SectionReader.Read(p)
+	if err == io.EOF {
+		if pos, _ := s.Seek(0, io.SeekCurrent); pos < s.Size() {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+func (s section) ReadAt(p []byte, off int64) (int, error) {
+	n, err := s.SectionReader.ReadAt(p, off)
+	if err == io.EOF && off+int64(n) < s.Size() {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// chunkReader reads up to n bytes of r; with exact, stopping short of n is
+// an error. Errors other than io.EOF are sticky.
+type chunkReader struct {
+	r     io.Reader
+	n     int64
+	exact bool
+	err   error
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	if err == io.EOF && c.n > 0 && c.exact {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		c.err = err
+	}
+	return n, err
+}
+
+// errReader is a chunk that only fails with err.
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
@@ -0,0 +1,147 @@
+package split
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// onlyReader hides every method but Read.
+type onlyReader struct{ io.Reader }
+
+func chunks(t *testing.T, r io.Reader, size, chunk int64) []string {
+	t.Helper()
+	var out []string
+	for part := range Reader(r, size, chunk) {
+		b, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("chunk %d: %v", len(out), err)
+		}
+		out = append(out, string(b))
+	}
+	return out
+}
+
+func TestReader(t *testing.T) {
+	const data = "abcdefghij"
+	want := []string{"abcd", "efgh", "ij"}
+	tests := []struct {
+		name string
+		r    io.Reader
+		size int64
+	}{
+		{"ReaderAt", strings.NewReader(data), int64(len(data))},
+		{"stream", onlyReader{strings.NewReader(data)}, int64(len(data))},
+		{"unknown size", onlyReader{strings.NewReader(data)}, -1},
+		{"one byte reads", iotest.OneByteReader(strings.NewReader(data)), -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chunks(t, tt.r, tt.size, 4); strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Errorf("chunks = %q, want %q", got, want)
+			}
+		})
+	}
+
+	if got := chunks(t, onlyReader{strings.NewReader("abcdefgh")}, -1, 4); len(got) != 2 {
+		t.Errorf("exact multiple: chunks = %q", got)
+	}
+	if got := chunks(t, onlyReader{strings.NewReader("")}, -1, 4); len(got) != 0 {
+		t.Errorf("empty: chunks = %q", got)
+	}
+	if got := chunks(t, onlyReader{strings.NewReader(data)}, -1, 0); len(got) != 1 || got[0] != data {
+		t.Errorf("no chunk size: chunks = %q", got)
+	}
+}
+
+func TestReaderSkipsUnread(t *testing.T) {
+	var firsts []byte
+	for part := range Reader(onlyReader{strings.NewReader("aaabbbccc")}, 9, 3) {
+		b := make([]byte, 1)
+		io.ReadFull(part, b)
+		firsts = append(firsts, b[0])
+	}
+	if string(firsts) != "abc" {
+		t.Errorf("first bytes = %q", firsts)
+	}
+}
+
+func TestReaderShort(t *testing.T) {
+	var errs []error
+	for part := range Reader(onlyReader{strings.NewReader("abcdef")}, 10, 4) {
+		_, err := io.ReadAll(part)
+		errs = append(errs, err)
+	}
+	if len(errs) != 2 || errs[0] != nil || !errors.Is(errs[1], io.ErrUnexpectedEOF) {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestReaderAtRetry(t *testing.T) {
+	var parts []io.Reader
+	for part := range Reader(bytes.NewReader([]byte("0123456789")), 10, 3) {
+		parts = append(parts, part)
+	}
+	// Section readers stay valid and can be read again from the start.
+	last := parts[len(parts)-1].(io.ReadSeeker)
+	io.ReadAll(last)
+	last.Seek(0, io.SeekStart)
+	if b, _ := io.ReadAll(last); string(b) != "9" || len(parts) != 4 {
+		t.Errorf("%d parts, last = %q", len(parts), b)
+	}
+}
+
+func TestReaderAtShort(t *testing.T) {
+	var errs []error
+	for part := range Reader(strings.NewReader("abcde"), 10, 4) {
+		_, err := io.ReadAll(part)
+		errs = append(errs, err)
+	}
+	if len(errs) != 3 || errs[0] != nil || !errors.Is(errs[1], io.ErrUnexpectedEOF) || !errors.Is(errs[2], io.ErrUnexpectedEOF) {
+		t.Errorf("errors = %v", errs)
+	}
+
+	for part := range Reader(strings.NewReader("abcde"), 6, 6) {
+		b := make([]byte, 6)
+		if n, err := part.(io.ReaderAt).ReadAt(b, 0); n != 5 || !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("ReadAt = %d, %v", n, err)
+		}
+	}
+}
+
+func TestReaderSourceError(t *testing.T) {
+	errBoom := errors.New("boom")
+	source := func() io.Reader {
+		return io.MultiReader(strings.NewReader(strings.Repeat("x", 20)), iotest.ErrReader(errBoom))
+	}
+
+	// The error hits between chunks, while peeking for the next one.
+	var n int
+	var err error
+	for part := range Reader(source(), -1, 10) {
+		var b []byte
+		if b, err = io.ReadAll(part); err != nil {
+			break
+		}
+		n += len(b)
+	}
+	if n != 20 || !errors.Is(err, errBoom) {
+		t.Errorf("read %d bytes, err = %v; want 20 bytes and errBoom", n, err)
+	}
+
+	// The error hits while skipping the unread rest of a chunk.
+	var parts int
+	err = nil
+	for part := range Reader(source(), -1, 8) {
+		parts++
+		if parts > 3 {
+			_, err = io.ReadAll(part)
+		}
+	}
+	if parts != 4 || !errors.Is(err, errBoom) {
+		t.Errorf("%d parts, err = %v; want the error in a fourth part", parts, err)
+	}
+}
@@ -0,0 +1,355 @@
+// Package mimemail composes RFC 5322 messages with MIME bodies and streams
+// them to an io.Writer, so attachments are never held in memory.
+//
+// The body structure depends on what the message carries:
+//
+//	multipart/mixed                 (only with attachments)
+//	├── multipart/related           (only with inline parts)
+//	│   ├── multipart/alternative   (only with both text and HTML)
+//	│   │   ├── text/plain
+//	│   │   └── text/html
+//	│   └── inline parts, referenced from the HTML as cid:<Content-ID>
+//	└── attachments
+//
+// Text is encoded as quoted-printable utf-8, everything else as base64.
+package mimemail
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message is an email message. Addresses in Bcc receive the message but are
+// not written to its header.
+type Message struct {
+	From      mail.Address
+	To        []mail.Address
+	Cc        []mail.Address
+	Bcc       []mail.Address
+	Subject   string
+	Date      time.Time // zero means the time of writing
+	MessageID string    // without angle brackets; generated if empty
+	Header    textproto.MIMEHeader
+
+	Text string
+	HTML string
+
+	Inline      []Part // images and other parts the HTML refers to by Content-ID
+	Attachments []Part
+}
+
+// Part is an inline part or an attachment. Content is read when the message
+// is written; Open, if set, is used instead and its result closed.
+type Part struct {
+	Filename    string
+	ContentType string // guessed from Filename if empty
+	ContentID   string // inline parts only, without angle brackets
+	Content     io.Reader
+	Open        func() (io.ReadCloser, error)
+}
+
+// Attach adds an attachment read from r.
+func (m *Message) Attach(filename, contentType string, r io.Reader) *Message {
+	m.Attachments = append(m.Attachments, Part{Filename: filename, ContentType: contentType, Content: r})
+	return m
+}
+
+// AttachFile adds an attachment opened from path when the message is written.
+func (m *Message) AttachFile(path string) *Message {
+	m.Attachments = append(m.Attachments, Part{Filename: filepath.Base(path), Open: opener(path)})
+	return m
+}
+
+// Embed adds an inline part, referenced from the HTML as "cid:" + contentID.
+func (m *Message) Embed(contentID, filename, contentType string, r io.Reader) *Message {
+	m.Inline = append(m.Inline, Part{Filename: filename, ContentType: contentType, ContentID: contentID, Content: r})
+	return m
+}
+
+// EmbedFile adds an inline part opened from path when the message is written.
+func (m *Message) EmbedFile(contentID, path string) *Message {
+	m.Inline = append(m.Inline, Part{Filename: filepath.Base(path), ContentID: contentID, Open: opener(path)})
+	return m
+}
+
+func opener(path string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) { return os.Open(path) }
+}
+
+// Recipients returns the envelope recipients: To, Cc and Bcc.
+func (m *Message) Recipients() []string {
+	var rcpt []string
+	for _, list := range [][]mail.Address{m.To, m.Cc, m.Bcc} {
+		for _, a := range list {
+			rcpt = append(rcpt, a.Address)
+		}
+	}
+	return rcpt
+}
+
+// WriteTo writes the message in wire format with CRLF line endings.
+func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	if m.From.Address == "" {
+		return 0, errors.New("mimemail: message has no From address")
+	}
+	if len(m.Recipients()) == 0 {
+		return 0, errors.New("mimemail: message has no recipients")
+	}
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	root := m.body()
+	if err := m.writeHeader(cw, root.header); err != nil {
+		return cw.n, err
+	}
+	if err := root.write(cw); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.w.(*bufio.Writer).Flush()
+}
+
+// Reader returns the message as a stream, written by a goroutine on demand.
+// Closing the reader early stops the goroutine.
+func (m *Message) Reader() io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := m.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+func (m *Message) writeHeader(w io.Writer, content textproto.MIMEHeader) error {
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	id := m.MessageID
+	if id == "" {
+		id = randomID() + "@" + domain(m.From.Address)
+	}
+
+	var b strings.Builder
+	line := func(k, v string) {
+		if v != "" {
+			fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+		}
+	}
+	line("From", m.From.String())
+	line("To", addressList(m.To))
+	line("Cc", addressList(m.Cc))
+	line("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	line("Date", date.Format(time.RFC1123Z))
+	line("Message-Id", "<"+id+">")
+	line("MIME-Version", "1.0")
+
+	keys := make([]string, 0, len(m.Header))
+	for k := range m.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range m.Header[k] {
+			line(k, v)
+		}
+	}
+	for _, k := range sortedKeys(content) {
+		line(k, content.Get(k))
+	}
+	b.WriteString("\r\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// entity is a MIME entity: its content headers and a function writing its
+// encoded body.
+type entity struct {
+	header textproto.MIMEHeader
+	write  func(w io.Writer) error
+}
+
+func (m *Message) body() entity {
+	var content []entity
+	if m.Text != "" || m.HTML == "" {
+		content = append(content, textEntity("text/plain", m.Text))
+	}
+	if m.HTML != "" {
+		content = append(content, textEntity("text/html", m.HTML))
+	}
+	root := content[0]
+	if len(content) > 1 {
+		root = multipartEntity("alternative", content)
+	}
+	if len(m.Inline) > 0 {
+		related := []entity{root}
+		for _, p := range m.Inline {
+			related = append(related, binaryEntity(p, "inline"))
+		}
+		root = multipartEntity("related", related)
+	}
+	if len(m.Attachments) > 0 {
+		mixed := []entity{root}
+		for _, p := range m.Attachments {
+			mixed = append(mixed, binaryEntity(p, "attachment"))
+		}
+		root = multipartEntity("mixed", mixed)
+	}
+	return root
+}
+
+func textEntity(mediaType, text string) entity {
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", mediaType+"; charset=utf-8")
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	return entity{header: h, write: func(w io.Writer) error {
+		qp := quotedprintable.NewWriter(w)
+		if _, err := io.WriteString(qp, text); err != nil {
+			return err
+		}
+		return qp.Close()
+	}}
+}
+
+func binaryEntity(p Part, disposition string) entity {
+	contentType := p.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(p.Filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Transfer-Encoding", "base64")
+	if p.Filename != "" {
+		h.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": p.Filename}))
+	} else {
+		h.Set("Content-Disposition", disposition)
+	}
+	if p.ContentID != "" {
+		h.Set("Content-Id", "<"+p.ContentID+">")
+	}
+	return entity{header: h, write: func(w io.Writer) error {
+		r := p.Content
+		if p.Open != nil {
+			rc, err := p.Open()
+			if err != nil {
+				return fmt.Errorf("mimemail: opening %q: %w", p.Filename, err)
+			}
+			defer rc.Close()
+			r = rc
+		}
+		if r == nil {
+			return fmt.Errorf("mimemail: part %q has no content", p.Filename)
+		}
+		enc := base64.NewEncoder(base64.StdEncoding, &lineWrapper{w: w, width: 76})
+		if _, err := io.Copy(enc, r); err != nil {
+			return err
+		}
+		return enc.Close()
+	}}
+}
+
+func multipartEntity(subtype string, children []entity) entity {
+	boundary := randomID() + randomID()
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", mime.FormatMediaType("multipart/"+subtype, map[string]string{"boundary": boundary}))
+	return entity{header: h, write: func(w io.Writer) error {
+		mw := multipart.NewWriter(w)
+		if err := mw.SetBoundary(boundary); err != nil {
+			return err
+		}
+		for _, child := range children {
+			pw, err := mw.CreatePart(child.header)
+			if err != nil {
+				return err
+			}
+			if err := child.write(pw); err != nil {
+				return err
+			}
+		}
+		return mw.Close()
+	}}
+}
+
+func sortedKeys(h textproto.MIMEHeader) []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func addressList(list []mail.Address) string {
+	s := make([]string, len(list))
+	for i, a := range list {
+		s[i] = a.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+func domain(addr string) string {
+	if i := strings.LastIndexByte(addr, '@'); i >= 0 {
+		return addr[i+1:]
+	}
+	return "localhost"
+}
+
+func randomID() string {
+	var b [12]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// lineWrapper inserts a CRLF after every width bytes written.
+type lineWrapper struct {
+	w     io.Writer
+	width int
+	col   int
+}
+
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if l.col == l.width {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return n, err
+			}
+			l.col = 0
+		}
+		c := min(len(p), l.width-l.col)
+		m, err := l.w.Write(p[:c])
+		n += m
+		l.col += m
+		if err != nil {
+			return n, err
+		}
+		p = p[c:]
+	}
+	return n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,232 @@
+package mimemail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// leaf is a decoded non-multipart entity found while walking a message.
+type leaf struct {
+	path   string // media types from the root, e.g. "multipart/mixed/text/plain"
+	header textproto.MIMEHeader
+	body   string
+}
+
+func walk(t *testing.T, path string, header textproto.MIMEHeader, body io.Reader) []leaf {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	path += "/" + mediaType
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var leaves []leaf
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return leaves
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			leaves = append(leaves, walk(t, path, p.Header, p)...)
+		}
+	}
+	switch header.Get("Content-Transfer-Encoding") {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return []leaf{{path: strings.TrimPrefix(path, "/"), header: header, body: string(b)}}
+}
+
+func parse(t *testing.T, raw []byte) (*mail.Message, []leaf) {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg, walk(t, "", textproto.MIMEHeader(msg.Header), msg.Body)
+}
+
+func newMessage() *Message {
+	return &Message{
+		From:    mail.Address{Name: "Jörg", Address: "jorg@example.com"},
+		To:      []mail.Address{{Address: "ann@example.com"}},
+		Cc:      []mail.Address{{Name: "Bob", Address: "bob@example.com"}},
+		Bcc:     []mail.Address{{Address: "hidden@example.com"}},
+		Subject: "Report — Q3",
+		Date:    time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	m := newMessage()
+	m.Text = "hello\nworld"
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	msg, leaves := parse(t, buf.Bytes())
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != m.Subject {
+		t.Errorf("Subject = %q, %v", subject, err)
+	}
+	if msg.Header.Get("Bcc") != "" || bytes.Contains(buf.Bytes(), []byte("hidden@")) {
+		t.Error("Bcc recipient leaked into the message")
+	}
+	if got := msg.Header.Get("Message-Id"); !strings.HasSuffix(got, "@example.com>") {
+		t.Errorf("Message-Id = %q", got)
+	}
+	if len(leaves) != 1 || leaves[0].path != "text/plain" || leaves[0].body != "hello\r\nworld" {
+		t.Fatalf("leaves = %+v", leaves)
+	}
+	if got := m.Recipients(); len(got) != 3 || got[2] != "hidden@example.com" {
+		t.Errorf("Recipients() = %v", got)
+	}
+}
+
+func TestFullStructure(t *testing.T) {
+	dir := t.TempDir()
+	report := filepath.Join(dir, "report.csv")
+	if err := os.WriteFile(report, []byte("a,b\n1,2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	logo := bytes.Repeat([]byte{0x89, 'P', 'N', 'G', 0, 0xff}, 100)
+
+	m := newMessage()
+	m.Text = "See the logo."
+	m.HTML = `<p>See the <img src="cid:logo@example.com"></p>`
+	m.Embed("logo@example.com", "logo.png", "", bytes.NewReader(logo))
+	m.AttachFile(report)
+	m.Attach("notes.txt", "text/plain", strings.NewReader("notes"))
+
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	_, leaves := parse(t, buf.Bytes())
+
+	want := []struct{ path, body string }{
+		{"multipart/mixed/multipart/related/multipart/alternative/text/plain", m.Text},
+		{"multipart/mixed/multipart/related/multipart/alternative/text/html", m.HTML},
+		{"multipart/mixed/multipart/related/image/png", string(logo)},
+		{"multipart/mixed/text/csv", "a,b\n1,2\n"},
+		{"multipart/mixed/text/plain", "notes"},
+	}
+	if len(leaves) != len(want) {
+		t.Fatalf("got %d leaves, want %d: %+v", len(leaves), len(want), leaves)
+	}
+	for i, w := range want {
+		if leaves[i].path != w.path || leaves[i].body != w.body {
+			t.Errorf("leaf %d = %s %q, want %s %q", i, leaves[i].path, leaves[i].body, w.path, w.body)
+		}
+	}
+	if got := leaves[2].header.Get("Content-Id"); got != "<logo@example.com>" {
+		t.Errorf("Content-Id = %q", got)
+	}
+	if got := leaves[2].header.Get("Content-Disposition"); got != `inline; filename=logo.png` {
+		t.Errorf("inline Content-Disposition = %q", got)
+	}
+	if got := leaves[3].header.Get("Content-Disposition"); got != "attachment; filename=report.csv" {
+		t.Errorf("attachment Content-Disposition = %q", got)
+	}
+
+	for line := range strings.SplitSeq(buf.String(), "\r\n") {
+		if len(line) > 998 || strings.Contains(line, "\n") {
+			t.Fatalf("line breaks RFC 5322 limits: %q", line)
+		}
+	}
+}
+
+func TestMissingAttachment(t *testing.T) {
+	m := newMessage()
+	m.AttachFile(filepath.Join(t.TempDir(), "missing.pdf"))
+	if _, err := io.ReadAll(m.Reader()); err == nil || !strings.Contains(err.Error(), "missing.pdf") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestSendMail(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type session struct {
+		rcpt []string
+		data string
+	}
+	done := make(chan session, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		var s session
+		tp.PrintfLine("220 test ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO":
+				tp.PrintfLine("250 test")
+			case "MAIL":
+				tp.PrintfLine("250 ok")
+			case "RCPT":
+				s.rcpt = append(s.rcpt, line)
+				tp.PrintfLine("250 ok")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				b, _ := tp.ReadDotBytes()
+				s.data = string(b)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				done <- s
+				return
+			default:
+				tp.PrintfLine("502 %s not implemented", cmd)
+			}
+		}
+	}()
+
+	m := newMessage()
+	m.Text = "over smtp\n.leading dot"
+	if err := m.SendMail(ln.Addr().String(), nil); err != nil {
+		t.Fatal(err)
+	}
+	s := <-done
+	if len(s.rcpt) != 3 {
+		t.Errorf("RCPT commands = %v", s.rcpt)
+	}
+	// ReadDotBytes undoes dot-stuffing and CRLF, and ends with a newline.
+	_, leaves := parse(t, []byte(s.data))
+	if len(leaves) != 1 || strings.TrimSuffix(leaves[0].body, "\n") != "over smtp\n.leading dot" {
+		t.Fatalf("leaves = %+v", leaves)
+	}
+}
@@ -0,0 +1,53 @@
+package mimemail
+
+import (
+	"crypto/tls"
+	"net"
+	"net/smtp"
+)
+
+// SendMail submits m to the SMTP server at addr ("host:port"), upgrading to
+// TLS when the server offers STARTTLS and authenticating with a if it is not
+// nil. The message is streamed into the DATA command rather than built in
+// memory first as smtp.SendMail requires.
+func (m *Message) SendMail(addr string, a smtp.Auth) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if a != nil {
+		if err := c.Auth(a); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.From.Address); err != nil {
+		return err
+	}
+	for _, rcpt := range m.Recipients() {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := m.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,198 @@
+// Package smtpx submits mimemail messages over SMTP, streaming the body
+// into the DATA command so attachments are never buffered whole.
+package smtpx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/isauran/go-std-library/mail/mimemail"
+)
+
+// ErrTLSRequired is returned by Send with RequireTLS when the server does
+// not offer STARTTLS.
+var ErrTLSRequired = errors.New("smtpx: server does not support STARTTLS")
+
+type config struct {
+	tls         *tls.Config
+	implicitTLS bool
+	requireTLS  bool
+	localName   string
+}
+
+// Option configures Send.
+type Option func(*config)
+
+// TLSConfig sets the TLS configuration used for STARTTLS and implicit TLS.
+// ServerName defaults to the host of addr.
+func TLSConfig(cfg *tls.Config) Option {
+	return func(c *config) { c.tls = cfg }
+}
+
+// ImplicitTLS connects with TLS from the start instead of upgrading with
+// STARTTLS. It is the default for port 465.
+func ImplicitTLS() Option {
+	return func(c *config) { c.implicitTLS = true }
+}
+
+// RequireTLS fails with ErrTLSRequired instead of sending in plain text
+// when the server does not offer STARTTLS.
+func RequireTLS() Option {
+	return func(c *config) { c.requireTLS = true }
+}
+
+// LocalName sets the host name sent with EHLO ("localhost" by default).
+func LocalName(name string) Option {
+	return func(c *config) { c.localName = name }
+}
+
+// RecipientError is a recipient refused by the server.
+type RecipientError struct {
+	Recipient string
+	Err       error // usually a *textproto.Error with the SMTP reply
+}
+
+func (e RecipientError) Error() string {
+	return e.Recipient + ": " + e.Err.Error()
+}
+
+func (e RecipientError) Unwrap() error {
+	return e.Err
+}
+
+// SendError reports the recipients the server refused. The message was
+// still delivered to the accepted ones unless Delivered is empty.
+type SendError struct {
+	Rejected  []RecipientError
+	Delivered []string
+}
+
+func (e *SendError) Error() string {
+	msgs := make([]string, len(e.Rejected))
+	for i, r := range e.Rejected {
+		msgs[i] = r.Error()
+	}
+	return fmt.Sprintf("smtpx: %d of %d recipients rejected: %s",
+		len(e.Rejected), len(e.Rejected)+len(e.Delivered), strings.Join(msgs, "; "))
+}
+
+// Send submits msg to the SMTP server at addr ("host:port") for all of its
+// To, Cc and Bcc recipients. STARTTLS is used whenever the server offers it;
+// auth, if not nil, runs after TLS is established. Canceling ctx aborts the
+// session, including a DATA transfer in progress.
+//
+// Recipients refused by the server do not stop the submission: the message
+// goes to the others and Send returns a *SendError listing the refusals.
+func Send(ctx context.Context, addr string, auth smtp.Auth, msg *mimemail.Message, opts ...Option) (err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	cfg := config{implicitTLS: port == "465", localName: "localhost"}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	tlsConfig := &tls.Config{ServerName: host}
+	if cfg.tls != nil {
+		tlsConfig = cfg.tls.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = host
+		}
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if cfg.implicitTLS {
+		tc := tls.Client(conn, tlsConfig)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tc
+	}
+	// smtp.Client has no context support, so a canceled context closes the
+	// connection to unblock whatever command is in flight.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer func() {
+		if !stop() && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if err := c.Hello(cfg.localName); err != nil {
+		return err
+	}
+	if !cfg.implicitTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if cfg.requireTLS {
+			return ErrTLSRequired
+		}
+	}
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(msg.From.Address); err != nil {
+		return err
+	}
+	var sendErr SendError
+	for _, rcpt := range msg.Recipients() {
+		if err := c.Rcpt(rcpt); err != nil {
+			sendErr.Rejected = append(sendErr.Rejected, RecipientError{Recipient: rcpt, Err: err})
+			continue
+		}
+		sendErr.Delivered = append(sendErr.Delivered, rcpt)
+	}
+	if len(sendErr.Delivered) == 0 {
+		c.Reset()
+		return &sendErr
+	}
+
+	if err := data(c, msg); err != nil {
+		return err
+	}
+	if err := c.Quit(); err != nil {
+		return err
+	}
+	if len(sendErr.Rejected) > 0 {
+		return &sendErr
+	}
+	return nil
+}
+
+// data streams the message into the DATA command through the message's
+// pipe, so the composer and the connection run at the same pace.
+func data(c *smtp.Client, msg *mimemail.Message) error {
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	body := msg.Reader()
+	defer body.Close()
+	if _, err := io.Copy(w, body); err != nil {
+		// The server has no way to tell a truncated message from a whole one
+		// once the terminating dot is sent, so drop the connection instead.
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,172 @@
+package smtpx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isauran/go-std-library/mail/mimemail"
+)
+
+type session struct {
+	rcpt []string
+	data string
+	quit bool
+}
+
+// serve runs a minimal SMTP server for a single session, refusing
+// recipients whose address contains "reject". The session is sent on the
+// returned channel when the client disconnects.
+func serve(t *testing.T, ehlo ...string) (string, <-chan session) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	done := make(chan session, 1)
+	go func() {
+		var s session
+		defer func() { done <- s }()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 test ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO":
+				for _, ext := range ehlo {
+					tp.PrintfLine("250-%s", ext)
+				}
+				tp.PrintfLine("250 test")
+			case "MAIL", "RSET":
+				tp.PrintfLine("250 ok")
+			case "RCPT":
+				if strings.Contains(line, "reject") {
+					tp.PrintfLine("550 no such user")
+					continue
+				}
+				s.rcpt = append(s.rcpt, line)
+				tp.PrintfLine("250 ok")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				b, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				s.data = string(b)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				s.quit = true
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("502 %s not implemented", cmd)
+			}
+		}
+	}()
+	return ln.Addr().String(), done
+}
+
+func newMessage(to ...string) *mimemail.Message {
+	m := &mimemail.Message{
+		From:    mail.Address{Address: "from@example.com"},
+		Subject: "hello",
+		Text:    "body",
+	}
+	for _, addr := range to {
+		m.To = append(m.To, mail.Address{Address: addr})
+	}
+	return m
+}
+
+func TestSend(t *testing.T) {
+	addr, done := serve(t)
+	if err := Send(context.Background(), addr, nil, newMessage("a@example.com", "b@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	s := <-done
+	if !s.quit || len(s.rcpt) != 2 {
+		t.Fatalf("session = %+v", s)
+	}
+	if !strings.Contains(s.data, "Subject: hello") || !strings.Contains(s.data, "body") {
+		t.Fatalf("data = %q", s.data)
+	}
+}
+
+func TestSendRejectedRecipients(t *testing.T) {
+	addr, done := serve(t)
+	err := Send(context.Background(), addr, nil, newMessage("a@example.com", "reject@example.com"))
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) {
+		t.Fatalf("err = %v", err)
+	}
+	if len(sendErr.Rejected) != 1 || sendErr.Rejected[0].Recipient != "reject@example.com" {
+		t.Errorf("Rejected = %v", sendErr.Rejected)
+	}
+	var tpErr *textproto.Error
+	if !errors.As(sendErr.Rejected[0], &tpErr) || tpErr.Code != 550 {
+		t.Errorf("Rejected[0].Err = %v", sendErr.Rejected[0].Err)
+	}
+	if len(sendErr.Delivered) != 1 || sendErr.Delivered[0] != "a@example.com" {
+		t.Errorf("Delivered = %v", sendErr.Delivered)
+	}
+	if s := <-done; s.data == "" {
+		t.Error("message not delivered to the accepted recipient")
+	}
+}
+
+func TestSendAllRejected(t *testing.T) {
+	addr, done := serve(t)
+	err := Send(context.Background(), addr, nil, newMessage("reject@example.com"))
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) || len(sendErr.Delivered) != 0 {
+		t.Fatalf("err = %v", err)
+	}
+	if s := <-done; s.data != "" {
+		t.Errorf("data sent with no accepted recipients: %q", s.data)
+	}
+}
+
+func TestSendRequireTLS(t *testing.T) {
+	addr, _ := serve(t)
+	err := Send(context.Background(), addr, nil, newMessage("a@example.com"), RequireTLS())
+	if !errors.Is(err, ErrTLSRequired) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestSendCanceled(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		// Accept and never greet, so the client blocks reading the banner.
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := Send(ctx, ln.Addr().String(), nil, newMessage("a@example.com")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+// Package taskgroup runs a group of tasks in goroutines and waits for them,
+// turning the Add/Done bookkeeping of sync.WaitGroup into structured
+// concurrency: the first error cancels the group's context, panics come back
+// as errors instead of crashing the process, and the number of tasks running
+// at once can be bounded:
+//
+//	g, ctx := taskgroup.New(ctx, taskgroup.Limit(4))
+//	for _, url := range urls {
+//		g.GoErr(func(ctx context.Context) error { return fetch(ctx, url) })
+//	}
+//	err := g.Wait()
+//
+// Collect gathers the values of tasks in the order they were started.
+package taskgroup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+)
+
+// ErrPanic matches the *PanicError of a task that panicked.
+var ErrPanic = errors.New("taskgroup: panic in task")
+
+// PanicError is a panic recovered in a task.
+type PanicError struct {
+	Value any    // the value passed to panic
+	Stack []byte // stack of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+func (e *PanicError) Is(target error) bool { return target == ErrPanic }
+
+type config struct {
+	limit     int
+	keepGoing bool
+}
+
+// Option configures a Group.
+type Option func(*config)
+
+// Limit bounds the number of tasks running at once to n; Go and GoErr block
+// until a slot is free. n <= 0 means no limit, the default.
+func Limit(n int) Option {
+	return func(c *config) { c.limit = n }
+}
+
+// KeepGoing stops errors from canceling the group's context, for tasks that
+// are independent of each other. Wait then returns all errors joined.
+func KeepGoing() Option {
+	return func(c *config) { c.keepGoing = true }
+}
+
+// Group is a set of tasks started together. A Group must not be reused
+// after Wait.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	cfg    config
+	sem    chan struct{}
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	errs []error
+}
+
+// New returns an empty group and the context its tasks run with. The
+// context is canceled by the first failing task, unless KeepGoing is set,
+// and once Wait returns.
+func New(ctx context.Context, opts ...Option) (*Group, context.Context) {
+	g := &Group{}
+	for _, opt := range opts {
+		opt(&g.cfg)
+	}
+	if g.cfg.limit > 0 {
+		g.sem = make(chan struct{}, g.cfg.limit)
+	}
+	g.ctx, g.cancel = context.WithCancelCause(ctx)
+	return g, g.ctx
+}
+
+// Go runs fn in a new goroutine. A panic in fn fails the group with a
+// *PanicError.
+func (g *Group) Go(fn func()) {
+	g.GoErr(func(context.Context) error {
+		fn()
+		return nil
+	})
+}
+
+// GoErr runs fn in a new goroutine with the group's context. An error
+// returned by fn, or a panic in it, fails the group. Tasks are started even
+// after the group failed; fn is expected to check ctx.
+func (g *Group) GoErr(fn func(ctx context.Context) error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.wg.Go(func() {
+		if g.sem != nil {
+			defer func() { <-g.sem }()
+		}
+		g.fail(catch(func() error { return fn(g.ctx) }))
+	})
+}
+
+// Wait waits for all tasks and returns the first error, or all errors
+// joined in the order they occurred with KeepGoing.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel(context.Canceled)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cfg.keepGoing {
+		return errors.Join(g.errs...)
+	}
+	if len(g.errs) > 0 {
+		return g.errs[0]
+	}
+	return nil
+}
+
+func (g *Group) fail(err error) {
+	if err == nil {
+		return
+	}
+	g.mu.Lock()
+	g.errs = append(g.errs, err)
+	g.mu.Unlock()
+	if !g.cfg.keepGoing {
+		g.cancel(err)
+	}
+}
+
+// catch calls fn, returning a panic in it as a *PanicError.
+func catch(fn func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return fn()
+}
+
+// Result is the outcome of one task run by Results.
+type Result[T any] struct {
+	Value T
+	Err   error
+}
+
+// Results runs tasks producing a value in a Group and keeps their
+// outcomes.
+type Results[T any] struct {
+	g       *Group
+	mu      sync.Mutex
+	results []Result[T]
+}
+
+// Collect returns a collector running its tasks in g.
+func Collect[T any](g *Group) *Results[T] {
+	return &Results[T]{g: g}
+}
+
+// Go runs fn like Group.GoErr and records its value and error, or the
+// *PanicError of a panic, at the index of this call.
+func (r *Results[T]) Go(fn func(ctx context.Context) (T, error)) {
+	r.mu.Lock()
+	i := len(r.results)
+	r.results = append(r.results, Result[T]{})
+	r.mu.Unlock()
+	r.g.GoErr(func(ctx context.Context) error {
+		var res Result[T]
+		res.Err = catch(func() (err error) {
+			res.Value, err = fn(ctx)
+			return err
+		})
+		r.mu.Lock()
+		r.results[i] = res
+		r.mu.Unlock()
+		return res.Err
+	})
+}
+
+// Wait waits for the group and returns one result per task, in the order
+// the tasks were started, along with the error of Group.Wait.
+func (r *Results[T]) Wait() ([]Result[T], error) {
+	err := r.g.Wait()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.results), err
+}
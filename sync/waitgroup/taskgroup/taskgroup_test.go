@@ -0,0 +1,122 @@
+package taskgroup
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroup(t *testing.T) {
+	g, _ := New(context.Background())
+	var n atomic.Int32
+	for range 10 {
+		g.Go(func() { n.Add(1) })
+	}
+	if err := g.Wait(); err != nil || n.Load() != 10 {
+		t.Errorf("Wait = %v after %d tasks", err, n.Load())
+	}
+}
+
+func TestLimit(t *testing.T) {
+	g, _ := New(context.Background(), Limit(2))
+	var running, peak atomic.Int32
+	for range 8 {
+		g.Go(func() {
+			cur := running.Add(1)
+			for {
+				old := peak.Load()
+				if cur <= old || peak.CompareAndSwap(old, cur) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+		})
+	}
+	g.Wait()
+	if p := peak.Load(); p < 1 || p > 2 {
+		t.Errorf("peak concurrency = %d, want at most 2", p)
+	}
+}
+
+func TestFirstErrorCancels(t *testing.T) {
+	boom := errors.New("boom")
+	g, ctx := New(context.Background())
+	g.GoErr(func(context.Context) error { return boom })
+	g.GoErr(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := g.Wait(); !errors.Is(err, boom) {
+		t.Errorf("Wait = %v, want boom", err)
+	}
+	if !errors.Is(context.Cause(ctx), boom) {
+		t.Errorf("cause = %v", context.Cause(ctx))
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	a, b := errors.New("a"), errors.New("b")
+	g, ctx := New(context.Background(), KeepGoing())
+	g.GoErr(func(context.Context) error { return a })
+	g.GoErr(func(context.Context) error { return b })
+	g.GoErr(func(ctx context.Context) error {
+		time.Sleep(5 * time.Millisecond)
+		return ctx.Err()
+	})
+	if err := g.Wait(); !errors.Is(err, a) || !errors.Is(err, b) || errors.Is(err, context.Canceled) {
+		t.Errorf("Wait = %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Error("context not canceled after Wait")
+	}
+}
+
+func TestPanic(t *testing.T) {
+	g, _ := New(context.Background())
+	g.Go(func() { panic("oops") })
+	err := g.Wait()
+	var pe *PanicError
+	if !errors.Is(err, ErrPanic) || !errors.As(err, &pe) || pe.Value != "oops" || len(pe.Stack) == 0 {
+		t.Errorf("Wait = %v", err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	g, _ := New(context.Background(), KeepGoing(), Limit(3))
+	r := Collect[int](g)
+	for i := range 6 {
+		r.Go(func(context.Context) (int, error) {
+			time.Sleep(time.Duration(6-i) * time.Millisecond)
+			switch i {
+			case 2:
+				return 0, errors.New("two")
+			case 4:
+				panic("four")
+			}
+			return i * i, nil
+		})
+	}
+	results, err := r.Wait()
+	if err == nil || len(results) != 6 {
+		t.Fatalf("Wait = %d results, %v", len(results), err)
+	}
+	for i, res := range results {
+		switch i {
+		case 2:
+			if res.Err == nil {
+				t.Error("result 2: no error")
+			}
+		case 4:
+			if !errors.Is(res.Err, ErrPanic) {
+				t.Errorf("result 4: %v", res.Err)
+			}
+		default:
+			if res.Err != nil || res.Value != i*i {
+				t.Errorf("result %d = %+v", i, res)
+			}
+		}
+	}
+}